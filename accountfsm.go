@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ==================== 账号状态机 ====================
+
+// AccountStatus 账号状态
+type AccountStatus int
+
+const (
+	StatusPending    AccountStatus = iota // 待刷新
+	StatusReady                           // 就绪可用
+	StatusCooling                         // 被限流，使用冷却中（仍在就绪队列，冷却结束前不优先分配）
+	StatusInvalid                         // 失效（连续刷新失败，已移除）
+	StatusDisabled                        // 已禁用
+	StatusRefreshing                      // 刷新中（已被 worker 取出，不在任何队列）
+)
+
+var accountStatusNames = map[AccountStatus]string{
+	StatusPending:    "pending",
+	StatusReady:      "ready",
+	StatusCooling:    "cooling",
+	StatusInvalid:    "invalid",
+	StatusDisabled:   "disabled",
+	StatusRefreshing: "refreshing",
+}
+
+func (s AccountStatus) String() string {
+	if name, ok := accountStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// MarshalText 状态在 JSON 中以名称表示
+func (s AccountStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText 按名称解析状态，兼容旧版本的 "cooldown"
+func (s *AccountStatus) UnmarshalText(b []byte) error {
+	name := string(b)
+	if name == "cooldown" {
+		name = "cooling"
+	}
+	for st, n := range accountStatusNames {
+		if n == name {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的账号状态 %q", name)
+}
+
+// accountTransitions 允许的状态转换。失效是终态；禁用只能通过启用回到待刷新
+var accountTransitions = map[AccountStatus][]AccountStatus{
+	StatusPending:    {StatusRefreshing, StatusReady, StatusDisabled},
+	StatusRefreshing: {StatusReady, StatusPending, StatusInvalid, StatusDisabled},
+	StatusReady:      {StatusPending, StatusCooling, StatusDisabled},
+	StatusCooling:    {StatusReady, StatusPending, StatusDisabled},
+	StatusDisabled:   {StatusPending},
+	StatusInvalid:    {},
+}
+
+func canTransition(from, to AccountStatus) bool {
+	for _, s := range accountTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountTransition 一次状态转换
+type AccountTransition struct {
+	Time   time.Time     `json:"time"`
+	From   AccountStatus `json:"from"`
+	To     AccountStatus `json:"to"`
+	Reason string        `json:"reason"`
+}
+
+// accountHistoryMax 每个账号保留的状态转换条数
+const accountHistoryMax = 50
+
+// transitionLocked 将账号切换到 to 状态并记录原因，调用方需持有 acc.mu。
+// 状态不变时不记录；不允许的转换返回错误且不修改状态
+func (acc *Account) transitionLocked(to AccountStatus, reason string) error {
+	from := acc.Status
+	if from == to {
+		return nil
+	}
+	if !canTransition(from, to) {
+		return fmt.Errorf("账号 %s 不能从 %s 转为 %s（%s）", acc.ID, from, to, reason)
+	}
+	acc.Status = to
+	acc.history = append(acc.history, AccountTransition{Time: time.Now(), From: from, To: to, Reason: reason})
+	if len(acc.history) > accountHistoryMax {
+		acc.history = append(acc.history[:0:0], acc.history[len(acc.history)-accountHistoryMax:]...)
+	}
+	return nil
+}
+
+// moveLocked 同 transitionLocked，不允许的转换只记录日志，返回是否已处于 to 状态
+func (acc *Account) moveLocked(to AccountStatus, reason string) bool {
+	if err := acc.transitionLocked(to, reason); err != nil {
+		log.Printf("⚠️ %v", err)
+		return false
+	}
+	return true
+}
+
+// Transition 切换账号状态，见 transitionLocked
+func (acc *Account) Transition(to AccountStatus, reason string) error {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return acc.transitionLocked(to, reason)
+}
+
+// History 返回最近 n 条状态转换（新的在前），n<=0 返回全部
+func (acc *Account) History(n int) []AccountTransition {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return acc.historyLocked(n)
+}
+
+func (acc *Account) historyLocked(n int) []AccountTransition {
+	if n <= 0 || n > len(acc.history) {
+		n = len(acc.history)
+	}
+	out := make([]AccountTransition, 0, n)
+	for i := len(acc.history) - 1; i >= len(acc.history)-n; i-- {
+		out = append(out, acc.history[i])
+	}
+	return out
+}
+
+// usable 就绪或冷却中的账号持有有效 JWT，可以分配给请求
+func (s AccountStatus) usable() bool {
+	return s == StatusReady || s == StatusCooling
+}
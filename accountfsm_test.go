@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAccountTransitions(t *testing.T) {
+	all := []AccountStatus{StatusPending, StatusRefreshing, StatusReady, StatusCooling, StatusDisabled, StatusInvalid}
+	allowed := map[[2]AccountStatus]bool{
+		{StatusPending, StatusRefreshing}:  true,
+		{StatusPending, StatusReady}:       true,
+		{StatusPending, StatusDisabled}:    true,
+		{StatusRefreshing, StatusReady}:    true,
+		{StatusRefreshing, StatusPending}:  true,
+		{StatusRefreshing, StatusInvalid}:  true,
+		{StatusRefreshing, StatusDisabled}: true,
+		{StatusReady, StatusPending}:       true,
+		{StatusReady, StatusCooling}:       true,
+		{StatusReady, StatusDisabled}:      true,
+		{StatusCooling, StatusReady}:       true,
+		{StatusCooling, StatusPending}:     true,
+		{StatusCooling, StatusDisabled}:    true,
+		{StatusDisabled, StatusPending}:    true,
+	}
+	for _, from := range all {
+		for _, to := range all {
+			if from == to {
+				continue
+			}
+			t.Run(fmt.Sprintf("%s→%s", from, to), func(t *testing.T) {
+				acc := &Account{ID: "a", Status: from}
+				err := acc.Transition(to, "test")
+				if allowed[[2]AccountStatus{from, to}] {
+					h := acc.History(0)
+					if err != nil || acc.Status != to || len(h) != 1 || h[0].From != from || h[0].To != to || h[0].Reason != "test" {
+						t.Fatalf("应允许: err=%v status=%v history=%+v", err, acc.Status, h)
+					}
+				} else if err == nil || acc.Status != from || len(acc.History(0)) != 0 {
+					t.Fatalf("应拒绝: err=%v status=%v", err, acc.Status)
+				}
+			})
+		}
+	}
+
+	// 状态不变不算转换
+	acc := &Account{Status: StatusReady}
+	if err := acc.Transition(StatusReady, "noop"); err != nil || len(acc.History(0)) != 0 {
+		t.Fatalf("相同状态: %v %+v", err, acc.History(0))
+	}
+}
+
+func TestAccountHistoryBounded(t *testing.T) {
+	acc := &Account{Status: StatusReady}
+	for i := 0; i < accountHistoryMax+10; i++ {
+		to := StatusCooling
+		if acc.Status == StatusCooling {
+			to = StatusReady
+		}
+		if err := acc.Transition(to, fmt.Sprintf("#%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := acc.History(0)
+	if len(h) != accountHistoryMax || h[0].Reason != fmt.Sprintf("#%d", accountHistoryMax+9) {
+		t.Fatalf("历史条数 %d, 最新 %+v", len(h), h[0])
+	}
+	if h := acc.History(3); len(h) != 3 || h[2].Reason != fmt.Sprintf("#%d", accountHistoryMax+7) {
+		t.Fatalf("History(3) = %+v", h)
+	}
+}
+
+func TestPoolLifecycleTransitions(t *testing.T) {
+	g := newTestGateway(t, 1)
+	acc := g.accounts[0]
+
+	pool.MarkNeedsRefresh(acc, "上游返回 401")
+	if got := pool.GetPendingAccount(); got != acc || acc.Status != StatusRefreshing {
+		t.Fatalf("取出后状态 = %v", acc.Status)
+	}
+	if counts := pool.StatusCounts(); counts["refreshing"] != 1 || counts["ready"] != 0 {
+		t.Fatalf("counts = %v", counts)
+	}
+	// 刷新中的账号再次遇到 401 不重复入队
+	pool.MarkNeedsRefresh(acc, "上游返回 401")
+	if pool.PendingCount() != 0 || acc.Status != StatusRefreshing {
+		t.Fatalf("刷新中被重复入队: pending=%d status=%v", pool.PendingCount(), acc.Status)
+	}
+	pool.MarkReady(acc)
+
+	pool.MarkCooling(acc, time.Hour, "上游返回 429")
+	if acc.Status != StatusCooling || pool.ReadyCount() != 1 || pool.StatusCounts()["cooling"] != 1 {
+		t.Fatalf("限流后状态 = %v, counts=%v", acc.Status, pool.StatusCounts())
+	}
+	acc.mu.Lock()
+	acc.CoolUntil = time.Now().Add(-time.Second)
+	acc.mu.Unlock()
+	pool.RefreshExpiredAccounts()
+	if acc.Status != StatusReady {
+		t.Fatalf("冷却到期后状态 = %v", acc.Status)
+	}
+
+	var reasons []string
+	for _, h := range acc.History(0) {
+		reasons = append(reasons, h.To.String()+":"+h.Reason)
+	}
+	want := []string{"ready:限流冷却结束", "cooling:上游返回 429", "ready:刷新成功", "refreshing:开始刷新", "pending:上游返回 401"}
+	if fmt.Sprint(reasons) != fmt.Sprint(want) {
+		t.Fatalf("历史 = %v", reasons)
+	}
+
+	w := g.get(t, "/admin/accounts/user0/history?limit=2")
+	var resp struct {
+		Status  string `json:"status"`
+		History []struct {
+			From   string `json:"from"`
+			To     string `json:"to"`
+			Reason string `json:"reason"`
+		} `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 200 || resp.Status != "ready" || len(resp.History) != 2 || resp.History[0].From != "cooling" || resp.History[1].To != "cooling" {
+		t.Fatalf("history 接口: %d %s", w.Code, w.Body.String())
+	}
+	var d AccountDetail
+	json.Unmarshal(g.get(t, "/admin/accounts/user0").Body.Bytes(), &d)
+	if len(d.History) != 5 {
+		t.Fatalf("详情中的历史: %+v", d.History)
+	}
+}
+
+// 限流冷却中的账号不会被 Next 的兜底分支选中
+func TestNextSkipsCoolingAccounts(t *testing.T) {
+	g := newTestGateway(t, 2)
+	cooling, busy := g.accounts[0], g.accounts[1]
+	pool.MarkCooling(cooling, time.Minute, "429")
+	busy.mu.Lock()
+	busy.LastUsed = time.Now() // 处于使用冷却中，只能由兜底分支选中
+	busy.mu.Unlock()
+
+	for i := 0; i < 4; i++ {
+		if acc := pool.Next(); acc != busy {
+			t.Fatalf("第 %d 次选中 %v，期望未冷却的账号", i, acc)
+		}
+	}
+	if cooling.Status != StatusCooling {
+		t.Fatalf("冷却账号状态 = %v", cooling.Status)
+	}
+
+	pool.MarkCooling(busy, time.Minute, "429")
+	if acc := pool.Next(); acc != nil {
+		t.Fatalf("全部冷却时不应分配账号: %v", acc.Data.Email)
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 账号管理接口 ====================
+
+// AccountDetail 单个账号的详细信息（不含凭据）
+type AccountDetail struct {
+	AccountInfo
+	FullName            string              `json:"full_name,omitempty"`
+	ConfigID            string              `json:"config_id"`
+	CSESIDX             string              `json:"csesidx"`
+	Disabled            bool                `json:"disabled"`
+	BrowserRefreshCount int                 `json:"browser_refresh_count"`
+	UpdatedAt           string              `json:"updated_at,omitempty"` // 凭据最后写入时间
+	Cookies             []string            `json:"cookies"`              // 只列出名称
+	HasAuthorization    bool                `json:"has_authorization"`
+	EncryptedAtRest     bool                `json:"encrypted_at_rest"`
+	CoolUntil           *time.Time          `json:"cool_until,omitempty"`
+	History             []AccountTransition `json:"history"` // 最近的状态转换，新的在前
+}
+
+func (acc *Account) detail() AccountDetail {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	d := AccountDetail{
+		AccountInfo: AccountInfo{
+			ID:           acc.ID,
+			Email:        acc.Data.Email,
+			Status:       acc.Status.String(),
+			LastRefresh:  acc.LastRefresh,
+			LastUsed:     acc.LastUsed,
+			FailCount:    acc.FailCount,
+			SuccessCount: acc.SuccessCount,
+			TotalCount:   acc.TotalCount,
+			JWTExpires:   acc.JWTExpires,
+		},
+		FullName:            acc.Data.FullName,
+		ConfigID:            acc.ConfigID,
+		CSESIDX:             acc.CSESIDX,
+		Disabled:            acc.Data.Disabled,
+		BrowserRefreshCount: acc.BrowserRefreshCount,
+		UpdatedAt:           acc.Data.Timestamp,
+		Cookies:             []string{},
+		HasAuthorization:    acc.Data.Authorization != "",
+		EncryptedAtRest:     accountKeyring() != nil,
+		History:             acc.historyLocked(0),
+	}
+	if acc.Status == StatusCooling {
+		until := acc.CoolUntil
+		d.CoolUntil = &until
+	}
+	for _, ck := range acc.Data.GetAllCookies() {
+		d.Cookies = append(d.Cookies, ck.Name)
+	}
+	return d
+}
+
+// accountKeyring 账号存储使用的加密密钥，未启用加密时为 nil
+func accountKeyring() *keyring {
+	if s, ok := accountStore.(*encryptedAccountStore); ok {
+		return s.keys
+	}
+	return nil
+}
+
+// accountPatch 修改账号的请求体，字段为空表示不修改
+type accountPatch struct {
+	Disabled *bool   `json:"disabled"`
+	ConfigID *string `json:"config_id"`
+}
+
+func registerAccountRoutes(admin *gin.RouterGroup) {
+	lookup := func(c *gin.Context) *Account {
+		acc := pool.Get(c.Param("id"))
+		if acc == nil {
+			c.JSON(404, gin.H{"error": "账号不存在", "id": c.Param("id")})
+		}
+		return acc
+	}
+
+	admin.GET("/accounts/:id", requireRole(RoleViewer), func(c *gin.Context) {
+		if acc := lookup(c); acc != nil {
+			c.JSON(200, acc.detail())
+		}
+	})
+
+	admin.GET("/accounts/:id/history", requireRole(RoleViewer), func(c *gin.Context) {
+		acc := lookup(c)
+		if acc == nil {
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		acc.mu.Lock()
+		status, history := acc.Status, acc.historyLocked(limit)
+		acc.mu.Unlock()
+		c.JSON(200, gin.H{"id": acc.ID, "status": status, "history": history})
+	})
+
+	admin.PATCH("/accounts/:id", requireRole(RoleOperator), func(c *gin.Context) {
+		var req accountPatch
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		acc := lookup(c)
+		if acc == nil {
+			return
+		}
+		actor := adminActor(c).Name
+		if req.ConfigID != nil {
+			configID := *req.ConfigID
+			if err := acc.saveWith(func(d *AccountData) { d.ConfigID = configID }); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			acc.mu.Lock()
+			acc.ConfigID = configID
+			acc.Data.ConfigID = configID
+			acc.mu.Unlock()
+			log.Printf("✏️ %s 修改账号 %s 的 configId: %q", actor, acc.ID, *req.ConfigID)
+		}
+		if req.Disabled != nil {
+			if err := pool.SetDisabled(acc, *req.Disabled, actor); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(200, acc.detail())
+	})
+
+	setDisabled := func(disabled bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			acc := lookup(c)
+			if acc == nil {
+				return
+			}
+			if err := pool.SetDisabled(acc, disabled, adminActor(c).Name); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(200, acc.detail())
+		}
+	}
+	admin.POST("/accounts/:id/disable", requireRole(RoleOperator), setDisabled(true))
+	admin.POST("/accounts/:id/enable", requireRole(RoleOperator), setDisabled(false))
+
+	admin.DELETE("/accounts/:id", requireRole(RoleOwner), func(c *gin.Context) {
+		acc := lookup(c)
+		if acc == nil {
+			return
+		}
+		if err := pool.DeleteAccount(acc, adminActor(c).Name); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "账号已删除", "id": acc.ID})
+	})
+
+	admin.POST("/accounts/import", requireRole(RoleOwner), handleAccountImport)
+	admin.GET("/accounts/export", requireRole(RoleOwner), handleAccountExport)
+}
+
+// ==================== 批量导入 ====================
+
+const (
+	maxImportBytes   = 64 << 20 // 单次导入的总大小
+	maxImportEntries = 10000
+)
+
+// importEntry 导入包中的一个 JSON 文件
+type importEntry struct {
+	name string
+	data []byte
+}
+
+// ImportResult 导入结果
+type ImportResult struct {
+	Imported []string      `json:"imported"`
+	Updated  []string      `json:"updated"`
+	Skipped  []string      `json:"skipped"` // 已存在且未指定 overwrite
+	Errors   []ImportError `json:"errors"`
+}
+
+type ImportError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// handleAccountImport 导入账号：multipart 上传的文件，或直接提交 JSON / zip / tar(.gz) 请求体。
+// JSON 可以是单个 AccountData 或数组；?overwrite=true 时覆盖已有账号
+func handleAccountImport(c *gin.Context) {
+	c.Request.Body = io.NopCloser(io.LimitReader(c.Request.Body, maxImportBytes+1))
+	var entries []importEntry
+	var err error
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		entries, err = readImportForm(c)
+	} else {
+		var body []byte
+		body, err = io.ReadAll(c.Request.Body)
+		if err == nil && len(body) > maxImportBytes {
+			err = fmt.Errorf("导入内容超过 %d MB", maxImportBytes>>20)
+		}
+		if err == nil {
+			entries, err = expandImport("body", body)
+		}
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(400, gin.H{"error": "没有找到账号文件"})
+		return
+	}
+
+	res := importAccounts(c.Request.Context(), entries, c.Query("overwrite") == "true")
+	if n := len(res.Imported) + len(res.Updated); n > 0 {
+		log.Printf("📥 %s 导入账号: 新增 %d，更新 %d，跳过 %d，失败 %d",
+			adminActor(c).Name, len(res.Imported), len(res.Updated), len(res.Skipped), len(res.Errors))
+		if err := pool.Load(accountStore); err != nil {
+			log.Printf("⚠️ 重新加载账号失败: %v", err)
+		}
+	}
+	c.JSON(200, res)
+}
+
+func readImportForm(c *gin.Context) ([]importEntry, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	var entries []importEntry
+	for _, files := range form.File {
+		for _, fh := range files {
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			expanded, err := expandImport(fh.Filename, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fh.Filename, err)
+			}
+			entries = append(entries, expanded...)
+		}
+	}
+	return entries, nil
+}
+
+// expandImport 按内容识别格式：zip、gzip 压缩的 tar、tar，其余按 JSON 处理
+func expandImport(name string, data []byte) ([]importEntry, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readZipEntries(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readTarEntries(io.LimitReader(gz, maxImportBytes))
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return readTarEntries(bytes.NewReader(data))
+	}
+	return []importEntry{{name: name, data: data}}, nil
+}
+
+// importableFile 只导入 .json 文件，跳过目录和隐藏文件（如 macOS 的 ._ 文件）
+func importableFile(name string) bool {
+	base := path.Base(name)
+	return strings.HasSuffix(strings.ToLower(base), ".json") && !strings.HasPrefix(base, ".")
+}
+
+func readZipEntries(data []byte) ([]importEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var entries []importEntry
+	total := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !importableFile(f.Name) {
+			continue
+		}
+		if len(entries) >= maxImportEntries {
+			return nil, fmt.Errorf("文件数超过 %d", maxImportEntries)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, maxImportBytes-int64(total)+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if total += len(content); total > maxImportBytes {
+			return nil, fmt.Errorf("解压后超过 %d MB", maxImportBytes>>20)
+		}
+		entries = append(entries, importEntry{name: f.Name, data: content})
+	}
+	return entries, nil
+}
+
+func readTarEntries(r io.Reader) ([]importEntry, error) {
+	tr := tar.NewReader(r)
+	var entries []importEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !importableFile(hdr.Name) {
+			continue
+		}
+		if len(entries) >= maxImportEntries {
+			return nil, fmt.Errorf("文件数超过 %d", maxImportEntries)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		entries = append(entries, importEntry{name: hdr.Name, data: content})
+	}
+}
+
+// parseImportEntry 文件内容为单个账号或账号数组
+func parseImportEntry(data []byte) ([]AccountData, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var list []AccountData
+		err := json.Unmarshal(trimmed, &list)
+		return list, err
+	}
+	var one AccountData
+	if err := json.Unmarshal(trimmed, &one); err != nil {
+		return nil, err
+	}
+	return []AccountData{one}, nil
+}
+
+// prepareImportedAccount 解密（加密导出包）并校验账号数据
+func prepareImportedAccount(data AccountData) (AccountData, error) {
+	if data.Email == "" {
+		return data, errors.New("缺少 email")
+	}
+	if data.Sealed != nil {
+		opened, err := accountKeyring().Open(data)
+		if err != nil {
+			return data, err
+		}
+		data = opened
+	}
+	if data.Authorization == redactedMark || data.CookieString == redactedMark {
+		return data, errors.New("脱敏导出的数据不包含凭据，无法导入")
+	}
+	hasSES := false
+	for _, ck := range data.GetAllCookies() {
+		if ck.Value == redactedMark {
+			return data, errors.New("脱敏导出的数据不包含凭据，无法导入")
+		}
+		if ck.Name == "__Secure-C_SES" && ck.Value != "" {
+			hasSES = true
+		}
+	}
+	if !hasSES {
+		return data, errors.New("缺少 __Secure-C_SES Cookie")
+	}
+	if data.CSESIDX == "" && extractCSESIDX(data.Authorization) == "" {
+		return data, errors.New("缺少 csesidx")
+	}
+	data.Timestamp = time.Now().Format(time.RFC3339)
+	return data, nil
+}
+
+func importAccounts(ctx context.Context, entries []importEntry, overwrite bool) ImportResult {
+	res := ImportResult{Imported: []string{}, Updated: []string{}, Skipped: []string{}, Errors: []ImportError{}}
+	for _, entry := range entries {
+		list, err := parseImportEntry(entry.data)
+		if err != nil {
+			res.Errors = append(res.Errors, ImportError{File: entry.name, Error: "解析失败: " + err.Error()})
+			continue
+		}
+		for i, data := range list {
+			label := entry.name
+			if len(list) > 1 {
+				label = fmt.Sprintf("%s[%d]", entry.name, i)
+			}
+			data, err := prepareImportedAccount(data)
+			if err != nil {
+				res.Errors = append(res.Errors, ImportError{File: label, Error: err.Error()})
+				continue
+			}
+			id := accountIDForEmail(data.Email)
+			_, err = accountStore.Get(ctx, id)
+			exists := err == nil
+			if exists && !overwrite {
+				res.Skipped = append(res.Skipped, id)
+				continue
+			}
+			if err := accountStore.Put(ctx, id, data); err != nil {
+				res.Errors = append(res.Errors, ImportError{File: label, Error: err.Error()})
+				continue
+			}
+			if exists {
+				res.Updated = append(res.Updated, id)
+			} else {
+				res.Imported = append(res.Imported, id)
+			}
+		}
+	}
+	return res
+}
+
+// ==================== 导出 ====================
+
+// redactAccountData 去掉凭据，只保留用于核对的字段
+func redactAccountData(data AccountData) AccountData {
+	if data.Authorization != "" {
+		data.Authorization = redactedMark
+	}
+	if data.CookieString != "" {
+		data.CookieString = redactedMark
+	}
+	cookies := make([]Cookie, len(data.Cookies))
+	for i, ck := range data.Cookies {
+		ck.Value = redactedMark
+		cookies[i] = ck
+	}
+	data.Cookies = cookies
+	if len(data.ResponseHeaders) > 0 {
+		headers := make(map[string]string, len(data.ResponseHeaders))
+		for k := range data.ResponseHeaders {
+			headers[k] = redactedMark
+		}
+		data.ResponseHeaders = headers
+	}
+	return data
+}
+
+// handleAccountExport 导出全部账号。mode=redacted（默认）去掉凭据；
+// mode=encrypted 用当前主密钥加密凭据，可导入到使用相同密钥的实例。
+// format 可选 json（默认）、zip、tar（gzip 压缩）
+func handleAccountExport(c *gin.Context) {
+	mode := c.DefaultQuery("mode", "redacted")
+	format := c.DefaultQuery("format", "json")
+	if mode != "redacted" && mode != "encrypted" {
+		c.JSON(400, gin.H{"error": "mode 只支持 redacted、encrypted"})
+		return
+	}
+	if format != "json" && format != "zip" && format != "tar" {
+		c.JSON(400, gin.H{"error": "format 只支持 json、zip、tar"})
+		return
+	}
+	keys := accountKeyring()
+	if mode == "encrypted" && keys == nil {
+		c.JSON(400, gin.H{"error": "未配置加密密钥，无法加密导出"})
+		return
+	}
+
+	items, err := accountStore.List(c.Request.Context())
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	for i := range items {
+		if mode == "redacted" {
+			items[i].Data = redactAccountData(items[i].Data)
+		} else if items[i].Data, err = keys.Seal(items[i].Data); err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("加密账号 %s 失败: %v", items[i].ID, err)})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		list := make([]AccountData, len(items))
+		for i, item := range items {
+			list[i] = item.Data
+		}
+		err = writeIndentedJSON(&buf, list)
+	case "zip":
+		err = writeZipBundle(&buf, items)
+	case "tar":
+		err = writeTarBundle(&buf, items)
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	ext := map[string]string{"json": "json", "zip": "zip", "tar": "tar.gz"}[format]
+	contentType := map[string]string{"json": "application/json", "zip": "application/zip", "tar": "application/gzip"}[format]
+	filename := fmt.Sprintf("accounts-%s-%s.%s", mode, time.Now().Format("20060102-150405"), ext)
+	log.Printf("📤 %s 导出 %d 个账号（%s, %s）", adminActor(c).Name, len(items), mode, format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(200, contentType, buf.Bytes())
+}
+
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeZipBundle(w io.Writer, items []StoredAccount) error {
+	zw := zip.NewWriter(w)
+	for _, item := range items {
+		f, err := zw.Create(item.ID + ".json")
+		if err != nil {
+			return err
+		}
+		if err := writeIndentedJSON(f, item.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarBundle(w io.Writer, items []StoredAccount) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, item := range items {
+		data, err := json.MarshalIndent(item.Data, "", "  ")
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: item.ID + ".json", Mode: 0600, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
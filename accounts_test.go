@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func (g *testGateway) send(t *testing.T, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return g.serve(req, nil)
+}
+
+func TestAdminAccountDetailAndDisable(t *testing.T) {
+	g := newTestGateway(t, 2)
+
+	w := g.get(t, "/admin/accounts/user0")
+	var d AccountDetail
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if w.Code != 200 || d.ID != "user0" || d.ConfigID != "test-config" || d.CSESIDX != "1000" ||
+		d.JWTExpires.IsZero() || len(d.Cookies) != 1 || d.Cookies[0] != "__Secure-C_SES" {
+		t.Fatalf("详情: %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "ses-0") || strings.Contains(w.Body.String(), "jwt-0") {
+		t.Fatalf("详情中不应包含凭据: %s", w.Body.String())
+	}
+	if w := g.get(t, "/admin/accounts/user1@test.local"); w.Code != 200 {
+		t.Fatalf("按邮箱查找: %d", w.Code)
+	}
+	if w := g.get(t, "/admin/accounts/nobody"); w.Code != 404 {
+		t.Fatalf("不存在的账号: %d", w.Code)
+	}
+
+	w = g.send(t, "PATCH", "/admin/accounts/user0", "application/json", []byte(`{"disabled": true, "config_id": "cfg-2"}`))
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if w.Code != 200 || d.Status != "disabled" || !d.Disabled || d.ConfigID != "cfg-2" {
+		t.Fatalf("禁用: %d %s", w.Code, w.Body.String())
+	}
+	if pool.ReadyCount() != 1 || pool.StatusCounts()["disabled"] != 1 {
+		t.Fatalf("禁用后 ready=%d counts=%v", pool.ReadyCount(), pool.StatusCounts())
+	}
+	for i := 0; i < 4; i++ {
+		if acc := pool.Next(); acc == g.accounts[0] {
+			t.Fatal("禁用的账号不应被分配")
+		}
+	}
+	stored, err := accountStore.Get(context.Background(), "user0")
+	if err != nil || !stored.Disabled || stored.ConfigID != "cfg-2" {
+		t.Fatalf("禁用状态未写回存储: %+v, %v", stored, err)
+	}
+
+	// 重新加载时保持禁用
+	if err := pool.Load(accountStore); err != nil {
+		t.Fatal(err)
+	}
+	if acc := pool.Get("user0"); acc != g.accounts[0] || pool.StatusCounts()["disabled"] != 1 {
+		t.Fatalf("重新加载后: %v", pool.StatusCounts())
+	}
+
+	if w := g.post(t, "/admin/accounts/user0/enable", nil); w.Code != 200 {
+		t.Fatalf("启用: %d", w.Code)
+	}
+	if pool.PendingCount() != 1 || pool.StatusCounts()["disabled"] != 0 {
+		t.Fatalf("启用后应进入刷新队列: %v", pool.StatusCounts())
+	}
+}
+
+// failingPutStore 写入总是失败的账号存储
+type failingPutStore struct{ AccountStore }
+
+func (failingPutStore) Put(context.Context, string, AccountData) error {
+	return errors.New("store unavailable")
+}
+
+func TestAccountChangesNotAppliedWhenSaveFails(t *testing.T) {
+	g := newTestGateway(t, 2)
+	accountStore = failingPutStore{accountStore}
+	ch, cancel := events.Subscribe(16, eventTypeFilter([]string{"account.*"}))
+	defer cancel()
+
+	if w := g.post(t, "/admin/accounts/user0/disable", nil); w.Code != 500 {
+		t.Fatalf("保存失败时禁用状态码 = %d", w.Code)
+	}
+	w := g.send(t, "PATCH", "/admin/accounts/user0", "application/json", []byte(`{"config_id": "cfg-2"}`))
+	if w.Code != 500 {
+		t.Fatalf("保存失败时修改 configId 状态码 = %d", w.Code)
+	}
+	acc := g.accounts[0]
+	acc.mu.Lock()
+	status, disabled, configID := acc.Status, acc.Data.Disabled, acc.ConfigID
+	acc.mu.Unlock()
+	if status != StatusReady || disabled || configID != "test-config" || pool.ReadyCount() != 2 {
+		t.Fatalf("保存失败后内存已修改: status=%s disabled=%v configId=%s ready=%d", status, disabled, configID, pool.ReadyCount())
+	}
+	select {
+	case e := <-ch:
+		t.Fatalf("保存失败时不应发布事件: %+v", e)
+	default:
+	}
+}
+
+func TestDisabledAccountNotRequeued(t *testing.T) {
+	g := newTestGateway(t, 1)
+	acc := g.accounts[0]
+	pool.MarkPending(acc, "test")
+	if pool.GetPendingAccount() != acc {
+		t.Fatal("应取出待刷新账号")
+	}
+
+	// 刷新过程中被禁用，刷新完成后进入禁用列表而不是就绪队列
+	if err := pool.SetDisabled(acc, true, "test"); err != nil {
+		t.Fatal(err)
+	}
+	pool.MarkReady(acc)
+	if pool.ReadyCount() != 0 || pool.StatusCounts()["disabled"] != 1 {
+		t.Fatalf("counts = %v", pool.StatusCounts())
+	}
+
+	// 刷新过程中被删除，不再放回
+	if err := pool.SetDisabled(acc, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if pool.GetPendingAccount() != acc {
+		t.Fatal("启用后应进入刷新队列")
+	}
+	if w := g.send(t, "DELETE", "/admin/accounts/user0", "", nil); w.Code != 200 {
+		t.Fatalf("删除: %d %s", w.Code, w.Body.String())
+	}
+	pool.requeue(acc, "test")
+	if pool.TotalCount() != 0 || pool.Get("user0") != nil {
+		t.Fatalf("删除的账号被放回: total=%d", pool.TotalCount())
+	}
+	if _, err := accountStore.Get(context.Background(), "user0"); err == nil {
+		t.Fatal("存储中的账号未删除")
+	}
+}
+
+// 禁用账号仍计入号池容量，不会触发补充注册
+func TestDisabledAccountsCountTowardTarget(t *testing.T) {
+	g := newTestGateway(t, 3)
+	updateConfig(func(cfg *AppConfig) { cfg.Pool.TargetCount = 3 })
+	if err := pool.SetDisabled(g.accounts[0], true, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if pool.TotalCount() != 2 || pool.CapacityCount() != 3 {
+		t.Fatalf("total = %d, capacity = %d", pool.TotalCount(), pool.CapacityCount())
+	}
+
+	w := g.post(t, "/admin/register", nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "账号数量已足够") {
+		t.Fatalf("禁用账号后不应注册: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func importJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAdminAccountImport(t *testing.T) {
+	g := newTestGateway(t, 0)
+	var res ImportResult
+	decode := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != 200 {
+			t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+		}
+		res = ImportResult{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+	}
+
+	// JSON 数组，含无效记录
+	noCookie := testAccountData("broken@test.local")
+	noCookie.Cookies = nil
+	redacted := redactAccountData(testAccountData("redacted@test.local"))
+	decode(g.send(t, "POST", "/admin/accounts/import", "application/json",
+		importJSON(t, []AccountData{testAccountData("a@test.local"), testAccountData("b@test.local"), noCookie, redacted})))
+	if len(res.Imported) != 2 || len(res.Errors) != 2 || !strings.Contains(res.Errors[1].Error, "脱敏") {
+		t.Fatalf("JSON 导入: %+v", res)
+	}
+	if pool.TotalCount() != 2 {
+		t.Fatalf("导入后号池账号数 = %d", pool.TotalCount())
+	}
+
+	// zip：已存在的账号默认跳过
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, email := range []string{"a@test.local", "c@test.local"} {
+		f, _ := zw.Create("accounts/" + email + ".json")
+		f.Write(importJSON(t, testAccountData(email)))
+	}
+	f, _ := zw.Create("__MACOSX/._c@test.local.json")
+	f.Write([]byte("garbage"))
+	zw.Close()
+	decode(g.send(t, "POST", "/admin/accounts/import", "application/zip", zbuf.Bytes()))
+	if len(res.Imported) != 1 || res.Imported[0] != "c@test.local" || len(res.Skipped) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("zip 导入: %+v", res)
+	}
+
+	// tar.gz 通过 multipart 上传，overwrite 覆盖已有账号
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	updated := testAccountData("b@test.local")
+	updated.ConfigID = "cfg-new"
+	data := importJSON(t, updated)
+	tw.WriteHeader(&tar.Header{Name: "b@test.local.json", Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg})
+	tw.Write(data)
+	tw.Close()
+	gz.Close()
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("files", "backup.tar.gz")
+	part.Write(tbuf.Bytes())
+	part, _ = mw.CreateFormFile("files", "d@test.local.json")
+	part.Write(importJSON(t, testAccountData("d@test.local")))
+	mw.Close()
+	decode(g.send(t, "POST", "/admin/accounts/import?overwrite=true", mw.FormDataContentType(), form.Bytes()))
+	if len(res.Updated) != 1 || res.Updated[0] != "b@test.local" || len(res.Imported) != 1 {
+		t.Fatalf("tar 导入: %+v", res)
+	}
+	if stored, _ := accountStore.Get(context.Background(), "b@test.local"); stored.ConfigID != "cfg-new" {
+		t.Fatalf("覆盖失败: %+v", stored)
+	}
+
+	if w := g.send(t, "POST", "/admin/accounts/import", "application/json", []byte(`{not json`)); w.Code != 200 ||
+		!strings.Contains(w.Body.String(), "解析失败") {
+		t.Fatalf("无效 JSON: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminAccountExport(t *testing.T) {
+	g := newTestGateway(t, 0)
+	ctx := context.Background()
+	keys := mustKeyring(t, testKey(7))
+	useAccountStore(t, newEncryptedAccountStore(newFileAccountStore(t.TempDir()), keys))
+	alice := secretAccountData()
+	if err := accountStore.Put(ctx, "alice@test.local", alice); err != nil {
+		t.Fatal(err)
+	}
+
+	w := g.get(t, "/admin/accounts/export")
+	var list []AccountData
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != 200 || len(list) != 1 || list[0].Email != alice.Email || list[0].Cookies[0].Value != redactedMark {
+		t.Fatalf("脱敏导出: %d %s", w.Code, w.Body.String())
+	}
+	assertNoSecrets(t, w.Body.String())
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "accounts-redacted-") {
+		t.Fatalf("Content-Disposition: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	w = g.get(t, "/admin/accounts/export?mode=encrypted&format=zip")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("加密导出: %d %s", w.Code, w.Body.String())
+	}
+	assertNoSecrets(t, w.Body.String())
+	bundle := w.Body.Bytes()
+
+	// 导入到使用相同密钥的另一个实例
+	useAccountStore(t, newEncryptedAccountStore(newFileAccountStore(t.TempDir()), keys))
+	w = g.send(t, "POST", "/admin/accounts/import", "application/zip", bundle)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"imported":["alice@test.local"]`) {
+		t.Fatalf("导入加密包: %d %s", w.Code, w.Body.String())
+	}
+	got, err := accountStore.Get(ctx, "alice@test.local")
+	if err != nil || got.Authorization != alice.Authorization {
+		t.Fatalf("导入后 Get = %+v, %v", got, err)
+	}
+
+	// 没有密钥时不能加密导出，也不能导入加密包
+	useAccountStore(t, newFileAccountStore(t.TempDir()))
+	if w := g.get(t, "/admin/accounts/export?mode=encrypted"); w.Code != http.StatusBadRequest {
+		t.Fatalf("无密钥加密导出: %d", w.Code)
+	}
+	w = g.send(t, "POST", "/admin/accounts/import", "application/zip", bundle)
+	if !strings.Contains(w.Body.String(), `"imported":[]`) || !strings.Contains(w.Body.String(), "密钥") {
+		t.Fatalf("无密钥导入加密包: %s", w.Body.String())
+	}
+
+	if w := g.get(t, "/admin/accounts/export?format=rar"); w.Code != 400 {
+		t.Fatalf("无效格式: %d", w.Code)
+	}
+}
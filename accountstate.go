@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ==================== 账号运行状态持久化 ====================
+
+// AccountState 账号的运行状态，与凭据文件分开保存，重启后恢复。
+// 状态机状态不保存：JWT 不持久化，重启后账号一律从 pending 开始
+type AccountState struct {
+	FailCount    int       `json:"fail_count"`
+	SuccessCount int       `json:"success_count"`
+	TotalCount   int       `json:"total_count"`
+	LastUsed     time.Time `json:"last_used"`
+	LastRefresh  time.Time `json:"last_refresh"`
+	JWTExpires   time.Time `json:"jwt_expires"`
+}
+
+var accountStateBucket = []byte("accounts")
+
+// accountStateStore 基于 bbolt 的状态库（<data_dir>/meta/state.db）
+type accountStateStore struct {
+	db   *bolt.DB
+	mu   sync.Mutex
+	last map[string]AccountState // 最近一次写入的状态，Flush 时只写变化的账号
+}
+
+var accountStates *accountStateStore
+
+func openAccountStateStore(path string) (*accountStateStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开状态库 %s 失败: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(accountStateBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &accountStateStore{db: db, last: make(map[string]AccountState)}, nil
+}
+
+// initAccountStates 打开状态库；失败时不持久化，但不影响服务
+func initAccountStates() {
+	store, err := openAccountStateStore(filepath.Join(DataDir, "meta", "state.db"))
+	if err != nil {
+		log.Printf("⚠️ %v，账号运行状态将不会保存", err)
+		return
+	}
+	accountStates = store
+}
+
+// accountStateKey 以邮箱标识账号，更换存储或 ID 后仍能对应
+func accountStateKey(acc *Account) string {
+	if acc.Data.Email != "" {
+		return strings.ToLower(acc.Data.Email)
+	}
+	return acc.ID
+}
+
+func (acc *Account) runtimeState() AccountState {
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	return AccountState{
+		FailCount:    acc.FailCount,
+		SuccessCount: acc.SuccessCount,
+		TotalCount:   acc.TotalCount,
+		LastUsed:     acc.LastUsed,
+		LastRefresh:  acc.LastRefresh,
+		JWTExpires:   acc.JWTExpires,
+	}
+}
+
+// Restore 将保存的状态写回账号；没有记录时返回 false
+func (s *accountStateStore) Restore(acc *Account) bool {
+	if s == nil {
+		return false
+	}
+	key := accountStateKey(acc)
+	var st AccountState
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(accountStateBucket).Get([]byte(key))
+		if data != nil && json.Unmarshal(data, &st) == nil {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		return false
+	}
+	acc.mu.Lock()
+	acc.FailCount = st.FailCount
+	acc.SuccessCount = st.SuccessCount
+	acc.TotalCount = st.TotalCount
+	acc.LastUsed = st.LastUsed
+	// 刷新时间仅供展示：JWT 不持久化，刷新冷却只对持有 JWT 的账号生效，
+	// 重启后账号仍从待刷新开始；限流冷却由 LastUsed 延续
+	acc.LastRefresh = st.LastRefresh
+	acc.JWTExpires = st.JWTExpires
+	acc.mu.Unlock()
+
+	s.mu.Lock()
+	s.last[key] = st
+	s.mu.Unlock()
+	return true
+}
+
+// Flush 将号池中状态有变化的账号写入状态库（单个事务）
+func (s *accountStateStore) Flush(p *AccountPool) error {
+	if s == nil {
+		return nil
+	}
+	p.mu.RLock()
+	accounts := make([]*Account, 0, len(p.readyAccounts)+len(p.pendingAccounts)+len(p.disabledAccounts))
+	accounts = append(accounts, p.readyAccounts...)
+	accounts = append(accounts, p.pendingAccounts...)
+	accounts = append(accounts, p.disabledAccounts...)
+	p.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := make(map[string]AccountState)
+	for _, acc := range accounts {
+		key := accountStateKey(acc)
+		st := acc.runtimeState()
+		if prev, ok := s.last[key]; !ok || !sameAccountState(prev, st) {
+			changed[key] = st
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(accountStateBucket)
+		for key, st := range changed {
+			data, err := json.Marshal(st)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, st := range changed {
+		s.last[key] = st
+	}
+	return nil
+}
+
+func sameAccountState(a, b AccountState) bool {
+	return a.FailCount == b.FailCount && a.SuccessCount == b.SuccessCount &&
+		a.TotalCount == b.TotalCount && a.LastUsed.Equal(b.LastUsed) && a.LastRefresh.Equal(b.LastRefresh) &&
+		a.JWTExpires.Equal(b.JWTExpires)
+}
+
+// Delete 账号被移除时删除其状态
+func (s *accountStateStore) Delete(acc *Account) {
+	if s == nil {
+		return
+	}
+	key := accountStateKey(acc)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountStateBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		log.Printf("⚠️ 删除账号状态失败 %s: %v", key, err)
+	}
+	s.mu.Lock()
+	delete(s.last, key)
+	s.mu.Unlock()
+}
+
+func (s *accountStateStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// startStateFlusher 定期保存账号运行状态；关闭时的最后一次保存由 shutdown 完成
+func startStateFlusher(interval time.Duration) {
+	if accountStates == nil {
+		return
+	}
+	app.Go("state-flusher", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := accountStates.Flush(pool); err != nil {
+				log.Printf("⚠️ 保存账号运行状态失败: %v", err)
+			}
+		}
+	})
+}
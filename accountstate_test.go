@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useAccountStates 在临时目录打开状态库，测试结束后关闭并恢复
+func useAccountStates(t *testing.T, path string) *accountStateStore {
+	t.Helper()
+	store, err := openAccountStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := accountStates
+	accountStates = store
+	t.Cleanup(func() {
+		store.Close()
+		accountStates = old
+	})
+	return store
+}
+
+func writeAccountFile(t *testing.T, dir, email string) string {
+	t.Helper()
+	path := filepath.Join(dir, email+".json")
+	data := `{"email": "` + email + `", "csesidx": "12345", "cookies": [{"name": "__Secure-C_SES", "value": "ses"}]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAccountStatePersistsAcrossLoad(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "meta", "state.db")
+	writeAccountFile(t, dir, "Alice@test.local")
+	writeAccountFile(t, dir, "bob@test.local")
+
+	store := useAccountStates(t, dbPath)
+	p := &AccountPool{}
+	if err := p.Load(newFileAccountStore(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.pendingAccounts) != 2 {
+		t.Fatalf("加载账号数 = %d", len(p.pendingAccounts))
+	}
+
+	used := time.Now().Add(-time.Minute).Truncate(time.Second)
+	alice := p.pendingAccounts[0]
+	alice.mu.Lock()
+	alice.SuccessCount, alice.TotalCount, alice.FailCount = 7, 9, 2
+	alice.LastUsed = used
+	alice.LastRefresh = used
+	alice.JWTExpires = used.Add(time.Hour)
+	alice.mu.Unlock()
+	if err := store.Flush(p); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 模拟重启：重新打开状态库并加载
+	useAccountStates(t, dbPath)
+	p2 := &AccountPool{}
+	if err := p2.Load(newFileAccountStore(dir)); err != nil {
+		t.Fatal(err)
+	}
+	var restored *Account
+	for _, acc := range p2.pendingAccounts {
+		if acc.Data.Email == "Alice@test.local" {
+			restored = acc
+		}
+	}
+	if restored == nil {
+		t.Fatal("账号未加载")
+	}
+	if restored.SuccessCount != 7 || restored.TotalCount != 9 || restored.FailCount != 2 ||
+		!restored.LastUsed.Equal(used) {
+		t.Fatalf("恢复的状态: %+v", restored.runtimeState())
+	}
+	// JWT 不持久化，账号仍需刷新；刷新时间仅供展示
+	if restored.JWT != "" || restored.Status != StatusPending {
+		t.Fatal("恢复后的账号不应处于就绪状态")
+	}
+	if !restored.LastRefresh.Equal(used) || !restored.JWTExpires.Equal(used.Add(time.Hour)) {
+		t.Fatalf("刷新时间未恢复: %+v", restored.runtimeState())
+	}
+	infos := p2.ListAccounts()
+	found := false
+	for _, info := range infos {
+		if info.Email == "Alice@test.local" && info.SuccessCount == 7 && info.TotalCount == 9 && info.LastRefresh.Equal(used) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("/admin/accounts 计数: %+v", infos)
+	}
+}
+
+func TestAccountStateFlushOnlyChangedAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := useAccountStates(t, filepath.Join(dir, "state.db"))
+	path := writeAccountFile(t, dir, "c@test.local")
+	useAccountStore(t, newFileAccountStore(dir))
+	acc := &Account{Data: AccountData{Email: "c@test.local"}, ID: "c@test.local"}
+	p := &AccountPool{pendingAccounts: []*Account{acc}}
+
+	if err := store.Flush(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.last["c@test.local"]; !ok {
+		t.Fatal("首次 Flush 未写入")
+	}
+	// 无变化时不写入：手动改写 last 后再 Flush，记录应保持不变
+	marker := AccountState{TotalCount: 1}
+	store.last["c@test.local"] = marker
+	acc.mu.Lock()
+	acc.TotalCount = 1
+	acc.mu.Unlock()
+	store.Flush(p)
+	if got := store.last["c@test.local"]; got != marker {
+		t.Fatalf("无变化时仍然写入: %+v", got)
+	}
+
+	acc.mu.Lock()
+	acc.TotalCount = 2
+	acc.mu.Unlock()
+	store.Flush(p)
+	restored := &Account{Data: AccountData{Email: "C@test.local"}}
+	if !store.Restore(restored) || restored.TotalCount != 2 {
+		t.Fatalf("恢复的计数 = %d", restored.TotalCount)
+	}
+
+	// 移除账号时删除状态
+	p.RemoveAccount(acc)
+	if store.Restore(&Account{Data: AccountData{Email: "c@test.local"}}) {
+		t.Fatal("移除账号后状态仍存在")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("凭据文件未删除")
+	}
+}
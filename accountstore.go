@@ -0,0 +1,745 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ==================== 账号存储 ====================
+
+// StorageConfig 账号存储后端配置
+type StorageConfig struct {
+	Backend string            `json:"backend"` // file（默认，<data_dir>/*.json）、bolt 或 consul
+	Path    string            `json:"path"`    // bolt 数据库路径，默认 <data_dir>/meta/accounts.db
+	Consul  ConsulStoreConfig `json:"consul"`
+}
+
+// ConsulStoreConfig Consul KV（HTTP API）配置，多个网关实例可共享同一组账号
+type ConsulStoreConfig struct {
+	Address string `json:"address"` // 如 http://127.0.0.1:8500
+	Prefix  string `json:"prefix"`  // 键前缀，默认 business2api/accounts/
+	Token   string `json:"token"`   // ACL Token
+}
+
+var errAccountNotFound = errors.New("账号不存在")
+
+// StoredAccount 存储中的一个账号
+type StoredAccount struct {
+	ID   string
+	Data AccountData
+}
+
+// AccountChange 存储中账号的变化
+type AccountChange struct {
+	Op string // put 或 delete
+	ID string
+}
+
+// AccountStore 账号凭据存储
+type AccountStore interface {
+	// List 返回全部账号；无法解析的记录跳过并记录日志
+	List(ctx context.Context) ([]StoredAccount, error)
+	Get(ctx context.Context, id string) (AccountData, error)
+	Put(ctx context.Context, id string, data AccountData) error
+	Delete(ctx context.Context, id string) error
+	// Watch 推送账号变化，ctx 结束时关闭通道
+	Watch(ctx context.Context) (<-chan AccountChange, error)
+	Close() error
+}
+
+var accountStore AccountStore
+
+// validAccountID ID 会用作文件名或键名，不允许路径分隔符
+func validAccountID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) || strings.ContainsRune(id, 0) {
+		return fmt.Errorf("无效的账号 ID %q", id)
+	}
+	return nil
+}
+
+// accountIDForEmail 新账号的 ID（与原来的 <email>.json 文件名一致）
+func accountIDForEmail(email string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(email)
+}
+
+// newAccountStore 按配置创建存储后端
+func newAccountStore(cfg StorageConfig, dataDir string) (AccountStore, error) {
+	switch cfg.Backend {
+	case "", "file":
+		return newFileAccountStore(dataDir), nil
+	case "bolt":
+		path := cfg.Path
+		if path == "" {
+			path = filepath.Join(dataDir, "meta", "accounts.db")
+		}
+		return openBoltAccountStore(path)
+	case "consul":
+		return newConsulAccountStore(cfg.Consul)
+	}
+	return nil, fmt.Errorf("不支持的账号存储 %q（可选 file、bolt、consul）", cfg.Backend)
+}
+
+// initAccountStore 打开配置的账号存储；配置了密钥时凭据加密保存
+func initAccountStore() {
+	cfg := currentConfig()
+	store, err := newAccountStore(cfg.Storage, DataDir)
+	if err != nil {
+		log.Fatalf("❌ 打开账号存储失败: %v", err)
+	}
+	keys, err := loadKeyring(cfg.Encryption)
+	if err != nil {
+		log.Fatalf("❌ 加载凭据加密密钥失败: %v", err)
+	}
+	accountStore = newEncryptedAccountStore(store, keys)
+	if backend := cfg.Storage.Backend; backend != "" && backend != "file" {
+		log.Printf("🗄️ 账号存储: %s", backend)
+	}
+	if keys != nil {
+		log.Printf("🔐 账号凭据加密已启用（kid=%s）", keys.primary.id)
+	}
+}
+
+// startAccountWatcher 存储中的账号变化时重新加载号池（合并 200 毫秒内的连续变化）
+func startAccountWatcher() {
+	changes, err := accountStore.Watch(app.Context())
+	if err != nil {
+		log.Printf("⚠️ 无法监听账号存储: %v", err)
+		return
+	}
+	app.Go("account-watcher", func(ctx context.Context) {
+		var reload <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+				if reload == nil {
+					reload = time.After(200 * time.Millisecond)
+				}
+			case <-reload:
+				reload = nil
+				if err := pool.Load(accountStore); err != nil {
+					log.Printf("⚠️ 重新加载账号失败: %v", err)
+				}
+			}
+		}
+	})
+}
+
+// ==================== 文件存储 ====================
+
+// fileAccountStore 每个账号一个 <id>.json 文件
+type fileAccountStore struct {
+	dir          string
+	pollInterval time.Duration // 无法使用 inotify 时的轮询间隔
+}
+
+func newFileAccountStore(dir string) *fileAccountStore {
+	return &fileAccountStore{dir: dir, pollInterval: 5 * time.Second}
+}
+
+func (s *fileAccountStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileAccountStore) List(ctx context.Context) ([]StoredAccount, error) {
+	s.removeStaleTemps()
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []StoredAccount
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), ".json")
+		// 旧版本以 0644 写入，收紧为仅属主可读
+		if info, err := os.Stat(f); err == nil && info.Mode().Perm()&0077 != 0 {
+			if err := os.Chmod(f, 0600); err != nil {
+				log.Printf("⚠️ 修改 %s 权限失败: %v", f, err)
+			}
+		}
+		data, err := s.Get(ctx, id)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+			continue
+		}
+		out = append(out, StoredAccount{ID: id, Data: data})
+	}
+	return out, nil
+}
+
+func (s *fileAccountStore) Get(ctx context.Context, id string) (AccountData, error) {
+	var acc AccountData
+	if err := validAccountID(id); err != nil {
+		return acc, err
+	}
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return acc, errAccountNotFound
+	}
+	if err != nil {
+		return acc, fmt.Errorf("读取 %s 失败: %w", s.path(id), err)
+	}
+	if err := json.Unmarshal(data, &acc); err != nil {
+		return acc, fmt.Errorf("解析 %s 失败: %w", s.path(id), err)
+	}
+	return acc, nil
+}
+
+func (s *fileAccountStore) Put(ctx context.Context, id string, data AccountData) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化账号数据失败: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(id), body, 0600); err != nil {
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic 先写入同目录下的临时文件并 fsync，再重命名覆盖，
+// 进程崩溃时目标文件要么是旧内容，要么是完整的新内容
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // 重命名成功后为空操作
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	// 同步目录，确保重命名本身落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// removeStaleTemps 清理崩溃遗留的临时文件
+func (s *fileAccountStore) removeStaleTemps() {
+	temps, _ := filepath.Glob(filepath.Join(s.dir, ".*.json.tmp-*"))
+	for _, f := range temps {
+		if info, err := os.Stat(f); err == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(f)
+		}
+	}
+}
+
+func (s *fileAccountStore) Delete(ctx context.Context, id string) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return errAccountNotFound
+	}
+	return err
+}
+
+// Watch 通过 inotify 等监听目录，收到事件后比较文件的修改时间和大小；
+// 无法监听时（如 inotify 数量超限）退回定期轮询
+func (s *fileAccountStore) Watch(ctx context.Context) (<-chan AccountChange, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	var events chan fsnotify.Event
+	var errs chan error
+	var poll *time.Ticker
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(s.dir); err != nil {
+			watcher.Close()
+		}
+	}
+	if err == nil {
+		events, errs = watcher.Events, watcher.Errors
+	} else {
+		log.Printf("⚠️ 无法监听目录 %s: %v，改为每 %v 检查一次", s.dir, err, s.pollInterval)
+		poll = time.NewTicker(s.pollInterval)
+	}
+
+	ch := make(chan AccountChange, 16)
+	prev := s.snapshot()
+	go func() {
+		defer close(ch)
+		var tick <-chan time.Time
+		if poll != nil {
+			defer poll.Stop()
+			tick = poll.C
+		} else {
+			defer watcher.Close()
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(ev.Name)
+				if !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+					continue
+				}
+			case err, ok := <-errs:
+				if !ok {
+					return
+				}
+				// 事件队列溢出等错误：全量比较即可补上遗漏的变化
+				log.Printf("⚠️ 监听目录 %s 出错: %v", s.dir, err)
+			case <-tick:
+			}
+			cur := s.snapshot()
+			for _, change := range diffAccountVersions(prev, cur) {
+				select {
+				case ch <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = cur
+		}
+	}()
+	return ch, nil
+}
+
+func (s *fileAccountStore) snapshot() map[string]string {
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	out := make(map[string]string, len(files))
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			out[strings.TrimSuffix(filepath.Base(f), ".json")] = info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+		}
+	}
+	return out
+}
+
+func (s *fileAccountStore) Close() error { return nil }
+
+// diffAccountVersions 比较两次快照（ID -> 版本），按 ID 排序输出变化
+func diffAccountVersions(prev, cur map[string]string) []AccountChange {
+	var changes []AccountChange
+	for id, v := range cur {
+		if prev[id] != v {
+			changes = append(changes, AccountChange{Op: "put", ID: id})
+		}
+	}
+	for id := range prev {
+		if _, ok := cur[id]; !ok {
+			changes = append(changes, AccountChange{Op: "delete", ID: id})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	return changes
+}
+
+// ==================== 嵌入式数据库存储 ====================
+
+var accountDataBucket = []byte("accounts")
+
+// boltAccountStore 账号保存在单个 bbolt 文件中；仅限单进程使用
+type boltAccountStore struct {
+	db *bolt.DB
+
+	mu       sync.Mutex
+	watchers map[chan AccountChange]struct{}
+}
+
+func openBoltAccountStore(path string) (*boltAccountStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开 %s 失败: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(accountDataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltAccountStore{db: db, watchers: make(map[chan AccountChange]struct{})}, nil
+}
+
+func (s *boltAccountStore) List(ctx context.Context) ([]StoredAccount, error) {
+	var out []StoredAccount
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountDataBucket).ForEach(func(k, v []byte) error {
+			var data AccountData
+			if err := json.Unmarshal(v, &data); err != nil {
+				log.Printf("⚠️ 解析账号 %s 失败: %v", k, err)
+				return nil
+			}
+			out = append(out, StoredAccount{ID: string(k), Data: data})
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *boltAccountStore) Get(ctx context.Context, id string) (AccountData, error) {
+	var data AccountData
+	if err := validAccountID(id); err != nil {
+		return data, err
+	}
+	var raw []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(accountDataBucket).Get([]byte(id)); v != nil {
+			raw = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if raw == nil {
+		return data, errAccountNotFound
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return data, fmt.Errorf("解析账号 %s 失败: %w", id, err)
+	}
+	return data, nil
+}
+
+func (s *boltAccountStore) Put(ctx context.Context, id string, data AccountData) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountDataBucket).Put([]byte(id), body)
+	})
+	if err == nil {
+		s.notify(AccountChange{Op: "put", ID: id})
+	}
+	return err
+}
+
+func (s *boltAccountStore) Delete(ctx context.Context, id string) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(accountDataBucket)
+		found = b.Get([]byte(id)) != nil
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errAccountNotFound
+	}
+	s.notify(AccountChange{Op: "delete", ID: id})
+	return nil
+}
+
+// Watch bbolt 文件被独占打开，变化只可能来自本进程
+func (s *boltAccountStore) Watch(ctx context.Context) (<-chan AccountChange, error) {
+	ch := make(chan AccountChange, 16)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (s *boltAccountStore) notify(change AccountChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+func (s *boltAccountStore) Close() error { return s.db.Close() }
+
+// ==================== Consul KV 存储 ====================
+
+// consulAccountStore 通过 Consul KV HTTP API 存储账号，Watch 使用阻塞查询
+type consulAccountStore struct {
+	base   string
+	prefix string
+	token  string
+	client *http.Client
+	wait   time.Duration // 阻塞查询的最长等待
+}
+
+type consulKVPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func newConsulAccountStore(cfg ConsulStoreConfig) (*consulAccountStore, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("storage.consul.address 未配置")
+	}
+	u, err := url.Parse(cfg.Address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("无效的 Consul 地址 %q", cfg.Address)
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "business2api/accounts/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &consulAccountStore{
+		base:   strings.TrimRight(cfg.Address, "/"),
+		prefix: strings.TrimLeft(prefix, "/"),
+		token:  cfg.Token,
+		client: &http.Client{Timeout: 6 * time.Minute},
+		wait:   5 * time.Minute,
+	}, nil
+}
+
+func (s *consulAccountStore) keyURL(id string, query url.Values) string {
+	u := s.base + "/v1/kv/" + s.prefix + url.PathEscape(id)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (s *consulAccountStore) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	return s.client.Do(req)
+}
+
+// listPairs 读取前缀下的全部键；index > 0 时为阻塞查询
+func (s *consulAccountStore) listPairs(ctx context.Context, index uint64) ([]consulKVPair, uint64, error) {
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(s.wait.Seconds())))
+	}
+	resp, err := s.do(ctx, "GET", s.keyURL("", q), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	newIndex, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if resp.StatusCode == 404 {
+		return nil, newIndex, nil
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("consul: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var pairs []consulKVPair
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, 0, fmt.Errorf("consul: 解析响应失败: %w", err)
+	}
+	return pairs, newIndex, nil
+}
+
+func (s *consulAccountStore) idOf(key string) string {
+	id, _ := url.PathUnescape(strings.TrimPrefix(key, s.prefix))
+	return id
+}
+
+func (s *consulAccountStore) List(ctx context.Context) ([]StoredAccount, error) {
+	pairs, _, err := s.listPairs(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	var out []StoredAccount
+	for _, p := range pairs {
+		id := s.idOf(p.Key)
+		if id == "" || strings.Contains(id, "/") {
+			continue // 前缀本身或子目录
+		}
+		var data AccountData
+		if err := json.Unmarshal(p.Value, &data); err != nil {
+			log.Printf("⚠️ 解析账号 %s 失败: %v", p.Key, err)
+			continue
+		}
+		out = append(out, StoredAccount{ID: id, Data: data})
+	}
+	return out, nil
+}
+
+func (s *consulAccountStore) Get(ctx context.Context, id string) (AccountData, error) {
+	var data AccountData
+	if err := validAccountID(id); err != nil {
+		return data, err
+	}
+	resp, err := s.do(ctx, "GET", s.keyURL(id, url.Values{"raw": {"true"}}), nil)
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return data, errAccountNotFound
+	}
+	if resp.StatusCode != 200 {
+		return data, fmt.Errorf("consul: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return data, fmt.Errorf("解析账号 %s 失败: %w", id, err)
+	}
+	return data, nil
+}
+
+func (s *consulAccountStore) Put(ctx context.Context, id string, data AccountData) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "PUT", s.keyURL(id, nil), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("consul: 写入 %s 失败: HTTP %d", id, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *consulAccountStore) Delete(ctx context.Context, id string) error {
+	if err := validAccountID(id); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "DELETE", s.keyURL(id, nil), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("consul: 删除 %s 失败: HTTP %d", id, resp.StatusCode)
+	}
+	return nil
+}
+
+// Watch 阻塞查询前缀，X-Consul-Index 变化时比较各键的 ModifyIndex
+func (s *consulAccountStore) Watch(ctx context.Context) (<-chan AccountChange, error) {
+	pairs, index, err := s.listPairs(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan AccountChange, 16)
+	go func() {
+		defer close(ch)
+		prev := s.versions(pairs)
+		backoff := time.Second
+		for ctx.Err() == nil {
+			pairs, newIndex, err := s.listPairs(ctx, max(index, 1))
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("⚠️ 监听 Consul 失败: %v，%v 后重试", err, backoff)
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return
+				}
+				if backoff *= 2; backoff > 30*time.Second {
+					backoff = 30 * time.Second
+				}
+				continue
+			}
+			backoff = time.Second
+			// 索引回退（如 Consul 重建）时重新开始
+			if newIndex < index {
+				newIndex = 0
+			}
+			index = newIndex
+			cur := s.versions(pairs)
+			for _, change := range diffAccountVersions(prev, cur) {
+				select {
+				case ch <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = cur
+		}
+	}()
+	return ch, nil
+}
+
+func (s *consulAccountStore) versions(pairs []consulKVPair) map[string]string {
+	out := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		if id := s.idOf(p.Key); id != "" && !strings.Contains(id, "/") {
+			out[id] = strconv.FormatUint(p.ModifyIndex, 10)
+		}
+	}
+	return out
+}
+
+func (s *consulAccountStore) Close() error { return nil }
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useAccountStore 替换全局账号存储，测试结束后恢复
+func useAccountStore(t *testing.T, store AccountStore) {
+	t.Helper()
+	old := accountStore
+	accountStore = store
+	t.Cleanup(func() {
+		store.Close()
+		accountStore = old
+	})
+}
+
+// fakeConsul 内存中的 Consul KV，支持 recurse、raw 与阻塞查询
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	kv      map[string]consulKVPair
+	changed chan struct{}
+	token   string
+}
+
+func newFakeConsul(t *testing.T, token string) *httptest.Server {
+	f := &fakeConsul{index: 1, kv: make(map[string]consulKVPair), changed: make(chan struct{}), token: token}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.token != "" && r.Header.Get("X-Consul-Token") != f.token {
+		http.Error(w, "ACL not found", 403)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	q := r.URL.Query()
+	switch r.Method {
+	case "PUT", "DELETE":
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.index++
+		if r.Method == "PUT" {
+			f.kv[key] = consulKVPair{Key: key, Value: body, ModifyIndex: f.index}
+		} else {
+			delete(f.kv, key)
+		}
+		close(f.changed)
+		f.changed = make(chan struct{})
+		f.mu.Unlock()
+		w.Write([]byte("true"))
+		return
+	}
+
+	if idx, _ := strconv.ParseUint(q.Get("index"), 10, 64); idx > 0 {
+		wait, _ := time.ParseDuration(q.Get("wait"))
+		f.mu.Lock()
+		cur, changed := f.index, f.changed
+		f.mu.Unlock()
+		if idx >= cur {
+			select {
+			case <-changed:
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if q.Has("recurse") {
+		var pairs []consulKVPair
+		for k, p := range f.kv {
+			if strings.HasPrefix(k, key) {
+				pairs = append(pairs, p)
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(404)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		json.NewEncoder(w).Encode(pairs)
+		return
+	}
+	p, ok := f.kv[key]
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+	if q.Has("raw") {
+		w.Write(p.Value)
+		return
+	}
+	json.NewEncoder(w).Encode([]consulKVPair{p})
+}
+
+func testAccountData(email string) AccountData {
+	return AccountData{
+		Email:   email,
+		CSESIDX: "12345",
+		Cookies: []Cookie{{Name: "__Secure-C_SES", Value: "ses-" + email}},
+	}
+}
+
+func waitAccountChange(t *testing.T, ch <-chan AccountChange, want AccountChange) {
+	t.Helper()
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case got, ok := <-ch:
+			if !ok {
+				t.Fatal("监听通道已关闭")
+			}
+			if got == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("未收到变化 %+v", want)
+		}
+	}
+}
+
+func TestAccountStoreBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) AccountStore{
+		"file": func(t *testing.T) AccountStore {
+			s := newFileAccountStore(t.TempDir())
+			s.pollInterval = 20 * time.Millisecond
+			return s
+		},
+		"bolt": func(t *testing.T) AccountStore {
+			s, err := openBoltAccountStore(filepath.Join(t.TempDir(), "meta", "accounts.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"consul": func(t *testing.T) AccountStore {
+			srv := newFakeConsul(t, "secret-token")
+			s, err := newConsulAccountStore(ConsulStoreConfig{Address: srv.URL, Prefix: "gw/accounts", Token: "secret-token"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.wait = time.Second
+			return s
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			list, err := store.List(ctx)
+			if err != nil || len(list) != 0 {
+				t.Fatalf("空存储 List = %v, %v", list, err)
+			}
+			if _, err := store.Get(ctx, "missing@test.local"); !errors.Is(err, errAccountNotFound) {
+				t.Fatalf("Get 不存在的账号: %v", err)
+			}
+			for _, id := range []string{"", "..", "a/b", `a\b`} {
+				if err := store.Put(ctx, id, testAccountData("x@test.local")); err == nil {
+					t.Fatalf("Put(%q) 应失败", id)
+				}
+			}
+
+			changes, err := store.Watch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Put(ctx, "a@test.local", testAccountData("a@test.local")); err != nil {
+				t.Fatal(err)
+			}
+			waitAccountChange(t, changes, AccountChange{Op: "put", ID: "a@test.local"})
+			if err := store.Put(ctx, "b@test.local", testAccountData("b@test.local")); err != nil {
+				t.Fatal(err)
+			}
+			waitAccountChange(t, changes, AccountChange{Op: "put", ID: "b@test.local"})
+
+			got, err := store.Get(ctx, "a@test.local")
+			if err != nil || got.Email != "a@test.local" || len(got.Cookies) != 1 || got.Cookies[0].Value != "ses-a@test.local" {
+				t.Fatalf("Get = %+v, %v", got, err)
+			}
+			list, err = store.List(ctx)
+			if err != nil || len(list) != 2 {
+				t.Fatalf("List = %+v, %v", list, err)
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+			if list[0].ID != "a@test.local" || list[1].Data.Email != "b@test.local" {
+				t.Fatalf("List = %+v", list)
+			}
+
+			if err := store.Delete(ctx, "a@test.local"); err != nil {
+				t.Fatal(err)
+			}
+			waitAccountChange(t, changes, AccountChange{Op: "delete", ID: "a@test.local"})
+			if _, err := store.Get(ctx, "a@test.local"); !errors.Is(err, errAccountNotFound) {
+				t.Fatalf("删除后 Get: %v", err)
+			}
+
+			cancel()
+			for range changes {
+			}
+		})
+	}
+}
+
+func TestConsulAccountStoreRejectsBadToken(t *testing.T) {
+	srv := newFakeConsul(t, "secret-token")
+	store, err := newConsulAccountStore(ConsulStoreConfig{Address: srv.URL, Token: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.List(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("List 应返回 403 错误: %v", err)
+	}
+	if _, err := newConsulAccountStore(ConsulStoreConfig{}); err == nil {
+		t.Fatal("未配置地址时应失败")
+	}
+}
+
+func TestNewAccountStoreBackendSelection(t *testing.T) {
+	dir := t.TempDir()
+	if s, err := newAccountStore(StorageConfig{}, dir); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.(*fileAccountStore); !ok {
+		t.Fatalf("默认后端 = %T", s)
+	}
+	s, err := newAccountStore(StorageConfig{Backend: "bolt"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := os.Stat(filepath.Join(dir, "meta", "accounts.db")); err != nil {
+		t.Fatal("bolt 默认路径未创建")
+	}
+	if _, err := newAccountStore(StorageConfig{Backend: "redis"}, dir); err == nil {
+		t.Fatal("未知后端应失败")
+	}
+}
+
+func TestPoolLoadPicksUpStoreChanges(t *testing.T) {
+	store, err := openBoltAccountStore(filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useAccountStore(t, store)
+	ctx := context.Background()
+	data := testAccountData("a@test.local")
+	data.Timestamp = "2026-01-01T00:00:00Z"
+	store.Put(ctx, "a@test.local", data)
+	store.Put(ctx, "b@test.local", testAccountData("b@test.local"))
+
+	p := &AccountPool{}
+	if err := p.Load(store); err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalCount() != 2 {
+		t.Fatalf("加载账号数 = %d", p.TotalCount())
+	}
+	var a *Account
+	for _, acc := range p.pendingAccounts {
+		if acc.ID == "a@test.local" {
+			a = acc
+		}
+	}
+
+	// 另一个实例更新了凭据并删除了 b
+	data.Timestamp = "2026-01-02T00:00:00Z"
+	data.Cookies[0].Value = "ses-new"
+	store.Put(ctx, "a@test.local", data)
+	store.Delete(ctx, "b@test.local")
+	if err := p.Load(store); err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalCount() != 1 || p.pendingAccounts[0] != a {
+		t.Fatalf("重新加载后账号: %d", p.TotalCount())
+	}
+	if a.Data.Cookies[0].Value != "ses-new" {
+		t.Fatal("未更新已有账号的凭据")
+	}
+
+	// Save 写回存储
+	a.Data.FullName = "Alice"
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := store.Get(ctx, "a@test.local")
+	if saved.FullName != "Alice" || saved.CookieString != "__Secure-C_SES=ses-new" {
+		t.Fatalf("保存的数据: %+v", saved)
+	}
+}
+
+func TestFileAccountStoreAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileAccountStore(dir)
+	ctx := context.Background()
+
+	// 旧版本写入的 0644 文件，以及崩溃遗留的临时文件
+	legacy := filepath.Join(dir, "old@test.local.json")
+	data, _ := json.Marshal(testAccountData("old@test.local"))
+	os.WriteFile(legacy, data, 0644)
+	stale := filepath.Join(dir, ".old@test.local.json.tmp-123")
+	os.WriteFile(stale, []byte(`{"email": "trunc`), 0600)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stale, old, old)
+
+	if err := store.Put(ctx, "a@test.local", testAccountData("a@test.local")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a@test.local.json"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("新文件权限 = %v, %v", info.Mode().Perm(), err)
+	}
+
+	items, err := store.List(ctx)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("List = %+v, %v", items, err)
+	}
+	if info, _ := os.Stat(legacy); info.Mode().Perm() != 0600 {
+		t.Fatalf("旧文件权限未收紧: %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("遗留的临时文件未清理")
+	}
+	temps, _ := filepath.Glob(filepath.Join(dir, ".*"))
+	if len(temps) != 0 {
+		t.Fatalf("写入后残留临时文件: %v", temps)
+	}
+}
+
+func TestFileAccountStoreWatchesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileAccountStore(dir)
+	store.pollInterval = time.Hour // 只依赖文件系统通知
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := store.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 外部工具直接放入、修改、删除账号文件
+	path := filepath.Join(dir, "ext@test.local.json")
+	data, _ := json.Marshal(testAccountData("ext@test.local"))
+	os.WriteFile(path, data, 0600)
+	waitAccountChange(t, changes, AccountChange{Op: "put", ID: "ext@test.local"})
+
+	if err := store.Put(ctx, "ext@test.local", testAccountData("ext2@test.local")); err != nil {
+		t.Fatal(err)
+	}
+	waitAccountChange(t, changes, AccountChange{Op: "put", ID: "ext@test.local"})
+
+	os.Remove(path)
+	waitAccountChange(t, changes, AccountChange{Op: "delete", ID: "ext@test.local"})
+
+	// 临时文件和非 JSON 文件不产生变化
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0600)
+	select {
+	case change := <-changes:
+		t.Fatalf("意外的变化: %+v", change)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestAccountWatcherStopsWithLifecycle(t *testing.T) {
+	lc := useLifecycle(t)
+	useAccountStore(t, newFileAccountStore(t.TempDir()))
+
+	startAccountWatcher()
+	lc.mu.Lock()
+	running := lc.running["account-watcher"]
+	lc.mu.Unlock()
+	if running != 1 {
+		t.Fatalf("账号监听未登记为后台任务: %d", running)
+	}
+	// Stop 须等到监听退出，之后才能安全关闭存储
+	if !lc.Stop(time.Second) {
+		t.Fatal("账号监听未随 Stop 退出")
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if len(lc.running) != 0 {
+		t.Fatalf("仍有后台任务在运行: %v", lc.running)
+	}
+}
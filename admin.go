@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 管理员鉴权 ====================
+
+// 管理员角色：viewer 只读，operator 可触发刷新/注册，owner 可修改配置与 Key
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleOwner    = "owner"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleOwner: 3}
+
+// AdminCredential 管理员凭据，token 与 token_sha256 二选一
+type AdminCredential struct {
+	Name        string `json:"name"`
+	Token       string `json:"token,omitempty"`
+	TokenSHA256 string `json:"token_sha256,omitempty"`
+	Role        string `json:"role"`
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Credentials []AdminCredential `json:"credentials"`
+	AuditLog    string            `json:"audit_log"` // 审计日志路径，默认 <data_dir>/meta/audit.log
+}
+
+// AdminActor 通过鉴权的管理员
+type AdminActor struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+const ctxAdminActor = "adminActor"
+
+// adminAuthenticator 按 token 哈希索引管理员凭据；没有任何凭据时拒绝所有管理请求
+type adminAuthenticator struct {
+	byHash map[string]AdminActor
+}
+
+var adminAuthState = &adminAuthenticator{}
+
+// initAdminAuth 加载管理员凭据；未配置时 /admin 不可用（api_keys 只用于推理接口，不会兼作管理员凭据）
+func initAdminAuth() {
+	cfg := currentConfig()
+	auth := &adminAuthenticator{byHash: make(map[string]AdminActor)}
+	for i, cred := range cfg.Admin.Credentials {
+		if roleRank[cred.Role] == 0 {
+			log.Printf("⚠️ 管理员凭据 #%d (%s) 角色无效: %q，已忽略", i, cred.Name, cred.Role)
+			continue
+		}
+		hash := strings.ToLower(cred.TokenSHA256)
+		if cred.Token != "" {
+			hash = hashAPIKey(cred.Token)
+		}
+		if hash == "" {
+			log.Printf("⚠️ 管理员凭据 #%d (%s) 缺少 token，已忽略", i, cred.Name)
+			continue
+		}
+		name := cred.Name
+		if name == "" {
+			name = fmt.Sprintf("admin-%d", i)
+		}
+		auth.byHash[hash] = AdminActor{Name: name, Role: cred.Role}
+	}
+
+	if len(auth.byHash) == 0 {
+		log.Println("⚠️ 未配置管理员凭据，/admin 已禁用；请在 admin.credentials 或 ADMIN_TOKEN 中配置")
+	} else {
+		log.Printf("🛡️ 已加载 %d 个管理员凭据", len(auth.byHash))
+	}
+	adminAuthState = auth
+}
+
+// adminAuth 校验管理员凭据（Authorization: Bearer 或 X-Admin-Token）
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := adminAuthState
+		if len(auth.byHash) == 0 {
+			c.AbortWithStatusJSON(403, gin.H{"error": "管理接口未启用：未配置管理员凭据（admin.credentials 或 ADMIN_TOKEN）"})
+			return
+		}
+		token, fromCookie := adminToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Missing admin token"})
+			return
+		}
+		actor, ok := auth.byHash[hashAPIKey(token)]
+		if !ok {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		// 仅凭 Cookie 的修改请求必须带自定义头，跨站表单无法伪造
+		if fromCookie && c.Request.Method != "GET" && c.Request.Method != "HEAD" &&
+			c.GetHeader("X-Requested-With") != dashboardRequestedWith {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Missing X-Requested-With header"})
+			return
+		}
+		c.Set(ctxAdminActor, actor)
+		c.Next()
+	}
+}
+
+// adminToken 依次从 X-Admin-Token、Authorization、控制台登录 Cookie 中取凭据
+func adminToken(c *gin.Context) (token string, fromCookie bool) {
+	if token = c.GetHeader("X-Admin-Token"); token != "" {
+		return token, false
+	}
+	if token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		return token, false
+	}
+	token, _ = c.Cookie(adminSessionCookie)
+	return token, token != ""
+}
+
+// requireRole 要求不低于指定角色
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := adminActor(c)
+		if roleRank[actor.Role] < roleRank[role] {
+			c.AbortWithStatusJSON(403, gin.H{"error": fmt.Sprintf("需要 %s 角色，当前为 %s", role, actor.Role)})
+			return
+		}
+		c.Next()
+	}
+}
+
+func adminActor(c *gin.Context) AdminActor {
+	if v, ok := c.Get(ctxAdminActor); ok {
+		return v.(AdminActor)
+	}
+	return AdminActor{}
+}
+
+// ==================== 审计日志 ====================
+
+// AuditEntry 一次修改类管理操作
+type AuditEntry struct {
+	Time     time.Time              `json:"time"`
+	Actor    string                 `json:"actor"`
+	Role     string                 `json:"role"`
+	ClientIP string                 `json:"client_ip"`
+	Method   string                 `json:"method"`
+	Path     string                 `json:"path"`
+	Query    string                 `json:"query,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+	Status   int                    `json:"status"`
+}
+
+// auditLogger 追加写入 JSONL 文件，并在内存中保留最近的记录
+type auditLogger struct {
+	mu     sync.Mutex
+	path   string
+	recent []AuditEntry
+}
+
+const auditRecentMax = 1000
+
+var auditLog = &auditLogger{}
+
+func initAuditLog() {
+	path := currentConfig().Admin.AuditLog
+	if path == "" {
+		path = filepath.Join(DataDir, "meta", "audit.log")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Printf("❌ 创建审计日志目录失败: %v", err)
+		return
+	}
+	auditLog = &auditLogger{path: path, recent: readAuditTail(path, auditRecentMax)}
+}
+
+// readAuditTail 启动时加载最近的审计记录
+func readAuditTail(path string, n int) []AuditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+func (a *auditLogger) Record(e AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.recent = append(a.recent, e)
+	if len(a.recent) > auditRecentMax {
+		a.recent = a.recent[len(a.recent)-auditRecentMax:]
+	}
+	if a.path == "" {
+		return
+	}
+	line, _ := json.Marshal(e)
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("⚠️ 写入审计日志失败: %v", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// Recent 返回最近 n 条记录（新的在前）
+func (a *auditLogger) Recent(n int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n <= 0 || n > len(a.recent) {
+		n = len(a.recent)
+	}
+	out := make([]AuditEntry, 0, n)
+	for i := len(a.recent) - 1; i >= len(a.recent)-n; i-- {
+		out = append(out, a.recent[i])
+	}
+	return out
+}
+
+// 审计参数中需要脱敏的字段
+var auditSecretFields = []string{"token", "password", "secret", "authorization", "cookie"}
+
+func redactAuditParams(params map[string]interface{}) {
+	for k, v := range params {
+		lower := strings.ToLower(k)
+		for _, f := range auditSecretFields {
+			if strings.Contains(lower, f) {
+				params[k] = redactedMark
+			}
+		}
+		if nested, ok := v.(map[string]interface{}); ok && params[k] != redactedMark {
+			redactAuditParams(nested)
+		}
+	}
+}
+
+// auditMiddleware 记录所有修改类管理请求（含被拒绝的请求）
+func auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" {
+			c.Next()
+			return
+		}
+		var params map[string]interface{}
+		if c.Request.Body != nil {
+			// 只读取前 1MB 用于记录，其余部分原样留给处理函数（如账号导入）
+			body, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+			if json.Unmarshal(body, &params) == nil {
+				redactAuditParams(params)
+			}
+		}
+
+		c.Next()
+
+		actor := adminActor(c)
+		auditLog.Record(AuditEntry{
+			Time:     time.Now(),
+			Actor:    actor.Name,
+			Role:     actor.Role,
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			Query:    c.Request.URL.RawQuery,
+			Params:   params,
+			Status:   c.Writer.Status(),
+		})
+		log.Printf("📝 审计: %s(%s) %s %s -> %d", actor.Name, actor.Role, c.Request.Method, c.Request.URL.Path, c.Writer.Status())
+	}
+}
+
+func registerAuditRoutes(admin *gin.RouterGroup) {
+	admin.GET("/audit", requireRole(RoleOwner), func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		c.JSON(200, gin.H{"entries": auditLog.Recent(limit)})
+	})
+	admin.GET("/whoami", func(c *gin.Context) {
+		c.JSON(200, adminActor(c))
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useAdminCredentials 以给定凭据重新初始化管理员鉴权（需先调用 newTestGateway 以便恢复）
+func useAdminCredentials(creds ...AdminCredential) {
+	updateConfig(func(cfg *AppConfig) { cfg.Admin.Credentials = creds })
+	initAdminAuth()
+}
+
+func (g *testGateway) get(t *testing.T, path string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return g.serve(httptest.NewRequest("GET", path, nil), headers)
+}
+
+func TestAdminRoles(t *testing.T) {
+	g := newTestGateway(t, 1)
+	useAdminCredentials(
+		AdminCredential{Name: "alice", Token: "tok-viewer", Role: RoleViewer},
+		AdminCredential{Name: "bob", Token: "tok-operator", Role: RoleOperator},
+		AdminCredential{Name: "carol", TokenSHA256: hashAPIKey("tok-owner"), Role: RoleOwner},
+	)
+	keyStore.SetLegacyKeys([]string{"sk-client"})
+
+	cooldown := map[string]int{"refresh_cooldown_sec": 240, "use_cooldown_sec": 15}
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/admin/status", "", 401},
+		{"GET", "/admin/status", "sk-client", 401}, // 客户端 Key 不能访问管理接口
+		{"GET", "/admin/status", "tok-viewer", 200},
+		{"POST", "/admin/force-refresh", "tok-viewer", 403},
+		{"POST", "/admin/force-refresh", "tok-operator", 200},
+		{"POST", "/admin/config/cooldown", "tok-operator", 403},
+		{"POST", "/admin/config/cooldown", "tok-owner", 200},
+		{"GET", "/admin/audit", "tok-operator", 403},
+	}
+	for _, tc := range cases {
+		var w *httptest.ResponseRecorder
+		if tc.method == "GET" {
+			w = g.get(t, tc.path, "X-Admin-Token", tc.token)
+		} else {
+			w = g.post(t, tc.path, cooldown, "Authorization", "Bearer "+tc.token)
+		}
+		if w.Code != tc.want {
+			t.Errorf("%s %s (%s): 状态码 = %d, 期望 %d", tc.method, tc.path, tc.token, w.Code, tc.want)
+		}
+	}
+}
+
+// 未配置管理员凭据时 /admin 不可用，api_keys 也不能兼作管理员凭据
+func TestAdminDisabledWithoutCredentials(t *testing.T) {
+	g := newTestGateway(t, 1)
+	updateConfig(func(cfg *AppConfig) { cfg.APIKeys = []string{"sk-client"} })
+	keyStore.SetLegacyKeys([]string{"sk-client"})
+	useAdminCredentials()
+
+	for _, token := range []string{"", "sk-client"} {
+		if w := g.get(t, "/admin/status", "X-Admin-Token", token); w.Code != 403 {
+			t.Errorf("token %q: 状态码 = %d, 期望 403", token, w.Code)
+		}
+	}
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer("sk-client")...); w.Code != 200 {
+		t.Fatalf("推理接口不受影响: %d", w.Code)
+	}
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	g := newTestGateway(t, 1)
+	useAdminCredentials(
+		AdminCredential{Name: "bob", Token: "tok-operator", Role: RoleOperator},
+		AdminCredential{Name: "carol", Token: "tok-owner", Role: RoleOwner},
+	)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog = &auditLogger{path: path}
+
+	g.post(t, "/admin/config/cooldown", map[string]int{"refresh_cooldown_sec": 300, "use_cooldown_sec": 20}, "X-Admin-Token", "tok-owner")
+	g.post(t, "/admin/config/cooldown", map[string]interface{}{"refresh_cooldown_sec": 1, "token": "s3cret"}, "X-Admin-Token", "tok-operator")
+	g.get(t, "/admin/status", "X-Admin-Token", "tok-owner") // 只读请求不记录
+
+	w := g.get(t, "/admin/audit", "X-Admin-Token", "tok-owner")
+	var resp struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Entries) != 2 {
+		t.Fatalf("审计记录数 = %d: %s", len(resp.Entries), w.Body.String())
+	}
+	denied, allowed := resp.Entries[0], resp.Entries[1]
+	if denied.Actor != "bob" || denied.Status != 403 || denied.Params["token"] != redactedMark {
+		t.Fatalf("被拒绝的请求记录错误: %+v", denied)
+	}
+	if allowed.Actor != "carol" || allowed.Role != RoleOwner || allowed.Path != "/admin/config/cooldown" ||
+		allowed.Status != 200 || allowed.Params["use_cooldown_sec"] != float64(20) {
+		t.Fatalf("审计记录错误: %+v", allowed)
+	}
+
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 2 || strings.Contains(string(data), "s3cret") {
+		t.Fatalf("审计文件内容错误: %s", data)
+	}
+	if tail := readAuditTail(path, 1); len(tail) != 1 || tail[0].Actor != "bob" {
+		t.Fatalf("读取审计文件失败: %+v", tail)
+	}
+}
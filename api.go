@@ -34,14 +34,23 @@ type GeminiInlineData struct {
 
 // handleGeminiGenerate 处理Gemini generateContent API格式的请求
 func handleGeminiGenerate(c *gin.Context) {
-	model := c.Param("model")
+	// 路径形如 /{model}:generateContent 或 /{model}:streamGenerateContent
+	model, method, _ := strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+	if method != "" && method != "generateContent" && method != "streamGenerateContent" {
+		respondError(c, 404, "not_found_error", fmt.Sprintf("不支持的方法: %s", method))
+		return
+	}
 	if model == "" {
-		model = FixedModels[0]
+		model = availableModels()[0]
 	}
 
 	var geminiReq GeminiRequest
 	if err := c.ShouldBindJSON(&geminiReq); err != nil {
-		c.JSON(400, gin.H{"error": gin.H{"code": 400, "message": err.Error(), "status": "INVALID_ARGUMENT"}})
+		respondError(c, 400, "invalid_request_error", err.Error())
+		return
+	}
+	if verr := validateGeminiRequest(&geminiReq); verr != nil {
+		respondValidationError(c, verr)
 		return
 	}
 
@@ -77,7 +86,7 @@ func handleGeminiGenerate(c *gin.Context) {
 			if part.InlineData != nil {
 				contentParts = append(contentParts, map[string]interface{}{
 					"type": "image_url",
-					"image_url": map[string]string{
+					"image_url": map[string]interface{}{
 						"url": fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
 					},
 				})
@@ -129,29 +138,60 @@ func handleGeminiGenerate(c *gin.Context) {
 }
 
 type ClaudeRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	System      string    `json:"system,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Stream      bool      `json:"stream"`
-	Temperature float64   `json:"temperature,omitempty"`
-	Tools       []ToolDef `json:"tools,omitempty"`
+	Model       string       `json:"model"`
+	Messages    []Message    `json:"messages"`
+	System      string       `json:"system,omitempty"`
+	MaxTokens   int          `json:"max_tokens,omitempty"`
+	Stream      bool         `json:"stream"`
+	Temperature float64      `json:"temperature,omitempty"`
+	Tools       []ClaudeTool `json:"tools,omitempty"`
+}
+
+// ClaudeTool Claude 原生工具定义（name/input_schema），同时兼容 OpenAI 格式的 function 工具
+type ClaudeTool struct {
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+	ToolDef
+}
+
+// toToolDef 转换为内部使用的 OpenAI 格式工具定义
+func (t ClaudeTool) toToolDef() ToolDef {
+	if t.Function.Name != "" {
+		return t.ToolDef
+	}
+	return ToolDef{Type: "function", Function: FunctionDef{Name: t.Name, Description: t.Description, Parameters: t.InputSchema}}
+}
+
+func convertClaudeTools(tools []ClaudeTool) []ToolDef {
+	if len(tools) == 0 {
+		return nil
+	}
+	out := make([]ToolDef, len(tools))
+	for i, t := range tools {
+		out[i] = t.toToolDef()
+	}
+	return out
 }
 
 // handleClaudeMessages 处理Claude Messages API格式的请求
 func handleClaudeMessages(c *gin.Context) {
 	var claudeReq ClaudeRequest
 	if err := c.ShouldBindJSON(&claudeReq); err != nil {
-		c.JSON(400, gin.H{"type": "error", "error": gin.H{"type": "invalid_request_error", "message": err.Error()}})
+		respondError(c, 400, "invalid_request_error", err.Error())
+		return
+	}
+	if verr := validateClaudeRequest(&claudeReq); verr != nil {
+		respondValidationError(c, verr)
 		return
 	}
 
 	req := ChatRequest{
 		Model:       claudeReq.Model,
-		Messages:    claudeReq.Messages,
+		Messages:    convertClaudeMessages(claudeReq.Messages),
 		Stream:      claudeReq.Stream,
 		Temperature: claudeReq.Temperature,
-		Tools:       claudeReq.Tools,
+		Tools:       convertClaudeTools(claudeReq.Tools),
 	}
 
 	// 如果Claude格式有单独的system字段，插入到messages开头
@@ -162,7 +202,7 @@ func handleClaudeMessages(c *gin.Context) {
 
 	// 保持模型名原样，不做映射
 	if req.Model == "" {
-		req.Model = FixedModels[0]
+		req.Model = availableModels()[0]
 	}
 
 	streamChat(c, req)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 多协议错误响应 ====================
+
+// apiDialect 客户端使用的 API 协议
+type apiDialect int
+
+const (
+	dialectOpenAI apiDialect = iota
+	dialectClaude
+	dialectGemini
+)
+
+// dialectOf 根据请求路径判断协议
+func dialectOf(c *gin.Context) apiDialect {
+	path := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"):
+		return dialectClaude
+	case strings.HasPrefix(path, "/v1beta/models/"), strings.HasPrefix(path, "/v1/models/"):
+		return dialectGemini
+	default:
+		return dialectOpenAI
+	}
+}
+
+// Gemini 错误状态（google.rpc.Code）
+var geminiStatus = map[int]string{
+	400: "INVALID_ARGUMENT",
+	401: "UNAUTHENTICATED",
+	403: "PERMISSION_DENIED",
+	404: "NOT_FOUND",
+	429: "RESOURCE_EXHAUSTED",
+	500: "INTERNAL",
+	503: "UNAVAILABLE",
+	504: "DEADLINE_EXCEEDED",
+}
+
+// errorBody 按协议构造错误响应体；errType 使用 OpenAI/Claude 的错误类型（如 invalid_request_error）
+func errorBody(dialect apiDialect, status int, errType, message, param string) gin.H {
+	switch dialect {
+	case dialectClaude:
+		return gin.H{"type": "error", "error": gin.H{"type": errType, "message": message}}
+	case dialectGemini:
+		statusName, ok := geminiStatus[status]
+		if !ok {
+			statusName = "UNKNOWN"
+		}
+		body := gin.H{"code": status, "message": message, "status": statusName}
+		if param != "" {
+			body["details"] = []gin.H{{
+				"@type":           "type.googleapis.com/google.rpc.BadRequest",
+				"fieldViolations": []gin.H{{"field": param, "description": message}},
+			}}
+		}
+		return gin.H{"error": body}
+	default:
+		body := gin.H{"message": message, "type": errType, "code": nil}
+		if param != "" {
+			body["param"] = param
+		}
+		return gin.H{"error": body}
+	}
+}
+
+// respondError 以客户端协议返回错误并中止请求
+func respondError(c *gin.Context, status int, errType, message string) {
+	c.AbortWithStatusJSON(status, errorBody(dialectOf(c), status, errType, message, ""))
+}
+
+// respondRateLimited 返回 429 和 Retry-After；Gemini 额外附带 google.rpc.RetryInfo
+func respondRateLimited(c *gin.Context, retryAfter int, message string) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	dialect := dialectOf(c)
+	body := errorBody(dialect, 429, "rate_limit_error", message, "")
+	if dialect == dialectGemini {
+		body["error"].(gin.H)["details"] = []gin.H{{
+			"@type":      "type.googleapis.com/google.rpc.RetryInfo",
+			"retryDelay": fmt.Sprintf("%ds", retryAfter),
+		}}
+	}
+	c.AbortWithStatusJSON(429, body)
+}
+
+// respondValidationError 返回字段级 400 错误
+func respondValidationError(c *gin.Context, verr *ValidationError) {
+	c.AbortWithStatusJSON(400, errorBody(dialectOf(c), 400, "invalid_request_error", verr.Error(), verr.Field))
+}
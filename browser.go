@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -127,9 +128,10 @@ func generateCustomDomainEmail(domain string) string {
 
 // isQQImapConfigured 检查是否配置了IMAP邮箱（支持任何IMAP服务：Gmail, QQ, 163等）
 func isQQImapConfigured() bool {
-	return appConfig.Email.RegisterDomain != "" &&
-		appConfig.Email.QQImap.Address != "" &&
-		appConfig.Email.QQImap.AuthCode != ""
+	cfg := currentConfig().Email
+	return cfg.RegisterDomain != "" &&
+		cfg.QQImap.Address != "" &&
+		cfg.QQImap.AuthCode != ""
 }
 
 func getTemporaryEmail() (string, error) {
@@ -138,8 +140,9 @@ func getTemporaryEmail() (string, error) {
 	// 优先使用自定义域名（IMAP邮箱转发方案）
 	if isQQImapConfigured() {
 		log.Printf("✅ [临时邮箱] 检测到IMAP邮箱配置，使用自定义域名")
-		email := generateCustomDomainEmail(appConfig.Email.RegisterDomain)
-		log.Printf("✅ [临时邮箱] 生成自定义域名邮箱: %s (转发到 %s)", email, appConfig.Email.QQImap.Address)
+		cfg := currentConfig().Email
+		email := generateCustomDomainEmail(cfg.RegisterDomain)
+		log.Printf("✅ [临时邮箱] 生成自定义域名邮箱: %s (转发到 %s)", email, cfg.QQImap.Address)
 		return email, nil
 	}
 
@@ -205,7 +208,7 @@ func getEmailFromProvider(provider TempMailProvider) (string, error) {
 
 // testQQImapConnection 测试IMAP邮箱连接
 func testQQImapConnection() {
-	cfg := appConfig.Email.QQImap
+	cfg := currentConfig().Email.QQImap
 	if cfg.Address == "" || cfg.AuthCode == "" {
 		log.Println("❌ IMAP邮箱未配置，请在 config.json 中配置 email.qq_imap")
 		return
@@ -326,7 +329,7 @@ func testQQImapConnection() {
 // targetEmail: 注册用的邮箱地址（用于匹配收件人）
 // maxWait: 最大等待时间
 func getVerificationCodeFromQQMail(targetEmail string, maxWait time.Duration) (string, error) {
-	cfg := appConfig.Email.QQImap
+	cfg := currentConfig().Email.QQImap
 	if cfg.Address == "" || cfg.AuthCode == "" {
 		return "", fmt.Errorf("IMAP邮箱未配置")
 	}
@@ -1617,7 +1620,7 @@ afterEmailSubmit:
 		if isQQImapConfigured() {
 			// IMAP邮箱方案：直接获取验证码
 			log.Printf("📬 [注册 %d] 使用IMAP邮箱获取验证码 (IMAP邮箱: %s, 目标邮箱: %s)...",
-				threadID, appConfig.Email.QQImap.Address, email)
+				threadID, currentConfig().Email.QQImap.Address, email)
 			code, codeErr = getVerificationCode(email, maxWaitTime)
 		} else {
 			log.Printf("📨 [注册 %d] 使用临时邮箱API获取验证码...", threadID)
@@ -2015,7 +2018,7 @@ func min(a, b int) int {
 }
 
 // SaveBrowserRegisterResult 保存注册结果
-func SaveBrowserRegisterResult(result *BrowserRegisterResult, dataDir string) error {
+func SaveBrowserRegisterResult(result *BrowserRegisterResult) error {
 	log.Printf("💾 [保存账号] 开始保存注册结果...")
 	log.Printf("📧 [保存账号] 邮箱: %s", result.Email)
 
@@ -2042,23 +2045,15 @@ func SaveBrowserRegisterResult(result *BrowserRegisterResult, dataDir string) er
 	log.Printf("   • Cookies数量: %d", len(data.Cookies))
 	log.Printf("   • Timestamp: %s", data.Timestamp)
 
-	log.Printf("🔄 [保存账号] 序列化为JSON...")
-	jsonData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Printf("❌ [保存账号] 序列化失败: %v", err)
-		return fmt.Errorf("序列化失败: %w", err)
-	}
-	log.Printf("✅ [保存账号] JSON大小: %d 字节", len(jsonData))
-
-	filename := filepath.Join(dataDir, fmt.Sprintf("%s.json", result.Email))
-	log.Printf("💾 [保存账号] 写入文件: %s", filename)
+	id := accountIDForEmail(result.Email)
+	log.Printf("💾 [保存账号] 写入账号存储: %s", id)
 
-	if err := os.WriteFile(filename, jsonData, 0644); err != nil {
-		log.Printf("❌ [保存账号] 写入文件失败: %v", err)
-		return fmt.Errorf("写入文件失败: %w", err)
+	if err := accountStore.Put(context.Background(), id, data); err != nil {
+		log.Printf("❌ [保存账号] 写入失败: %v", err)
+		return fmt.Errorf("写入账号存储失败: %w", err)
 	}
 
-	log.Printf("✅ [保存账号] 账号保存成功: %s", filename)
+	log.Printf("✅ [保存账号] 账号保存成功: %s", id)
 	return nil
 }
 
@@ -2333,11 +2328,11 @@ func RefreshCookieWithBrowser(acc *Account, headless bool, proxy string) *Browse
 		maxWaitTime := 3 * time.Minute
 
 		// 判断是否使用IMAP邮箱（检查邮箱域名是否匹配配置的注册域名）
-		useQQImap := isQQImapConfigured() && strings.HasSuffix(email, "@"+appConfig.Email.RegisterDomain)
+		useQQImap := isQQImapConfigured() && strings.HasSuffix(email, "@"+currentConfig().Email.RegisterDomain)
 
 		if useQQImap {
 			// IMAP邮箱方案
-			log.Printf("[Cookie刷新] [%s] 使用IMAP邮箱获取验证码 (邮箱: %s)...", email, appConfig.Email.QQImap.Address)
+			log.Printf("[Cookie刷新] [%s] 使用IMAP邮箱获取验证码 (邮箱: %s)...", email, currentConfig().Email.QQImap.Address)
 			code, codeErr = getVerificationCode(email, maxWaitTime)
 		} else {
 			// 临时邮箱方案
@@ -2510,15 +2505,17 @@ extractResult:
 	return result
 }
 
-// NativeRegisterWorker 原生 Go 注册 worker
-func NativeRegisterWorker(id int, dataDirAbs string) {
+// NativeRegisterWorker 原生 Go 注册 worker；ctx 取消后不再开始新任务，进行中的任务完成并保存后退出
+func NativeRegisterWorker(ctx context.Context, id int) {
 	log.Printf("🏁 [注册线程 %d] 线程启动，延迟 %d 秒后开始工作", id, id*3)
-	time.Sleep(time.Duration(id) * 3 * time.Second)
+	if !sleepCtx(ctx, time.Duration(id)*3*time.Second) {
+		return
+	}
 
 	taskCount := 0
-	for atomic.LoadInt32(&isRegistering) == 1 {
-		currentCount := pool.TotalCount()
-		targetCount := appConfig.Pool.TargetCount
+	for atomic.LoadInt32(&isRegistering) == 1 && ctx.Err() == nil {
+		currentCount := pool.CapacityCount()
+		targetCount := currentConfig().Pool.TargetCount
 
 		if currentCount >= targetCount {
 			log.Printf("✅ [注册线程 %d] 已达目标账号数 (%d/%d)，线程退出", id, currentCount, targetCount)
@@ -2529,18 +2526,18 @@ func NativeRegisterWorker(id int, dataDirAbs string) {
 		log.Printf("🔨 [注册线程 %d] 开始第 %d 次注册任务 (当前进度: %d/%d)", id, taskCount, currentCount, targetCount)
 
 		startTime := time.Now()
-		result := RunBrowserRegister(appConfig.Pool.RegisterHeadless, Proxy, id)
+		result := RunBrowserRegister(currentConfig().Pool.RegisterHeadless, Proxy, id)
 		duration := time.Since(startTime)
 
 		if result.Success {
 			log.Printf("💾 [注册线程 %d] 保存注册结果到文件...", id)
-			if err := SaveBrowserRegisterResult(result, dataDirAbs); err != nil {
+			if err := SaveBrowserRegisterResult(result); err != nil {
 				log.Printf("❌ [注册线程 %d] 保存失败 (耗时 %v): %v", id, duration, err)
 				registerStats.AddFailed(err.Error())
 			} else {
 				log.Printf("✅ [注册线程 %d] 保存成功 (耗时 %v)，重新加载账号池", id, duration)
 				registerStats.AddSuccess()
-				pool.Load(DataDir)
+				pool.Load(accountStore)
 				log.Printf("📊 [注册线程 %d] 当前账号池: 总数=%d, 就绪=%d, 待刷新=%d",
 					id, pool.TotalCount(), pool.ReadyCount(), pool.PendingCount())
 			}
@@ -2557,10 +2554,10 @@ func NativeRegisterWorker(id int, dataDirAbs string) {
 				strings.Contains(errMsg, "timeout") || strings.Contains(errMsg, "连接") {
 				waitTime := 10 + id*2
 				log.Printf("⏳ [注册线程 %d] 检测到限流/超时错误，等待 %d 秒后重试...", id, waitTime)
-				time.Sleep(time.Duration(waitTime) * time.Second)
+				sleepCtx(ctx, time.Duration(waitTime)*time.Second)
 			} else {
 				log.Printf("⏳ [注册线程 %d] 等待 3 秒后继续...", id)
-				time.Sleep(3 * time.Second)
+				sleepCtx(ctx, 3*time.Second)
 			}
 		}
 	}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ==================== 配置加载 ====================
+
+// 配置文件路径，可用 --config 或 B2A_CONFIG 指定
+var (
+	configPath         = "config.json"
+	configPathExplicit bool
+)
+
+// 内置默认值，每次加载都从它开始
+var defaultConfigJSON, _ = json.Marshal(builtinConfig)
+
+func defaultAppConfig() AppConfig {
+	var cfg AppConfig
+	json.Unmarshal(defaultConfigJSON, &cfg)
+	return cfg
+}
+
+// liveConfig 当前生效的配置。热更新时整体替换为新快照，快照发布后不再修改；
+// 读取方每个请求/任务取一次 currentConfig()，不要在多处分别读取
+var liveConfig atomic.Pointer[AppConfig]
+
+func init() {
+	cfg := defaultAppConfig()
+	liveConfig.Store(&cfg)
+}
+
+// currentConfig 返回当前配置快照（只读）
+func currentConfig() *AppConfig {
+	return liveConfig.Load()
+}
+
+// setConfig 发布新的配置快照
+func setConfig(cfg AppConfig) {
+	liveConfig.Store(&cfg)
+}
+
+// loadAppConfig 启动时加载配置；配置无效时直接退出，而不是悄悄使用默认值
+func loadAppConfig() {
+	cfg, err := buildConfig(configPath, !configPathExplicit)
+	if err != nil {
+		log.Fatalf("❌ 加载配置失败: %v", err)
+	}
+	setConfig(cfg)
+	initLogging()
+	applyConfigGlobals()
+}
+
+// applyConfigGlobals 将配置同步到全局变量
+func applyConfigGlobals() {
+	cfg := currentConfig()
+	DataDir = cfg.DataDir
+	Proxy = cfg.Proxy
+	ListenAddr = cfg.ListenAddr
+	DefaultConfig = cfg.DefaultConfig
+	setModels(cfg.Models)
+
+	// 号池运行时设置（叠加管理接口的修改）
+	initRuntimeSettings()
+}
+
+// buildConfig 读取配置文件、应用环境变量并校验，不修改当前配置。
+// 文件不存在时，create 为 true 则写入默认配置，否则报错
+func buildConfig(path string, create bool) (AppConfig, error) {
+	cfg := defaultAppConfig()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := parseConfigJSON(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("✅ 加载配置文件: %s", path)
+	case os.IsNotExist(err) && create:
+		log.Printf("⚠️ 配置文件不存在，创建默认配置: %s", path)
+		if err := saveDefaultConfig(path); err != nil {
+			log.Printf("❌ 创建默认配置失败: %v", err)
+		}
+	case os.IsNotExist(err):
+		return cfg, fmt.Errorf("配置文件 %s 不存在", path)
+	default:
+		return cfg, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	applyLegacyEnv(&cfg)
+	if err := applyEnvOverrides(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	if err := validateConfig(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// parseConfigJSON 解析配置，错误信息带行列号和字段路径。
+// 未知字段（多为拼写错误或已废弃的配置）只警告，以兼容旧配置文件
+func parseConfigJSON(data []byte, cfg *AppConfig) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(cfg)
+	if err == nil && dec.More() {
+		err = errors.New("配置文件末尾有多余内容")
+	}
+	if err == nil {
+		if field := unknownConfigField(data); field != "" {
+			log.Printf("⚠️ 配置中有未知字段 %s，已忽略（请检查拼写）", field)
+		}
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := jsonLineCol(data, syntaxErr.Offset)
+		return fmt.Errorf("第 %d 行第 %d 列: JSON 语法错误: %v", line, col, syntaxErr)
+	case errors.As(err, &typeErr):
+		line, col := jsonLineCol(data, typeErr.Offset)
+		return fmt.Errorf("第 %d 行第 %d 列: %s 应为 %s，实际为 JSON %s", line, col, typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		line, _ := jsonLineCol(data, int64(len(data)))
+		return fmt.Errorf("第 %d 行: JSON 不完整（缺少括号或引号）", line)
+	}
+	return err
+}
+
+// unknownConfigField 返回第一个未知字段的名称
+func unknownConfigField(data []byte) string {
+	var probe AppConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&probe); err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return ""
+}
+
+func jsonLineCol(data []byte, offset int64) (line, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// 保存默认配置到文件
+func saveDefaultConfig(configPath string) error {
+	data, err := json.MarshalIndent(builtinConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, data, 0644)
+}
+
+// ==================== 环境变量 ====================
+
+// applyLegacyEnv 兼容旧的环境变量（B2A_ 变量优先）
+func applyLegacyEnv(cfg *AppConfig) {
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		cfg.DataDir = v
+	}
+	if v := os.Getenv("PROXY"); v != "" {
+		cfg.Proxy = v
+	}
+	if v := os.Getenv("LISTEN_ADDR"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := os.Getenv("CONFIG_ID"); v != "" {
+		cfg.DefaultConfig = v
+	}
+	if v := os.Getenv("API_KEY"); v != "" {
+		cfg.APIKeys = append(cfg.APIKeys, v)
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.Admin.Credentials = append(cfg.Admin.Credentials, AdminCredential{Name: "env", Token: v, Role: RoleOwner})
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.Log.Format = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.Log.Level = v
+	}
+	if v := os.Getenv("ENCRYPTION_KEY_FILE"); v != "" {
+		cfg.Encryption.KeyFile = v
+	}
+}
+
+// firstEnv 返回第一个非空的环境变量
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// configEnvName 字段路径对应的环境变量，如 pool.target_count -> B2A_POOL_TARGET_COUNT
+func configEnvName(path []string) string {
+	return "B2A_" + strings.ToUpper(strings.Join(path, "_"))
+}
+
+// walkConfigFields 遍历配置中的每个非结构体字段（按 JSON 名称组成路径）
+func walkConfigFields(v reflect.Value, path []string, fn func(path []string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		p := append(append([]string(nil), path...), name)
+		if f.Type.Kind() == reflect.Struct {
+			if err := walkConfigFields(v.Field(i), p, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(p, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvOverrides 每个配置项都可用 B2A_<路径> 覆盖；<名称>_FILE 从文件读取值，适合 Docker/Kubernetes secrets。
+// 列表可写成逗号分隔或 JSON，其余复杂类型（如 admin.credentials、rate_limit.tiers）写 JSON
+func applyEnvOverrides(cfg *AppConfig, lookup func(string) (string, bool)) error {
+	return walkConfigFields(reflect.ValueOf(cfg).Elem(), nil, func(path []string, field reflect.Value) error {
+		name := configEnvName(path)
+		value, ok := lookup(name)
+		ok = ok && value != "" // 空值视为未设置（如 compose 中未赋值的 ${VAR}）
+		fileVar := name + "_FILE"
+		if file, fileOK := lookup(fileVar); fileOK && file != "" {
+			if ok {
+				return fmt.Errorf("%s 和 %s 不能同时设置", name, fileVar)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fileVar, err)
+			}
+			name, value, ok = fileVar, strings.TrimRight(string(data), "\r\n"), true
+		}
+		if !ok {
+			return nil
+		}
+		if err := setConfigField(field, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	s := strings.TrimSpace(value)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("应为 true 或 false，收到 %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("应为整数，收到 %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("应为数字，收到 %q", value)
+		}
+		field.SetFloat(f)
+	default:
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(s, "[") {
+			var items []string
+			for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+		ptr := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(s), ptr.Interface()); err != nil {
+			return fmt.Errorf("应为 JSON（%s）: %v", field.Type(), err)
+		}
+		field.Set(ptr.Elem())
+	}
+	return nil
+}
+
+// ==================== 配置校验 ====================
+
+// configProblems 收集全部问题后一次报告
+type configProblems []string
+
+func (p *configProblems) add(field, format string, args ...interface{}) {
+	*p = append(*p, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *configProblems) nonNegative(field string, v int) {
+	if v < 0 {
+		p.add(field, "不能为负数，收到 %d", v)
+	}
+}
+
+func (p *configProblems) oneOf(field, v string, allowed ...string) {
+	for _, a := range allowed {
+		if v == a {
+			return
+		}
+	}
+	p.add(field, "只支持 %s，收到 %q", strings.Join(allowed, "、"), v)
+}
+
+func (p *configProblems) httpURL(field, v string) {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add(field, "应为 http(s):// 开头的地址，收到 %q", v)
+	}
+}
+
+func (p *configProblems) listenAddr(field, v string) {
+	if _, port, err := net.SplitHostPort(v); err != nil || port == "" {
+		p.add(field, "应为 host:port 或 :port，收到 %q", v)
+	}
+}
+
+// validateConfig 检查取值范围和相互关系
+func validateConfig(cfg *AppConfig) error {
+	var p configProblems
+
+	if cfg.ListenAddr == "" {
+		p.add("listen_addr", "不能为空")
+	} else {
+		p.listenAddr("listen_addr", cfg.ListenAddr)
+	}
+	if cfg.DataDir == "" {
+		p.add("data_dir", "不能为空")
+	}
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" {
+			p.add("proxy", "无效的代理地址")
+		} else {
+			p.oneOf("proxy", u.Scheme, "http", "https", "socks5", "socks5h")
+		}
+	}
+	seen := make(map[string]bool)
+	for i, m := range cfg.Models {
+		field := fmt.Sprintf("models[%d]", i)
+		if strings.TrimSpace(m) == "" {
+			p.add(field, "不能为空")
+		} else if seen[m] {
+			p.add(field, "重复的模型 %q", m)
+		}
+		seen[m] = true
+	}
+
+	pc := cfg.Pool
+	p.nonNegative("pool.target_count", pc.TargetCount)
+	p.nonNegative("pool.min_count", pc.MinCount)
+	if pc.MinCount > pc.TargetCount {
+		p.add("pool.min_count", "不能大于 target_count（%d > %d）", pc.MinCount, pc.TargetCount)
+	}
+	p.nonNegative("pool.check_interval_minutes", pc.CheckIntervalMinutes)
+	p.nonNegative("pool.register_threads", pc.RegisterThreads)
+	p.nonNegative("pool.refresh_cooldown_sec", pc.RefreshCooldownSec)
+	p.nonNegative("pool.use_cooldown_sec", pc.UseCooldownSec)
+	p.nonNegative("pool.max_fail_count", pc.MaxFailCount)
+	p.nonNegative("pool.browser_refresh_max_retry", pc.BrowserRefreshMaxRetry)
+
+	if port := cfg.Email.QQImap.Port; port < 0 || port > 65535 {
+		p.add("email.qq_imap.port", "应在 0~65535 之间，收到 %d", port)
+	}
+
+	t := cfg.Timeout
+	p.nonNegative("timeout.default_sec", t.DefaultSec)
+	p.nonNegative("timeout.image_sec", t.ImageSec)
+	p.nonNegative("timeout.video_sec", t.VideoSec)
+	p.nonNegative("timeout.max_sec", t.MaxSec)
+	if t.MaxSec > 0 && t.DefaultSec > t.MaxSec {
+		p.add("timeout.default_sec", "不能大于 max_sec（%d > %d）", t.DefaultSec, t.MaxSec)
+	}
+
+	if cfg.Upstream.APIBaseURL != "" {
+		p.httpURL("upstream.api_base_url", cfg.Upstream.APIBaseURL)
+	}
+	if cfg.Upstream.AuthBaseURL != "" {
+		p.httpURL("upstream.auth_base_url", cfg.Upstream.AuthBaseURL)
+	}
+
+	l := cfg.Limits
+	p.nonNegative("limits.max_messages", l.MaxMessages)
+	p.nonNegative("limits.max_media_count", l.MaxMediaCount)
+	p.nonNegative("limits.max_media_mb", l.MaxMediaMB)
+	p.nonNegative("limits.max_tools", l.MaxTools)
+
+	for i, cred := range cfg.Admin.Credentials {
+		field := fmt.Sprintf("admin.credentials[%d]", i)
+		if roleRank[cred.Role] == 0 {
+			p.add(field+".role", "只支持 viewer、operator、owner，收到 %q", cred.Role)
+		}
+		if cred.Token == "" && cred.TokenSHA256 == "" {
+			p.add(field, "需要 token 或 token_sha256")
+		}
+		if h := cred.TokenSHA256; h != "" {
+			if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+				p.add(field+".token_sha256", "应为 64 位十六进制")
+			}
+		}
+	}
+
+	rl := cfg.RateLimit
+	for name, tier := range rl.Tiers {
+		field := "rate_limit.tiers." + name
+		p.nonNegative(field+".rpm", tier.RPM)
+		p.nonNegative(field+".concurrency", tier.Concurrency)
+		p.nonNegative(field+".tpm", tier.TPM)
+	}
+	// 未定义的 default 等级表示不限流，其他名称多半是拼写错误
+	if _, ok := rl.Tiers[rl.DefaultTier]; rl.DefaultTier != "" && rl.DefaultTier != "default" && !ok {
+		p.add("rate_limit.default_tier", "等级 %q 未在 rate_limit.tiers 中定义", rl.DefaultTier)
+	}
+	p.nonNegative("rate_limit.per_ip.rpm", rl.PerIP.RPM)
+	p.nonNegative("rate_limit.per_ip.concurrency", rl.PerIP.Concurrency)
+	p.nonNegative("rate_limit.per_ip.tpm", rl.PerIP.TPM)
+
+	q := cfg.Queue
+	p.nonNegative("queue.max_size", q.MaxSize)
+	p.nonNegative("queue.timeout_sec", q.TimeoutSec)
+	p.nonNegative("queue.keepalive_sec", q.KeepAliveSec)
+	for tier, w := range q.TierWeights {
+		p.nonNegative("queue.tier_weights."+tier, w)
+	}
+
+	if cfg.Metrics.Listen != "" {
+		p.listenAddr("metrics.listen", cfg.Metrics.Listen)
+	}
+
+	if _, err := newLogHandler(cfg.Log, nil); err != nil {
+		p.add("log", "%v", err)
+	}
+
+	tr := cfg.Tracing
+	p.oneOf("tracing.exporter", tr.Exporter, "", "none", "stdout", "console", "otlp")
+	if tr.Endpoint != "" {
+		p.httpURL("tracing.endpoint", tr.Endpoint)
+	}
+	if tr.SampleRatio < 0 || tr.SampleRatio > 1 {
+		p.add("tracing.sample_ratio", "应在 0~1 之间，收到 %v", tr.SampleRatio)
+	}
+
+	h := cfg.Health
+	p.nonNegative("health.min_ready_accounts", h.MinReadyAccounts)
+	if h.MaxUpstreamErrorRate < 0 || h.MaxUpstreamErrorRate > 1 {
+		p.add("health.max_upstream_error_rate", "应在 0~1 之间，收到 %v", h.MaxUpstreamErrorRate)
+	}
+	p.nonNegative("health.error_window_sec", h.ErrorWindowSec)
+	p.nonNegative("health.min_upstream_samples", h.MinUpstreamSamples)
+
+	ev := cfg.Events
+	p.nonNegative("events.dedupe_window_sec", ev.DedupeWindowSec)
+	p.nonNegative("events.retry_storm_threshold", ev.RetryStormThreshold)
+	for i, wh := range ev.Webhooks {
+		field := fmt.Sprintf("events.webhooks[%d]", i)
+		p.httpURL(field+".url", wh.URL)
+		p.oneOf(field+".format", wh.Format, "", "json", "slack")
+		p.nonNegative(field+".max_retries", wh.MaxRetries)
+		p.nonNegative(field+".timeout_sec", wh.TimeoutSec)
+	}
+
+	p.nonNegative("shutdown.drain_timeout_sec", cfg.Shutdown.DrainTimeoutSec)
+	p.nonNegative("shutdown.worker_timeout_sec", cfg.Shutdown.WorkerTimeoutSec)
+
+	p.oneOf("storage.backend", cfg.Storage.Backend, "", "file", "bolt", "consul")
+	if cfg.Storage.Backend == "consul" {
+		if cfg.Storage.Consul.Address == "" {
+			p.add("storage.consul.address", "backend 为 consul 时必填")
+		} else {
+			p.httpURL("storage.consul.address", cfg.Storage.Consul.Address)
+		}
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("配置无效（%d 项）:\n  - %s", len(p), strings.Join(p, "\n  - "))
+}
+
+// ==================== 模型列表 ====================
+
+var modelList atomic.Value // []string
+
+// availableModels 对外提供的模型，第一个为默认模型
+func availableModels() []string {
+	if models, ok := modelList.Load().([]string); ok && len(models) > 0 {
+		return models
+	}
+	return FixedModels
+}
+
+// setModels 配置为空时使用内置列表
+func setModels(models []string) {
+	modelList.Store(append([]string(nil), models...))
+}
+
+// ==================== 热加载 ====================
+
+// 无需重启即可生效的配置（前缀匹配）；这些配置在使用时读取，或在 applyReloadedConfig 中同步
+var reloadableConfigPaths = []string{
+	"api_keys",
+	"models",
+	"pool.refresh_cooldown_sec",
+	"pool.use_cooldown_sec",
+	"pool.max_fail_count",
+	"pool.target_count",
+	"pool.min_count",
+	"timeout",
+	"limits",
+	"rate_limit",
+	"shutdown",
+}
+
+// ConfigReloadResult 一次重新加载的结果
+type ConfigReloadResult struct {
+	Time            time.Time `json:"time"`
+	Trigger         string    `json:"trigger"`
+	Applied         []string  `json:"applied"`          // 已生效的配置项
+	RestartRequired []string  `json:"restart_required"` // 已修改但需重启才生效的配置项
+	Error           string    `json:"error,omitempty"`
+}
+
+var (
+	configReloadMu   sync.Mutex
+	lastConfigReload atomic.Pointer[ConfigReloadResult]
+)
+
+// reloadablePrefix 返回配置项所属的可热更新前缀，不可热更新时返回空
+func reloadablePrefix(path string) string {
+	for _, p := range reloadableConfigPaths {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return p
+		}
+	}
+	return ""
+}
+
+func configTree(cfg AppConfig) map[string]interface{} {
+	var tree map[string]interface{}
+	data, _ := json.Marshal(cfg)
+	json.Unmarshal(data, &tree)
+	return tree
+}
+
+// diffConfigPaths 返回取值不同的配置项路径（对象逐层比较，数组整体比较）
+func diffConfigPaths(old, next AppConfig) []string {
+	var out []string
+	var walk func(prefix string, a, b interface{})
+	walk = func(prefix string, a, b interface{}) {
+		am, aok := a.(map[string]interface{})
+		bm, bok := b.(map[string]interface{})
+		if !aok || !bok {
+			if !reflect.DeepEqual(a, b) {
+				out = append(out, prefix)
+			}
+			return
+		}
+		keys := make(map[string]bool)
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		for k := range keys {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			walk(path, am[k], bm[k])
+		}
+	}
+	walk("", configTree(old), configTree(next))
+	sort.Strings(out)
+	return out
+}
+
+// mergeConfigPaths 在 old 的基础上采用 next 中指定路径的值（路径取自 reloadableConfigPaths）
+func mergeConfigPaths(old, next AppConfig, paths []string) (AppConfig, error) {
+	tree, src := configTree(old), configTree(next)
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		dst, from := tree, src
+		for _, k := range keys[:len(keys)-1] {
+			sub, ok := dst[k].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				dst[k] = sub
+			}
+			dst = sub
+			from, _ = from[k].(map[string]interface{})
+		}
+		last := keys[len(keys)-1]
+		if v, ok := from[last]; ok {
+			dst[last] = v
+		} else {
+			delete(dst, last)
+		}
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return old, err
+	}
+	var merged AppConfig
+	err = json.Unmarshal(data, &merged)
+	return merged, err
+}
+
+// reloadConfig 重新读取配置：校验失败时保持原配置；可热更新的项立即生效，其余只报告
+func reloadConfig(trigger string) ConfigReloadResult {
+	configReloadMu.Lock()
+	defer configReloadMu.Unlock()
+
+	res := ConfigReloadResult{Time: time.Now(), Trigger: trigger, Applied: []string{}, RestartRequired: []string{}}
+	defer func() { lastConfigReload.Store(&res) }()
+
+	next, err := buildConfig(configPath, false)
+	if err != nil {
+		res.Error = err.Error()
+		log.Printf("❌ 重新加载配置失败，继续使用当前配置: %v", err)
+		return res
+	}
+	old := *currentConfig()
+	var prefixes []string
+	for _, path := range diffConfigPaths(old, next) {
+		if prefix := reloadablePrefix(path); prefix != "" {
+			res.Applied = append(res.Applied, path)
+			if len(prefixes) == 0 || prefixes[len(prefixes)-1] != prefix {
+				prefixes = append(prefixes, prefix)
+			}
+		} else {
+			res.RestartRequired = append(res.RestartRequired, path)
+		}
+	}
+	if len(res.Applied) > 0 {
+		merged, err := mergeConfigPaths(old, next, prefixes)
+		if err != nil {
+			res.Error = err.Error()
+			res.Applied = []string{}
+			log.Printf("❌ 合并配置失败: %v", err)
+			return res
+		}
+		setConfig(merged)
+		applyReloadedConfig(old, trigger)
+	}
+
+	switch {
+	case len(res.Applied) == 0 && len(res.RestartRequired) == 0:
+		log.Printf("🔄 配置无变化（%s）", trigger)
+	default:
+		if len(res.Applied) > 0 {
+			log.Printf("🔄 配置已重新加载（%s）: %s", trigger, strings.Join(res.Applied, ", "))
+		}
+		if len(res.RestartRequired) > 0 {
+			log.Printf("⚠️ 以下配置需要重启才能生效: %s", strings.Join(res.RestartRequired, ", "))
+		}
+	}
+	return res
+}
+
+// applyReloadedConfig 同步热更新配置对应的运行时状态
+func applyReloadedConfig(old AppConfig, trigger string) {
+	cfg := currentConfig()
+	if !reflect.DeepEqual(old.APIKeys, cfg.APIKeys) && keyStore != nil {
+		keyStore.SetLegacyKeys(cfg.APIKeys)
+	}
+	runtimeSettings.SetBase(cfg.Pool, trigger)
+	setModels(cfg.Models)
+}
+
+// startConfigReloader 收到 SIGHUP 或配置文件变化时重新加载
+func startConfigReloader() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// 监听所在目录：编辑器通常以重命名方式保存文件
+	var events chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(configPath)); err == nil {
+			events = watcher.Events
+		} else {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("⚠️ 无法监听配置文件: %v，可发送 SIGHUP 重新加载", err)
+	}
+
+	lastHash := configFileHash()
+	app.Go("config-reloader", func(ctx context.Context) {
+		defer signal.Stop(hup)
+		if watcher != nil && events != nil {
+			defer watcher.Close()
+		}
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				lastHash = configFileHash()
+				reloadConfig("SIGHUP")
+			case ev := <-events:
+				if filepath.Clean(ev.Name) == filepath.Clean(configPath) && debounce == nil {
+					debounce = time.After(500 * time.Millisecond)
+				}
+			case <-debounce:
+				debounce = nil
+				// 内容未变（如 touch）时不重新加载
+				if h := configFileHash(); h != "" && h != lastHash {
+					lastHash = h
+					reloadConfig("file")
+				}
+			}
+		}
+	})
+}
+
+func configFileHash() string {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return string(sum[:])
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigJSONErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"syntax", "{\n  \"listen_addr\": \":8000\",\n  \"pool\": {,}\n}", []string{"第 3 行", "JSON 语法错误"}},
+		{"type", "{\n  \"pool\": {\n    \"target_count\": \"50\"\n  }\n}", []string{"第 3 行", "pool.target_count", "int"}},
+		{"trailing", `{} {}`, []string{"多余内容"}},
+	}
+	for _, tc := range cases {
+		cfg := defaultAppConfig()
+		err := parseConfigJSON([]byte(tc.data), &cfg)
+		if err == nil {
+			t.Fatalf("%s: 应报错", tc.name)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(err.Error(), w) {
+				t.Errorf("%s: 错误 %q 缺少 %q", tc.name, err, w)
+			}
+		}
+	}
+}
+
+func TestParseConfigJSONUnknownField(t *testing.T) {
+	cfg := defaultAppConfig()
+	data := []byte(`{"pool": {"target_cuont": 5, "register_script": "./main.js", "min_count": 3}}`)
+	if err := parseConfigJSON(data, &cfg); err != nil {
+		t.Fatalf("未知字段不应导致失败: %v", err)
+	}
+	if cfg.Pool.MinCount != 3 {
+		t.Fatal("其余字段应正常解析")
+	}
+	if got := unknownConfigField(data); got != `"target_cuont"` {
+		t.Fatalf("未知字段 = %s", got)
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "metrics_token")
+	os.WriteFile(tokenFile, []byte("s3cret\n"), 0600)
+	keysFile := filepath.Join(dir, "api_keys")
+	os.WriteFile(keysFile, []byte("sk-a\nsk-b\n"), 0600)
+
+	env := map[string]string{
+		"B2A_POOL_TARGET_COUNT":               "80",
+		"B2A_POOL_REGISTER_HEADLESS":          "false",
+		"B2A_HEALTH_MAX_UPSTREAM_ERROR_RATE":  "0.25",
+		"B2A_MODELS":                          "gemini-2.5-pro, gemini-2.5-flash",
+		"B2A_API_KEYS_FILE":                   keysFile,
+		"B2A_METRICS_TOKEN_FILE":              tokenFile,
+		"B2A_EMAIL_QQ_IMAP_SERVER":            "imap.example.com",
+		"B2A_RATE_LIMIT_TIERS":                `{"gold": {"rpm": 600}}`,
+		"B2A_ADMIN_CREDENTIALS":               `[{"name": "ops", "token": "t", "role": "operator"}]`,
+		"B2A_LOG_SUBSYSTEMS":                  `{"browser": "warn"}`,
+		"B2A_STORAGE_CONSUL_PREFIX":           "gw/",
+		"B2A_TRACING_HEADERS":                 "",
+		"B2A_ENCRYPTION_PREVIOUS_KEY_FILES":   "/a.key,/b.key",
+		"B2A_EVENTS_RETRY_STORM_THRESHOLD":    "0",
+		"B2A_QUEUE_TIER_WEIGHTS":              `{"gold": 3}`,
+		"B2A_POOL_BROWSER_REFRESH_MAX_RETRY":  "2",
+		"B2A_UPSTREAM_API_BASE_URL":           "http://127.0.0.1:9000",
+		"B2A_TIMEOUT_MAX_SEC":                 "600",
+		"B2A_LIMITS_MAX_MEDIA_MB":             "5",
+		"B2A_RECORD_ENABLED":                  "true",
+		"B2A_DATA_DIR":                        "/var/lib/b2a",
+		"B2A_EVENTS_WEBHOOKS":                 `[{"url": "https://hooks.example.com/x", "events": ["pool.*"]}]`,
+		"B2A_HEALTH_MIN_UPSTREAM_SAMPLES":     "7",
+		"B2A_ADMIN_AUDIT_LOG":                 "/tmp/audit.log",
+		"B2A_TRACING_SAMPLE_RATIO":            "0.5",
+		"B2A_POOL_ENABLE_BROWSER_REFRESH":     "1",
+		"B2A_STORAGE_BACKEND":                 "bolt",
+		"B2A_METRICS_ENABLED":                 "false",
+		"B2A_DEFAULT_CONFIG":                  "cfg-1",
+		"B2A_LISTEN_ADDR":                     ":9000",
+		"B2A_QUEUE_MAX_SIZE":                  "10",
+		"B2A_EMAIL_QQ_IMAP_AUTH_CODE":         "",
+		"B2A_EMAIL_QQ_IMAP_AUTH_CODE_UNKNOWN": "ignored",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	cfg := defaultAppConfig()
+	if err := applyEnvOverrides(&cfg, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Pool.TargetCount != 80 || cfg.Pool.RegisterHeadless || cfg.Health.MaxUpstreamErrorRate != 0.25 {
+		t.Fatalf("标量覆盖失败: %+v %+v", cfg.Pool, cfg.Health)
+	}
+	if strings.Join(cfg.Models, "|") != "gemini-2.5-pro|gemini-2.5-flash" || strings.Join(cfg.APIKeys, "|") != "sk-a|sk-b" {
+		t.Fatalf("列表覆盖失败: %v %v", cfg.Models, cfg.APIKeys)
+	}
+	if cfg.Metrics.Token != "s3cret" {
+		t.Fatalf("_FILE 变量应去掉末尾换行: %q", cfg.Metrics.Token)
+	}
+	if cfg.RateLimit.Tiers["gold"].RPM != 600 || len(cfg.Admin.Credentials) != 1 || cfg.Admin.Credentials[0].Role != RoleOperator {
+		t.Fatalf("JSON 覆盖失败: %+v %+v", cfg.RateLimit.Tiers, cfg.Admin.Credentials)
+	}
+	if cfg.Email.QQImap.Server != "imap.example.com" || cfg.Email.QQImap.AuthCode != "" || cfg.Storage.Consul.Prefix != "gw/" {
+		t.Fatal("嵌套字段覆盖失败")
+	}
+	if len(cfg.Encryption.PreviousKeyFiles) != 2 || len(cfg.Events.Webhooks) != 1 || cfg.ListenAddr != ":9000" {
+		t.Fatal("覆盖失败")
+	}
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []map[string]string{
+		{"B2A_POOL_TARGET_COUNT": "many"},
+		{"B2A_METRICS_ENABLED": "yes please"},
+		{"B2A_RATE_LIMIT_TIERS": "{not json"},
+		{"B2A_METRICS_TOKEN": "a", "B2A_METRICS_TOKEN_FILE": tokenFile},
+		{"B2A_METRICS_TOKEN_FILE": filepath.Join(dir, "missing")},
+	}
+	for _, e := range bad {
+		cfg := defaultAppConfig()
+		err := applyEnvOverrides(&cfg, func(k string) (string, bool) { v, ok := e[k]; return v, ok })
+		if err == nil {
+			t.Fatalf("%v 应报错", e)
+		}
+		for k := range e {
+			if strings.HasSuffix(k, "_FILE") && !strings.Contains(err.Error(), "_FILE") {
+				t.Fatalf("错误应指出变量名: %v", err)
+			}
+		}
+	}
+}
+
+func TestValidateConfigReportsAllProblems(t *testing.T) {
+	cfg := defaultAppConfig()
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatalf("默认配置应有效: %v", err)
+	}
+
+	cfg.ListenAddr = "8000"
+	cfg.Pool.MinCount = 100
+	cfg.Pool.UseCooldownSec = -1
+	cfg.Proxy = "ftp://proxy:21"
+	cfg.Models = []string{"a", "a"}
+	cfg.RateLimit.DefaultTier = "gold"
+	cfg.Log.Level = "loud"
+	cfg.Tracing.SampleRatio = 2
+	cfg.Admin.Credentials = []AdminCredential{{Name: "x", Role: "root"}}
+	cfg.Events.Webhooks = []WebhookConfig{{URL: "hooks.example.com", Format: "teams"}}
+	cfg.Storage.Backend = "consul"
+	err := validateConfig(&cfg)
+	if err == nil {
+		t.Fatal("应报错")
+	}
+	for _, want := range []string{
+		"listen_addr", "pool.min_count", "pool.use_cooldown_sec", "proxy", "models[1]", "rate_limit.default_tier",
+		"log.level", "tracing.sample_ratio", "admin.credentials[0].role", "admin.credentials[0]: 需要 token",
+		"events.webhooks[0].url", "events.webhooks[0].format", "storage.consul.address",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误信息缺少 %s:\n%v", want, err)
+		}
+	}
+}
+
+// updateConfig 发布修改后的配置快照（不恢复，需由调用方或 newTestGateway 负责）
+func updateConfig(modify func(cfg *AppConfig)) {
+	next := *currentConfig()
+	modify(&next)
+	setConfig(next)
+}
+
+// useConfig 发布修改后的配置快照，测试结束后恢复原配置
+func useConfig(t *testing.T, modify func(cfg *AppConfig)) {
+	t.Helper()
+	old := currentConfig()
+	t.Cleanup(func() { liveConfig.Store(old) })
+	updateConfig(modify)
+}
+
+// useConfigFile 写入配置文件并作为当前配置加载，测试结束后恢复全局状态
+func useConfigFile(t *testing.T, cfg map[string]interface{}) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, cfg)
+
+	oldCfg, oldPath, oldExplicit := currentConfig(), configPath, configPathExplicit
+	oldKeys, oldAdmin := keyStore, adminAuthState
+	oldSettings := runtimeSettings
+	t.Cleanup(func() {
+		liveConfig.Store(oldCfg)
+		configPath, configPathExplicit = oldPath, oldExplicit
+		keyStore, adminAuthState = oldKeys, oldAdmin
+		runtimeSettings = oldSettings
+		setModels(nil)
+		lastConfigReload.Store(nil)
+	})
+
+	configPath, configPathExplicit = path, true
+	loaded, err := buildConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(loaded)
+	keyStore = newKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	keyStore.SetLegacyKeys(loaded.APIKeys)
+	runtimeSettings = newSettingsStore(filepath.Join(t.TempDir(), "runtime_settings.json"))
+	runtimeSettings.SetBase(loaded.Pool, "config")
+	return path
+}
+
+func writeConfigFile(t *testing.T, path string, cfg map[string]interface{}) {
+	t.Helper()
+	data, _ := json.MarshalIndent(cfg, "", "  ")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfigAppliesSafeChanges(t *testing.T) {
+	cfg := map[string]interface{}{
+		"listen_addr": ":8000",
+		"api_keys":    []string{"sk-old"},
+		"pool":        map[string]interface{}{"refresh_cooldown_sec": 240, "use_cooldown_sec": 15},
+		"limits":      map[string]interface{}{"max_messages": 500},
+	}
+	path := useConfigFile(t, cfg)
+
+	cfg["api_keys"] = []string{"sk-new"}
+	cfg["models"] = []string{"gemini-2.5-pro"}
+	cfg["pool"] = map[string]interface{}{"refresh_cooldown_sec": 60, "use_cooldown_sec": 5}
+	cfg["limits"] = map[string]interface{}{"max_messages": 3}
+	cfg["listen_addr"] = ":9000"
+	cfg["metrics"] = map[string]interface{}{"enabled": false}
+	writeConfigFile(t, path, cfg)
+
+	res := reloadConfig("test")
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	wantApplied := "api_keys,limits.max_messages,models,pool.refresh_cooldown_sec,pool.use_cooldown_sec"
+	if got := strings.Join(res.Applied, ","); got != wantApplied {
+		t.Fatalf("已生效 = %s", got)
+	}
+	if got := strings.Join(res.RestartRequired, ","); got != "listen_addr,metrics.enabled" {
+		t.Fatalf("需重启 = %s", got)
+	}
+
+	// 热更新的项已生效，其余保持原值
+	if keyStore.Lookup("sk-new") == nil || keyStore.Lookup("sk-old") != nil {
+		t.Fatal("API Key 未更新")
+	}
+	if s := currentSettings(); s.RefreshCooldown != time.Minute || s.UseCooldown != 5*time.Second {
+		t.Fatalf("冷却 = %v / %v", s.RefreshCooldown, s.UseCooldown)
+	}
+	applied := currentConfig()
+	if applied.Limits.MaxMessages != 3 || availableModels()[0] != "gemini-2.5-pro" {
+		t.Fatal("限制或模型未更新")
+	}
+	if applied.ListenAddr != ":8000" || !applied.Metrics.Enabled {
+		t.Fatal("需重启的配置不应立即生效")
+	}
+	if lastConfigReload.Load() == nil {
+		t.Fatal("未记录重新加载结果")
+	}
+
+	// 无效配置不影响当前配置
+	os.WriteFile(path, []byte(`{"limits": {"max_messages": -1}}`), 0600)
+	res = reloadConfig("test")
+	if !strings.Contains(res.Error, "limits.max_messages") || currentConfig() != applied {
+		t.Fatalf("无效配置: %+v, max_messages=%d", res, currentConfig().Limits.MaxMessages)
+	}
+}
+
+func TestAdminConfigReload(t *testing.T) {
+	g := newTestGateway(t, 1)
+	path := useConfigFile(t, map[string]interface{}{"limits": map[string]interface{}{"max_tools": 128}})
+	writeConfigFile(t, path, map[string]interface{}{"limits": map[string]interface{}{"max_tools": 1}})
+
+	w := g.post(t, "/admin/config/reload", nil)
+	if w.Code != 200 {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	var res ConfigReloadResult
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if len(res.Applied) != 1 || res.Applied[0] != "limits.max_tools" || !strings.HasPrefix(res.Trigger, "admin:") {
+		t.Fatalf("结果: %+v", res)
+	}
+
+	os.WriteFile(path, []byte(`{"limits": `), 0600)
+	if w := g.post(t, "/admin/config/reload", nil); w.Code != 422 {
+		t.Fatalf("无效配置状态码 %d", w.Code)
+	}
+}
+
+// 热更新与请求并发进行（配合 -race 检查）
+func TestReloadConfigConcurrentWithRequests(t *testing.T) {
+	g := newTestGateway(t, 2)
+	path := useConfigFile(t, map[string]interface{}{"limits": map[string]interface{}{"max_messages": 100}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			writeConfigFile(t, path, map[string]interface{}{"limits": map[string]interface{}{"max_messages": 100 + i}})
+			reloadConfig("test")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")); w.Code != 200 {
+			t.Fatalf("热更新期间请求失败: %d %s", w.Code, w.Body.String())
+		}
+	}
+	<-done
+	if got := currentConfig().Limits.MaxMessages; got != 119 {
+		t.Fatalf("max_messages = %d", got)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 管理控制台 ====================
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+const (
+	adminSessionCookie     = "b2a_admin"
+	dashboardRequestedWith = "b2a-dashboard"
+	adminSessionMaxAge     = 12 * 3600
+)
+
+// PoolSample 号池状态采样点
+type PoolSample struct {
+	Time       time.Time `json:"time"`
+	Pending    int       `json:"pending"`
+	Refreshing int       `json:"refreshing"`
+	Ready      int       `json:"ready"`
+	Cooling    int       `json:"cooling"`
+	Invalid    int       `json:"invalid"`
+	QueueDepth int       `json:"queue_depth"`
+}
+
+// poolHistory 最近一段时间的号池采样，供控制台绘制趋势
+type poolHistory struct {
+	mu      sync.Mutex
+	samples []PoolSample
+	max     int
+}
+
+// 每 30 秒采样一次，保留 6 小时
+var poolTrend = &poolHistory{max: 720}
+
+func (h *poolHistory) Add(s PoolSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, s)
+	if len(h.samples) > h.max {
+		h.samples = h.samples[len(h.samples)-h.max:]
+	}
+}
+
+func (h *poolHistory) Snapshot() []PoolSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]PoolSample(nil), h.samples...)
+}
+
+func samplePool(now time.Time) PoolSample {
+	counts := pool.StatusCounts()
+	depth, _ := admission.Stats()["depth"].(int)
+	return PoolSample{
+		Time:       now,
+		Pending:    counts["pending"],
+		Refreshing: counts["refreshing"],
+		Ready:      counts["ready"],
+		Cooling:    counts["cooling"],
+		Invalid:    counts["invalid"],
+		QueueDepth: depth,
+	}
+}
+
+// startPoolSampler 定时记录号池状态
+func startPoolSampler(interval time.Duration) {
+	poolTrend.Add(samplePool(time.Now()))
+	app.Go("pool-sampler", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				poolTrend.Add(samplePool(now))
+			}
+		}
+	})
+}
+
+// UpstreamErrorEntry 一次失败的上游调用
+type UpstreamErrorEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Operation string    `json:"operation"`
+	Class     string    `json:"class"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// upstreamErrorLog 最近的上游错误（环形缓冲）
+type upstreamErrorLog struct {
+	mu      sync.Mutex
+	entries []UpstreamErrorEntry
+	next    int
+	full    bool
+}
+
+var recentUpstreamErrors = newUpstreamErrorLog(100)
+
+func newUpstreamErrorLog(n int) *upstreamErrorLog {
+	return &upstreamErrorLog{entries: make([]UpstreamErrorEntry, n)}
+}
+
+func (l *upstreamErrorLog) Add(e UpstreamErrorEntry) {
+	e.Error = redactSecrets(e.Error)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent 返回最近的错误，新的在前
+func (l *upstreamErrorLog) Recent() []UpstreamErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	out := make([]UpstreamErrorEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return out
+}
+
+// 配置中需要隐藏的字段（在审计脱敏字段基础上增加）
+var configSecretFields = append([]string{"api_keys", "auth_code", "headers"}, auditSecretFields...)
+
+// runtimeConfigView 脱敏后的当前配置，以及运行中可调整的参数
+func runtimeConfigView() gin.H {
+	var cfg map[string]interface{}
+	data, _ := json.Marshal(currentConfig())
+	json.Unmarshal(data, &cfg)
+	redactConfig(cfg)
+	if proxy, ok := cfg["proxy"].(string); ok && proxy != "" {
+		cfg["proxy"] = redactProxyURL(proxy)
+	}
+	// Webhook 地址（如 Slack）本身就是凭据，只保留主机名
+	if ev, ok := cfg["events"].(map[string]interface{}); ok {
+		hooks, _ := ev["webhooks"].([]interface{})
+		for _, h := range hooks {
+			if hook, ok := h.(map[string]interface{}); ok {
+				if u, err := url.Parse(fmt.Sprint(hook["url"])); err == nil && u.Host != "" {
+					hook["url"] = u.Scheme + "://" + u.Host + "/" + redactedMark
+				}
+			}
+		}
+	}
+	settings := currentSettings()
+	return gin.H{
+		"config": cfg,
+		"runtime": gin.H{
+			"refresh_cooldown_sec":     int(settings.RefreshCooldown.Seconds()),
+			"use_cooldown_sec":         int(settings.UseCooldown.Seconds()),
+			"max_fail_count":           settings.MaxFailCount,
+			"enable_browser_refresh":   settings.EnableBrowserRefresh,
+			"browser_refresh_headless": settings.BrowserRefreshHeadless,
+			"is_registering":           atomic.LoadInt32(&isRegistering) == 1,
+			"overrides":                runtimeSettings.Overrides(),
+		},
+		"config_path": configPath,
+		"last_reload": lastConfigReload.Load(),
+	}
+}
+
+func redactConfig(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			lower := strings.ToLower(k)
+			secret := false
+			for _, f := range configSecretFields {
+				if strings.Contains(lower, f) {
+					secret = true
+					break
+				}
+			}
+			if secret {
+				if !isEmptyConfigValue(val) {
+					v[k] = redactedMark
+				}
+				continue
+			}
+			redactConfig(val)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactConfig(item)
+		}
+	}
+}
+
+func isEmptyConfigValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// redactProxyURL 隐藏代理地址中的密码
+func redactProxyURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// dashboardAuth 控制台页面鉴权：未登录的浏览器跳转到登录页
+func dashboardAuth() gin.HandlerFunc {
+	auth := adminAuth()
+	return func(c *gin.Context) {
+		if token, _ := adminToken(c); token == "" {
+			c.Redirect(http.StatusSeeOther, "/admin/ui/login")
+			c.Abort()
+			return
+		}
+		auth(c)
+	}
+}
+
+func setAdminSession(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminSessionCookie, token, maxAge, "/admin", "", secure, true)
+}
+
+// dashboardHeaders 控制台页面禁止缓存、禁止嵌入，只加载同源脚本
+func dashboardHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'self'; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'")
+}
+
+func serveDashboardPage(c *gin.Context, name string, replacer *strings.Replacer) {
+	data, err := dashboardFiles.ReadFile("dashboard/" + name)
+	if err != nil {
+		c.Status(404)
+		return
+	}
+	page := string(data)
+	if replacer != nil {
+		page = replacer.Replace(page)
+	}
+	dashboardHeaders(c)
+	c.Data(200, "text/html; charset=utf-8", []byte(page))
+}
+
+func registerDashboardRoutes(r *gin.Engine, admin *gin.RouterGroup) {
+	// 登录页不含任何数据，无需鉴权
+	r.GET("/admin/ui/login", func(c *gin.Context) {
+		msg := ""
+		if c.Query("error") != "" {
+			msg = `<div class="error">Token 无效</div>`
+		}
+		serveDashboardPage(c, "login.html", strings.NewReplacer("{{error}}", msg))
+	})
+	r.POST("/admin/ui/login", func(c *gin.Context) {
+		token := strings.TrimSpace(c.PostForm("token"))
+		actor, ok := adminAuthState.byHash[hashAPIKey(token)]
+		if !ok || token == "" {
+			log.Printf("⚠️ 控制台登录失败: %s", c.ClientIP())
+			c.Redirect(http.StatusSeeOther, "/admin/ui/login?error=1")
+			return
+		}
+		setAdminSession(c, token, adminSessionMaxAge)
+		log.Printf("🔐 控制台登录: %s(%s) %s", actor.Name, actor.Role, c.ClientIP())
+		c.Redirect(http.StatusSeeOther, "/admin/ui/")
+	})
+	r.POST("/admin/ui/logout", func(c *gin.Context) {
+		setAdminSession(c, "", -1)
+		c.Redirect(http.StatusSeeOther, "/admin/ui/login")
+	})
+
+	ui := r.Group("/admin/ui", dashboardAuth(), requireRole(RoleViewer))
+	index := func(c *gin.Context) {
+		serveDashboardPage(c, "index.html", nil)
+	}
+	ui.GET("", index)
+	ui.GET("/", index)
+	assets, _ := fs.Sub(dashboardFiles, "dashboard/assets")
+	ui.GET("/assets/*filepath", func(c *gin.Context) {
+		dashboardHeaders(c)
+		c.FileFromFS(c.Param("filepath"), http.FS(assets))
+	})
+
+	// 控制台使用的数据接口，也可直接调用
+	admin.GET("/pool/history", requireRole(RoleViewer), func(c *gin.Context) {
+		c.JSON(200, gin.H{"samples": poolTrend.Snapshot()})
+	})
+	admin.GET("/errors", requireRole(RoleViewer), func(c *gin.Context) {
+		c.JSON(200, gin.H{"errors": recentUpstreamErrors.Recent()})
+	})
+	admin.GET("/config", requireRole(RoleViewer), func(c *gin.Context) {
+		c.JSON(200, runtimeConfigView())
+	})
+}
@@ -0,0 +1,284 @@
+// Business2API 控制台：轮询管理接口并渲染，操作按钮调用现有的管理接口
+(function () {
+  "use strict";
+
+  var ROLE_RANK = { viewer: 1, operator: 2, owner: 3 };
+  var STATUS_NAMES = { ready: "就绪", pending: "待刷新", refreshing: "刷新中", cooling: "冷却", disabled: "已禁用", invalid: "失效" };
+  var actor = { role: "viewer" };
+
+  function $(sel) { return document.querySelector(sel); }
+
+  function el(tag, text, cls) {
+    var e = document.createElement(tag);
+    if (text !== undefined && text !== null) e.textContent = String(text);
+    if (cls) e.className = cls;
+    return e;
+  }
+
+  // 所有请求都带 X-Requested-With，Cookie 登录时服务端据此放行修改操作
+  function api(method, path, body) {
+    var opts = {
+      method: method,
+      credentials: "same-origin",
+      headers: { "X-Requested-With": "b2a-dashboard" }
+    };
+    if (body !== undefined) {
+      opts.headers["Content-Type"] = "application/json";
+      opts.body = JSON.stringify(body);
+    }
+    return fetch(path, opts).then(function (resp) {
+      if (resp.status === 401) {
+        location.href = "/admin/ui/login";
+        throw new Error("未登录");
+      }
+      return resp.json().then(function (data) {
+        if (!resp.ok) throw new Error(data.error || data.message || ("HTTP " + resp.status));
+        return data;
+      });
+    });
+  }
+
+  function toast(msg, isError) {
+    var t = $("#toast");
+    t.textContent = msg;
+    t.className = isError ? "error" : "";
+    t.hidden = false;
+    clearTimeout(toast.timer);
+    toast.timer = setTimeout(function () { t.hidden = true; }, 4000);
+  }
+
+  function fmtTime(s) {
+    if (!s || s.indexOf("0001-") === 0) return "-";
+    var d = new Date(s);
+    return d.toLocaleString();
+  }
+
+  function fmtBytes(n) {
+    if (!n) return "0";
+    var units = ["B", "KB", "MB", "GB"];
+    var i = 0;
+    while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+    return n.toFixed(i ? 1 : 0) + units[i];
+  }
+
+  function fillTable(sel, rows) {
+    var tbody = $(sel + " tbody");
+    tbody.textContent = "";
+    if (!rows.length) {
+      var tr = el("tr");
+      var td = el("td", "暂无数据", "empty");
+      td.colSpan = $(sel + " thead tr").children.length;
+      tr.appendChild(td);
+      tbody.appendChild(tr);
+      return;
+    }
+    rows.forEach(function (cells) {
+      var tr = el("tr");
+      cells.forEach(function (c) {
+        var td = el("td");
+        if (c instanceof Node) td.appendChild(c); else td.textContent = c === undefined || c === null ? "" : String(c);
+        tr.appendChild(td);
+      });
+      tbody.appendChild(tr);
+    });
+  }
+
+  function badge(status) {
+    return el("span", STATUS_NAMES[status] || status, "badge " + status);
+  }
+
+  // ---------- 渲染 ----------
+
+  function renderSummary(status) {
+    var box = $("#summary");
+    box.textContent = "";
+    var queue = status.queue || {};
+    [
+      ["就绪账号", status.ready],
+      ["待刷新", status.pending],
+      ["目标/最少", status.target + " / " + status.min],
+      ["总请求", status.total_requests],
+      ["成功率", status.success_rate],
+      ["排队", (queue.depth || 0) + (queue.enabled ? " / " + queue.max_size : "（未启用）")],
+      ["注册中", status.is_registering ? "是" : "否"]
+    ].forEach(function (item) {
+      var card = el("div", null, "card");
+      card.appendChild(el("div", item[0], "label"));
+      card.appendChild(el("div", item[1], "value"));
+      box.appendChild(card);
+    });
+  }
+
+  var SERIES = [
+    ["ready", "#2e7d32"],
+    ["pending", "#f9a825"],
+    ["refreshing", "#ef6c00"],
+    ["cooling", "#1565c0"],
+    ["invalid", "#c62828"],
+    ["queue_depth", "#6a1b9a"]
+  ];
+
+  function renderTrend(samples) {
+    var box = $("#trend");
+    box.textContent = "";
+    if (samples.length < 2) {
+      box.appendChild(el("div", "采样数据不足", "empty"));
+      return;
+    }
+    var ns = "http://www.w3.org/2000/svg";
+    var w = 960, h = 200, pad = 24;
+    var max = 1;
+    samples.forEach(function (s) {
+      SERIES.forEach(function (k) { if (s[k[0]] > max) max = s[k[0]]; });
+    });
+    var t0 = new Date(samples[0].time).getTime();
+    var t1 = new Date(samples[samples.length - 1].time).getTime();
+    var span = Math.max(t1 - t0, 1);
+    var svg = document.createElementNS(ns, "svg");
+    svg.setAttribute("viewBox", "0 0 " + w + " " + h);
+    svg.setAttribute("preserveAspectRatio", "none");
+
+    var axis = document.createElementNS(ns, "text");
+    axis.setAttribute("x", 2);
+    axis.setAttribute("y", 12);
+    axis.setAttribute("class", "axis");
+    axis.textContent = String(max);
+    svg.appendChild(axis);
+
+    SERIES.forEach(function (k) {
+      var points = samples.map(function (s) {
+        var x = pad + (new Date(s.time).getTime() - t0) / span * (w - 2 * pad);
+        var y = h - pad - (s[k[0]] || 0) / max * (h - 2 * pad);
+        return x.toFixed(1) + "," + y.toFixed(1);
+      });
+      var line = document.createElementNS(ns, "polyline");
+      line.setAttribute("points", points.join(" "));
+      line.setAttribute("fill", "none");
+      line.setAttribute("stroke", k[1]);
+      line.setAttribute("stroke-width", "2");
+      svg.appendChild(line);
+    });
+    box.appendChild(svg);
+  }
+
+  function renderAccounts(data) {
+    var accounts = data.accounts || [];
+    $("#account-count").textContent = accounts.length + " 个";
+    var canOperate = ROLE_RANK[actor.role] >= ROLE_RANK.operator;
+    fillTable("#accounts", accounts.map(function (a) {
+      var btn = "";
+      if (canOperate) {
+        btn = el("button", "浏览器刷新", "small");
+        btn.addEventListener("click", function () {
+          run(api("POST", "/admin/browser-refresh", { email: a.email }));
+        });
+      }
+      return [a.email, badge(a.status), fmtTime(a.last_refresh), fmtTime(a.last_used), fmtTime(a.jwt_expires),
+        a.success_count + " / " + a.fail_count + " / " + a.total_count, btn];
+    }));
+  }
+
+  function renderRequests(data) {
+    var records = (data.records || []).slice().reverse();
+    fillTable("#requests", records.map(function (r) {
+      var status = el("span", r.status, r.status >= 400 ? "status-error" : "status-ok");
+      return [fmtTime(r.time), r.key_id || "-", r.model || "-", status, r.latency_ms + "ms", r.retries || 0];
+    }));
+  }
+
+  function renderErrors(data) {
+    fillTable("#errors", (data.errors || []).slice(0, 30).map(function (e) {
+      return [fmtTime(e.time), e.operation, e.class, e.status || "-", e.error || ""];
+    }));
+  }
+
+  function renderUsage(data) {
+    fillTable("#usage", (data.usage || []).map(function (u) {
+      return [u.key_id || "(无 Key)", u.owner || "-", u.requests, u.succeeded, u.failed, u.total_tokens,
+        fmtBytes(u.media_bytes_in) + " / " + fmtBytes(u.media_bytes_out), u.avg_latency_ms.toFixed(0) + "ms"];
+    }));
+  }
+
+  function renderConfig(data) {
+    $("#config").textContent = JSON.stringify(data, null, 2);
+    var rt = data.runtime || {};
+    var cd = $("#cooldown");
+    if (!cd.contains(document.activeElement)) {
+      cd.refresh_cooldown_sec.value = rt.refresh_cooldown_sec;
+      cd.use_cooldown_sec.value = rt.use_cooldown_sec;
+    }
+    var br = $("#browser-refresh");
+    if (!br.contains(document.activeElement)) {
+      br.enable.checked = !!rt.enable_browser_refresh;
+      br.headless.checked = !!rt.browser_refresh_headless;
+    }
+  }
+
+  // 按角色隐藏无权限的操作
+  function applyRole() {
+    document.querySelectorAll("[data-role]").forEach(function (node) {
+      node.hidden = ROLE_RANK[actor.role] < ROLE_RANK[node.getAttribute("data-role")];
+    });
+  }
+
+  // ---------- 轮询 ----------
+
+  function load() {
+    var today = new Date().toISOString().slice(0, 10);
+    return Promise.all([
+      api("GET", "/admin/status").then(renderSummary),
+      api("GET", "/admin/pool/history").then(function (d) { renderTrend(d.samples || []); }),
+      api("GET", "/admin/accounts").then(renderAccounts),
+      api("GET", "/admin/usage/records?limit=50&from=" + today).then(renderRequests),
+      api("GET", "/admin/errors").then(renderErrors),
+      api("GET", "/admin/usage?group_by=key&from=" + today).then(renderUsage),
+      api("GET", "/admin/config").then(renderConfig)
+    ]).then(function () {
+      $("#updated").textContent = "更新于 " + new Date().toLocaleTimeString();
+    }).catch(function (err) {
+      toast("加载失败: " + err.message, true);
+    });
+  }
+
+  function run(promise) {
+    return promise.then(function (data) {
+      toast(data.message || "已完成");
+      return load();
+    }).catch(function (err) {
+      toast(err.message, true);
+    });
+  }
+
+  // ---------- 操作 ----------
+
+  document.querySelectorAll("[data-action]").forEach(function (btn) {
+    btn.addEventListener("click", function () {
+      var action = btn.getAttribute("data-action");
+      if (action === "force-refresh" && !confirm("确定强制刷新全部账号？")) return;
+      run(api("POST", "/admin/" + action));
+    });
+  });
+
+  $("#cooldown").addEventListener("submit", function (ev) {
+    ev.preventDefault();
+    var f = ev.target;
+    run(api("POST", "/admin/config/cooldown", {
+      refresh_cooldown_sec: parseInt(f.refresh_cooldown_sec.value, 10) || 0,
+      use_cooldown_sec: parseInt(f.use_cooldown_sec.value, 10) || 0
+    }));
+  });
+
+  $("#browser-refresh").addEventListener("submit", function (ev) {
+    ev.preventDefault();
+    var f = ev.target;
+    run(api("POST", "/admin/config/browser-refresh", { enable: f.enable.checked, headless: f.headless.checked }));
+  });
+
+  api("GET", "/admin/whoami").then(function (d) {
+    actor = d;
+    $("#whoami").textContent = actor.name + "（" + actor.role + "）";
+    applyRole();
+  }).then(load).catch(function (err) { toast(err.message, true); });
+
+  setInterval(load, 10000);
+})();
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 测试环境 ====================
+
+// testAdminToken newTestGateway 配置的 owner 凭据，测试请求访问 /admin 时默认携带
+const testAdminToken = "adm-test-owner"
+
+type testGateway struct {
+	fake     *fakeUpstream
+	router   *gin.Engine
+	accounts []*Account
+}
+
+// newTestGateway 启动上游桩服务，并用 n 个就绪账号构建独立号池
+func newTestGateway(t *testing.T, n int) *testGateway {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	fake := newFakeUpstream(t)
+
+	oldUpstream, oldPool, oldKeys := upstream, pool, keyStore
+	oldAdmin, oldAudit, oldConfig := adminAuthState, auditLog, currentConfig()
+	oldLimiter := limiter
+	oldAdmission, oldLedger := admission, ledger
+	oldAccountStore, oldSettings := accountStore, runtimeSettings
+	t.Cleanup(func() {
+		accountStore, runtimeSettings = oldAccountStore, oldSettings
+		ledger.Close()
+		admission, ledger = oldAdmission, oldLedger
+		upstream, pool, keyStore = oldUpstream, oldPool, oldKeys
+		adminAuthState, auditLog, limiter = oldAdmin, oldAudit, oldLimiter
+		liveConfig.Store(oldConfig)
+	})
+
+	upstream = instrumentUpstream(newHTTPUpstream(fake.Client(), UpstreamConfig{APIBaseURL: fake.URL, AuthBaseURL: fake.URL}))
+	pool = &AccountPool{refreshInterval: time.Second, refreshWorkers: 1}
+	keyStore = newKeyStore(filepath.Join(t.TempDir(), "api_keys.json"))
+	useAdminCredentials(AdminCredential{Name: "test-owner", Token: testAdminToken, Role: RoleOwner})
+	auditLog = &auditLogger{}
+	limiter = newRateLimiter()
+	admission = newAdmissionQueue(0, 0, 0)
+	ledger = &usageLedger{dir: t.TempDir()}
+	runtimeSettings = newSettingsStore(filepath.Join(t.TempDir(), "runtime_settings.json"))
+
+	accountStore = newFileAccountStore(t.TempDir())
+	var accounts []*Account
+	for i := 0; i < n; i++ {
+		acc := &Account{
+			Data: AccountData{
+				Email:   fmt.Sprintf("user%d@test.local", i),
+				Cookies: []Cookie{{Name: "__Secure-C_SES", Value: fmt.Sprintf("ses-%d", i)}},
+			},
+			ID:          fmt.Sprintf("user%d", i),
+			JWT:         fmt.Sprintf("jwt-%d", i),
+			JWTExpires:  time.Now().Add(5 * time.Minute),
+			ConfigID:    "test-config",
+			CSESIDX:     fmt.Sprintf("%d", 1000+i),
+			LastRefresh: time.Now(),
+			Status:      StatusReady,
+		}
+		pool.MarkReady(acc)
+		accounts = append(accounts, acc)
+	}
+
+	return &testGateway{fake: fake, router: setupRouter(), accounts: accounts}
+}
+
+func (g *testGateway) post(t *testing.T, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("序列化请求失败: %v", err)
+	}
+	req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return g.serve(req, headers)
+}
+
+// serve 设置请求头后交给路由；未自带管理员凭据的 /admin 请求使用 testAdminToken
+func (g *testGateway) serve(req *http.Request, headers []string) *httptest.ResponseRecorder {
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	if strings.HasPrefix(req.URL.Path, "/admin") && req.Header.Get("Authorization") == "" &&
+		req.Header.Get("X-Admin-Token") == "" && req.Header.Get("Cookie") == "" {
+		req.Header.Set("X-Admin-Token", testAdminToken)
+	}
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, req)
+	return w
+}
+
+func chatBody(model string, stream bool, content interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"model":    model,
+		"stream":   stream,
+		"messages": []map[string]interface{}{{"role": "user", "content": content}},
+	}
+}
+
+// completion 非流式响应
+type completion struct {
+	Choices []struct {
+		Message struct {
+			Content          *string    `json:"content"`
+			ReasoningContent string     `json:"reasoning_content"`
+			ToolCalls        []ToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+func decodeCompletion(t *testing.T, w *httptest.ResponseRecorder) completion {
+	t.Helper()
+	if w.Code != 200 {
+		t.Fatalf("状态码 = %d, 响应: %s", w.Code, w.Body.String())
+	}
+	var resp completion
+	if err := json.Unmarshal(bytes.TrimSpace(w.Body.Bytes()), &resp); err != nil {
+		t.Fatalf("解析响应失败: %v, 响应: %s", err, w.Body.String())
+	}
+	if len(resp.Choices) != 1 {
+		t.Fatalf("choices 数量 = %d", len(resp.Choices))
+	}
+	return resp
+}
+
+// streamResult 汇总 SSE 流式响应
+type streamResult struct {
+	Role         string
+	Content      string
+	Reasoning    string
+	ToolNames    []string
+	FinishReason string
+	Done         bool
+}
+
+func decodeStream(t *testing.T, w *httptest.ResponseRecorder) streamResult {
+	t.Helper()
+	if w.Code != 200 {
+		t.Fatalf("状态码 = %d, 响应: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var res streamResult
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			res.Done = true
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Role             string `json:"role"`
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+					ToolCalls        []struct {
+						Function FunctionCall `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("解析 SSE 数据失败: %v, 数据: %s", err, payload)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Role != "" {
+				res.Role = choice.Delta.Role
+			}
+			res.Content += choice.Delta.Content
+			res.Reasoning += choice.Delta.ReasoningContent
+			for _, tc := range choice.Delta.ToolCalls {
+				res.ToolNames = append(res.ToolNames, tc.Function.Name)
+			}
+			if choice.FinishReason != nil {
+				res.FinishReason = *choice.FinishReason
+			}
+		}
+	}
+	if !res.Done {
+		t.Fatalf("流式响应缺少 [DONE]: %s", w.Body.String())
+	}
+	return res
+}
+
+// ==================== OpenAI 接口 ====================
+
+func TestChatCompletionsNonStream(t *testing.T) {
+	g := newTestGateway(t, 1)
+	g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(thoughtReply("thinking"), textReply("Hello "), textReply("world"))})
+
+	resp := decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+	msg := resp.Choices[0].Message
+	if msg.Content == nil || *msg.Content != "Hello world" {
+		t.Fatalf("content = %v", msg.Content)
+	}
+	if msg.ReasoningContent != "thinking" {
+		t.Fatalf("reasoning_content = %q", msg.ReasoningContent)
+	}
+	if resp.Choices[0].FinishReason != "stop" {
+		t.Fatalf("finish_reason = %q", resp.Choices[0].FinishReason)
+	}
+	if g.fake.Count(epCreateSession) != 1 || g.fake.Count(epStreamAssist) != 1 {
+		t.Fatalf("上游调用次数: session=%d, assist=%d", g.fake.Count(epCreateSession), g.fake.Count(epStreamAssist))
+	}
+	if g.accounts[0].SuccessCount != 1 {
+		t.Fatalf("SuccessCount = %d", g.accounts[0].SuccessCount)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	g := newTestGateway(t, 1)
+	g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(thoughtReply("plan"), textReply("Hello "), textReply("world"))})
+
+	res := decodeStream(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", true, "hi")))
+	if res.Role != "assistant" || res.Content != "Hello world" || res.Reasoning != "plan" {
+		t.Fatalf("流式结果 = %+v", res)
+	}
+	if res.FinishReason != "stop" {
+		t.Fatalf("finish_reason = %q", res.FinishReason)
+	}
+}
+
+func TestChatCompletionsToolCalls(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			g := newTestGateway(t, 1)
+			g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(functionCallReply("get_weather", map[string]interface{}{"city": "Paris"}))})
+
+			body := chatBody("gemini-2.5-flash", stream, "weather?")
+			body["tools"] = []ToolDef{{Type: "function", Function: FunctionDef{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}}}
+			w := g.post(t, "/v1/chat/completions", body)
+
+			// 自定义工具应透传为 functionDeclarations
+			if reqs := g.fake.Requests(epStreamAssist); len(reqs) != 1 || !bytes.Contains(reqs[0].Body, []byte(`"functionDeclarations"`)) {
+				t.Fatalf("streamAssist 请求未包含 functionDeclarations")
+			}
+
+			if stream {
+				res := decodeStream(t, w)
+				if len(res.ToolNames) != 1 || res.ToolNames[0] != "get_weather" || res.FinishReason != "tool_calls" {
+					t.Fatalf("流式结果 = %+v", res)
+				}
+				return
+			}
+			resp := decodeCompletion(t, w)
+			msg := resp.Choices[0].Message
+			if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+				t.Fatalf("tool_calls = %+v", msg.ToolCalls)
+			}
+			if msg.Content != nil || resp.Choices[0].FinishReason != "tool_calls" {
+				t.Fatalf("content = %v, finish_reason = %q", msg.Content, resp.Choices[0].FinishReason)
+			}
+		})
+	}
+}
+
+func TestGeneratedFileDownload(t *testing.T) {
+	imgData := []byte("\x89PNG fake image")
+	want := formatImageAsMarkdown("image/png", base64.StdEncoding.EncodeToString(imgData))
+
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			g := newTestGateway(t, 1)
+			g.fake.AddFile("gen-1", "image/png", imgData)
+			g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(textReply("here: "), fileReply("gen-1", "image/png"))})
+
+			w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash-image", stream, "draw"))
+			var content string
+			if stream {
+				content = decodeStream(t, w).Content
+			} else {
+				content = *decodeCompletion(t, w).Choices[0].Message.Content
+			}
+			if content != "here: "+want {
+				t.Fatalf("content = %q", content)
+			}
+			if g.fake.Count(epListFiles) != 1 || g.fake.Count(epDownload) != 1 {
+				t.Fatalf("下载调用次数: list=%d, download=%d", g.fake.Count(epListFiles), g.fake.Count(epDownload))
+			}
+		})
+	}
+}
+
+func TestMediaUpload(t *testing.T) {
+	g := newTestGateway(t, 1)
+	content := []map[string]interface{}{
+		{"type": "text", "text": "describe"},
+		{"type": "image_url", "image_url": map[string]string{"url": "data:image/png;base64,iVBORw0KGgo="}},
+	}
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, content)))
+
+	uploads := g.fake.Requests(epAddContext)
+	if len(uploads) != 1 || !bytes.Contains(uploads[0].Body, []byte(`"fileContents":"iVBORw0KGgo="`)) {
+		t.Fatalf("上传请求 = %d", len(uploads))
+	}
+	assist := g.fake.Requests(epStreamAssist)
+	if len(assist) != 1 || !bytes.Contains(assist[0].Body, []byte(`"fileIds":["uploaded-file"]`)) {
+		t.Fatalf("streamAssist 请求未包含上传的 fileId")
+	}
+}
+
+func TestMalformedUpstreamBodies(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated": assistTruncated(textReply("Hello "), textReply("world")),
+		"ndjson":    assistNDJSON(textReply("Hello "), textReply("world")),
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := newTestGateway(t, 1)
+			g.fake.Script(epStreamAssist, fakeResponse{Body: body})
+
+			resp := decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+			if c := resp.Choices[0].Message.Content; c == nil || *c != "Hello world" {
+				t.Fatalf("content = %v", c)
+			}
+		})
+	}
+}
+
+// ==================== Claude / Gemini 接口 ====================
+
+func TestClaudeMessages(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			g := newTestGateway(t, 1)
+			g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(textReply("bonjour"))})
+
+			w := g.post(t, "/v1/messages", map[string]interface{}{
+				"model":      "gemini-2.5-pro",
+				"system":     "be brief",
+				"max_tokens": 64,
+				"stream":     stream,
+				"messages":   []map[string]interface{}{{"role": "user", "content": "hello"}},
+			})
+			if stream {
+				if res := decodeStream(t, w); res.Content != "bonjour" {
+					t.Fatalf("content = %q", res.Content)
+				}
+			} else if c := decodeCompletion(t, w).Choices[0].Message.Content; c == nil || *c != "bonjour" {
+				t.Fatalf("content = %v", c)
+			}
+
+			// system 字段应拼入 prompt
+			reqs := g.fake.Requests(epStreamAssist)
+			if len(reqs) != 1 || !bytes.Contains(reqs[0].Body, []byte("be brief")) {
+				t.Fatalf("streamAssist 请求未包含系统提示词")
+			}
+		})
+	}
+}
+
+func TestClaudeMessagesToolUse(t *testing.T) {
+	g := newTestGateway(t, 1)
+	g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(textReply("15 度，晴"))})
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(claudeToolUseRequest))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	g.router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	if c := decodeCompletion(t, w).Choices[0].Message.Content; c == nil || *c != "15 度，晴" {
+		t.Fatalf("content = %v", c)
+	}
+
+	// 原生工具定义应转换为 functionDeclarations
+	reqs := g.fake.Requests(epStreamAssist)
+	if len(reqs) != 1 || !bytes.Contains(reqs[0].Body, []byte(`"functionDeclarations"`)) ||
+		!bytes.Contains(reqs[0].Body, []byte("get_weather")) {
+		t.Fatalf("streamAssist 请求未包含 Claude 工具定义")
+	}
+}
+
+func TestGeminiGenerateContent(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			g := newTestGateway(t, 1)
+			g.fake.Script(epStreamAssist, fakeResponse{Body: assistBody(textReply("hola"))})
+
+			path := "/v1beta/models/gemini-2.5-flash:generateContent"
+			if stream {
+				path = "/v1beta/models/gemini-2.5-flash:streamGenerateContent?alt=sse"
+			}
+			w := g.post(t, path, map[string]interface{}{
+				"contents": []map[string]interface{}{{"role": "user", "parts": []map[string]string{{"text": "hi"}}}},
+			})
+			if stream {
+				if res := decodeStream(t, w); res.Content != "hola" {
+					t.Fatalf("content = %q", res.Content)
+				}
+			} else if c := decodeCompletion(t, w).Choices[0].Message.Content; c == nil || *c != "hola" {
+				t.Fatalf("content = %v", c)
+			}
+		})
+	}
+}
+
+// ==================== 号池状态转换 ====================
+
+func TestUnauthorizedMovesAccountToPending(t *testing.T) {
+	g := newTestGateway(t, 2)
+	g.fake.Script(epStreamAssist, fakeResponse{Status: 401, Body: []byte(`{"error":{"status":"UNAUTHENTICATED"}}`)})
+
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+
+	if pool.ReadyCount() != 1 || pool.PendingCount() != 1 {
+		t.Fatalf("号池状态: ready=%d, pending=%d", pool.ReadyCount(), pool.PendingCount())
+	}
+	first := g.accounts[0]
+	if first.Status != StatusPending || !first.LastRefresh.IsZero() {
+		t.Fatalf("401 账号应待刷新: status=%v, lastRefresh=%v", first.Status, first.LastRefresh)
+	}
+	if g.accounts[1].SuccessCount != 1 {
+		t.Fatalf("重试账号 SuccessCount = %d", g.accounts[1].SuccessCount)
+	}
+}
+
+func TestRateLimitedAccountCoolsDown(t *testing.T) {
+	g := newTestGateway(t, 2)
+	g.fake.Script(epStreamAssist, fakeResponse{Status: 429, Body: []byte(`{"error":{"status":"RESOURCE_EXHAUSTED"}}`)})
+
+	start := time.Now()
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+
+	first := g.accounts[0]
+	if first.FailCount != 1 || !first.LastUsed.After(start.Add(currentSettings().UseCooldown)) || first.Status != StatusCooling {
+		t.Fatalf("429 账号应延长冷却: failCount=%d, lastUsed=%v, status=%v", first.FailCount, first.LastUsed, first.Status)
+	}
+	if pool.ReadyCount() != 2 {
+		t.Fatalf("429 不应移出就绪池: ready=%d", pool.ReadyCount())
+	}
+	if atomic.LoadInt64(&pool.totalFailed) != 1 || atomic.LoadInt64(&pool.totalSuccess) != 1 {
+		t.Fatalf("统计: failed=%d, success=%d", pool.totalFailed, pool.totalSuccess)
+	}
+}
+
+func TestAllRetriesFail(t *testing.T) {
+	g := newTestGateway(t, 2)
+	for i := 0; i < maxRetries; i++ {
+		g.fake.Script(epStreamAssist, fakeResponse{Status: 500, Body: []byte(`{"error":"boom"}`)})
+	}
+
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "HTTP 500") {
+		t.Fatalf("状态码 = %d, 响应: %s", w.Code, w.Body.String())
+	}
+	if got := atomic.LoadInt64(&pool.totalFailed); got != int64(maxRetries) {
+		t.Fatalf("totalFailed = %d", got)
+	}
+	if pool.ReadyCount() != 2 {
+		t.Fatalf("5xx 不应移出就绪池: ready=%d", pool.ReadyCount())
+	}
+}
+
+func TestThoughtOnlyResponseRetries(t *testing.T) {
+	g := newTestGateway(t, 2)
+	g.fake.Script(epStreamAssist,
+		fakeResponse{Body: []byte(`[{"streamAssistResponse":{"answer":{"replies":[{"groundedContent":{"content":{"thought":true}}}]}}}]`)},
+		fakeResponse{Body: assistBody(textReply("done"))},
+	)
+
+	if c := decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"))).Choices[0].Message.Content; c == nil || *c != "done" {
+		t.Fatalf("content = %v", c)
+	}
+	if g.fake.Count(epStreamAssist) != 2 {
+		t.Fatalf("streamAssist 调用次数 = %d", g.fake.Count(epStreamAssist))
+	}
+}
+
+func TestNoAccountsAvailable(t *testing.T) {
+	g := newTestGateway(t, 0)
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "没有可用账号") {
+		t.Fatalf("状态码 = %d, 响应: %s", w.Code, w.Body.String())
+	}
+	if g.fake.Count(epCreateSession) != 0 {
+		t.Fatalf("不应调用上游")
+	}
+}
+
+func TestInvalidRequestTimeoutHeader(t *testing.T) {
+	g := newTestGateway(t, 1)
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), "X-Request-Timeout", "soon")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `"type":"invalid_request_error"`) {
+		t.Fatalf("OpenAI: %d %s", w.Code, w.Body.String())
+	}
+	// 各方言使用各自的错误格式
+	claude := map[string]interface{}{"model": "gemini-2.5-flash", "max_tokens": 16, "messages": []map[string]string{{"role": "user", "content": "hi"}}}
+	w = g.post(t, "/v1/messages", claude, "X-Request-Timeout", "soon")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `"type":"error"`) || !strings.Contains(w.Body.String(), `"invalid_request_error"`) {
+		t.Fatalf("Claude: %d %s", w.Code, w.Body.String())
+	}
+	gemini := map[string]interface{}{"contents": []map[string]interface{}{{"parts": []map[string]string{{"text": "hi"}}}}}
+	w = g.post(t, "/v1beta/models/gemini-2.5-flash:generateContent", gemini, "X-Request-Timeout", "soon")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), `"status":"INVALID_ARGUMENT"`) {
+		t.Fatalf("Gemini: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// 冷却期内重启：JWT 不持久化，账号必须重新刷新后才能就绪
+func TestRestartWithinCooldownRefreshesJWT(t *testing.T) {
+	g := newTestGateway(t, 1)
+	useLifecycle(t)
+	statePath := filepath.Join(t.TempDir(), "state.db")
+	store := useAccountStates(t, statePath)
+
+	acc := g.accounts[0]
+	acc.Data.CSESIDX, acc.Data.ConfigID = acc.CSESIDX, acc.ConfigID
+	if err := acc.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(pool); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 模拟重启：重新打开状态库并加载号池
+	useAccountStates(t, statePath)
+	pool = &AccountPool{refreshInterval: time.Hour, refreshWorkers: 1}
+	if err := pool.Load(accountStore); err != nil {
+		t.Fatal(err)
+	}
+	pool.StartPoolManager()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.ReadyCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	restored := pool.Next()
+	if restored == nil {
+		t.Fatal("重启后账号未就绪")
+	}
+	if jwt, _, err := restored.GetJWT(); err != nil || jwt == "" {
+		t.Fatalf("重启后 GetJWT: %q, %v", jwt, err)
+	}
+	if g.fake.Count(epGetOXSRF) != 1 {
+		t.Fatalf("重启后应刷新一次 JWT, getoxsrf 调用 %d 次", g.fake.Count(epGetOXSRF))
+	}
+}
+
+func TestRefreshJWTAgainstFake(t *testing.T) {
+	g := newTestGateway(t, 1)
+	acc := g.accounts[0]
+	acc.JWT, acc.JWTExpires, acc.LastRefresh = "", time.Time{}, time.Time{}
+
+	if err := acc.RefreshJWT(); err != nil {
+		t.Fatalf("RefreshJWT: %v", err)
+	}
+	if acc.JWT == "" || acc.JWTExpires.Before(time.Now()) {
+		t.Fatalf("JWT 未更新: %q, %v", acc.JWT, acc.JWTExpires)
+	}
+	reqs := g.fake.Requests(epGetOXSRF)
+	if len(reqs) != 1 || !strings.Contains(reqs[0].Header.Get("Cookie"), "__Secure-C_SES=ses-0") {
+		t.Fatalf("getoxsrf 请求未携带 Cookie")
+	}
+
+	acc.JWTExpires, acc.LastRefresh = time.Time{}, time.Time{}
+	g.fake.Script(epGetOXSRF, fakeResponse{Status: 401, Body: []byte("expired")})
+	if err := acc.RefreshJWT(); err == nil || !strings.Contains(err.Error(), "账号失效") {
+		t.Fatalf("401 应返回账号失效错误, got %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ==================== 凭据加密 ====================
+
+// EncryptionConfig 账号凭据静态加密（信封加密）
+// 密钥也可通过环境变量 B2A_ENCRYPTION_KEY（base64）或 B2A_ENCRYPTION_KEY_FILE 提供（兼容不带 B2A_ 前缀的旧名称）；
+// 轮换时把旧密钥放入 previous_key_files 或 B2A_ENCRYPTION_PREVIOUS_KEYS（逗号分隔），加载时自动改用新密钥
+type EncryptionConfig struct {
+	KeyFile          string   `json:"key_file"`           // 主密钥文件：32 字节，base64 或 hex
+	PreviousKeyFiles []string `json:"previous_key_files"` // 轮换前的旧密钥，只用于解密
+}
+
+// SealedSecrets 加密后的敏感字段
+// 每个账号使用随机的数据密钥（DEK）加密敏感字段，DEK 再由主密钥加密；轮换主密钥只需重新加密 DEK
+type SealedSecrets struct {
+	Version int    `json:"v"`
+	KeyID   string `json:"kid"`  // 主密钥 ID（密钥 SHA-256 的前 8 字节）
+	DEK     string `json:"dek"`  // nonce || 密文，base64
+	Data    string `json:"data"` // nonce || 密文，base64
+}
+
+// accountSecrets 需要加密的字段
+type accountSecrets struct {
+	Authorization   string            `json:"authorization,omitempty"`
+	Cookies         []Cookie          `json:"cookies,omitempty"`
+	CookieString    string            `json:"cookie_string,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+}
+
+const sealedSecretsVersion = 1
+
+var errNoEncryptionKey = errors.New("账号凭据已加密，但未配置对应的密钥")
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// keyring 主密钥和轮换前的旧密钥
+type keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("密钥长度应为 32 字节，实际 %d", len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseKeyMaterial 解析 base64 或 hex 编码的 32 字节密钥
+func parseKeyMaterial(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil && len(b) == 32 {
+			return b, nil
+		}
+	}
+	return nil, errors.New("密钥须为 32 字节的 base64 或 hex 编码（可用 openssl rand -base64 32 生成）")
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+	key, err := parseKeyMaterial(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// newKeyring 第一个密钥为主密钥
+func newKeyring(raws ...[]byte) (*keyring, error) {
+	k := &keyring{keys: make(map[string]*masterKey)}
+	for _, raw := range raws {
+		mk, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if k.primary == nil {
+			k.primary = mk
+		}
+		k.keys[mk.id] = mk
+	}
+	return k, nil
+}
+
+// loadKeyring 按配置和环境变量加载密钥；未配置时返回 nil（不加密）
+func loadKeyring(cfg EncryptionConfig) (*keyring, error) {
+	var primary []byte
+	if v := firstEnv("B2A_ENCRYPTION_KEY", "ENCRYPTION_KEY"); v != "" {
+		key, err := parseKeyMaterial(v)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+		}
+		primary = key
+	} else if cfg.KeyFile != "" {
+		key, err := readKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		primary = key
+	}
+
+	var previous [][]byte
+	for _, path := range cfg.PreviousKeyFiles {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	if v := firstEnv("B2A_ENCRYPTION_PREVIOUS_KEYS", "ENCRYPTION_PREVIOUS_KEYS"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			key, err := parseKeyMaterial(s)
+			if err != nil {
+				return nil, fmt.Errorf("ENCRYPTION_PREVIOUS_KEYS: %w", err)
+			}
+			previous = append(previous, key)
+		}
+	}
+
+	if primary == nil {
+		if len(previous) > 0 {
+			return nil, errors.New("配置了旧密钥但没有主密钥")
+		}
+		return nil, nil
+	}
+	return newKeyring(append([][]byte{primary}, previous...)...)
+}
+
+func sealBytes(aead cipher.AEAD, plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func openBytes(aead cipher.AEAD, sealed string, aad []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("密文过短")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// 密文绑定主密钥 ID 和邮箱，防止不同账号之间互换
+func dekAAD(kid string) []byte { return []byte("b2a-dek/" + kid) }
+
+func secretsAAD(email string) []byte { return []byte("b2a-account/" + strings.ToLower(email)) }
+
+// Seal 加密敏感字段，返回不含明文凭据的副本
+func (k *keyring) Seal(data AccountData) (AccountData, error) {
+	secrets, err := json.Marshal(accountSecrets{
+		Authorization:   data.Authorization,
+		Cookies:         data.Cookies,
+		CookieString:    data.CookieString,
+		ResponseHeaders: data.ResponseHeaders,
+	})
+	if err != nil {
+		return data, err
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return data, err
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return data, err
+	}
+	sealedData, err := sealBytes(dekAEAD, secrets, secretsAAD(data.Email))
+	if err != nil {
+		return data, err
+	}
+	sealedDEK, err := sealBytes(k.primary.aead, dek, dekAAD(k.primary.id))
+	if err != nil {
+		return data, err
+	}
+
+	out := data
+	out.Authorization, out.Cookies, out.CookieString, out.ResponseHeaders = "", nil, "", nil
+	out.Sealed = &SealedSecrets{Version: sealedSecretsVersion, KeyID: k.primary.id, DEK: sealedDEK, Data: sealedData}
+	return out, nil
+}
+
+func (k *keyring) openDEK(s *SealedSecrets) ([]byte, error) {
+	var mk *masterKey
+	if k != nil {
+		mk = k.keys[s.KeyID]
+	}
+	if mk == nil {
+		return nil, fmt.Errorf("%w（kid=%s）", errNoEncryptionKey, s.KeyID)
+	}
+	dek, err := openBytes(mk.aead, s.DEK, dekAAD(s.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("解密数据密钥失败: %w", err)
+	}
+	return dek, nil
+}
+
+// Open 解密敏感字段；未加密的数据原样返回。k 为 nil 时遇到加密数据返回错误
+func (k *keyring) Open(data AccountData) (AccountData, error) {
+	s := data.Sealed
+	if s == nil {
+		return data, nil
+	}
+	if s.Version != sealedSecretsVersion {
+		return data, fmt.Errorf("不支持的加密格式版本 %d", s.Version)
+	}
+	dek, err := k.openDEK(s)
+	if err != nil {
+		return data, err
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return data, err
+	}
+	plain, err := openBytes(dekAEAD, s.Data, secretsAAD(data.Email))
+	if err != nil {
+		return data, fmt.Errorf("解密凭据失败: %w", err)
+	}
+	var secrets accountSecrets
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return data, fmt.Errorf("解析解密后的凭据失败: %w", err)
+	}
+
+	out := data
+	out.Sealed = nil
+	out.Authorization = secrets.Authorization
+	out.Cookies = secrets.Cookies
+	out.CookieString = secrets.CookieString
+	out.ResponseHeaders = secrets.ResponseHeaders
+	return out, nil
+}
+
+// Rewrap 用主密钥重新加密数据密钥，敏感字段的密文不变
+func (k *keyring) Rewrap(data AccountData) (AccountData, error) {
+	dek, err := k.openDEK(data.Sealed)
+	if err != nil {
+		return data, err
+	}
+	sealedDEK, err := sealBytes(k.primary.aead, dek, dekAAD(k.primary.id))
+	if err != nil {
+		return data, err
+	}
+	out := data
+	sealed := *data.Sealed
+	sealed.KeyID, sealed.DEK = k.primary.id, sealedDEK
+	out.Sealed = &sealed
+	return out, nil
+}
+
+// upgrade 明文记录加密、旧密钥加密的记录改用主密钥；无需改写时返回 false
+func (k *keyring) upgrade(data AccountData) (AccountData, bool, error) {
+	if k == nil {
+		return data, false, nil
+	}
+	if data.Sealed == nil {
+		sealed, err := k.Seal(data)
+		return sealed, err == nil, err
+	}
+	if data.Sealed.KeyID != k.primary.id {
+		rewrapped, err := k.Rewrap(data)
+		return rewrapped, err == nil, err
+	}
+	return data, false, nil
+}
+
+// encryptedAccountStore 在任意账号存储之上透明加解密
+type encryptedAccountStore struct {
+	AccountStore
+	keys *keyring // nil 时不加密，但仍能识别已加密的记录
+}
+
+func newEncryptedAccountStore(inner AccountStore, keys *keyring) *encryptedAccountStore {
+	return &encryptedAccountStore{AccountStore: inner, keys: keys}
+}
+
+// migrate 将明文或旧密钥的记录写回为当前密钥加密，失败只记录日志
+func (s *encryptedAccountStore) migrate(ctx context.Context, id string, data AccountData) {
+	upgraded, changed, err := s.keys.upgrade(data)
+	if err != nil {
+		log.Printf("⚠️ 加密账号 %s 失败: %v", id, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	if err := s.AccountStore.Put(ctx, id, upgraded); err != nil {
+		log.Printf("⚠️ 写回加密后的账号 %s 失败: %v", id, err)
+		return
+	}
+	if data.Sealed == nil {
+		log.Printf("🔐 已加密账号凭据: %s", id)
+	} else {
+		log.Printf("🔐 已用新密钥重新加密: %s", id)
+	}
+}
+
+func (s *encryptedAccountStore) List(ctx context.Context) ([]StoredAccount, error) {
+	items, err := s.AccountStore.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]StoredAccount, 0, len(items))
+	for _, item := range items {
+		data, err := s.keys.Open(item.Data)
+		if err != nil {
+			log.Printf("⚠️ 账号 %s: %v", item.ID, err)
+			continue
+		}
+		s.migrate(ctx, item.ID, item.Data)
+		out = append(out, StoredAccount{ID: item.ID, Data: data})
+	}
+	return out, nil
+}
+
+func (s *encryptedAccountStore) Get(ctx context.Context, id string) (AccountData, error) {
+	stored, err := s.AccountStore.Get(ctx, id)
+	if err != nil {
+		return stored, err
+	}
+	data, err := s.keys.Open(stored)
+	if err != nil {
+		return data, fmt.Errorf("账号 %s: %w", id, err)
+	}
+	s.migrate(ctx, id, stored)
+	return data, nil
+}
+
+func (s *encryptedAccountStore) Put(ctx context.Context, id string, data AccountData) error {
+	if s.keys != nil {
+		sealed, err := s.keys.Seal(data)
+		if err != nil {
+			return fmt.Errorf("加密账号 %s 失败: %w", id, err)
+		}
+		data = sealed
+	}
+	return s.AccountStore.Put(ctx, id, data)
+}
+
+// ==================== 命令行 ====================
+
+// runDecryptMode 解密并打印账号（账号文件路径或存储中的 ID），供排查使用
+func runDecryptMode(target string) {
+	keys, err := loadKeyring(currentConfig().Encryption)
+	if err != nil {
+		log.Fatalf("❌ 加载密钥失败: %v", err)
+	}
+
+	var data AccountData
+	if raw, err := os.ReadFile(target); err == nil {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			log.Fatalf("❌ 解析 %s 失败: %v", target, err)
+		}
+		if data, err = keys.Open(data); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	} else {
+		initAccountStore()
+		if data, err = accountStore.Get(context.Background(), target); err != nil {
+			log.Fatalf("❌ 读取账号 %s 失败: %v", target, err)
+		}
+	}
+	out, _ := json.MarshalIndent(data, "", "  ")
+	fmt.Println(string(out))
+}
+
+// runReencryptMode 用当前主密钥加密全部账号后退出（加密明文记录、轮换旧密钥）
+func runReencryptMode() {
+	initAccountStore()
+	if accountStore.(*encryptedAccountStore).keys == nil {
+		log.Fatal("❌ 未配置加密密钥（ENCRYPTION_KEY、ENCRYPTION_KEY_FILE 或 encryption.key_file）")
+	}
+	items, err := accountStore.List(context.Background())
+	if err != nil {
+		log.Fatalf("❌ 读取账号失败: %v", err)
+	}
+	log.Printf("✅ 已检查 %d 个账号", len(items))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func mustKeyring(t *testing.T, raws ...[]byte) *keyring {
+	t.Helper()
+	k, err := newKeyring(raws...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func secretAccountData() AccountData {
+	return AccountData{
+		Email:           "alice@test.local",
+		Authorization:   "Bearer top-secret",
+		Cookies:         []Cookie{{Name: "__Secure-C_SES", Value: "cookie-secret"}},
+		CookieString:    "__Secure-C_SES=cookie-secret",
+		ResponseHeaders: map[string]string{"set-cookie": "header-secret"},
+		CSESIDX:         "12345",
+	}
+}
+
+func readRawAccount(t *testing.T, dir, id string) (AccountData, string) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data AccountData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data, string(raw)
+}
+
+func assertNoSecrets(t *testing.T, raw string) {
+	t.Helper()
+	for _, s := range []string{"top-secret", "cookie-secret", "header-secret"} {
+		if strings.Contains(raw, s) {
+			t.Fatalf("密文中出现明文 %q: %s", s, raw)
+		}
+	}
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	k := mustKeyring(t, testKey(1))
+	data := secretAccountData()
+	sealed, err := k.Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(sealed)
+	assertNoSecrets(t, string(raw))
+	if sealed.Email != data.Email || sealed.CSESIDX != data.CSESIDX || sealed.Sealed.KeyID != k.primary.id {
+		t.Fatalf("非敏感字段应保留: %s", raw)
+	}
+
+	opened, err := k.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Sealed != nil || opened.Authorization != data.Authorization || opened.Cookies[0].Value != "cookie-secret" ||
+		opened.CookieString != data.CookieString || opened.ResponseHeaders["set-cookie"] != "header-secret" {
+		t.Fatalf("解密结果: %+v", opened)
+	}
+
+	// 密文与邮箱绑定，不能挪到其他账号
+	moved := sealed
+	moved.Email = "mallory@test.local"
+	if _, err := k.Open(moved); err == nil {
+		t.Fatal("更换邮箱后仍能解密")
+	}
+	// 错误的密钥
+	if _, err := mustKeyring(t, testKey(2)).Open(sealed); !errors.Is(err, errNoEncryptionKey) {
+		t.Fatalf("未知密钥: %v", err)
+	}
+	var none *keyring
+	if _, err := none.Open(sealed); !errors.Is(err, errNoEncryptionKey) {
+		t.Fatalf("未配置密钥: %v", err)
+	}
+	if plain, err := none.Open(data); err != nil || plain.Authorization != data.Authorization {
+		t.Fatal("未加密的数据应原样返回")
+	}
+}
+
+func TestEncryptedStoreMigratesPlainFiles(t *testing.T) {
+	dir := t.TempDir()
+	inner := newFileAccountStore(dir)
+	ctx := context.Background()
+	if err := inner.Put(ctx, "alice@test.local", secretAccountData()); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newEncryptedAccountStore(inner, mustKeyring(t, testKey(1)))
+	items, err := store.List(ctx)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("List = %v, %v", items, err)
+	}
+	if items[0].Data.Cookies[0].Value != "cookie-secret" {
+		t.Fatalf("返回的数据应为明文: %+v", items[0].Data)
+	}
+	stored, raw := readRawAccount(t, dir, "alice@test.local")
+	if stored.Sealed == nil {
+		t.Fatal("明文文件未迁移")
+	}
+	assertNoSecrets(t, raw)
+
+	// 新写入的账号直接加密
+	bob := secretAccountData()
+	bob.Email = "bob@test.local"
+	if err := store.Put(ctx, "bob@test.local", bob); err != nil {
+		t.Fatal(err)
+	}
+	_, raw = readRawAccount(t, dir, "bob@test.local")
+	assertNoSecrets(t, raw)
+	got, err := store.Get(ctx, "bob@test.local")
+	if err != nil || got.Authorization != "Bearer top-secret" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	// 未配置密钥时跳过已加密的账号，而不是加载空凭据
+	if items, _ := newEncryptedAccountStore(inner, nil).List(ctx); len(items) != 0 {
+		t.Fatalf("未配置密钥时加载了 %d 个加密账号", len(items))
+	}
+}
+
+func TestEncryptedStoreKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	inner := newFileAccountStore(dir)
+	ctx := context.Background()
+	oldKeys := mustKeyring(t, testKey(1))
+	if err := newEncryptedAccountStore(inner, oldKeys).Put(ctx, "alice@test.local", secretAccountData()); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := readRawAccount(t, dir, "alice@test.local")
+
+	// 新密钥为主密钥，旧密钥仅用于解密
+	rotated := mustKeyring(t, testKey(2), testKey(1))
+	items, err := newEncryptedAccountStore(inner, rotated).List(ctx)
+	if err != nil || len(items) != 1 || items[0].Data.Authorization != "Bearer top-secret" {
+		t.Fatalf("轮换期间 List = %+v, %v", items, err)
+	}
+	after, raw := readRawAccount(t, dir, "alice@test.local")
+	assertNoSecrets(t, raw)
+	if after.Sealed.KeyID != rotated.primary.id || after.Sealed.KeyID == before.Sealed.KeyID {
+		t.Fatalf("未改用新密钥: %+v", after.Sealed)
+	}
+	if after.Sealed.Data != before.Sealed.Data {
+		t.Fatal("轮换只应重新加密数据密钥")
+	}
+
+	// 移除旧密钥后仍可读取
+	got, err := newEncryptedAccountStore(inner, mustKeyring(t, testKey(2))).Get(ctx, "alice@test.local")
+	if err != nil || got.Cookies[0].Value != "cookie-secret" {
+		t.Fatalf("移除旧密钥后 Get = %+v, %v", got, err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(testKey(1))+"\n"), 0600)
+
+	t.Setenv("ENCRYPTION_KEY", "")
+	t.Setenv("ENCRYPTION_PREVIOUS_KEYS", "")
+	if k, err := loadKeyring(EncryptionConfig{}); err != nil || k != nil {
+		t.Fatalf("未配置密钥时应返回 nil: %v, %v", k, err)
+	}
+	k, err := loadKeyring(EncryptionConfig{KeyFile: keyFile})
+	if err != nil || k.primary.id != mustKeyring(t, testKey(1)).primary.id {
+		t.Fatalf("密钥文件: %v", err)
+	}
+
+	// 环境变量优先，旧密钥可用 hex
+	t.Setenv("ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(testKey(2)))
+	t.Setenv("ENCRYPTION_PREVIOUS_KEYS", strings.Repeat("03", 32))
+	k, err = loadKeyring(EncryptionConfig{KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.primary.id != mustKeyring(t, testKey(2)).primary.id || len(k.keys) != 2 {
+		t.Fatalf("密钥: primary=%s keys=%d", k.primary.id, len(k.keys))
+	}
+
+	t.Setenv("ENCRYPTION_KEY", "too-short")
+	if _, err := loadKeyring(EncryptionConfig{}); err == nil {
+		t.Fatal("无效密钥应报错")
+	}
+	t.Setenv("ENCRYPTION_KEY", "")
+	if _, err := loadKeyring(EncryptionConfig{}); err == nil {
+		t.Fatal("只有旧密钥时应报错")
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ==================== 事件总线 ====================
+
+// EventType 事件类型
+type EventType string
+
+const (
+	EventAccountNeedsRefresh EventType = "account.needs_refresh" // 账号被移回刷新池
+	EventAccountRemoved      EventType = "account.removed"       // 账号连续失败被移除或被管理员删除
+	EventAccountDisabled     EventType = "account.disabled"      // 账号被管理员禁用
+	EventAccountEnabled      EventType = "account.enabled"       // 账号被管理员重新启用
+	EventPoolLow             EventType = "pool.low"              // 就绪账号数低于 min_count
+	EventPoolRecovered       EventType = "pool.recovered"        // 就绪账号数恢复
+	EventRetryStorm          EventType = "request.retry_storm"   // 短时间内大量切换账号重试
+)
+
+// 事件级别
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Event 一次状态变化
+type Event struct {
+	ID       string                 `json:"id"`
+	Type     EventType              `json:"type"`
+	Time     time.Time              `json:"time"`
+	Severity string                 `json:"severity"`
+	Key      string                 `json:"key,omitempty"` // 去重键：同类型同键的事件在去重窗口内只通知一次
+	Message  string                 `json:"message"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventsConfig 事件通知配置
+type EventsConfig struct {
+	Webhooks            []WebhookConfig `json:"webhooks"`
+	DedupeWindowSec     int             `json:"dedupe_window_sec"`     // 同类事件去重窗口，默认 300
+	RetryStormThreshold int             `json:"retry_storm_threshold"` // 每分钟重试次数达到该值时发出 request.retry_storm，0 表示不检测
+}
+
+// WebhookConfig 单个 Webhook
+type WebhookConfig struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Format     string   `json:"format"`      // json（默认）或 slack
+	Secret     string   `json:"secret"`      // 设置后使用 HMAC-SHA256 签名
+	Events     []string `json:"events"`      // 订阅的事件类型，留空订阅全部；支持前缀通配如 account.*
+	MaxRetries int      `json:"max_retries"` // 失败重试次数，默认 3
+	TimeoutSec int      `json:"timeout_sec"` // 单次请求超时，默认 10
+}
+
+type eventSubscriber struct {
+	ch     chan Event
+	accept func(EventType) bool
+}
+
+// eventBus 进程内发布订阅；发布不阻塞，订阅者处理不过来时丢弃
+type eventBus struct {
+	mu      sync.RWMutex
+	subs    map[int]*eventSubscriber
+	nextID  int
+	dropped atomic.Int64
+}
+
+var events = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[int]*eventSubscriber)}
+}
+
+// Subscribe 订阅事件，accept 为 nil 时接收全部；返回的函数用于取消订阅
+func (b *eventBus) Subscribe(buffer int, accept func(EventType) bool) (<-chan Event, func()) {
+	if accept == nil {
+		accept = func(EventType) bool { return true }
+	}
+	sub := &eventSubscriber{ch: make(chan Event, buffer), accept: accept}
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+func (b *eventBus) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Severity == "" {
+		e.Severity = SeverityInfo
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs {
+		if !sub.accept(e.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// publishEvent 发布事件并记录日志
+func publishEvent(typ EventType, severity, key, message string, data map[string]interface{}) {
+	log.Printf("📣 事件 %s: %s", typ, message)
+	events.Publish(Event{Type: typ, Severity: severity, Key: key, Message: message, Data: data})
+}
+
+// eventTypeFilter 按类型列表过滤，支持 account.* 前缀通配；列表为空时全部接收
+func eventTypeFilter(types []string) func(EventType) bool {
+	if len(types) == 0 {
+		return nil
+	}
+	return func(t EventType) bool {
+		for _, want := range types {
+			want = strings.TrimSpace(want)
+			if want == "*" || want == string(t) ||
+				(strings.HasSuffix(want, ".*") && strings.HasPrefix(string(t), strings.TrimSuffix(want, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// accountEventData 事件中的账号标识：邮箱部分隐藏，附带与 trace 一致的摘要
+func accountEventData(acc *Account) map[string]interface{} {
+	return map[string]interface{}{
+		"account":      emailPattern.ReplaceAllString(acc.Data.Email, "$1***@$2"),
+		"account_hash": accountHash(acc),
+	}
+}
+
+// ==================== 号池事件 ====================
+
+var poolLow atomic.Bool
+
+// checkPoolLevel 就绪账号数跨过 min_count 时发出 pool.low / pool.recovered
+func (p *AccountPool) checkPoolLevel() {
+	minCount := currentConfig().Pool.MinCount
+	if minCount <= 0 {
+		return
+	}
+	ready := p.ReadyCount()
+	data := map[string]interface{}{"ready": ready, "min_count": minCount, "pending": p.PendingCount()}
+	if ready < minCount {
+		if poolLow.CompareAndSwap(false, true) {
+			publishEvent(EventPoolLow, SeverityCritical, "pool",
+				fmt.Sprintf("就绪账号 %d 个，低于最小值 %d", ready, minCount), data)
+		}
+	} else if poolLow.CompareAndSwap(true, false) {
+		publishEvent(EventPoolRecovered, SeverityInfo, "pool",
+			fmt.Sprintf("就绪账号恢复到 %d 个", ready), data)
+	}
+}
+
+// retryStormDetector 统计最近一分钟的切换账号重试次数
+type retryStormDetector struct {
+	mu        sync.Mutex
+	window    *errorWindow
+	lastFired time.Time
+}
+
+var retryStorm = &retryStormDetector{window: newErrorWindow(time.Minute)}
+
+func (d *retryStormDetector) Record(now time.Time) {
+	threshold := currentConfig().Events.RetryStormThreshold
+	if threshold <= 0 {
+		return
+	}
+	d.window.Record(now, true)
+	_, n := d.window.Rate(now)
+	d.mu.Lock()
+	fire := n >= threshold && now.Sub(d.lastFired) >= time.Minute
+	if fire {
+		d.lastFired = now
+	}
+	d.mu.Unlock()
+	if fire {
+		publishEvent(EventRetryStorm, SeverityWarning, "retry_storm",
+			fmt.Sprintf("最近一分钟重试 %d 次", n), map[string]interface{}{"retries": n, "threshold": threshold})
+	}
+}
+
+// ==================== Webhook ====================
+
+// webhook 重试退避基数（测试中缩短）
+var webhookBackoff = time.Second
+
+type webhookSender struct {
+	cfg    WebhookConfig
+	client *http.Client
+	window time.Duration
+	events <-chan Event
+	cancel func()
+	done   chan struct{}
+	sent   map[string]time.Time // 去重：类型+键 -> 最近一次通知时间
+}
+
+var webhookSenders []*webhookSender
+
+// initEvents 为每个 Webhook 启动独立的发送协程
+func initEvents() {
+	cfg := currentConfig().Events
+	window := time.Duration(cfg.DedupeWindowSec) * time.Second
+	for _, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			continue
+		}
+		if _, err := webhookPayload(wh.Format, Event{}); err != nil {
+			log.Printf("❌ Webhook %s 配置无效: %v", webhookName(wh), err)
+			continue
+		}
+		s := newWebhookSender(wh, window)
+		webhookSenders = append(webhookSenders, s)
+		go s.run()
+		log.Printf("📣 Webhook 已启用: %s (%s)", webhookName(wh), wh.Format)
+	}
+}
+
+// stopWebhooks 停止订阅，并等待已收到的事件发送完成
+func stopWebhooks(ctx context.Context) {
+	for _, s := range webhookSenders {
+		s.cancel()
+	}
+	for _, s := range webhookSenders {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+	webhookSenders = nil
+}
+
+func webhookName(cfg WebhookConfig) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	if u, err := url.Parse(cfg.URL); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+func newWebhookSender(cfg WebhookConfig, window time.Duration) *webhookSender {
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.TimeoutSec <= 0 {
+		cfg.TimeoutSec = 10
+	}
+	ch, cancel := events.Subscribe(256, eventTypeFilter(cfg.Events))
+	return &webhookSender{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.TimeoutSec) * time.Second},
+		window: window,
+		events: ch,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		sent:   make(map[string]time.Time),
+	}
+}
+
+func (s *webhookSender) run() {
+	defer close(s.done)
+	for e := range s.events {
+		if s.duplicate(e) {
+			continue
+		}
+		if err := s.deliver(e); err != nil {
+			log.Printf("❌ Webhook %s 发送 %s 失败: %v", webhookName(s.cfg), e.Type, err)
+		}
+	}
+}
+
+// duplicate 去重窗口内已通知过的同类事件
+func (s *webhookSender) duplicate(e Event) bool {
+	if e.Key == "" || s.window <= 0 {
+		return false
+	}
+	for k, t := range s.sent {
+		if e.Time.Sub(t) >= s.window {
+			delete(s.sent, k)
+		}
+	}
+	key := string(e.Type) + "|" + e.Key
+	if _, ok := s.sent[key]; ok {
+		return true
+	}
+	s.sent[key] = e.Time
+	return false
+}
+
+// deliver 发送一次事件，网络错误、5xx 和 429 时按指数退避重试
+func (s *webhookSender) deliver(e Event) error {
+	body, err := webhookPayload(s.cfg.Format, e)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt <= s.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookBackoff << (attempt - 1))
+		}
+		retry, err := s.post(e, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+		log.Printf("⚠️ Webhook %s 发送失败 (%d/%d): %v", webhookName(s.cfg), attempt+1, s.cfg.MaxRetries+1, err)
+	}
+	return lastErr
+}
+
+func (s *webhookSender) post(e Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "business2api-webhook")
+	req.Header.Set("X-B2A-Event", string(e.Type))
+	req.Header.Set("X-B2A-Event-ID", e.ID) // 重试时不变，接收方可据此去重
+	if s.cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-B2A-Timestamp", ts)
+		req.Header.Set("X-B2A-Signature", "sha256="+signWebhook(s.cfg.Secret, ts, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == 429, fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// signWebhook HMAC-SHA256(secret, "<timestamp>.<body>")，十六进制
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var severityEmoji = map[string]string{SeverityInfo: "ℹ️", SeverityWarning: "⚠️", SeverityCritical: "🚨"}
+
+func webhookPayload(format string, e Event) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.Marshal(e)
+	case "slack":
+		text := fmt.Sprintf("%s *[%s]* %s", severityEmoji[e.Severity], e.Type, e.Message)
+		if len(e.Data) > 0 {
+			data, _ := json.Marshal(e.Data)
+			text += "\n```" + string(data) + "```"
+		}
+		return json.Marshal(map[string]string{"text": text})
+	}
+	return nil, fmt.Errorf("不支持的 Webhook 格式 %q（可选 json、slack）", format)
+}
+
+// ==================== 事件流 ====================
+
+func registerEventRoutes(admin *gin.RouterGroup) {
+	// SSE 实时事件流：types=account.*,pool.low 过滤
+	admin.GET("/events", requireRole(RoleViewer), func(c *gin.Context) {
+		var types []string
+		if q := c.Query("types"); q != "" {
+			types = strings.Split(q, ",")
+		}
+		ch, cancel := events.Subscribe(64, eventTypeFilter(types))
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(200)
+		c.Writer.WriteString(": connected\n\n")
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-app.Draining():
+				// 事件流不会自行结束，关闭时主动断开以免拖住排空
+				return
+			case <-heartbeat.C:
+				c.Writer.WriteString(": keepalive\n\n")
+			case e := <-ch:
+				data, _ := json.Marshal(e)
+				fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+			c.Writer.Flush()
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitEvent 在超时内读取一个事件
+func waitEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待事件超时")
+	}
+	return Event{}
+}
+
+func TestEventBusFilterAndDrop(t *testing.T) {
+	bus := newEventBus()
+	ch, cancel := bus.Subscribe(1, eventTypeFilter([]string{"account.*"}))
+	defer cancel()
+
+	bus.Publish(Event{Type: EventPoolLow})
+	bus.Publish(Event{Type: EventAccountRemoved, Message: "first"})
+	bus.Publish(Event{Type: EventAccountNeedsRefresh, Message: "dropped"})
+
+	e := <-ch
+	if e.Type != EventAccountRemoved || e.ID == "" || e.Time.IsZero() || e.Severity != SeverityInfo {
+		t.Fatalf("事件: %+v", e)
+	}
+	if n := bus.dropped.Load(); n != 1 {
+		t.Fatalf("丢弃数 = %d", n)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("取消订阅后通道未关闭")
+	}
+	bus.Publish(Event{Type: EventAccountRemoved}) // 取消后发布不应 panic
+}
+
+// webhookReceiver 记录收到的请求，前 failures 次返回 failStatus
+type webhookReceiver struct {
+	mu         sync.Mutex
+	failures   int
+	failStatus int
+	requests   []*http.Request
+	bodies     [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(r.failStatus)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// runWebhook 启动发送协程，fn 执行完后停止并等待发送完成
+func runWebhook(t *testing.T, cfg WebhookConfig, window time.Duration, fn func()) {
+	t.Helper()
+	oldBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = oldBackoff }()
+
+	s := newWebhookSender(cfg, window)
+	go s.run()
+	fn()
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook 未在超时内完成")
+	}
+}
+
+func TestWebhookSignedRetryAndDedupe(t *testing.T) {
+	recv := &webhookReceiver{failures: 2, failStatus: 503}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	runWebhook(t, WebhookConfig{URL: srv.URL, Secret: "s3cret", Events: []string{"account.removed"}}, time.Minute, func() {
+		events.Publish(Event{Type: EventAccountRemoved, Key: "a", Message: "removed a"})
+		events.Publish(Event{Type: EventAccountRemoved, Key: "a", Message: "duplicate"})
+		events.Publish(Event{Type: EventAccountRemoved, Key: "b", Message: "removed b"})
+		events.Publish(Event{Type: EventPoolLow, Key: "pool"}) // 未订阅
+	})
+
+	// 第一个事件重试 2 次后成功，重复事件被去重
+	if len(recv.requests) != 4 {
+		t.Fatalf("请求数 = %d", len(recv.requests))
+	}
+	if recv.requests[0].Header.Get("X-B2A-Event-ID") != recv.requests[2].Header.Get("X-B2A-Event-ID") {
+		t.Fatal("重试时事件 ID 变化")
+	}
+	var got Event
+	json.Unmarshal(recv.bodies[3], &got)
+	if got.Message != "removed b" || got.Type != EventAccountRemoved {
+		t.Fatalf("最后一个事件: %s", recv.bodies[3])
+	}
+	req := recv.requests[3]
+	want := "sha256=" + signWebhook("s3cret", req.Header.Get("X-B2A-Timestamp"), recv.bodies[3])
+	if req.Header.Get("X-B2A-Signature") != want || req.Header.Get("X-B2A-Event") != "account.removed" {
+		t.Fatalf("签名头: %v", req.Header)
+	}
+}
+
+func TestWebhookSlackFormatNoRetryOn4xx(t *testing.T) {
+	recv := &webhookReceiver{failures: 1, failStatus: 400}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	runWebhook(t, WebhookConfig{URL: srv.URL, Format: "slack"}, 0, func() {
+		events.Publish(Event{Type: EventPoolLow, Severity: SeverityCritical, Key: "pool", Message: "就绪账号 0 个",
+			Data: map[string]interface{}{"ready": 0}})
+		events.Publish(Event{Type: EventPoolLow, Severity: SeverityCritical, Key: "pool", Message: "again"})
+	})
+
+	// 4xx 不重试；去重窗口为 0 时不去重
+	if len(recv.requests) != 2 {
+		t.Fatalf("请求数 = %d", len(recv.requests))
+	}
+	var payload map[string]string
+	json.Unmarshal(recv.bodies[0], &payload)
+	if !strings.Contains(payload["text"], "[pool.low]") || !strings.Contains(payload["text"], `"ready":0`) ||
+		recv.requests[0].Header.Get("X-B2A-Signature") != "" {
+		t.Fatalf("Slack 消息: %s", recv.bodies[0])
+	}
+}
+
+func TestPoolEventsPublished(t *testing.T) {
+	t.Cleanup(func() { poolLow.Store(false) })
+	useConfig(t, func(cfg *AppConfig) { cfg.Pool.MinCount = 2 })
+	poolLow.Store(false)
+	g := newTestGateway(t, 2)
+
+	ch, cancel := events.Subscribe(16, nil)
+	defer cancel()
+
+	pool.MarkNeedsRefresh(g.accounts[0], "test")
+	low := waitEvent(t, ch)
+	if low.Type != EventPoolLow || low.Data["ready"] != 1 || low.Severity != SeverityCritical {
+		t.Fatalf("pool.low: %+v", low)
+	}
+	e := waitEvent(t, ch)
+	if e.Type != EventAccountNeedsRefresh || e.Key != accountHash(g.accounts[0]) ||
+		e.Data["account"] != "us***@test.local" {
+		t.Fatalf("account.needs_refresh: %+v", e)
+	}
+
+	pool.MarkReady(g.accounts[0])
+	if e := waitEvent(t, ch); e.Type != EventPoolRecovered {
+		t.Fatalf("pool.recovered: %+v", e)
+	}
+}
+
+func TestRetryStormDetector(t *testing.T) {
+	useConfig(t, func(cfg *AppConfig) { cfg.Events.RetryStormThreshold = 3 })
+
+	ch, cancel := events.Subscribe(16, eventTypeFilter([]string{string(EventRetryStorm)}))
+	defer cancel()
+
+	d := &retryStormDetector{window: newErrorWindow(time.Minute)}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		d.Record(now)
+	}
+	if e := waitEvent(t, ch); e.Data["retries"] != 3 {
+		t.Fatalf("retry_storm: %+v", e)
+	}
+	// 一分钟内只触发一次
+	select {
+	case e := <-ch:
+		t.Fatalf("重复触发: %+v", e)
+	default:
+	}
+}
+
+func TestAdminEventStream(t *testing.T) {
+	g := newTestGateway(t, 1)
+	srv := httptest.NewServer(g.router)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/admin/events?types=pool.*", nil)
+	req.Header.Set("X-Admin-Token", testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("/admin/events = %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("首行: %q", line)
+	}
+
+	events.Publish(Event{Type: EventAccountRemoved, Message: "filtered"})
+	events.Publish(Event{Type: EventPoolRecovered, Message: "恢复"})
+
+	lines := make(chan string)
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "event: ") {
+				if got := strings.TrimSpace(strings.TrimPrefix(line, "event: ")); got != string(EventPoolRecovered) {
+					t.Fatalf("收到未订阅的事件 %s", got)
+				}
+				data := <-lines
+				if !strings.Contains(data, "恢复") {
+					t.Fatalf("data: %q", data)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("未收到事件")
+		}
+	}
+}
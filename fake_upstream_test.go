@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ==================== 上游桩服务 ====================
+
+// 上游桩端点名称
+const (
+	epGetOXSRF      = "getoxsrf"
+	epCreateSession = "widgetCreateSession"
+	epAddContext    = "widgetAddContextFile"
+	epStreamAssist  = "widgetStreamAssist"
+	epListFiles     = "widgetListSessionFileMetadata"
+	epDownload      = "download"
+)
+
+const fakeSessionName = "collections/default_collection/engines/test/sessions/fake-session"
+const fakeFullSession = "projects/1/locations/global/" + fakeSessionName
+
+// fakeResponse 一次脚本化响应
+type fakeResponse struct {
+	Status int
+	Body   []byte
+}
+
+// fakeFile 可供下载的生成文件
+type fakeFile struct {
+	MimeType string
+	Data     []byte
+}
+
+// fakeRequest 桩服务收到的请求记录
+type fakeRequest struct {
+	Endpoint string
+	Header   http.Header
+	Body     []byte
+}
+
+// fakeUpstream 进程内的 discoveryengine 桩服务，按端点排队脚本化响应
+type fakeUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	scripts  map[string][]fakeResponse
+	files    map[string]fakeFile
+	requests []fakeRequest
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	f := &fakeUpstream{
+		scripts: make(map[string][]fakeResponse),
+		files:   make(map[string]fakeFile),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Script 为端点追加响应，按顺序消费；队列为空时返回默认成功响应
+func (f *fakeUpstream) Script(endpoint string, responses ...fakeResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[endpoint] = append(f.scripts[endpoint], responses...)
+}
+
+// AddFile 注册一个 AI 生成的文件，供 ListSessionFileMetadata 和下载使用
+func (f *fakeUpstream) AddFile(fileID, mimeType string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileID] = fakeFile{MimeType: mimeType, Data: data}
+}
+
+// Count 返回端点收到的请求次数
+func (f *fakeUpstream) Count(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r.Endpoint == endpoint {
+			n++
+		}
+	}
+	return n
+}
+
+// Requests 返回端点收到的所有请求
+func (f *fakeUpstream) Requests(endpoint string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeRequest
+	for _, r := range f.requests {
+		if r.Endpoint == endpoint {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func endpointOf(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/auth/getoxsrf"):
+		return epGetOXSRF
+	case strings.HasPrefix(path, "/download/"):
+		return epDownload
+	default:
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+}
+
+func (f *fakeUpstream) handle(w http.ResponseWriter, r *http.Request) {
+	endpoint := endpointOf(r)
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Endpoint: endpoint, Header: r.Header.Clone(), Body: body})
+	var scripted *fakeResponse
+	if queue := f.scripts[endpoint]; len(queue) > 0 {
+		scripted = &queue[0]
+		f.scripts[endpoint] = queue[1:]
+	}
+	f.mu.Unlock()
+
+	if scripted != nil {
+		status := scripted.Status
+		if status == 0 {
+			status = 200
+		}
+		w.WriteHeader(status)
+		w.Write(scripted.Body)
+		return
+	}
+
+	switch endpoint {
+	case epGetOXSRF:
+		token := base64.RawURLEncoding.EncodeToString([]byte("fake-xsrf-key-0123456789"))
+		fmt.Fprintf(w, `)]}'
+{"xsrfToken":%q,"keyId":"fake-key"}`, token)
+	case epCreateSession:
+		writeJSON(w, map[string]interface{}{"session": map[string]string{"name": fakeSessionName}})
+	case epAddContext:
+		writeJSON(w, map[string]interface{}{"addContextFileResponse": map[string]string{"fileId": "uploaded-file"}})
+	case epStreamAssist:
+		w.Write(assistBody(textReply("ok")))
+	case epListFiles:
+		f.mu.Lock()
+		var metas []map[string]string
+		for id := range f.files {
+			metas = append(metas, map[string]string{"fileId": id, "session": fakeFullSession})
+		}
+		f.mu.Unlock()
+		writeJSON(w, map[string]interface{}{
+			"listSessionFileMetadataResponse": map[string]interface{}{"fileMetadata": metas},
+		})
+	case epDownload:
+		f.mu.Lock()
+		file, ok := f.files[r.URL.Query().Get("fileId")]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Content-Type", file.MimeType)
+		w.Write(file.Data)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ==================== streamAssist 响应构造 ====================
+
+func textReply(text string) map[string]interface{} {
+	return map[string]interface{}{"text": text}
+}
+
+func thoughtReply(text string) map[string]interface{} {
+	return map[string]interface{}{"text": text, "thought": true}
+}
+
+func fileReply(fileID, mimeType string) map[string]interface{} {
+	return map[string]interface{}{"file": map[string]string{"fileId": fileID, "mimeType": mimeType}}
+}
+
+func functionCallReply(name string, args map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"functionCall": map[string]interface{}{"name": name, "args": args}}
+}
+
+// assistChunk 构造一个 streamAssistResponse 数据块，每个 content 对应一个 reply
+func assistChunk(contents ...map[string]interface{}) map[string]interface{} {
+	var replies []interface{}
+	for _, content := range contents {
+		replies = append(replies, map[string]interface{}{
+			"groundedContent": map[string]interface{}{"content": content},
+		})
+	}
+	return map[string]interface{}{
+		"streamAssistResponse": map[string]interface{}{
+			"answer":      map[string]interface{}{"replies": replies},
+			"sessionInfo": map[string]string{"session": fakeSessionName},
+		},
+	}
+}
+
+// assistBody 以 JSON 数组格式返回，每个 content 一个数据块
+func assistBody(contents ...map[string]interface{}) []byte {
+	var chunks []map[string]interface{}
+	for _, content := range contents {
+		chunks = append(chunks, assistChunk(content))
+	}
+	data, _ := json.Marshal(chunks)
+	return data
+}
+
+// assistNDJSON 以 NDJSON 格式返回
+func assistNDJSON(contents ...map[string]interface{}) []byte {
+	var lines []string
+	for _, content := range contents {
+		data, _ := json.Marshal(assistChunk(content))
+		lines = append(lines, string(data))
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// assistTruncated 返回缺少结尾的 JSON 数组（模拟连接中断）
+func assistTruncated(contents ...map[string]interface{}) []byte {
+	data := assistBody(contents...)
+	return append(data[:len(data)-1], []byte(`,{"streamAssistResponse":{"answer":{"rep`)...)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 健康检查 ====================
+
+// HealthConfig /readyz 的判定阈值
+type HealthConfig struct {
+	MinReadyAccounts     int     `json:"min_ready_accounts"`      // 就绪账号数下限
+	MaxUpstreamErrorRate float64 `json:"max_upstream_error_rate"` // 近期上游错误率上限（0~1），0 表示不检查
+	ErrorWindowSec       int     `json:"error_window_sec"`        // 错误率统计窗口
+	MinUpstreamSamples   int     `json:"min_upstream_samples"`    // 窗口内调用数少于该值时不判定错误率
+}
+
+var processStart = time.Now()
+
+const errorBucketSec = 10
+
+// errorWindow 按 10 秒分桶统计近期上游调用的成功/失败数
+type errorWindow struct {
+	mu      sync.Mutex
+	buckets []errorBucket
+}
+
+type errorBucket struct {
+	slot   int64
+	total  int
+	errors int
+}
+
+var upstreamWindow = newErrorWindow(300 * time.Second)
+
+func newErrorWindow(window time.Duration) *errorWindow {
+	n := int(window.Seconds()) / errorBucketSec
+	if n < 1 {
+		n = 1
+	}
+	return &errorWindow{buckets: make([]errorBucket, n)}
+}
+
+// Record 记录一次上游调用结果
+func (w *errorWindow) Record(now time.Time, failed bool) {
+	slot := now.Unix() / errorBucketSec
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[slot%int64(len(w.buckets))]
+	if b.slot != slot {
+		*b = errorBucket{slot: slot}
+	}
+	b.total++
+	if failed {
+		b.errors++
+	}
+}
+
+// Rate 返回窗口内的错误率和调用数
+func (w *errorWindow) Rate(now time.Time) (float64, int) {
+	slot := now.Unix() / errorBucketSec
+	oldest := slot - int64(len(w.buckets)) + 1
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	total, errors := 0, 0
+	for _, b := range w.buckets {
+		if b.slot >= oldest && b.slot <= slot {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(errors) / float64(total), total
+}
+
+func initHealth() {
+	if sec := currentConfig().Health.ErrorWindowSec; sec > 0 {
+		upstreamWindow = newErrorWindow(time.Duration(sec) * time.Second)
+	}
+}
+
+// HealthCheck 单项检查结果
+type HealthCheck struct {
+	Name      string      `json:"name"`
+	OK        bool        `json:"ok"`
+	Message   string      `json:"message"`
+	Observed  interface{} `json:"observed,omitempty"`
+	Threshold interface{} `json:"threshold,omitempty"`
+}
+
+// runHealthChecks 执行全部就绪检查
+func runHealthChecks() []HealthCheck {
+	cfg := currentConfig().Health
+	var checks []HealthCheck
+
+	// 冷却中和已失效的账号不计入
+	ready := pool.StatusCounts()["ready"]
+	accounts := HealthCheck{Name: "accounts", OK: ready >= cfg.MinReadyAccounts, Observed: ready, Threshold: cfg.MinReadyAccounts}
+	if accounts.OK {
+		accounts.Message = fmt.Sprintf("%d 个就绪账号", ready)
+	} else {
+		accounts.Message = fmt.Sprintf("就绪账号 %d 个，少于 %d 个", ready, cfg.MinReadyAccounts)
+	}
+	checks = append(checks, accounts)
+
+	rate, samples := upstreamWindow.Rate(time.Now())
+	up := HealthCheck{Name: "upstream", OK: true, Observed: gin.H{"error_rate": rate, "samples": samples}, Threshold: cfg.MaxUpstreamErrorRate}
+	switch {
+	case cfg.MaxUpstreamErrorRate <= 0:
+		up.Message = "未启用错误率检查"
+	case samples < cfg.MinUpstreamSamples:
+		up.Message = fmt.Sprintf("近期调用 %d 次，样本不足", samples)
+	case rate > cfg.MaxUpstreamErrorRate:
+		up.OK = false
+		up.Message = fmt.Sprintf("近期上游错误率 %.0f%%，超过 %.0f%%", rate*100, cfg.MaxUpstreamErrorRate*100)
+	default:
+		up.Message = fmt.Sprintf("近期上游错误率 %.0f%%", rate*100)
+	}
+	checks = append(checks, up)
+
+	dataDir := HealthCheck{Name: "data_dir", OK: true, Message: "可写", Observed: DataDir}
+	if err := checkDirWritable(DataDir); err != nil {
+		dataDir.OK = false
+		dataDir.Message = err.Error()
+	}
+	checks = append(checks, dataDir)
+	return checks
+}
+
+// checkDirWritable 通过创建并删除临时文件检查目录是否可写
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".healthz-*")
+	if err != nil {
+		return fmt.Errorf("数据目录不可写: %w", err)
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return nil
+}
+
+func registerHealthRoutes(r *gin.Engine) {
+	// 存活：进程能响应即可
+	r.GET("/livez", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	// 就绪：任一检查失败返回 503，编排系统据此停止转发流量
+	r.GET("/readyz", func(c *gin.Context) {
+		var failed []string
+		for _, check := range runHealthChecks() {
+			if !check.OK {
+				failed = append(failed, check.Name)
+			}
+		}
+		if len(failed) > 0 {
+			c.JSON(503, gin.H{"status": "not_ready", "failed": failed})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ready"})
+	})
+
+	r.GET("/health/details", func(c *gin.Context) {
+		checks := runHealthChecks()
+		status, code := "ready", 200
+		for _, check := range checks {
+			if !check.OK {
+				status, code = "not_ready", 503
+			}
+		}
+		c.JSON(code, gin.H{
+			"status":         status,
+			"checks":         checks,
+			"uptime_seconds": int(time.Since(processStart).Seconds()),
+			"pool":           pool.StatusCounts(),
+			"queue_depth":    admission.Stats()["depth"],
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useHealthConfig 替换健康检查阈值、错误窗口和数据目录，测试结束后恢复
+func useHealthConfig(t *testing.T, cfg HealthConfig) {
+	t.Helper()
+	oldWindow, oldDir := upstreamWindow, DataDir
+	t.Cleanup(func() { upstreamWindow, DataDir = oldWindow, oldDir })
+	useConfig(t, func(c *AppConfig) { c.Health = cfg })
+	upstreamWindow = newErrorWindow(time.Minute)
+	DataDir = t.TempDir()
+}
+
+func TestErrorWindowRateAndExpiry(t *testing.T) {
+	w := newErrorWindow(time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	w.Record(now, true)
+	w.Record(now, false)
+	w.Record(now.Add(20*time.Second), false)
+	w.Record(now.Add(20*time.Second), false)
+	if rate, n := w.Rate(now.Add(20 * time.Second)); n != 4 || rate != 0.25 {
+		t.Fatalf("rate=%v samples=%d", rate, n)
+	}
+	// 超出窗口的桶不计入，复用的桶会被重置
+	if rate, n := w.Rate(now.Add(70 * time.Second)); n != 2 || rate != 0 {
+		t.Fatalf("过期后 rate=%v samples=%d", rate, n)
+	}
+	w.Record(now.Add(2*time.Minute), true)
+	if rate, n := w.Rate(now.Add(2 * time.Minute)); n != 1 || rate != 1 {
+		t.Fatalf("桶复用后 rate=%v samples=%d", rate, n)
+	}
+}
+
+func TestReadyzThresholds(t *testing.T) {
+	useHealthConfig(t, HealthConfig{MinReadyAccounts: 1, MaxUpstreamErrorRate: 0.5, MinUpstreamSamples: 3})
+	g := newTestGateway(t, 0)
+
+	if w := g.get(t, "/livez"); w.Code != 200 {
+		t.Fatalf("/livez = %d", w.Code)
+	}
+	w := g.get(t, "/readyz")
+	var resp struct {
+		Status string   `json:"status"`
+		Failed []string `json:"failed"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != 503 || resp.Status != "not_ready" || len(resp.Failed) != 1 || resp.Failed[0] != "accounts" {
+		t.Fatalf("无账号时 /readyz = %d %s", w.Code, w.Body.String())
+	}
+
+	g = newTestGateway(t, 1)
+	if w := g.get(t, "/readyz"); w.Code != 200 {
+		t.Fatalf("有账号时 /readyz = %d %s", w.Code, w.Body.String())
+	}
+
+	// 样本不足时不判定；超过阈值后不就绪
+	now := time.Now()
+	upstreamWindow.Record(now, true)
+	upstreamWindow.Record(now, true)
+	if w := g.get(t, "/readyz"); w.Code != 200 {
+		t.Fatalf("样本不足时 /readyz = %d", w.Code)
+	}
+	upstreamWindow.Record(now, false)
+	if w := g.get(t, "/readyz"); w.Code != 503 {
+		t.Fatalf("错误率 67%% 时 /readyz = %d", w.Code)
+	}
+
+	// 禁用错误率检查
+	useConfig(t, func(cfg *AppConfig) { cfg.Health.MaxUpstreamErrorRate = 0 })
+	if w := g.get(t, "/readyz"); w.Code != 200 {
+		t.Fatalf("禁用错误率检查后 /readyz = %d", w.Code)
+	}
+}
+
+func TestUpstreamErrorsFeedHealthWindow(t *testing.T) {
+	useHealthConfig(t, HealthConfig{MinReadyAccounts: 1, MaxUpstreamErrorRate: 0.5, MinUpstreamSamples: 1})
+	g := newTestGateway(t, 1)
+	g.fake.Script(epStreamAssist, fakeResponse{Status: 500, Body: []byte("boom")})
+	g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"))
+
+	rate, samples := upstreamWindow.Rate(time.Now())
+	if samples == 0 || rate == 0 {
+		t.Fatalf("上游调用未记入窗口: rate=%v samples=%d", rate, samples)
+	}
+}
+
+func TestHealthDetails(t *testing.T) {
+	useHealthConfig(t, HealthConfig{MinReadyAccounts: 2})
+	g := newTestGateway(t, 1)
+	DataDir = filepath.Join(t.TempDir(), "missing")
+
+	w := g.get(t, "/health/details")
+	var resp struct {
+		Status string         `json:"status"`
+		Checks []HealthCheck  `json:"checks"`
+		Pool   map[string]int `json:"pool"`
+		Uptime *int           `json:"uptime_seconds"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 503 || resp.Status != "not_ready" || resp.Pool["ready"] != 1 || resp.Uptime == nil {
+		t.Fatalf("/health/details = %d %s", w.Code, w.Body.String())
+	}
+	results := map[string]HealthCheck{}
+	for _, c := range resp.Checks {
+		results[c.Name] = c
+	}
+	if c := results["accounts"]; c.OK || c.Observed != float64(1) || c.Threshold != float64(2) {
+		t.Fatalf("accounts 检查: %+v", c)
+	}
+	if c := results["upstream"]; !c.OK {
+		t.Fatalf("upstream 检查: %+v", c)
+	}
+	if c := results["data_dir"]; c.OK || c.Message == "" {
+		t.Fatalf("data_dir 检查: %+v", c)
+	}
+
+	os.MkdirAll(DataDir, 0755)
+	if c := runHealthChecks()[2]; !c.OK {
+		t.Fatalf("目录存在后 data_dir 检查: %+v", c)
+	}
+}
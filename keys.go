@@ -0,0 +1,716 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ==================== 多租户 API Key ====================
+
+// 端点分类，用于 Key 的端点白名单
+const (
+	EndpointOpenAI = "openai" // /v1/chat/completions
+	EndpointClaude = "claude" // /v1/messages
+	EndpointGemini = "gemini" // /v1beta/models/*
+	EndpointModels = "models" // /v1/models
+)
+
+// KeyQuota 配额，0 表示不限制
+type KeyQuota struct {
+	DailyRequests   int64 `json:"daily_requests"`
+	MonthlyRequests int64 `json:"monthly_requests"`
+	DailyTokens     int64 `json:"daily_tokens"`
+	MonthlyTokens   int64 `json:"monthly_tokens"`
+}
+
+// KeyUsage 当前统计周期内的用量
+type KeyUsage struct {
+	Day             string `json:"day"`   // UTC 日期 2006-01-02
+	Month           string `json:"month"` // UTC 月份 2006-01
+	DailyRequests   int64  `json:"daily_requests"`
+	MonthlyRequests int64  `json:"monthly_requests"`
+	DailyTokens     int64  `json:"daily_tokens"`
+	MonthlyTokens   int64  `json:"monthly_tokens"`
+}
+
+// APIKey 一个客户端 Key，磁盘上只保存哈希
+type APIKey struct {
+	ID               string     `json:"id"`
+	Hash             string     `json:"hash,omitempty"` // sha256(key)
+	Prefix           string     `json:"prefix"`         // 明文前缀，便于辨认
+	Owner            string     `json:"owner"`
+	Label            string     `json:"label,omitempty"`
+	Enabled          bool       `json:"enabled"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	AllowedModels    []string   `json:"allowed_models,omitempty"`    // 支持通配符，如 gemini-2.5-*；为空表示全部
+	AllowedEndpoints []string   `json:"allowed_endpoints,omitempty"` // 为空表示全部
+	Tier             string     `json:"tier,omitempty"`              // 限流等级，为空使用 rate_limit.default_tier
+	Quota            KeyQuota   `json:"quota"`
+	Usage            KeyUsage   `json:"usage"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RotatedAt        *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+
+	legacy bool // 来自 config.json / API_KEY 环境变量的旧式 Key，拥有全部权限
+}
+
+// AllowsEndpoint 检查端点白名单
+func (k *APIKey) AllowsEndpoint(endpoint string) bool {
+	if k.legacy {
+		return true
+	}
+	if len(k.AllowedEndpoints) == 0 {
+		return true
+	}
+	for _, e := range k.AllowedEndpoints {
+		if e == endpoint || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsModel 检查模型白名单
+func (k *APIKey) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 {
+		return true
+	}
+	for _, pattern := range k.AllowedModels {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rollover 跨日/跨月时清零对应统计
+func (u *KeyUsage) rollover(now time.Time) {
+	day, month := now.UTC().Format("2006-01-02"), now.UTC().Format("2006-01")
+	if u.Day != day {
+		u.Day, u.DailyRequests, u.DailyTokens = day, 0, 0
+	}
+	if u.Month != month {
+		u.Month, u.MonthlyRequests, u.MonthlyTokens = month, 0, 0
+	}
+}
+
+// quotaExceeded 返回已耗尽的配额项，未超限返回空
+func (k *APIKey) quotaExceeded() string {
+	q, u := k.Quota, k.Usage
+	switch {
+	case q.DailyRequests > 0 && u.DailyRequests >= q.DailyRequests:
+		return "每日请求数"
+	case q.MonthlyRequests > 0 && u.MonthlyRequests >= q.MonthlyRequests:
+		return "每月请求数"
+	case q.DailyTokens > 0 && u.DailyTokens >= q.DailyTokens:
+		return "每日 Token 数"
+	case q.MonthlyTokens > 0 && u.MonthlyTokens >= q.MonthlyTokens:
+		return "每月 Token 数"
+	}
+	return ""
+}
+
+// view 返回不含哈希的副本，用于管理接口
+func (k *APIKey) view() APIKey {
+	v := *k
+	v.Hash = ""
+	return v
+}
+
+// KeyStore 管理 Key 的内存索引与持久化
+type KeyStore struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*APIKey // id -> key
+	byHash map[string]*APIKey
+	legacy map[string]*APIKey
+	dirty  bool
+
+	// managed 曾经有过托管 Key（文件存在或创建过），此后即使全部吊销也保持鉴权
+	managed bool
+}
+
+var keyStore = newKeyStore("")
+
+func newKeyStore(path string) *KeyStore {
+	return &KeyStore{
+		path:   path,
+		keys:   make(map[string]*APIKey),
+		byHash: make(map[string]*APIKey),
+		legacy: make(map[string]*APIKey),
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "sk-b2a-" + hex.EncodeToString(b)
+}
+
+// initKeyStore 从 <data_dir>/meta/api_keys.json 加载 Key，并登记 config.json 中的旧式 Key
+func initKeyStore() {
+	store := newKeyStore(filepath.Join(DataDir, "meta", "api_keys.json"))
+	if err := store.load(); err != nil {
+		log.Printf("❌ 加载 API Key 失败: %v", err)
+	}
+	legacy := currentConfig().APIKeys
+	store.SetLegacyKeys(legacy)
+	if len(legacy) > 0 {
+		log.Printf("⚠️ config.json 中有 %d 个明文 API Key，建议通过 POST /admin/keys 创建托管 Key 后移除", len(legacy))
+	}
+	keyStore = store
+	log.Printf("🔑 已加载 %d 个托管 API Key", len(store.keys))
+}
+
+func (s *KeyStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.managed = true
+	for _, k := range keys {
+		s.keys[k.ID] = k
+		s.byHash[k.Hash] = k
+	}
+	return nil
+}
+
+// SetLegacyKeys 登记旧式明文 Key（仅在内存中保存哈希）
+func (s *KeyStore) SetLegacyKeys(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.legacy = make(map[string]*APIKey)
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		s.legacy[hashAPIKey(key)] = &APIKey{
+			ID:      fmt.Sprintf("config-%d", i),
+			Owner:   "config",
+			Enabled: true,
+			legacy:  true,
+		}
+	}
+}
+
+// Empty 从未配置过任何 Key 时不启用鉴权（与旧行为一致）
+func (s *KeyStore) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.managed && len(s.legacy) == 0
+}
+
+// Lookup 按明文 Key 查找
+func (s *KeyStore) Lookup(key string) *APIKey {
+	h := hashAPIKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.legacy[h]; ok {
+		return k
+	}
+	return s.byHash[h]
+}
+
+// AllowsEndpoint 在锁内检查端点白名单（Key 属性可能被管理接口并发修改）
+func (s *KeyStore) AllowsEndpoint(k *APIKey, endpoint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return k.AllowsEndpoint(endpoint)
+}
+
+// AllowsModel 在锁内检查模型白名单
+func (s *KeyStore) AllowsModel(k *APIKey, model string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return k.AllowsModel(model)
+}
+
+// TierOf 返回 Key 的限流等级名
+func (s *KeyStore) TierOf(k *APIKey) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return k.Tier
+}
+
+// Check 校验 Key 状态与配额，不计数；限流通过后再由 Charge 计入
+func (s *KeyStore) Check(k *APIKey, now time.Time) (status int, errType, message string) {
+	if k.legacy {
+		return 0, "", ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return k.checkLocked(now)
+}
+
+// Charge 再次校验并计入一次请求（与 Check 之间可能有并发请求用掉配额）
+func (s *KeyStore) Charge(k *APIKey, now time.Time) (status int, errType, message string) {
+	if k.legacy {
+		return 0, "", ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status, errType, message = k.checkLocked(now); status != 0 {
+		return
+	}
+	k.Usage.DailyRequests++
+	k.Usage.MonthlyRequests++
+	k.LastUsedAt = &now
+	s.dirty = true
+	return 0, "", ""
+}
+
+func (k *APIKey) checkLocked(now time.Time) (status int, errType, message string) {
+	if !k.Enabled {
+		return 401, "authentication_error", "API Key 已禁用"
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return 401, "authentication_error", "API Key 已过期"
+	}
+	k.Usage.rollover(now)
+	if item := k.quotaExceeded(); item != "" {
+		return 429, "rate_limit_error", fmt.Sprintf("API Key 配额已用尽: %s", item)
+	}
+	return 0, "", ""
+}
+
+// AddTokens 累加 Token 用量
+func (s *KeyStore) AddTokens(k *APIKey, tokens int64) {
+	if k.legacy || tokens <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.Usage.rollover(time.Now())
+	k.Usage.DailyTokens += tokens
+	k.Usage.MonthlyTokens += tokens
+	s.dirty = true
+}
+
+// List 返回所有托管 Key（不含哈希）
+func (s *KeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		out = append(out, k.view())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Create 创建 Key，返回明文（仅此一次）
+func (s *KeyStore) Create(k APIKey) (string, APIKey, error) {
+	secret := generateAPIKey()
+	now := time.Now()
+	k.ID = "key_" + uuid.New().String()[:8]
+	k.Hash = hashAPIKey(secret)
+	k.Prefix = secret[:11]
+	k.CreatedAt = now
+	k.Usage = KeyUsage{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wasManaged := s.managed
+	s.managed = true
+	s.keys[k.ID] = &k
+	s.byHash[k.Hash] = &k
+	if err := s.saveLocked(); err != nil {
+		// 未能持久化的 Key 不生效，避免重启后凭空消失
+		delete(s.keys, k.ID)
+		delete(s.byHash, k.Hash)
+		s.managed = wasManaged
+		return "", APIKey{}, err
+	}
+	return secret, k.view(), nil
+}
+
+// Update 修改 Key 属性
+func (s *KeyStore) Update(id string, fn func(k *APIKey)) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, os.ErrNotExist
+	}
+	prev := *k
+	fn(k)
+	if err := s.saveLocked(); err != nil {
+		*k = prev
+		return APIKey{}, err
+	}
+	return k.view(), nil
+}
+
+// Rotate 生成新的明文，旧 Key 立即失效，属性与用量保留
+func (s *KeyStore) Rotate(id string) (string, APIKey, error) {
+	secret := generateAPIKey()
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return "", APIKey{}, os.ErrNotExist
+	}
+	prev := *k
+	delete(s.byHash, k.Hash)
+	k.Hash = hashAPIKey(secret)
+	k.Prefix = secret[:11]
+	k.RotatedAt = &now
+	s.byHash[k.Hash] = k
+	if err := s.saveLocked(); err != nil {
+		// 新明文未能持久化也不会返回给调用方，旧 Key 须继续有效
+		delete(s.byHash, k.Hash)
+		*k = prev
+		s.byHash[k.Hash] = k
+		return "", APIKey{}, err
+	}
+	return secret, k.view(), nil
+}
+
+// Revoke 删除 Key
+func (s *KeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.keys, id)
+	delete(s.byHash, k.Hash)
+	if err := s.saveLocked(); err != nil {
+		s.keys[id] = k
+		s.byHash[k.Hash] = k
+		return err
+	}
+	return nil
+}
+
+// Flush 将用量等变更写回磁盘
+func (s *KeyStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+func (s *KeyStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0600); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// startKeyFlusher 定期持久化用量；关闭时的最后一次保存由 shutdown 完成
+func startKeyFlusher(interval time.Duration) {
+	app.Go("key-flusher", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := keyStore.Flush(); err != nil {
+				log.Printf("⚠️ 保存 API Key 用量失败: %v", err)
+			}
+		}
+	})
+}
+
+// ==================== 鉴权中间件 ====================
+
+const ctxAPIKey = "apiKey"
+
+// routeEndpoint 根据请求路径判断端点分类
+func routeEndpoint(c *gin.Context) string {
+	if c.Request.URL.Path == "/v1/models" {
+		return EndpointModels
+	}
+	switch dialectOf(c) {
+	case dialectClaude:
+		return EndpointClaude
+	case dialectGemini:
+		return EndpointGemini
+	}
+	return EndpointOpenAI
+}
+
+func apiKeyAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keyStore.Empty() {
+			c.Next()
+			return
+		}
+		authHeader := c.GetHeader("Authorization")
+		apiKey := ""
+
+		if strings.HasPrefix(authHeader, "Bearer ") {
+			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
+		} else if apiKey = c.GetHeader("X-API-Key"); apiKey == "" {
+			apiKey = c.GetHeader("x-goog-api-key")
+		}
+
+		if apiKey == "" {
+			respondError(c, 401, "authentication_error", "Missing API key")
+			return
+		}
+
+		key := keyStore.Lookup(apiKey)
+		if key == nil {
+			respondError(c, 401, "authentication_error", "Invalid API key")
+			return
+		}
+		if endpoint := routeEndpoint(c); !keyStore.AllowsEndpoint(key, endpoint) {
+			respondError(c, 403, "permission_error", fmt.Sprintf("API Key 无权访问 %s 端点", endpoint))
+			return
+		}
+		if status, errType, msg := keyStore.Check(key, time.Now()); status != 0 {
+			respondError(c, status, errType, msg)
+			return
+		}
+
+		c.Set(ctxAPIKey, key)
+		c.Next()
+
+		if usage, ok := c.Get(ctxTokenUsage); ok {
+			keyStore.AddTokens(key, int64(usage.(TokenUsage).Total()))
+		}
+	}
+}
+
+// chargeRequest 限流放行后计入 Key 的请求配额，被拒绝时已写入响应
+func chargeRequest(c *gin.Context) bool {
+	key := requestKey(c)
+	if key == nil {
+		return true
+	}
+	if status, errType, msg := keyStore.Charge(key, time.Now()); status != 0 {
+		respondError(c, status, errType, msg)
+		return false
+	}
+	return true
+}
+
+// requestKey 返回当前请求使用的 Key，未启用鉴权时为 nil
+func requestKey(c *gin.Context) *APIKey {
+	if v, ok := c.Get(ctxAPIKey); ok {
+		return v.(*APIKey)
+	}
+	return nil
+}
+
+// authorizeModel 检查 Key 的模型白名单，不允许时返回 403
+func authorizeModel(c *gin.Context, model string) bool {
+	if key := requestKey(c); key != nil && !keyStore.AllowsModel(key, model) {
+		respondError(c, 403, "permission_error", fmt.Sprintf("API Key 无权使用模型 %s", model))
+		return false
+	}
+	return true
+}
+
+// ==================== Key 管理接口 ====================
+
+// keyRequest 创建/修改 Key 的请求体，字段为空表示不修改
+type keyRequest struct {
+	Owner            *string    `json:"owner"`
+	Label            *string    `json:"label"`
+	Enabled          *bool      `json:"enabled"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	ClearExpiry      bool       `json:"clear_expiry"`
+	AllowedModels    *[]string  `json:"allowed_models"`
+	AllowedEndpoints *[]string  `json:"allowed_endpoints"`
+	Quota            *KeyQuota  `json:"quota"`
+	Tier             *string    `json:"tier"`
+}
+
+var validEndpoints = map[string]bool{
+	EndpointOpenAI: true, EndpointClaude: true, EndpointGemini: true,
+	EndpointModels: true, "*": true,
+}
+
+func (r *keyRequest) validate() error {
+	if r.AllowedEndpoints != nil {
+		for _, e := range *r.AllowedEndpoints {
+			if !validEndpoints[e] {
+				return fmt.Errorf("未知端点 %q", e)
+			}
+		}
+	}
+	if r.AllowedModels != nil {
+		for _, m := range *r.AllowedModels {
+			if _, err := path.Match(m, ""); err != nil {
+				return fmt.Errorf("模型通配符 %q 非法", m)
+			}
+		}
+	}
+	if r.Tier != nil && *r.Tier != "" {
+		if _, ok := currentConfig().RateLimit.Tiers[*r.Tier]; !ok {
+			return fmt.Errorf("未知限流等级 %q", *r.Tier)
+		}
+	}
+	if r.Quota != nil {
+		q := r.Quota
+		if q.DailyRequests < 0 || q.MonthlyRequests < 0 || q.DailyTokens < 0 || q.MonthlyTokens < 0 {
+			return errors.New("配额不能为负数")
+		}
+	}
+	return nil
+}
+
+func (r *keyRequest) apply(k *APIKey) {
+	if r.AllowedEndpoints != nil {
+		k.AllowedEndpoints = *r.AllowedEndpoints
+	}
+	if r.AllowedModels != nil {
+		k.AllowedModels = *r.AllowedModels
+	}
+	if r.Owner != nil {
+		k.Owner = *r.Owner
+	}
+	if r.Label != nil {
+		k.Label = *r.Label
+	}
+	if r.Enabled != nil {
+		k.Enabled = *r.Enabled
+	}
+	if r.ExpiresAt != nil {
+		k.ExpiresAt = r.ExpiresAt
+	}
+	if r.ClearExpiry {
+		k.ExpiresAt = nil
+	}
+	if r.Quota != nil {
+		k.Quota = *r.Quota
+	}
+	if r.Tier != nil {
+		k.Tier = *r.Tier
+	}
+}
+
+func registerKeyRoutes(admin *gin.RouterGroup) {
+	admin.GET("/keys", requireRole(RoleViewer), func(c *gin.Context) {
+		c.JSON(200, gin.H{"keys": keyStore.List()})
+	})
+
+	admin.POST("/keys", requireRole(RoleOwner), func(c *gin.Context) {
+		var req keyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Owner == nil || *req.Owner == "" {
+			c.JSON(400, gin.H{"error": "owner 不能为空"})
+			return
+		}
+		if err := req.validate(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		k := APIKey{Enabled: true}
+		req.apply(&k)
+		secret, view, err := keyStore.Create(k)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("🔑 创建 API Key: %s (owner=%s)", view.ID, view.Owner)
+		c.JSON(201, gin.H{"key": secret, "info": view})
+	})
+
+	admin.PATCH("/keys/:id", requireRole(RoleOwner), func(c *gin.Context) {
+		var req keyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.validate(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		view, err := keyStore.Update(c.Param("id"), req.apply)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			c.JSON(404, gin.H{"error": "Key 不存在"})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			c.JSON(200, gin.H{"info": view})
+		}
+	})
+
+	admin.POST("/keys/:id/rotate", requireRole(RoleOwner), func(c *gin.Context) {
+		secret, view, err := keyStore.Rotate(c.Param("id"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			c.JSON(404, gin.H{"error": "Key 不存在"})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			log.Printf("🔑 轮换 API Key: %s", view.ID)
+			c.JSON(200, gin.H{"key": secret, "info": view})
+		}
+	})
+
+	admin.DELETE("/keys/:id", requireRole(RoleOwner), func(c *gin.Context) {
+		err := keyStore.Revoke(c.Param("id"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			c.JSON(404, gin.H{"error": "Key 不存在"})
+		case err != nil:
+			c.JSON(500, gin.H{"error": err.Error()})
+		default:
+			log.Printf("🔑 吊销 API Key: %s", c.Param("id"))
+			c.JSON(200, gin.H{"success": true})
+		}
+	})
+}
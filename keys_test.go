@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestKey 通过管理接口创建 Key，返回明文和 ID
+func createTestKey(t *testing.T, g *testGateway, body map[string]interface{}, auth ...string) (string, string) {
+	t.Helper()
+	w := g.post(t, "/admin/keys", body, auth...)
+	if w.Code != 201 {
+		t.Fatalf("创建 Key 失败: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Key  string `json:"key"`
+		Info APIKey `json:"info"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp.Key, resp.Info.ID
+}
+
+func bearer(key string) []string {
+	return []string{"Authorization", "Bearer " + key}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	g := newTestGateway(t, 1)
+	useAdminCredentials(AdminCredential{Name: "root", Token: "adm-owner", Role: RoleOwner})
+	admin := bearer("adm-owner")
+
+	key, id := createTestKey(t, g, map[string]interface{}{"owner": "team-a", "label": "ci"}, admin...)
+	if !strings.HasPrefix(key, "sk-b2a-") {
+		t.Fatalf("Key 格式: %s", key)
+	}
+
+	// 磁盘上只保存哈希
+	data, _ := os.ReadFile(keyStore.path)
+	if strings.Contains(string(data), key) || !strings.Contains(string(data), hashAPIKey(key)) {
+		t.Fatalf("Key 未以哈希形式保存: %s", data)
+	}
+
+	body := chatBody("gemini-2.5-flash", false, "hi")
+	if w := g.post(t, "/v1/chat/completions", body, bearer(key)...); w.Code != 200 {
+		t.Fatalf("有效 Key 被拒绝: %d %s", w.Code, w.Body.String())
+	}
+	if w := g.post(t, "/v1/chat/completions", body, bearer("sk-wrong")...); w.Code != 401 {
+		t.Fatalf("无效 Key 状态码 = %d", w.Code)
+	}
+	// 客户端 Key 不能访问管理接口
+	if w := g.post(t, "/admin/keys", map[string]interface{}{"owner": "x"}, bearer(key)...); w.Code != 401 {
+		t.Fatalf("普通 Key 访问 admin 状态码 = %d", w.Code)
+	}
+
+	// 轮换后旧 Key 失效
+	w := g.post(t, "/admin/keys/"+id+"/rotate", nil, admin...)
+	var rotated struct {
+		Key string `json:"key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	if w.Code != 200 || rotated.Key == "" || rotated.Key == key {
+		t.Fatalf("轮换失败: %d %s", w.Code, w.Body.String())
+	}
+	if w := g.post(t, "/v1/chat/completions", body, bearer(key)...); w.Code != 401 {
+		t.Fatalf("轮换后旧 Key 状态码 = %d", w.Code)
+	}
+	if w := g.post(t, "/v1/chat/completions", body, bearer(rotated.Key)...); w.Code != 200 {
+		t.Fatalf("轮换后新 Key 状态码 = %d", w.Code)
+	}
+
+	// 吊销
+	req := httptest.NewRequest("DELETE", "/admin/keys/"+id, nil)
+	req.Header.Set("Authorization", "Bearer adm-owner")
+	rec := httptest.NewRecorder()
+	g.router.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("吊销失败: %d", rec.Code)
+	}
+	if w := g.post(t, "/v1/chat/completions", body, bearer(rotated.Key)...); w.Code != 401 {
+		t.Fatalf("吊销后状态码 = %d", w.Code)
+	}
+}
+
+func TestAPIKeyRestrictions(t *testing.T) {
+	g := newTestGateway(t, 1)
+
+	key, id := createTestKey(t, g, map[string]interface{}{
+		"owner":             "team-b",
+		"allowed_models":    []string{"gemini-2.5-*"},
+		"allowed_endpoints": []string{"openai", "models"},
+		"quota":             map[string]interface{}{"daily_requests": 2},
+	})
+
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-3-pro-preview", false, "hi"), bearer(key)...); w.Code != 403 {
+		t.Fatalf("模型白名单未生效: %d", w.Code)
+	}
+	if w := g.post(t, "/v1/messages", map[string]interface{}{
+		"model": "gemini-2.5-flash", "messages": []map[string]string{{"role": "user", "content": "hi"}},
+	}, bearer(key)...); w.Code != 403 || !strings.Contains(w.Body.String(), `"type":"error"`) {
+		t.Fatalf("端点白名单未生效: %d %s", w.Code, w.Body.String())
+	}
+
+	// 模型白名单在准入后检查，被拒绝的请求同样计入配额：2 次已用尽
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...)
+	if w.Code != 200 {
+		t.Fatalf("请求失败: %d %s", w.Code, w.Body.String())
+	}
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...); w.Code != 429 {
+		t.Fatalf("配额未生效: %d", w.Code)
+	}
+
+	var info APIKey
+	for _, k := range keyStore.List() {
+		if k.ID == id {
+			info = k
+		}
+	}
+	if info.Usage.DailyRequests != 2 || info.Usage.DailyTokens == 0 {
+		t.Fatalf("用量统计: %+v", info.Usage)
+	}
+
+	// 过期
+	past := time.Now().Add(-time.Hour)
+	keyStore.Update(id, func(k *APIKey) { k.Quota = KeyQuota{}; k.ExpiresAt = &past })
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...); w.Code != 401 {
+		t.Fatalf("过期 Key 状态码 = %d", w.Code)
+	}
+}
+
+func TestKeyUsageRollover(t *testing.T) {
+	u := KeyUsage{Day: "2024-01-31", Month: "2024-01", DailyRequests: 5, MonthlyRequests: 50, DailyTokens: 10, MonthlyTokens: 100}
+	u.rollover(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if u.DailyRequests != 0 || u.MonthlyRequests != 0 || u.Day != "2024-02-01" || u.Month != "2024-02" {
+		t.Fatalf("跨月未清零: %+v", u)
+	}
+	u = KeyUsage{Day: "2024-02-01", Month: "2024-02", DailyRequests: 5, MonthlyRequests: 50}
+	u.rollover(time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC))
+	if u.DailyRequests != 0 || u.MonthlyRequests != 50 {
+		t.Fatalf("跨日统计错误: %+v", u)
+	}
+}
+
+func TestKeyCreateRollsBackOnSaveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	// 目标路径是非空目录，重命名必然失败
+	if err := os.MkdirAll(filepath.Join(path, "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	s := newKeyStore(path)
+
+	secret, _, err := s.Create(APIKey{Owner: "team-a", Enabled: true})
+	if err == nil {
+		t.Fatal("保存失败时 Create 应返回错误")
+	}
+	if secret != "" || len(s.List()) != 0 {
+		t.Fatalf("保存失败后 Key 仍留在内存: secret=%q keys=%d", secret, len(s.List()))
+	}
+	if !s.Empty() {
+		t.Fatal("保存失败后不应启用鉴权")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("临时文件未清理: %v", entries)
+	}
+}
+
+func TestKeyMutationsRollBackOnSaveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	s := newKeyStore(path)
+	secret, info, err := s.Create(APIKey{Owner: "team-a", Enabled: true, Tier: "free"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 此后每次保存都失败
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Join(path, "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Update(info.ID, func(k *APIKey) { k.Tier = "pro"; k.Enabled = false }); err == nil {
+		t.Fatal("保存失败时 Update 应返回错误")
+	}
+	if k := s.Lookup(secret); k == nil || s.TierOf(k) != "free" || !k.Enabled {
+		t.Fatalf("Update 未回滚: %+v", k)
+	}
+
+	newSecret, _, err := s.Rotate(info.ID)
+	if err == nil || newSecret != "" {
+		t.Fatalf("保存失败时 Rotate 应返回错误且不返回新明文: %q %v", newSecret, err)
+	}
+	if s.Lookup(secret) == nil {
+		t.Fatal("Rotate 失败后旧 Key 失效")
+	}
+	if len(s.byHash) != 1 {
+		t.Fatalf("Rotate 失败后哈希索引残留: %d", len(s.byHash))
+	}
+
+	if err := s.Revoke(info.ID); err == nil {
+		t.Fatal("保存失败时 Revoke 应返回错误")
+	}
+	if s.Lookup(secret) == nil || len(s.List()) != 1 {
+		t.Fatal("Revoke 失败后 Key 被删除")
+	}
+}
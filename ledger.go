@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 用量账本 ====================
+
+// UsageRecord 每个 API 请求一条记录
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	RequestID        string    `json:"request_id,omitempty"`
+	KeyID            string    `json:"key_id,omitempty"`
+	Owner            string    `json:"owner,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	Endpoint         string    `json:"endpoint"`
+	Stream           bool      `json:"stream"`
+	Status           int       `json:"status"`
+	LatencyMs        int64     `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	MediaBytesIn     int64     `json:"media_bytes_in"`
+	MediaBytesOut    int64     `json:"media_bytes_out"`
+	Retries          int       `json:"retries"`
+	ClientIP         string    `json:"client_ip,omitempty"`
+}
+
+// RequestMetrics 请求处理过程中由 streamChat 填写的统计
+type RequestMetrics struct {
+	Model         string
+	Stream        bool
+	Retries       int
+	MediaBytesIn  int64
+	MediaBytesOut int64
+}
+
+const ctxRequestMetrics = "requestMetrics"
+
+// requestMetrics 返回当前请求的统计对象（不存在时创建）
+func requestMetrics(c *gin.Context) *RequestMetrics {
+	if v, ok := c.Get(ctxRequestMetrics); ok {
+		return v.(*RequestMetrics)
+	}
+	m := &RequestMetrics{}
+	c.Set(ctxRequestMetrics, m)
+	return m
+}
+
+const ctxResponseStatus = "responseStatus"
+
+// responseStatus 返回请求的实际结果状态：SSE 已发出 200 后以 error 事件结束时，
+// 取 writeSSEError 记录的状态码，否则为写出的 HTTP 状态码
+func responseStatus(c *gin.Context) int {
+	if v, ok := c.Get(ctxResponseStatus); ok {
+		return v.(int)
+	}
+	return c.Writer.Status()
+}
+
+// base64Size 返回 base64 数据解码后的字节数
+func base64Size(data string) int64 {
+	n := int64(len(data)) / 4 * 3
+	if strings.HasSuffix(data, "==") {
+		n -= 2
+	} else if strings.HasSuffix(data, "=") {
+		n--
+	}
+	return n
+}
+
+// usageLedger 按 UTC 日期写入 <dir>/YYYY-MM-DD.jsonl
+type usageLedger struct {
+	mu   sync.Mutex
+	dir  string
+	day  string
+	file *os.File
+}
+
+var ledger = &usageLedger{}
+
+func initUsageLedger() {
+	dir := filepath.Join(DataDir, "meta", "usage")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("❌ 创建用量目录失败: %v，用量账本已禁用", err)
+		return
+	}
+	ledger = &usageLedger{dir: dir}
+}
+
+// Append 追加一条记录
+func (l *usageLedger) Append(rec UsageRecord) {
+	if l.dir == "" {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	day := rec.Time.UTC().Format("2006-01-02")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil || l.day != day {
+		if l.file != nil {
+			l.file.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(l.dir, day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Printf("⚠️ 打开用量文件失败: %v", err)
+			l.file = nil
+			return
+		}
+		l.file, l.day = f, day
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Printf("⚠️ 写入用量记录失败: %v", err)
+	}
+}
+
+// Close 关闭当前文件
+func (l *usageLedger) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// Query 读取 [from, to] 日期范围内满足条件的记录
+func (l *usageLedger) Query(from, to time.Time, filter func(*UsageRecord) bool) ([]UsageRecord, error) {
+	if l.dir == "" {
+		return nil, nil
+	}
+	var records []UsageRecord
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(filepath.Join(l.dir, day.Format("2006-01-02")+".jsonl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var rec UsageRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue
+			}
+			if filter == nil || filter(&rec) {
+				records = append(records, rec)
+			}
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+// usageMiddleware 请求结束后写入账本；放在 apiKeyAuth 之前，认证失败和被限流的请求也会记录
+func usageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		metrics := requestMetrics(c)
+		c.Next()
+
+		rec := UsageRecord{
+			Time:          start,
+			RequestID:     requestIDFrom(c.Request.Context()),
+			Model:         metrics.Model,
+			Endpoint:      routeEndpoint(c),
+			Stream:        metrics.Stream,
+			Status:        responseStatus(c),
+			LatencyMs:     time.Since(start).Milliseconds(),
+			MediaBytesIn:  metrics.MediaBytesIn,
+			MediaBytesOut: metrics.MediaBytesOut,
+			Retries:       metrics.Retries,
+			ClientIP:      c.ClientIP(),
+		}
+		if key := requestKey(c); key != nil {
+			rec.KeyID, rec.Owner = key.ID, key.Owner
+		}
+		if usage, ok := c.Get(ctxTokenUsage); ok {
+			u := usage.(TokenUsage)
+			rec.PromptTokens, rec.CompletionTokens = u.PromptTokens, u.CompletionTokens
+		}
+		ledger.Append(rec)
+		observeRequest(rec)
+	}
+}
+
+// ==================== 用量报表 ====================
+
+// UsageSummary 按维度汇总的用量
+type UsageSummary struct {
+	KeyID            string  `json:"key_id,omitempty"`
+	Owner            string  `json:"owner,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Day              string  `json:"day,omitempty"`
+	Requests         int64   `json:"requests"`
+	Succeeded        int64   `json:"succeeded"`
+	Failed           int64   `json:"failed"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	MediaBytesIn     int64   `json:"media_bytes_in"`
+	MediaBytesOut    int64   `json:"media_bytes_out"`
+	Retries          int64   `json:"retries"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+
+	latencySum int64
+}
+
+var usageGroupFields = map[string]bool{"key": true, "model": true, "day": true}
+
+// aggregateUsage 按 groupBy（key/model/day 的组合）汇总
+func aggregateUsage(records []UsageRecord, groupBy []string) []UsageSummary {
+	by := make(map[string]bool)
+	for _, g := range groupBy {
+		by[g] = true
+	}
+	groups := make(map[string]*UsageSummary)
+	var order []string
+	for _, r := range records {
+		var s UsageSummary
+		if by["key"] {
+			s.KeyID, s.Owner = r.KeyID, r.Owner
+		}
+		if by["model"] {
+			s.Model = r.Model
+		}
+		if by["day"] {
+			s.Day = r.Time.UTC().Format("2006-01-02")
+		}
+		id := s.KeyID + "\x00" + s.Model + "\x00" + s.Day
+		g, ok := groups[id]
+		if !ok {
+			g = &s
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.Requests++
+		if r.Status >= 200 && r.Status < 300 {
+			g.Succeeded++
+		} else {
+			g.Failed++
+		}
+		g.PromptTokens += int64(r.PromptTokens)
+		g.CompletionTokens += int64(r.CompletionTokens)
+		g.TotalTokens += int64(r.PromptTokens + r.CompletionTokens)
+		g.MediaBytesIn += r.MediaBytesIn
+		g.MediaBytesOut += r.MediaBytesOut
+		g.Retries += int64(r.Retries)
+		g.latencySum += r.LatencyMs
+	}
+
+	out := make([]UsageSummary, 0, len(order))
+	for _, id := range order {
+		g := groups[id]
+		g.AvgLatencyMs = float64(g.latencySum) / float64(g.Requests)
+		out = append(out, *g)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Day != out[j].Day {
+			return out[i].Day < out[j].Day
+		}
+		if out[i].KeyID != out[j].KeyID {
+			return out[i].KeyID < out[j].KeyID
+		}
+		return out[i].Model < out[j].Model
+	})
+	return out
+}
+
+// parseUsageRange 解析 from/to（YYYY-MM-DD，UTC），默认最近 30 天
+func parseUsageRange(c *gin.Context) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -29), today
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("from 格式错误，应为 YYYY-MM-DD")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, fmt.Errorf("to 格式错误，应为 YYYY-MM-DD")
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to 不能早于 from")
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		return from, to, fmt.Errorf("查询范围不能超过一年")
+	}
+	return from, to, nil
+}
+
+// usageFilter 按 key_id/owner/model 过滤
+func usageFilter(c *gin.Context) func(*UsageRecord) bool {
+	keyID, owner, model := c.Query("key_id"), c.Query("owner"), c.Query("model")
+	return func(r *UsageRecord) bool {
+		return (keyID == "" || r.KeyID == keyID) && (owner == "" || r.Owner == owner) && (model == "" || r.Model == model)
+	}
+}
+
+// writeCSV 以附件形式输出 CSV
+func writeCSV(c *gin.Context, filename string, header []string, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(200)
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	w.WriteAll(rows)
+}
+
+func itoa64(n int64) string { return strconv.FormatInt(n, 10) }
+
+func registerUsageRoutes(admin *gin.RouterGroup) {
+	// 汇总：group_by=key,model,day（任意组合），format=json|csv
+	admin.GET("/usage", requireRole(RoleViewer), func(c *gin.Context) {
+		from, to, err := parseUsageRange(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		groupBy := strings.Split(c.DefaultQuery("group_by", "key,model,day"), ",")
+		for _, g := range groupBy {
+			if !usageGroupFields[g] {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("group_by 只支持 key、model、day，收到 %q", g)})
+				return
+			}
+		}
+		records, err := ledger.Query(from, to, usageFilter(c))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		summary := aggregateUsage(records, groupBy)
+
+		if c.Query("format") == "csv" {
+			rows := make([][]string, 0, len(summary))
+			for _, s := range summary {
+				rows = append(rows, []string{
+					s.Day, s.KeyID, s.Owner, s.Model,
+					itoa64(s.Requests), itoa64(s.Succeeded), itoa64(s.Failed),
+					itoa64(s.PromptTokens), itoa64(s.CompletionTokens), itoa64(s.TotalTokens),
+					itoa64(s.MediaBytesIn), itoa64(s.MediaBytesOut), itoa64(s.Retries),
+					strconv.FormatFloat(s.AvgLatencyMs, 'f', 1, 64),
+				})
+			}
+			writeCSV(c, fmt.Sprintf("usage_%s_%s.csv", from.Format("20060102"), to.Format("20060102")),
+				[]string{"day", "key_id", "owner", "model", "requests", "succeeded", "failed",
+					"prompt_tokens", "completion_tokens", "total_tokens", "media_bytes_in", "media_bytes_out", "retries", "avg_latency_ms"},
+				rows)
+			return
+		}
+		c.JSON(200, gin.H{
+			"from":     from.Format("2006-01-02"),
+			"to":       to.Format("2006-01-02"),
+			"group_by": groupBy,
+			"usage":    summary,
+		})
+	})
+
+	// 明细导出：format=json|csv，limit=N 只取最近 N 条
+	admin.GET("/usage/records", requireRole(RoleViewer), func(c *gin.Context) {
+		from, to, err := parseUsageRange(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		records, err := ledger.Query(from, to, usageFilter(c))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n < len(records) {
+			records = records[len(records)-n:]
+		}
+		if c.Query("format") == "csv" {
+			rows := make([][]string, 0, len(records))
+			for _, r := range records {
+				rows = append(rows, []string{
+					r.Time.UTC().Format(time.RFC3339), r.RequestID, r.KeyID, r.Owner, r.Model, r.Endpoint,
+					strconv.FormatBool(r.Stream), strconv.Itoa(r.Status), itoa64(r.LatencyMs),
+					strconv.Itoa(r.PromptTokens), strconv.Itoa(r.CompletionTokens),
+					itoa64(r.MediaBytesIn), itoa64(r.MediaBytesOut), strconv.Itoa(r.Retries), r.ClientIP,
+				})
+			}
+			writeCSV(c, fmt.Sprintf("usage_records_%s_%s.csv", from.Format("20060102"), to.Format("20060102")),
+				[]string{"time", "request_id", "key_id", "owner", "model", "endpoint", "stream", "status", "latency_ms",
+					"prompt_tokens", "completion_tokens", "media_bytes_in", "media_bytes_out", "retries", "client_ip"},
+				rows)
+			return
+		}
+		c.JSON(200, gin.H{"records": records, "count": len(records)})
+	})
+}
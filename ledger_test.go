@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageLedgerRecordsAndReports(t *testing.T) {
+	g := newTestGateway(t, 1)
+	key, keyID := createTestKey(t, g, map[string]interface{}{"owner": "team-u"})
+
+	content := []map[string]interface{}{
+		{"type": "text", "text": "describe"},
+		{"type": "image_url", "image_url": map[string]string{"url": "data:image/png;base64,iVBORw0KGgo="}},
+	}
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, content), append(bearer(key), "X-Request-ID", "req-ledger-1")...)
+	decodeCompletion(t, w)
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-pro", false, "hi"), bearer(key)...))
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-pro", false, "hi"), bearer("sk-b2a-wrong")...); w.Code != 401 {
+		t.Fatalf("错误 Key 返回 %d", w.Code)
+	}
+
+	// 明细：认证失败的请求也有记录
+	w = g.get(t, "/admin/usage/records")
+	var records struct {
+		Records []UsageRecord `json:"records"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &records)
+	if len(records.Records) != 3 {
+		t.Fatalf("记录数 = %d: %s", len(records.Records), w.Body.String())
+	}
+	first := records.Records[0]
+	if first.KeyID != keyID || first.Owner != "team-u" || first.Model != "gemini-2.5-flash" || first.Endpoint != EndpointOpenAI ||
+		first.Status != 200 || first.PromptTokens == 0 || first.CompletionTokens == 0 || first.MediaBytesIn != 8 ||
+		first.RequestID != "req-ledger-1" {
+		t.Fatalf("记录内容: %+v", first)
+	}
+	if failed := records.Records[2]; failed.Status != 401 || failed.KeyID != "" {
+		t.Fatalf("认证失败记录: %+v", failed)
+	}
+	json.Unmarshal(g.get(t, "/admin/usage/records?limit=1").Body.Bytes(), &records)
+	if len(records.Records) != 1 || records.Records[0].Status != 401 {
+		t.Fatalf("limit=1: %+v", records.Records)
+	}
+
+	// 按 Key + 模型汇总
+	w = g.get(t, "/admin/usage?group_by=key,model&key_id="+keyID)
+	var report struct {
+		Usage []UsageSummary `json:"usage"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if len(report.Usage) != 2 || report.Usage[0].Model != "gemini-2.5-flash" || report.Usage[0].Day != "" ||
+		report.Usage[1].Requests != 1 || report.Usage[1].Succeeded != 1 {
+		t.Fatalf("汇总结果: %s", w.Body.String())
+	}
+
+	// CSV 导出
+	w = g.get(t, "/admin/usage?group_by=day&format=csv")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 2 || rows[0][4] != "requests" || rows[1][4] != "3" || rows[1][6] != "1" {
+		t.Fatalf("CSV: %v %v", rows, err)
+	}
+
+	if w := g.get(t, "/admin/usage?group_by=region"); w.Code != 400 {
+		t.Fatalf("非法 group_by 返回 %d", w.Code)
+	}
+	if w := g.get(t, "/admin/usage?from=2024-02-01&to=2024-01-01"); w.Code != 400 {
+		t.Fatalf("非法日期范围返回 %d", w.Code)
+	}
+}
+
+func TestUsageAggregateByDay(t *testing.T) {
+	day1 := time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+	records := []UsageRecord{
+		{Time: day1, KeyID: "a", Model: "m", Status: 200, LatencyMs: 100, PromptTokens: 10, CompletionTokens: 5, Retries: 1},
+		{Time: day1, KeyID: "b", Model: "m", Status: 500, LatencyMs: 300, MediaBytesOut: 42},
+		{Time: day2, KeyID: "a", Model: "m", Status: 200, LatencyMs: 50},
+	}
+	got := aggregateUsage(records, []string{"day"})
+	if len(got) != 2 || got[0].Day != "2025-03-01" || got[1].Day != "2025-03-02" {
+		t.Fatalf("按天汇总: %+v", got)
+	}
+	if s := got[0]; s.Requests != 2 || s.Failed != 1 || s.TotalTokens != 15 || s.MediaBytesOut != 42 || s.Retries != 1 || s.AvgLatencyMs != 200 {
+		t.Fatalf("第一天汇总: %+v", s)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ==================== 生命周期 ====================
+
+// ShutdownConfig 优雅关闭
+type ShutdownConfig struct {
+	DrainTimeoutSec  int `json:"drain_timeout_sec"`  // 等待进行中的请求（含流式响应）完成的最长时间
+	WorkerTimeoutSec int `json:"worker_timeout_sec"` // 等待后台任务退出、保存状态的最长时间
+}
+
+func (c ShutdownConfig) drainTimeout() time.Duration {
+	if c.DrainTimeoutSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.DrainTimeoutSec) * time.Second
+}
+
+func (c ShutdownConfig) workerTimeout() time.Duration {
+	if c.WorkerTimeoutSec <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.WorkerTimeoutSec) * time.Second
+}
+
+// lifecycle 管理随进程运行的后台任务：关闭时取消 ctx 并等待任务退出
+type lifecycle struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	running   map[string]int
+	draining  chan struct{}
+	drainOnce sync.Once
+}
+
+var app = newLifecycle()
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel, running: make(map[string]int), draining: make(chan struct{})}
+}
+
+// Context 关闭时取消
+func (l *lifecycle) Context() context.Context { return l.ctx }
+
+// Go 启动后台任务，fn 应在 ctx 取消后尽快返回
+func (l *lifecycle) Go(name string, fn func(ctx context.Context)) {
+	l.mu.Lock()
+	l.running[name]++
+	l.mu.Unlock()
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer func() {
+			l.mu.Lock()
+			if l.running[name]--; l.running[name] == 0 {
+				delete(l.running, name)
+			}
+			l.mu.Unlock()
+		}()
+		fn(l.ctx)
+	}()
+}
+
+// Drain 标记开始关闭：无限期的长连接（如事件流）据此结束，以免拖住排空
+func (l *lifecycle) Drain() {
+	l.drainOnce.Do(func() { close(l.draining) })
+}
+
+// Draining 开始关闭时关闭的通道
+func (l *lifecycle) Draining() <-chan struct{} { return l.draining }
+
+// Stop 取消后台任务并等待退出，超时返回 false 并记录未退出的任务
+func (l *lifecycle) Stop(timeout time.Duration) bool {
+	l.Drain()
+	l.cancel()
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		l.mu.Lock()
+		names := make([]string, 0, len(l.running))
+		for name := range l.running {
+			names = append(names, name)
+		}
+		l.mu.Unlock()
+		sort.Strings(names)
+		log.Printf("⚠️ 等待后台任务退出超时 (%v)，仍在运行: %v", timeout, names)
+		return false
+	}
+}
+
+// serveUntil 在 ln 上提供服务直到 ctx 结束，随后停止接受新连接并等待进行中的请求完成；
+// 超过 drain 仍未完成的连接被强制断开，返回 false。服务异常退出时返回错误
+func serveUntil(ctx context.Context, srv *http.Server, ln net.Listener, drain time.Duration) (bool, error) {
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	select {
+	case err := <-served:
+		return false, err
+	case <-ctx.Done():
+	}
+
+	app.Drain()
+	log.Printf("🛑 停止接受新请求，等待进行中的请求完成（最长 %v）", drain)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("⚠️ 等待请求完成超时，强制断开剩余连接: %v", err)
+		srv.Close()
+		return false, nil
+	}
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return false, err
+	}
+	return true, nil
+}
+
+// shutdown 停止后台任务，保存账号状态与用量并关闭存储；全部按时完成返回 true
+func shutdown(cfg ShutdownConfig) bool {
+	timeout := cfg.workerTimeout()
+	clean := app.Stop(timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stopWebhooks(ctx)
+	if err := accountStates.Flush(pool); err != nil {
+		log.Printf("⚠️ 保存账号运行状态失败: %v", err)
+		clean = false
+	}
+	if err := accountStates.Close(); err != nil {
+		log.Printf("⚠️ 关闭状态库失败: %v", err)
+	}
+	if keyStore != nil {
+		if err := keyStore.Flush(); err != nil {
+			log.Printf("⚠️ 保存 API Key 用量失败: %v", err)
+			clean = false
+		}
+	}
+	ledger.Close()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("⚠️ 导出链路追踪数据失败: %v", err)
+	}
+	if accountStore != nil {
+		if err := accountStore.Close(); err != nil {
+			log.Printf("⚠️ 关闭账号存储失败: %v", err)
+		}
+	}
+	if ctx.Err() != nil {
+		clean = false
+	}
+	return clean
+}
+
+// runGateway 启动 HTTP 服务，收到 SIGTERM/SIGINT 后优雅关闭，返回进程退出码：
+// 0 表示全部按时完成，1 表示有请求被强制断开或后台任务未按时退出
+func runGateway(handler http.Handler) int {
+	ln, err := net.Listen("tcp", ListenAddr)
+	if err != nil {
+		log.Fatalf(" 服务启动失败: %v", err)
+	}
+	srv := &http.Server{Handler: handler}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		// 恢复默认处理：再次发送信号时立即退出
+		stop()
+		log.Printf("🛑 收到退出信号，开始优雅关闭（再次发送可立即退出）")
+	}()
+
+	start := time.Now()
+	cfg := currentConfig().Shutdown
+	drained, err := serveUntil(ctx, srv, ln, cfg.drainTimeout())
+	if err != nil {
+		log.Printf("❌ 服务异常退出: %v", err)
+	}
+	clean := shutdown(cfg) && drained && err == nil
+	if !clean {
+		log.Printf("⚠️ 强制关闭完成，耗时 %v", time.Since(start).Round(time.Millisecond))
+		return 1
+	}
+	log.Printf("👋 已优雅关闭，耗时 %v", time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLifecycle 为测试替换全局生命周期，避免 Drain/Stop 影响其他测试
+func useLifecycle(t *testing.T) *lifecycle {
+	t.Helper()
+	old := app
+	app = newLifecycle()
+	t.Cleanup(func() {
+		app.Stop(time.Second)
+		app = old
+	})
+	return app
+}
+
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+type serveResult struct {
+	clean bool
+	err   error
+}
+
+func startServe(ctx context.Context, srv *http.Server, ln net.Listener, drain time.Duration) <-chan serveResult {
+	done := make(chan serveResult, 1)
+	go func() {
+		clean, err := serveUntil(ctx, srv, ln, drain)
+		done <- serveResult{clean, err}
+	}()
+	return done
+}
+
+func TestServeUntilDrainsInFlightStream(t *testing.T) {
+	useLifecycle(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	ln := listenLocal(t)
+	addr := ln.Addr().String()
+	ctx, stop := context.WithCancel(context.Background())
+	done := startServe(ctx, &http.Server{Handler: mux}, ln, 5*time.Second)
+
+	resp, err := http.Get("http://" + addr + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if first != "data: 0\n" {
+		t.Fatalf("首个事件 = %q", first)
+	}
+
+	// 流进行中收到退出信号：不再接受新连接，但当前流完整结束
+	stop()
+	select {
+	case <-app.Draining():
+	case <-time.After(time.Second):
+		t.Fatal("未进入排空状态")
+	}
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || !strings.HasSuffix(string(rest), "data: [DONE]\n\n") {
+		t.Fatalf("流被截断: %q, %v", rest, err)
+	}
+	res := <-done
+	if !res.clean || res.err != nil {
+		t.Fatalf("serveUntil = %+v", res)
+	}
+	if _, err := http.Get("http://" + addr + "/stream"); err == nil {
+		t.Fatal("关闭后仍接受新连接")
+	}
+}
+
+func TestServeUntilForcesAfterDrainTimeout(t *testing.T) {
+	useLifecycle(t)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	})
+	ln := listenLocal(t)
+	ctx, stop := context.WithCancel(context.Background())
+	done := startServe(ctx, &http.Server{Handler: handler}, ln, 100*time.Millisecond)
+
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String()); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	stop()
+	select {
+	case res := <-done:
+		if res.clean || res.err != nil {
+			t.Fatalf("超时后应强制关闭: %+v", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("排空超时后未返回")
+	}
+}
+
+func TestLifecycleStop(t *testing.T) {
+	l := useLifecycle(t)
+	for i := 0; i < 3; i++ {
+		l.Go("ticker", func(ctx context.Context) { <-ctx.Done() })
+	}
+	if !l.Stop(time.Second) {
+		t.Fatal("后台任务未退出")
+	}
+
+	l = useLifecycle(t)
+	release := make(chan struct{})
+	defer close(release)
+	l.Go("stuck", func(ctx context.Context) { <-release })
+	if l.Stop(50 * time.Millisecond) {
+		t.Fatal("未退出的任务应导致超时")
+	}
+}
+
+func TestShutdownStopsWorkersAndFlushesState(t *testing.T) {
+	g := newTestGateway(t, 2)
+	l := useLifecycle(t)
+	statePath := filepath.Join(t.TempDir(), "state.db")
+	useAccountStates(t, statePath)
+
+	pool.StartPoolManager()
+	startKeyFlusher(time.Hour)
+	startPoolSampler(time.Hour)
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+
+	// 事件流在开始关闭时断开
+	req := httptest.NewRequest("GET", "/admin/events", nil)
+	req.Header.Set("X-Admin-Token", testAdminToken)
+	w := httptest.NewRecorder()
+	streamDone := make(chan struct{})
+	go func() {
+		g.router.ServeHTTP(w, req)
+		close(streamDone)
+	}()
+	l.Drain()
+	select {
+	case <-streamDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("事件流未在关闭时结束")
+	}
+
+	start := time.Now()
+	if !shutdown(ShutdownConfig{WorkerTimeoutSec: 5}) {
+		t.Fatal("shutdown 未按时完成")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("worker 未及时退出: %v", time.Since(start))
+	}
+
+	// 状态库已关闭：重新打开确认计数已写入
+	reopened, err := openAccountStateStore(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	restored := &Account{Data: AccountData{Email: g.accounts[0].Data.Email}}
+	if !reopened.Restore(restored) || restored.TotalCount != g.accounts[0].TotalCount || restored.TotalCount == 0 {
+		t.Fatalf("关闭时未保存状态: %+v", restored.runtimeState())
+	}
+	if ledger.file != nil {
+		t.Fatal("用量账本未关闭")
+	}
+}
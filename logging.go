@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ==================== 日志 ====================
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Format     string            `json:"format"`     // text 或 json
+	Level      string            `json:"level"`      // debug / info / warn / error
+	Subsystems map[string]string `json:"subsystems"` // 按子系统覆盖级别，如 {"browser": "warn", "upstream": "debug"}
+}
+
+// logLevels 全局级别与各子系统级别
+type logLevels struct {
+	base slog.Level
+	subs map[string]slog.Level
+}
+
+func (l *logLevels) of(subsystem string) slog.Level {
+	if lv, ok := l.subs[subsystem]; ok {
+		return lv
+	}
+	return l.base
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var lv slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	err := lv.UnmarshalText([]byte(s))
+	return lv, err
+}
+
+// leveledHandler 按子系统过滤级别；子系统来自 With("subsystem", ...)
+type leveledHandler struct {
+	inner     slog.Handler
+	levels    *logLevels
+	subsystem string
+}
+
+func (h *leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.levels.of(h.subsystem)
+}
+
+func (h *leveledHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sub := h.subsystem
+	for _, a := range attrs {
+		if a.Key == "subsystem" {
+			sub = a.Value.String()
+		}
+	}
+	return &leveledHandler{inner: h.inner.WithAttrs(attrs), levels: h.levels, subsystem: sub}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{inner: h.inner.WithGroup(name), levels: h.levels, subsystem: h.subsystem}
+}
+
+// newLogHandler 创建带脱敏和分级过滤的 slog Handler
+func newLogHandler(cfg LogConfig, w io.Writer) (slog.Handler, error) {
+	levels := &logLevels{subs: make(map[string]slog.Level)}
+	var err error
+	if levels.base, err = parseLogLevel(cfg.Level); err != nil {
+		return nil, fmt.Errorf("log.level: %w", err)
+	}
+	for sub, s := range cfg.Subsystems {
+		lv, err := parseLogLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("log.subsystems.%s: %w", sub, err)
+		}
+		levels.subs[sub] = lv
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactAttr}
+	var inner slog.Handler
+	switch cfg.Format {
+	case "", "text":
+		inner = slog.NewTextHandler(w, opts)
+	case "json":
+		inner = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log.format 只支持 text 或 json，收到 %q", cfg.Format)
+	}
+	return &leveledHandler{inner: inner, levels: levels}, nil
+}
+
+var (
+	rootLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{ReplaceAttr: redactAttr}))
+	subLoggers sync.Map // subsystem -> *slog.Logger
+)
+
+// initLogging 按配置替换全局日志，并把已有的 log.Printf 输出接入 slog
+func initLogging() {
+	handler, err := newLogHandler(currentConfig().Log, os.Stderr)
+	if err != nil {
+		log.Printf("⚠️ 日志配置无效: %v，使用默认配置", err)
+		handler, _ = newLogHandler(LogConfig{}, os.Stderr)
+	}
+	setRootLogger(slog.New(handler))
+}
+
+func setRootLogger(l *slog.Logger) {
+	rootLogger = l
+	subLoggers = sync.Map{}
+	slog.SetDefault(l)
+	// slog.SetDefault 会接管 log 包输出，这里改为按文件名识别子系统的桥接
+	log.SetFlags(log.Lshortfile)
+	log.SetOutput(logBridge{})
+}
+
+// subsystemLogger 返回子系统日志器（缓存）
+func subsystemLogger(subsystem string) *slog.Logger {
+	if l, ok := subLoggers.Load(subsystem); ok {
+		return l.(*slog.Logger)
+	}
+	l, _ := subLoggers.LoadOrStore(subsystem, rootLogger.With("subsystem", subsystem))
+	return l.(*slog.Logger)
+}
+
+// ctxLogger 绑定子系统和请求 ID 的日志器
+type ctxLogger struct {
+	*slog.Logger
+}
+
+// logFor 返回带请求 ID 和 trace_id（如果 ctx 中有）的子系统日志器
+func logFor(ctx context.Context, subsystem string) ctxLogger {
+	l := subsystemLogger(subsystem)
+	if id := requestIDFrom(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID().String())
+	}
+	return ctxLogger{l}
+}
+
+// Printf 兼容原有的 emoji 格式日志，按前缀推断级别
+func (l ctxLogger) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.Log(context.Background(), inferLogLevel(msg), msg)
+}
+
+// inferLogLevel ❌ 为 error，⚠️ 为 warn，🔍 为 debug，其余为 info
+func inferLogLevel(msg string) slog.Level {
+	msg = strings.TrimSpace(msg)
+	switch {
+	case strings.HasPrefix(msg, "❌"):
+		return slog.LevelError
+	case strings.HasPrefix(msg, "⚠️"):
+		return slog.LevelWarn
+	case strings.HasPrefix(msg, "🔍"):
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// fileSubsystems 文件名到子系统的映射，未列出的文件以文件名作为子系统
+var fileSubsystems = map[string]string{
+	"main":     "api",
+	"api":      "api",
+	"apierror": "api",
+	"validate": "api",
+	"utils":    "api",
+	"keys":     "auth",
+	"admin":    "auth",
+}
+
+// logBridge 接收 log.Printf 的输出（"file.go:123: 消息"），转为 slog 记录
+type logBridge struct{}
+
+func (logBridge) Write(p []byte) (int, error) {
+	subsystem, source, msg := parseLogLine(string(p))
+	subsystemLogger(subsystem).Log(context.Background(), inferLogLevel(msg), msg, "source", source)
+	return len(p), nil
+}
+
+// parseLogLine 解析 log.Lshortfile 格式的一行，返回子系统、源位置和消息
+func parseLogLine(line string) (subsystem, source, msg string) {
+	line = strings.TrimRight(line, "\n")
+	file, rest, ok := strings.Cut(line, ": ")
+	if !ok || !strings.Contains(file, ".go:") {
+		return "app", "", line
+	}
+	name := strings.TrimSuffix(filepath.Base(file[:strings.Index(file, ":")]), ".go")
+	if s, ok := fileSubsystems[name]; ok {
+		name = s
+	}
+	return name, file, rest
+}
+
+// ==================== 脱敏 ====================
+
+// 日志脱敏模式；JWT 使用 recorder.go 中的 jwtPattern
+var (
+	apiKeyPattern  = regexp.MustCompile(`\b(sk-[A-Za-z0-9]+-)[A-Za-z0-9]{8,}|\b(AIza)[0-9A-Za-z_-]{20,}`)
+	cookiePattern  = regexp.MustCompile(`((?:__Secure-|__Host-)[A-Za-z0-9_-]+=)[^;\s",']+`)
+	headerPattern  = regexp.MustCompile(`(?i)("?(?:authorization|cookie|set-cookie|x-api-key|x-goog-api-key|x-admin-token)"?\s*[:=]\s*"?)(Bearer\s+)?[^\s",;]+`)
+	secretPattern  = regexp.MustCompile(`(?i)("?(?:api_key|apikey|token|password|secret|jwt)"?\s*[:=]\s*"?)[^\s",;]+`)
+	emailPattern   = regexp.MustCompile(`\b([A-Za-z0-9._%+-]{1,2})[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})\b`)
+	sensitiveAttrs = map[string]bool{"cookies": true, "jwt": true, "token": true, "api_key": true, "password": true, "secret": true}
+)
+
+// redactSecrets 去除日志中的 JWT、Cookie、Authorization、API Key，并部分隐藏邮箱
+func redactSecrets(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redactedMark)
+	s = apiKeyPattern.ReplaceAllString(s, "$1$2"+redactedMark)
+	s = cookiePattern.ReplaceAllString(s, "$1"+redactedMark)
+	s = headerPattern.ReplaceAllString(s, "$1$2"+redactedMark)
+	s = secretPattern.ReplaceAllString(s, "$1"+redactedMark)
+	return emailPattern.ReplaceAllString(s, "$1***@$2")
+}
+
+// redactAttr slog ReplaceAttr：敏感字段整体隐藏，其余字符串做模式脱敏
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if key := strings.ToLower(a.Key); sensitiveAttrs[key] || sensitiveHeaders[key] {
+		return slog.String(a.Key, redactedMark)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+// ==================== 请求 ID ====================
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware 使用客户端传入的 X-Request-ID（格式合法时），否则生成新 ID；
+// ID 写入响应头并放进请求 context，供 streamChat 和上游调用的日志使用
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Header("X-Request-ID", id)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom 从 context 中取请求 ID
+func requestIDFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	cases := map[string]string{
+		"jwt=eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.c2ln end":  "jwt=[REDACTED] end",
+		"Authorization: Bearer abc.def":                      "Authorization: Bearer [REDACTED]",
+		`{"authorization":"Bearer xyz"}`:                     `{"authorization":"Bearer [REDACTED]"}`,
+		"cookie __Secure-C_SES=CSE.abc123; __Host-C_OSES=zz": "cookie __Secure-C_SES=[REDACTED]; __Host-C_OSES=[REDACTED]",
+		"key sk-b2a-0123456789abcdef0123 used":               "key sk-b2a-[REDACTED] used",
+		"账号 alice@example.com 刷新失败":                          "账号 al***@example.com 刷新失败",
+		`{"password":"hunter2","xsrfToken":"abc"}`:           `{"password":"[REDACTED]","xsrfToken":"[REDACTED]"}`,
+		"📤 [1.2.3.4] 使用账号: user0@test.local":                 "📤 [1.2.3.4] 使用账号: us***@test.local",
+		"plain message without secrets":                      "plain message without secrets",
+	}
+	for in, want := range cases {
+		if got := redactSecrets(in); got != want {
+			t.Errorf("redactSecrets(%q) = %q, 期望 %q", in, got, want)
+		}
+	}
+}
+
+func TestLogHandlerLevelsAndRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := newLogHandler(LogConfig{Format: "json", Level: "info", Subsystems: map[string]string{"browser": "warn", "upstream": "debug"}}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := slog.New(handler)
+	ctx := withRequestID(context.Background(), "req-42")
+
+	root.With("subsystem", "browser").Info("被过滤")
+	root.With("subsystem", "browser").Warn("保留")
+	root.With("subsystem", "upstream").Debug("调试")
+	root.With("subsystem", "pool").Debug("被过滤")
+	ctxLogger{root.With("subsystem", "api", "request_id", requestIDFrom(ctx))}.Printf("❌ 失败 token=%s", "s3cr3t")
+	root.Info("凭据", "authorization", "Bearer abc", "cookie", "a=b")
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("非 JSON 输出: %s", line)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("输出 %d 行: %s", len(lines), buf.String())
+	}
+	if lines[0]["msg"] != "保留" || lines[1]["msg"] != "调试" {
+		t.Fatalf("子系统级别过滤错误: %s", buf.String())
+	}
+	if l := lines[2]; l["level"] != "ERROR" || l["request_id"] != "req-42" || l["msg"] != "❌ 失败 token=[REDACTED]" {
+		t.Fatalf("Printf 级别/请求 ID/脱敏: %v", l)
+	}
+	if l := lines[3]; l["authorization"] != redactedMark || l["cookie"] != redactedMark {
+		t.Fatalf("敏感字段未脱敏: %v", l)
+	}
+
+	if _, err := newLogHandler(LogConfig{Format: "xml"}, &buf); err == nil {
+		t.Fatal("非法格式未报错")
+	}
+	if _, err := newLogHandler(LogConfig{Subsystems: map[string]string{"pool": "loud"}}, &buf); err == nil {
+		t.Fatal("非法级别未报错")
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	cases := []struct{ line, sub, source, msg string }{
+		{"pool.go:331: ⚠️ 刷新失败\n", "pool", "pool.go:331", "⚠️ 刷新失败"},
+		{"main.go:12: 📥 请求: model=x", "api", "main.go:12", "📥 请求: model=x"},
+		{"no source: here", "app", "", "no source: here"},
+	}
+	for _, c := range cases {
+		sub, source, msg := parseLogLine(c.line)
+		if sub != c.sub || source != c.source || msg != c.msg {
+			t.Errorf("parseLogLine(%q) = %q %q %q", c.line, sub, source, msg)
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	g := newTestGateway(t, 1)
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), "X-Request-ID", "client-abc.1")
+	if w.Header().Get("X-Request-ID") != "client-abc.1" {
+		t.Fatalf("未沿用客户端请求 ID: %q", w.Header().Get("X-Request-ID"))
+	}
+	// 非法 ID 被替换为新生成的 ID
+	w = g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), "X-Request-ID", "bad id\twith spaces")
+	if id := w.Header().Get("X-Request-ID"); len(id) != 36 {
+		t.Fatalf("生成的请求 ID = %q", id)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -13,6 +15,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,6 +26,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	_ "golang.org/x/image/webp"
 )
 
@@ -43,6 +49,14 @@ type PoolConfig struct {
 	BrowserRefreshMaxRetry int  `json:"browser_refresh_max_retry"` // 浏览器刷新最大重试次数(0=禁用)
 }
 
+// 请求超时配置（按模型类别）
+type TimeoutConfig struct {
+	DefaultSec int `json:"default_sec"` // 普通模型请求超时(秒)
+	ImageSec   int `json:"image_sec"`   // 图片生成模型超时(秒)
+	VideoSec   int `json:"video_sec"`   // 视频生成模型超时(秒)
+	MaxSec     int `json:"max_sec"`     // X-Request-Timeout 允许的最大值(秒)
+}
+
 // QQ邮箱IMAP配置
 type QQImapConfig struct {
 	Server   string `json:"server"`    // IMAP服务器地址
@@ -58,16 +72,33 @@ type EmailConfig struct {
 }
 
 type AppConfig struct {
-	APIKeys       []string    `json:"api_keys"`       // API 密钥列表
-	ListenAddr    string      `json:"listen_addr"`    // 监听地址
-	DataDir       string      `json:"data_dir"`       // 数据目录
-	Pool          PoolConfig  `json:"pool"`           // 号池配置
-	Proxy         string      `json:"proxy"`          // 代理
-	DefaultConfig string      `json:"default_config"` // 默认 configId
-	Email         EmailConfig `json:"email"`          // 邮箱配置
+	APIKeys       []string         `json:"api_keys"`       // API 密钥列表
+	Models        []string         `json:"models"`         // 对外提供的模型，留空使用内置列表；第一个为默认模型
+	ListenAddr    string           `json:"listen_addr"`    // 监听地址
+	DataDir       string           `json:"data_dir"`       // 数据目录
+	Pool          PoolConfig       `json:"pool"`           // 号池配置
+	Proxy         string           `json:"proxy"`          // 代理
+	DefaultConfig string           `json:"default_config"` // 默认 configId
+	Email         EmailConfig      `json:"email"`          // 邮箱配置
+	Timeout       TimeoutConfig    `json:"timeout"`        // 请求超时配置
+	Upstream      UpstreamConfig   `json:"upstream"`       // 上游地址配置
+	Record        RecordConfig     `json:"record"`         // 上游流量录制
+	Limits        LimitsConfig     `json:"limits"`         // 请求大小限制
+	Admin         AdminConfig      `json:"admin"`          // 管理接口凭据与审计
+	RateLimit     RateLimitConfig  `json:"rate_limit"`     // 限流
+	Queue         QueueConfig      `json:"queue"`          // 号池繁忙时排队
+	Metrics       MetricsConfig    `json:"metrics"`        // Prometheus 指标
+	Log           LogConfig        `json:"log"`            // 日志格式与级别
+	Tracing       TracingConfig    `json:"tracing"`        // OpenTelemetry 链路追踪
+	Health        HealthConfig     `json:"health"`         // 就绪检查阈值
+	Events        EventsConfig     `json:"events"`         // 事件通知（Webhook）
+	Storage       StorageConfig    `json:"storage"`        // 账号存储后端
+	Encryption    EncryptionConfig `json:"encryption"`     // 账号凭据加密
+	Shutdown      ShutdownConfig   `json:"shutdown"`       // 优雅关闭
 }
 
-var appConfig = AppConfig{
+// builtinConfig 内置默认配置，当前生效的配置见 currentConfig
+var builtinConfig = AppConfig{
 	ListenAddr: ":8000",
 	DataDir:    "./data",
 	Pool: PoolConfig{
@@ -91,6 +122,41 @@ var appConfig = AppConfig{
 			Port:   993,
 		},
 	},
+	Upstream: UpstreamConfig{
+		APIBaseURL:  DefaultUpstreamAPIBase,
+		AuthBaseURL: DefaultUpstreamAuthBase,
+	},
+	Timeout: TimeoutConfig{
+		DefaultSec: 300,  // 5分钟
+		ImageSec:   600,  // 10分钟
+		VideoSec:   1800, // 30分钟
+		MaxSec:     1800,
+	},
+	Limits: LimitsConfig{
+		MaxMessages:   500,
+		MaxMediaCount: 10,
+		MaxMediaMB:    20,
+		MaxTools:      128,
+	},
+	RateLimit: RateLimitConfig{
+		DefaultTier: "default",
+		Tiers:       map[string]RateLimitTier{"default": {}},
+	},
+	Queue: QueueConfig{
+		MaxSize:      100,
+		TimeoutSec:   30,
+		KeepAliveSec: 5,
+	},
+	Metrics: MetricsConfig{Enabled: true},
+	Log:     LogConfig{Format: "text", Level: "info"},
+	Health: HealthConfig{
+		MinReadyAccounts:     1,
+		MaxUpstreamErrorRate: 0.5,
+		ErrorWindowSec:       300,
+		MinUpstreamSamples:   5,
+	},
+	Events:   EventsConfig{DedupeWindowSec: 300, RetryStormThreshold: 20},
+	Shutdown: ShutdownConfig{DrainTimeoutSec: 30, WorkerTimeoutSec: 10},
 }
 
 // 兼容旧的环境变量
@@ -102,74 +168,6 @@ var (
 	JwtTTL        = 270 * time.Second
 )
 
-// 保存默认配置到文件
-func saveDefaultConfig(configPath string) error {
-	data, err := json.MarshalIndent(appConfig, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(configPath, data, 0644)
-}
-
-func loadAppConfig() {
-	// 尝试加载配置文件
-	configPath := "config.json"
-	if data, err := os.ReadFile(configPath); err == nil {
-		if err := json.Unmarshal(data, &appConfig); err != nil {
-			log.Printf("⚠️ 解析配置文件失败: %v，使用默认配置", err)
-		} else {
-			log.Printf("✅ 加载配置文件: %s", configPath)
-		}
-	} else if os.IsNotExist(err) {
-		// 配置文件不存在，创建默认配置
-		log.Printf("⚠️ 配置文件不存在，创建默认配置: %s", configPath)
-		if err := saveDefaultConfig(configPath); err != nil {
-			log.Printf("❌ 创建默认配置失败: %v", err)
-		}
-	}
-
-	// 环境变量覆盖配置文件
-	if v := os.Getenv("DATA_DIR"); v != "" {
-		appConfig.DataDir = v
-	}
-	if v := os.Getenv("PROXY"); v != "" {
-		appConfig.Proxy = v
-	}
-	if v := os.Getenv("LISTEN_ADDR"); v != "" {
-		appConfig.ListenAddr = v
-	}
-	if v := os.Getenv("CONFIG_ID"); v != "" {
-		appConfig.DefaultConfig = v
-	}
-	if v := os.Getenv("API_KEY"); v != "" {
-		appConfig.APIKeys = append(appConfig.APIKeys, v)
-	}
-
-	// 设置全局变量
-	DataDir = appConfig.DataDir
-	Proxy = appConfig.Proxy
-	ListenAddr = appConfig.ListenAddr
-	DefaultConfig = appConfig.DefaultConfig
-
-	// 应用号池配置
-	SetCooldowns(appConfig.Pool.RefreshCooldownSec, appConfig.Pool.UseCooldownSec)
-	if appConfig.Pool.MaxFailCount > 0 {
-		MaxFailCount = appConfig.Pool.MaxFailCount
-	}
-	EnableBrowserRefresh = appConfig.Pool.EnableBrowserRefresh
-	BrowserRefreshHeadless = appConfig.Pool.BrowserRefreshHeadless
-	if appConfig.Pool.BrowserRefreshMaxRetry >= 0 {
-		BrowserRefreshMaxRetry = appConfig.Pool.BrowserRefreshMaxRetry
-	}
-
-	if EnableBrowserRefresh && BrowserRefreshMaxRetry > 0 {
-		log.Printf("🌐 浏览器刷新已启用 (headless=%v, 最大重试=%d)", BrowserRefreshHeadless, BrowserRefreshMaxRetry)
-	} else if EnableBrowserRefresh {
-		log.Printf("🌐 浏览器刷新已禁用 (max_retry=0)")
-		EnableBrowserRefresh = false
-	}
-}
-
 var FixedModels = []string{
 	"gemini-2.5-flash",
 	"gemini-2.5-pro",
@@ -206,182 +204,7 @@ func getEnv(key, def string) string {
 
 // 数据结构和号池管理已移至 pool.go
 // HTTP客户端和工具函数已移至 utils.go
-
-// ==================== Session 管理 ====================
-
-func getCommonHeaders(jwt, origAuth string) map[string]string {
-	headers := map[string]string{
-		"accept":             "*/*",
-		"accept-encoding":    "gzip, deflate, br, zstd",
-		"accept-language":    "zh-CN,zh;q=0.9,en;q=0.8",
-		"authorization":      "Bearer " + jwt,
-		"content-type":       "application/json",
-		"origin":             "https://business.gemini.google",
-		"referer":            "https://business.gemini.google/",
-		"user-agent":         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
-		"x-server-timeout":   "1800",
-		"sec-ch-ua":          `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`,
-		"sec-ch-ua-mobile":   "?0",
-		"sec-ch-ua-platform": `"Windows"`,
-		"sec-fetch-dest":     "empty",
-		"sec-fetch-mode":     "cors",
-		"sec-fetch-site":     "cross-site",
-	}
-	// 同时携带原始 authorization
-	if origAuth != "" {
-		headers["x-original-authorization"] = origAuth
-	}
-	return headers
-}
-
-func createSession(jwt, configID, origAuth string) (string, error) {
-	body := map[string]interface{}{
-		"configId":         configID,
-		"additionalParams": map[string]string{"token": "-"},
-		"createSessionRequest": map[string]interface{}{
-			"session": map[string]string{"name": "", "displayName": ""},
-		},
-	}
-
-	bodyBytes, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", "https://biz-discoveryengine.googleapis.com/v1alpha/locations/global/widgetCreateSession", bytes.NewReader(bodyBytes))
-
-	for k, v := range getCommonHeaders(jwt, origAuth) {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("createSession 请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := readResponseBody(resp)
-	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
-	}
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("createSession 失败: %d %s", resp.StatusCode, string(respBody))
-	}
-
-	var result struct {
-		Session struct {
-			Name string `json:"name"`
-		} `json:"session"`
-	}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("解析 session 响应失败: %w", err)
-	}
-
-	return result.Session.Name, nil
-}
-
-// 上传图片到 Session，返回 fileId（支持 base64 或 URL）
-func uploadContextFile(jwt, configID, sessionName, mimeType, base64Content, origAuth string) (string, error) {
-	ext := "jpg"
-	if parts := strings.Split(mimeType, "/"); len(parts) == 2 {
-		ext = parts[1]
-	}
-	fileName := fmt.Sprintf("upload_%d_%s.%s", time.Now().Unix(), uuid.New().String()[:6], ext)
-
-	body := map[string]interface{}{
-		"configId":         configID,
-		"additionalParams": map[string]string{"token": "-"},
-		"addContextFileRequest": map[string]interface{}{
-			"name":         sessionName,
-			"fileName":     fileName,
-			"mimeType":     mimeType,
-			"fileContents": base64Content,
-		},
-	}
-
-	bodyBytes, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", "https://biz-discoveryengine.googleapis.com/v1alpha/locations/global/widgetAddContextFile", bytes.NewReader(bodyBytes))
-
-	for k, v := range getCommonHeaders(jwt, origAuth) {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("上传文件请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := readResponseBody(resp)
-	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
-	}
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("上传文件失败: %d %s", resp.StatusCode, string(respBody))
-	}
-
-	var result struct {
-		AddContextFileResponse struct {
-			FileID string `json:"fileId"`
-		} `json:"addContextFileResponse"`
-	}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("解析上传响应失败: %w", err)
-	}
-
-	if result.AddContextFileResponse.FileID == "" {
-		return "", fmt.Errorf("上传成功但 fileId 为空，响应: %s", string(respBody))
-	}
-
-	return result.AddContextFileResponse.FileID, nil
-}
-
-// 通过 URL 上传图片到 Session，返回 fileId
-func uploadContextFileByURL(jwt, configID, sessionName, imageURL, origAuth string) (string, error) {
-	body := map[string]interface{}{
-		"configId":         configID,
-		"additionalParams": map[string]string{"token": "-"},
-		"addContextFileRequest": map[string]interface{}{
-			"name":    sessionName,
-			"fileUri": imageURL,
-		},
-	}
-
-	bodyBytes, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", "https://biz-discoveryengine.googleapis.com/v1alpha/locations/global/widgetAddContextFile", bytes.NewReader(bodyBytes))
-
-	for k, v := range getCommonHeaders(jwt, origAuth) {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("上传文件请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := readResponseBody(resp)
-	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
-	}
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("URL上传文件失败: %d %s", resp.StatusCode, string(respBody))
-	}
-
-	var result struct {
-		AddContextFileResponse struct {
-			FileID string `json:"fileId"`
-		} `json:"addContextFileResponse"`
-	}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("解析上传响应失败: %w", err)
-	}
-
-	if result.AddContextFileResponse.FileID == "" {
-		return "", fmt.Errorf("URL上传成功但 fileId 为空，响应: %s", string(respBody))
-	}
-
-	return result.AddContextFileResponse.FileID, nil
-}
+// 上游接口调用已移至 upstream.go
 
 type Message struct {
 	Role       string      `json:"role"`
@@ -466,7 +289,8 @@ func createChunk(id string, created int64, model string, delta map[string]interf
 	return string(data)
 }
 
-func extractContentFromReply(replyMap map[string]interface{}, jwt, session, configID, origAuth string) (text string, imageData string, imageMime string, reasoning string) {
+func extractContentFromReply(ctx context.Context, replyMap map[string]interface{}, jwt, session, configID, origAuth string) (text string, imageData string, imageMime string, reasoning string) {
+	lg := logFor(ctx, "api")
 	groundedContent, ok := replyMap["groundedContent"].(map[string]interface{})
 	if !ok {
 		return
@@ -511,10 +335,10 @@ func extractContentFromReply(replyMap map[string]interface{}, jwt, session, conf
 			} else if strings.HasPrefix(mimeType, "video/") {
 				fileType = "视频"
 			}
-			//	log.Printf("📥 发现%s: fileId=%s, mimeType=%s", fileType, fileId, mimeType)
-			data, err := downloadGeneratedFile(jwt, fileId, session, configID, origAuth)
+			//	lg.Printf("📥 发现%s: fileId=%s, mimeType=%s", fileType, fileId, mimeType)
+			data, err := downloadGeneratedFile(ctx, jwt, fileId, session, configID, origAuth)
 			if err != nil {
-				log.Printf("❌ 下载%s失败: %v", fileType, err)
+				lg.Printf("❌ 下载%s失败: %v", fileType, err)
 			} else {
 				imageData = data
 				imageMime = mimeType
@@ -526,12 +350,13 @@ func extractContentFromReply(replyMap map[string]interface{}, jwt, session, conf
 }
 
 // 下载生成的文件（图片或视频）——带重试机制
-func downloadGeneratedFile(jwt, fileId, session, configID, origAuth string) (string, error) {
-	return downloadGeneratedFileWithRetry(jwt, fileId, session, configID, origAuth, 3)
+func downloadGeneratedFile(ctx context.Context, jwt, fileId, session, configID, origAuth string) (string, error) {
+	return downloadGeneratedFileWithRetry(ctx, jwt, fileId, session, configID, origAuth, 3)
 }
 
 // downloadGeneratedFileWithRetry 下载文件，带重试机制，遇到 401 时尝试切换账号
-func downloadGeneratedFileWithRetry(jwt, fileId, session, configID, origAuth string, maxRetries int) (string, error) {
+func downloadGeneratedFileWithRetry(ctx context.Context, jwt, fileId, session, configID, origAuth string, maxRetries int) (string, error) {
+	lg := logFor(ctx, "api")
 	// 参数验证
 	if jwt == "" {
 		return "", fmt.Errorf("JWT 为空，无法下载文件")
@@ -543,17 +368,21 @@ func downloadGeneratedFileWithRetry(jwt, fileId, session, configID, origAuth str
 		return "", fmt.Errorf("configID 为空，无法下载文件")
 	}
 
-	log.Printf("📥 开始下载文件: fileId=%s, session=%s", fileId, session)
+	lg.Printf("📥 开始下载文件: fileId=%s, session=%s", fileId, session)
 
 	var lastErr error
 	currentJWT := jwt
 	currentOrigAuth := origAuth
 
 	for retry := 0; retry < maxRetries; retry++ {
-		result, err := downloadGeneratedFileOnce(currentJWT, fileId, session, configID, currentOrigAuth)
+		result, err := upstream.DownloadGeneratedFile(ctx, currentJWT, fileId, session, configID, currentOrigAuth)
 		if err == nil {
 			return result, nil
 		}
+		// 请求已取消或超时，不再重试
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("下载文件已中止: %w", ctx.Err())
+		}
 
 		lastErr = err
 		errMsg := err.Error()
@@ -561,14 +390,14 @@ func downloadGeneratedFileWithRetry(jwt, fileId, session, configID, origAuth str
 		// 检查是否是 401/403 错误
 		if strings.Contains(errMsg, "401") || strings.Contains(errMsg, "403") ||
 			strings.Contains(errMsg, "UNAUTHENTICATED") || strings.Contains(errMsg, "SESSION_COOKIE_INVALID") {
-			log.Printf("⚠️ 下载文件认证失败 (尝试 %d/%d): %v，尝试切换账号...", retry+1, maxRetries, err)
+			lg.Printf("⚠️ 下载文件认证失败 (尝试 %d/%d): %v，尝试切换账号...", retry+1, maxRetries, err)
 
 			// 尝试获取新账号
 			newAcc := pool.Next()
 			if newAcc != nil {
 				newJWT, newConfigID, jwtErr := newAcc.GetJWT()
 				if jwtErr == nil {
-					log.Printf("✅ 切换到新账号: %s", newAcc.Data.Email)
+					lg.Printf("✅ 切换到新账号: %s", newAcc.Data.Email)
 					currentJWT = newJWT
 					currentOrigAuth = newAcc.Data.Authorization
 					// 如果新账号有不同的 configID，也可以更新（但通常 session 是绑定的）
@@ -576,95 +405,17 @@ func downloadGeneratedFileWithRetry(jwt, fileId, session, configID, origAuth str
 					continue
 				}
 			}
-			log.Printf("❌ 无法获取新账号，重试当前账号...")
+			lg.Printf("❌ 无法获取新账号，重试当前账号...")
 		}
 
 		// 其他错误，等待后重试
-		log.Printf("❌ 下载文件失败 (尝试 %d/%d): %v", retry+1, maxRetries, err)
-		time.Sleep(500 * time.Millisecond)
-	}
-
-	return "", fmt.Errorf("下载文件失败，已重试 %d 次: %w", maxRetries, lastErr)
-}
-
-// downloadGeneratedFileOnce 单次下载文件尝试
-func downloadGeneratedFileOnce(jwt, fileId, session, configID, origAuth string) (string, error) {
-
-	// 步骤1: 使用 widgetListSessionFileMetadata 获取文件下载 URL
-	listBody := map[string]interface{}{
-		"configId":         configID,
-		"additionalParams": map[string]string{"token": "-"},
-		"listSessionFileMetadataRequest": map[string]interface{}{
-			"name":   session,
-			"filter": "file_origin_type = AI_GENERATED",
-		},
-	}
-	listBodyBytes, _ := json.Marshal(listBody)
-
-	listReq, _ := http.NewRequest("POST", "https://biz-discoveryengine.googleapis.com/v1alpha/locations/global/widgetListSessionFileMetadata", bytes.NewReader(listBodyBytes))
-	for k, v := range getCommonHeaders(jwt, origAuth) {
-		listReq.Header.Set(k, v)
-	}
-
-	listResp, err := httpClient.Do(listReq)
-	if err != nil {
-		return "", fmt.Errorf("获取文件元数据失败: %w", err)
-	}
-	defer listResp.Body.Close()
-
-	listRespBody, _ := readResponseBody(listResp)
-
-	if listResp.StatusCode != 200 {
-		return "", fmt.Errorf("获取文件元数据失败: HTTP %d: %s", listResp.StatusCode, string(listRespBody))
-	}
-
-	// 解析响应，查找匹配的 fileId
-	var listResult struct {
-		ListSessionFileMetadataResponse struct {
-			FileMetadata []struct {
-				FileID      string `json:"fileId"`
-				Session     string `json:"session"` // 包含完整的 projects 路径
-				DownloadURI string `json:"downloadUri"`
-			} `json:"fileMetadata"`
-		} `json:"listSessionFileMetadataResponse"`
-	}
-	if err := json.Unmarshal(listRespBody, &listResult); err != nil {
-		return "", fmt.Errorf("解析文件元数据失败: %w", err)
-	}
-
-	// 查找匹配的文件，获取完整 session 路径
-	var fullSession string
-	for _, meta := range listResult.ListSessionFileMetadataResponse.FileMetadata {
-		if meta.FileID == fileId {
-			fullSession = meta.Session // 如: projects/372889301682/locations/global/collections/...
-			break
+		lg.Printf("❌ 下载文件失败 (尝试 %d/%d): %v", retry+1, maxRetries, err)
+		if !sleepCtx(ctx, 500*time.Millisecond) {
+			return "", fmt.Errorf("下载文件已中止: %w", ctx.Err())
 		}
 	}
 
-	if fullSession == "" {
-		return "", fmt.Errorf("未找到 fileId=%s 的文件信息", fileId)
-	}
-
-	downloadURL := fmt.Sprintf("https://biz-discoveryengine.googleapis.com/download/v1alpha/%s:downloadFile?fileId=%s&alt=media", fullSession, fileId)
-	downloadReq, _ := http.NewRequest("GET", downloadURL, nil)
-	for k, v := range getCommonHeaders(jwt, origAuth) {
-		downloadReq.Header.Set(k, v)
-	}
-
-	downloadResp, err := httpClient.Do(downloadReq)
-	if err != nil {
-		return "", fmt.Errorf("下载图片失败: %w", err)
-	}
-	defer downloadResp.Body.Close()
-
-	imgBody, _ := readResponseBody(downloadResp)
-
-	if downloadResp.StatusCode != 200 {
-		return "", fmt.Errorf("下载图片失败: HTTP %d: %s", downloadResp.StatusCode, string(imgBody))
-	}
-
-	// 响应是原始二进制图片数据，需要转为 base64
-	return base64.StdEncoding.EncodeToString(imgBody), nil
+	return "", fmt.Errorf("下载文件失败，已重试 %d 次: %w", maxRetries, lastErr)
 }
 
 // 将图片转换为 Markdown 格式的 data URI
@@ -826,13 +577,17 @@ func parseMediaURL(urlStr, defaultType string) *MediaInfo {
 	}
 }
 
-func downloadImage(urlStr string) (string, string, error) {
-	return downloadMedia(urlStr, "image")
+func downloadImage(ctx context.Context, urlStr string) (string, string, error) {
+	return downloadMedia(ctx, urlStr, "image")
 }
 
 // downloadMedia 下载媒体文件（图片或视频）
-func downloadMedia(urlStr, mediaType string) (string, string, error) {
-	resp, err := httpClient.Get(urlStr)
+func downloadMedia(ctx context.Context, urlStr, mediaType string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return "", "", err
 	}
@@ -1107,12 +862,91 @@ func needsConversationContext(messages []Message) bool {
 	}
 	return false
 }
+
+// requestTimeout 计算请求超时：优先使用 X-Request-Timeout 请求头（秒数或 Go duration），否则按模型类别取配置值
+func requestTimeout(c *gin.Context, model string) (time.Duration, error) {
+	cfg := currentConfig().Timeout
+	maxTimeout := time.Duration(cfg.MaxSec) * time.Second
+
+	if v := strings.TrimSpace(c.GetHeader("X-Request-Timeout")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			sec, convErr := strconv.Atoi(v)
+			if convErr != nil {
+				return 0, fmt.Errorf("X-Request-Timeout 格式无效: %s", v)
+			}
+			d = time.Duration(sec) * time.Second
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("X-Request-Timeout 必须大于 0: %s", v)
+		}
+		if maxTimeout > 0 && d > maxTimeout {
+			d = maxTimeout
+		}
+		return d, nil
+	}
+
+	sec := cfg.DefaultSec
+	switch {
+	case strings.Contains(model, "video"):
+		sec = cfg.VideoSec
+	case strings.Contains(model, "image") || strings.Contains(model, "imagen"):
+		sec = cfg.ImageSec
+	}
+	if sec <= 0 {
+		sec = 300
+	}
+	d := time.Duration(sec) * time.Second
+	if maxTimeout > 0 && d > maxTimeout {
+		d = maxTimeout
+	}
+	return d, nil
+}
+
+// abortOnContextEnd 请求上下文结束时的处理：客户端断开只记录日志，超时返回 504
+func abortOnContextEnd(c *gin.Context, ctx context.Context, clientIP string, timeout time.Duration) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && c.Request.Context().Err() == nil {
+		log.Printf("⏰ [%s] 请求超时 (%v)，已取消上游请求", clientIP, timeout)
+		respondChatError(c, 504, fmt.Sprintf("请求超时 (%v)", timeout))
+		return
+	}
+	log.Printf("🚫 [%s] 客户端已断开，已取消上游请求", clientIP)
+	c.Abort()
+}
+
 func streamChat(c *gin.Context, req ChatRequest) {
+	lg := logFor(c.Request.Context(), "api")
 	chatID := "chatcmpl-" + uuid.New().String()
 	createdTime := time.Now().Unix()
 	clientIP := c.ClientIP()
 	// 入站日志
-	log.Printf("📥 [%s] 请求: model=%s ", clientIP, req.Model)
+	lg.Printf("📥 [%s] 请求: model=%s ", clientIP, req.Model)
+	if !authorizeModel(c, req.Model) {
+		return
+	}
+	metrics := requestMetrics(c)
+	metrics.Model, metrics.Stream = req.Model, req.Stream
+
+	timeout, err := requestTimeout(c, req.Model)
+	if err != nil {
+		respondError(c, 400, "invalid_request_error", err.Error())
+		return
+	}
+	// 客户端断开或超时后，取消所有上游调用
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	ctx, span := startSpan(ctx, "chat", trace.WithAttributes(
+		attribute.String("gen_ai.request.model", req.Model),
+		attribute.Bool("stream", req.Stream),
+		attribute.Int("messages", len(req.Messages)),
+	))
+	defer span.End()
+
+	if recorder != nil {
+		rec := recorder.Start(chatID, req.Model, req.Stream)
+		ctx = withRecording(ctx, rec)
+		defer recorder.Finish(rec)
+	}
 	// 解析消息：支持多轮对话拼接和系统提示词
 	var textContent string
 	var images []MediaInfo
@@ -1141,10 +975,13 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			textContent = userText
 		}
 	}
+	usage := TokenUsage{PromptTokens: estimateTokens(textContent)}
+	span.SetAttributes(attribute.Int("media.count", len(images)), attribute.Int("prompt_tokens", usage.PromptTokens))
 	var respBody []byte
 	var lastErr error
 	var usedAcc *Account
 	var usedJWT, usedOrigAuth, usedConfigID, usedSession string
+	attemptCtx := ctx // 当前尝试的 context，上游调用的 span 带上重试次数和账号
 
 	// 检测是否是可能长时间处理的模型（视频/图片生成）
 	isLongRunning := !req.Stream && (strings.Contains(req.Model, "video") ||
@@ -1203,28 +1040,55 @@ func streamChat(c *gin.Context, req ChatRequest) {
 	}()
 
 	for retry := 0; retry < maxRetries; retry++ {
-		acc := pool.Next()
-		if acc == nil {
-			c.JSON(500, gin.H{"error": "没有可用账号"})
+		if ctx.Err() != nil {
+			abortOnContextEnd(c, ctx, clientIP, timeout)
+			return
+		}
+		var keepAlive func()
+		if req.Stream {
+			keepAlive = sseKeepAlive(c)
+		}
+		tenant, weight := queueTenant(c)
+		_, selectSpan := startSpan(ctx, "account.select", trace.WithAttributes(attribute.Int("retry", retry), attribute.String("tenant", tenant)))
+		acc, err := admission.Acquire(ctx, tenant, weight, keepAlive)
+		if acc != nil {
+			selectSpan.SetAttributes(attribute.String("account.hash", accountHash(acc)))
+		} else {
+			selectSpan.SetStatus(codes.Error, err.Error())
+		}
+		selectSpan.End()
+		switch {
+		case err == errNoAccount:
+			respondChatError(c, 500, "没有可用账号")
+			return
+		case err == errQueueFull, err == errQueueTimeout:
+			lg.Printf("⏳ [%s] %v", clientIP, err)
+			respondQueueError(c, 503, err)
+			return
+		case err != nil:
+			abortOnContextEnd(c, ctx, clientIP, timeout)
 			return
 		}
 		usedAcc = acc
-		log.Printf("📤 [%s] 使用账号: %s", clientIP, acc.Data.Email)
+		attemptCtx = withSpanAttrs(ctx, attribute.Int("retry", retry), attribute.String("account.hash", accountHash(acc)))
+		lg.Printf("📤 [%s] 使用账号: %s", clientIP, acc.Data.Email)
 
 		if retry > 0 {
-			log.Printf("🔄 第 %d 次重试，切换账号: %s", retry+1, acc.Data.Email)
+			metrics.Retries = retry
+			retryStorm.Record(time.Now())
+			lg.Printf("🔄 第 %d 次重试，切换账号: %s", retry+1, acc.Data.Email)
 		}
 
 		jwt, configID, err := acc.GetJWT()
 		if err != nil {
-			log.Printf("❌ [%s] 获取 JWT 失败: %v", acc.Data.Email, err)
+			lg.Printf("❌ [%s] 获取 JWT 失败: %v", acc.Data.Email, err)
 			lastErr = err
 			continue
 		}
 
-		session, err := createSession(jwt, configID, acc.Data.Authorization)
+		session, err := upstream.CreateSession(attemptCtx, jwt, configID, acc.Data.Authorization)
 		if err != nil {
-			log.Printf("❌ [%s] 创建 Session 失败: %v", acc.Data.Email, err)
+			lg.Printf("❌ [%s] 创建 Session 失败: %v", acc.Data.Email, err)
 			// 401 错误标记账号需要刷新
 			if strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "UNAUTHENTICATED") {
 				//		pool.MarkNeedsRefresh(acc)
@@ -1235,6 +1099,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 
 		// 上传媒体文件并获取 fileIds
 		var fileIds []string
+		var mediaBytesIn int64
 		uploadFailed := false
 		for _, media := range images {
 			var fileId string
@@ -1247,13 +1112,16 @@ func streamChat(c *gin.Context, req ChatRequest) {
 
 			if media.IsURL {
 				// 优先尝试 URL 直接上传
-				fileId, err = uploadContextFileByURL(jwt, configID, session, media.URL, acc.Data.Authorization)
+				fileId, err = upstream.UploadContextFileByURL(attemptCtx, jwt, configID, session, media.URL, acc.Data.Authorization)
 				if err != nil {
 					// URL 上传失败，回退到下载后上传
-					mediaData, mimeType, dlErr := downloadMedia(media.URL, media.MediaType)
+					mediaData, mimeType, dlErr := downloadMedia(ctx, media.URL, media.MediaType)
 					if dlErr != nil {
-						log.Printf("⚠️ [%s] %s下载失败: %v", acc.Data.Email, mediaTypeName, dlErr)
+						lg.Printf("⚠️ [%s] %s下载失败: %v", acc.Data.Email, mediaTypeName, dlErr)
 						if strings.Contains(dlErr.Error(), "UPSTREAM_401") || strings.Contains(dlErr.Error(), "UPSTREAM_403") {
+							if writeSSEError(c, 500, "upstream_error", dlErr.Error()) {
+								return
+							}
 							c.JSON(500, gin.H{"error": gin.H{
 								"message": dlErr.Error(),
 								"type":    "upstream_error",
@@ -1264,13 +1132,15 @@ func streamChat(c *gin.Context, req ChatRequest) {
 						uploadFailed = true
 						break
 					}
-					fileId, err = uploadContextFile(jwt, configID, session, mimeType, mediaData, acc.Data.Authorization)
+					fileId, err = upstream.UploadContextFile(attemptCtx, jwt, configID, session, mimeType, mediaData, acc.Data.Authorization)
+					mediaBytesIn += base64Size(mediaData)
 				}
 			} else {
-				fileId, err = uploadContextFile(jwt, configID, session, media.MimeType, media.Data, acc.Data.Authorization)
+				fileId, err = upstream.UploadContextFile(attemptCtx, jwt, configID, session, media.MimeType, media.Data, acc.Data.Authorization)
+				mediaBytesIn += base64Size(media.Data)
 			}
 			if err != nil {
-				log.Printf("⚠️ [%s] %s上传失败: %v", acc.Data.Email, mediaTypeName, err)
+				lg.Printf("⚠️ [%s] %s上传失败: %v", acc.Data.Email, mediaTypeName, err)
 				uploadFailed = true
 				break
 			}
@@ -1280,6 +1150,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			lastErr = fmt.Errorf("媒体上传失败")
 			continue
 		}
+		metrics.MediaBytesIn = mediaBytesIn
 		// 构建 query parts（只包含文本）
 		queryParts := []map[string]interface{}{}
 		if textContent != "" {
@@ -1319,15 +1190,9 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		}
 
 		bodyBytes, _ := json.Marshal(body)
-		httpReq, _ := http.NewRequest("POST", "https://biz-discoveryengine.googleapis.com/v1alpha/locations/global/widgetStreamAssist", bytes.NewReader(bodyBytes))
-
-		for k, v := range getCommonHeaders(jwt, acc.Data.Authorization) {
-			httpReq.Header.Set(k, v)
-		}
-
-		resp, err := httpClient.Do(httpReq)
+		resp, err := upstream.StreamAssist(attemptCtx, jwt, acc.Data.Authorization, bodyBytes)
 		if err != nil {
-			log.Printf("❌ [%s] 请求失败: %v", acc.Data.Email, err)
+			lg.Printf("❌ [%s] 请求失败: %v", acc.Data.Email, err)
 			lastErr = err
 			continue
 		}
@@ -1335,24 +1200,22 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		if resp.StatusCode != 200 {
 			body, _ := readResponseBody(resp)
 			resp.Body.Close()
-			log.Printf("❌ [%s] Google 报错: %d %s (重试 %d/%d)", acc.Data.Email, resp.StatusCode, string(body), retry+1, maxRetries)
+			lg.Printf("❌ [%s] Google 报错: %d %s (重试 %d/%d)", acc.Data.Email, resp.StatusCode, string(body), retry+1, maxRetries)
 			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
 			// 401/403 无权限，标记需要刷新
 			if resp.StatusCode == 401 || resp.StatusCode == 403 {
-				log.Printf("⚠️ [%s] %d 无权限，标记需要刷新", acc.Data.Email, resp.StatusCode)
-				pool.MarkNeedsRefresh(acc)
+				lg.Printf("⚠️ [%s] %d 无权限，标记需要刷新", acc.Data.Email, resp.StatusCode)
+				pool.MarkNeedsRefresh(acc, fmt.Sprintf("上游返回 %d", resp.StatusCode))
 			}
 			// 429 限流，延长使用冷却时间（3倍冷却）
 			if resp.StatusCode == 429 {
-				cooldownTime := UseCooldown * 3
-				acc.mu.Lock()
-				acc.LastUsed = time.Now().Add(cooldownTime)
-				acc.mu.Unlock()
-				log.Printf("⏳ [%s] 429 限流，账号进入延长冷却 %v", acc.Data.Email, cooldownTime)
+				cooldownTime := currentSettings().UseCooldown * 3
+				pool.MarkCooling(acc, cooldownTime, "上游返回 429")
+				lg.Printf("⏳ [%s] 429 限流，账号进入延长冷却 %v", acc.Data.Email, cooldownTime)
 				// 429不计入重试次数，等待后继续尝试其他账号
 				pool.MarkUsed(acc, false)
-				time.Sleep(1 * time.Second) // 短暂等待后切换账号
-				retry--                     // 不计入重试次数
+				sleepCtx(ctx, time.Second) // 短暂等待后切换账号
+				retry--                    // 不计入重试次数
 				continue
 			}
 			pool.MarkUsed(acc, false) // 标记失败
@@ -1360,13 +1223,16 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		}
 
 		// 成功，读取响应
-		respBody, _ = readResponseBody(resp)
+		respBody, err = readResponseBody(resp)
 		resp.Body.Close()
+		if err != nil && ctx.Err() != nil {
+			continue
+		}
 
 		// 快速检查是否是认证错误响应
 		if bytes.Contains(respBody, []byte("uToken")) && !bytes.Contains(respBody, []byte("streamAssistResponse")) {
-			log.Printf("⚠️ [%s] 收到认证响应，标记需要刷新", acc.Data.Email)
-			pool.MarkNeedsRefresh(acc)
+			lg.Printf("⚠️ [%s] 收到认证响应，标记需要刷新", acc.Data.Email)
+			pool.MarkNeedsRefresh(acc, "上游返回认证响应")
 			lastErr = fmt.Errorf("认证失败，需要刷新账号")
 			continue
 		}
@@ -1375,7 +1241,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		hasContent := bytes.Contains(respBody, []byte(`"text"`)) || bytes.Contains(respBody, []byte(`"file"`)) || bytes.Contains(respBody, []byte(`"inlineData"`))
 		if !hasContent && bytes.Contains(respBody, []byte(`"thought"`)) {
 			// 只有思考内容，没有实际输出，重试
-			log.Printf("⚠️ [%s] 响应只有思考内容，无实际输出，重试 (%d/%d)", acc.Data.Email, retry+1, maxRetries)
+			lg.Printf("⚠️ [%s] 响应只有思考内容，无实际输出，重试 (%d/%d)", acc.Data.Email, retry+1, maxRetries)
 			lastErr = fmt.Errorf("空返回，只有思考内容")
 			continue
 		}
@@ -1390,9 +1256,13 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		break
 	}
 
+	if ctx.Err() != nil {
+		abortOnContextEnd(c, ctx, clientIP, timeout)
+		return
+	}
 	if lastErr != nil {
-		log.Printf("❌ 所有重试均失败: %v", lastErr)
-		c.JSON(500, gin.H{"error": lastErr.Error()})
+		lg.Printf("❌ 所有重试均失败: %v", lastErr)
+		respondChatError(c, 500, lastErr.Error())
 		return
 	}
 
@@ -1400,8 +1270,8 @@ func streamChat(c *gin.Context, req ChatRequest) {
 
 	// 检查空响应
 	if len(respBody) == 0 {
-		log.Printf("❌ 响应为空")
-		c.JSON(500, gin.H{"error": "Empty response from Google"})
+		lg.Printf("❌ 响应为空")
+		respondChatError(c, 500, "Empty response from Google")
 		return
 	}
 
@@ -1411,13 +1281,13 @@ func streamChat(c *gin.Context, req ChatRequest) {
 
 	// 1. 尝试标准 JSON 数组
 	if parseErr = json.Unmarshal(respBody, &dataList); parseErr != nil {
-		log.Printf("⚠️ JSON 数组解析失败: %v, 响应前100字符: %s", parseErr, string(respBody[:min(100, len(respBody))]))
+		lg.Printf("⚠️ JSON 数组解析失败: %v, 响应前100字符: %s", parseErr, string(respBody[:min(100, len(respBody))]))
 
 		// 2. 尝试修复不完整的 JSON 数组
 		dataList = parseIncompleteJSONArray(respBody)
 		if dataList == nil {
 			// 3. 尝试 NDJSON 格式
-			log.Printf("⚠️ 尝试 NDJSON 格式...")
+			lg.Printf("⚠️ 尝试 NDJSON 格式...")
 			dataList = parseNDJSON(respBody)
 		}
 
@@ -1425,15 +1295,15 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			// 输出完整响应用于调试
 			respStr := string(respBody)
 			if len(respStr) > 500 {
-				log.Printf("❌ 所有解析方式均失败, 响应长度: %d, 前500字符: %s", len(respBody), respStr[:500])
-				log.Printf("❌ 后200字符: %s", respStr[len(respStr)-200:])
+				lg.Printf("❌ 所有解析方式均失败, 响应长度: %d, 前500字符: %s", len(respBody), respStr[:500])
+				lg.Printf("❌ 后200字符: %s", respStr[len(respStr)-200:])
 			} else {
-				log.Printf("❌ 所有解析方式均失败, 响应长度: %d, 完整响应: %s", len(respBody), respStr)
+				lg.Printf("❌ 所有解析方式均失败, 响应长度: %d, 完整响应: %s", len(respBody), respStr)
 			}
-			c.JSON(500, gin.H{"error": "JSON Parse Error"})
+			respondChatError(c, 500, "JSON Parse Error")
 			return
 		}
-		log.Printf("✅ 备用解析成功，共 %d 个对象", len(dataList))
+		lg.Printf("✅ 备用解析成功，共 %d 个对象", len(dataList))
 	}
 
 	// 检查是否有有效响应
@@ -1462,9 +1332,9 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			}
 		}
 		if !hasValidResponse {
-			log.Printf("⚠️ 响应中没有 streamAssistResponse，响应内容: %v", dataList[0])
+			lg.Printf("⚠️ 响应中没有 streamAssistResponse，响应内容: %v", dataList[0])
 		}
-		log.Printf("📊 响应统计: %d 个数据块, 有效响应=%v, 包含文件=%v", len(dataList), hasValidResponse, hasFileContent)
+		lg.Printf("📊 响应统计: %d 个数据块, 有效响应=%v, 包含文件=%v", len(dataList), hasValidResponse, hasFileContent)
 	}
 
 	// 从响应中提取 session（用于下载图片）
@@ -1483,10 +1353,10 @@ func streamChat(c *gin.Context, req ChatRequest) {
 	// 如果响应中没有 session，使用请求时创建的 session 作为回退
 	if respSession == "" {
 		if usedSession != "" {
-			log.Printf("⚠️ 响应中未找到 session，使用请求时创建的 session: %s", usedSession)
+			lg.Printf("⚠️ 响应中未找到 session，使用请求时创建的 session: %s", usedSession)
 			respSession = usedSession
 		} else {
-			log.Printf("⚠️ 响应中未找到 session 且无回退 session，图片/视频下载可能失败")
+			lg.Printf("⚠️ 响应中未找到 session 且无回退 session，图片/视频下载可能失败")
 		}
 	} else {
 	}
@@ -1543,6 +1413,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 				// 检查是否是思考内容
 				if thought, ok := content["thought"].(bool); ok && thought {
 					if t, ok := content["text"].(string); ok && t != "" {
+						usage.CompletionTokens += estimateTokens(t)
 						chunk := createChunk(chatID, createdTime, req.Model, map[string]interface{}{"reasoning_content": t}, nil)
 						fmt.Fprintf(writer, "data: %s\n\n", chunk)
 						flusher.Flush()
@@ -1551,6 +1422,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 				}
 				// 输出文本（实时）
 				if t, ok := content["text"].(string); ok && t != "" {
+					usage.CompletionTokens += estimateTokens(t)
 					chunk := createChunk(chatID, createdTime, req.Model, map[string]interface{}{"content": t}, nil)
 					fmt.Fprintf(writer, "data: %s\n\n", chunk)
 					flusher.Flush()
@@ -1561,6 +1433,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 					mime, _ := inlineData["mimeType"].(string)
 					data, _ := inlineData["data"].(string)
 					if mime != "" && data != "" {
+						metrics.MediaBytesOut += base64Size(data)
 						imgMarkdown := formatImageAsMarkdown(mime, data)
 						chunk := createChunk(chatID, createdTime, req.Model, map[string]interface{}{"content": imgMarkdown}, nil)
 						fmt.Fprintf(writer, "data: %s\n\n", chunk)
@@ -1581,6 +1454,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 					name, _ := fc["name"].(string)
 					args, _ := fc["args"].(map[string]interface{})
 					argsBytes, _ := json.Marshal(args)
+					usage.CompletionTokens += estimateTokens(name + string(argsBytes))
 
 					toolCall := ToolCall{
 						ID:   "call_" + uuid.New().String()[:8],
@@ -1607,7 +1481,8 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			}
 		}
 		if len(pendingFiles) > 0 {
-			log.Printf("📥 开始下载 %d 个文件...", len(pendingFiles))
+			lg.Printf("📥 开始下载 %d 个文件...", len(pendingFiles))
+			downloadCtx, downloadSpan := startSpan(attemptCtx, "download_files", trace.WithAttributes(attribute.Int("files", len(pendingFiles))))
 			type downloadResult struct {
 				Index    int
 				Data     string
@@ -1620,7 +1495,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 				wg.Add(1)
 				go func(idx int, file PendingFile) {
 					defer wg.Done()
-					data, err := downloadGeneratedFile(usedJWT, file.FileID, respSession, usedConfigID, usedOrigAuth)
+					data, err := downloadGeneratedFile(downloadCtx, usedJWT, file.FileID, respSession, usedConfigID, usedOrigAuth)
 					results <- downloadResult{Index: idx, Data: data, MimeType: file.MimeType, Err: err}
 				}(i, pf)
 			}
@@ -1632,13 +1507,15 @@ func streamChat(c *gin.Context, req ChatRequest) {
 			for r := range results {
 				downloaded[r.Index] = r
 			}
+			downloadSpan.End()
 
 			// 按顺序输出
 			for i, r := range downloaded {
 				if r.Err != nil {
-					log.Printf("❌ 下载文件[%d]失败: %v", i, r.Err)
+					lg.Printf("❌ 下载文件[%d]失败: %v", i, r.Err)
 					continue
 				}
+				metrics.MediaBytesOut += base64Size(r.Data)
 				imgMarkdown := formatImageAsMarkdown(r.MimeType, r.Data)
 				chunk := createChunk(chatID, createdTime, req.Model, map[string]interface{}{"content": imgMarkdown}, nil)
 				fmt.Fprintf(writer, "data: %s\n\n", chunk)
@@ -1656,6 +1533,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		fmt.Fprintf(writer, "data: %s\n\n", finalChunk)
 		fmt.Fprintf(writer, "data: [DONE]\n\n")
 		flusher.Flush()
+		c.Set(ctxTokenUsage, usage)
 	} else {
 		// 非流式响应
 		var fullContent strings.Builder
@@ -1691,21 +1569,26 @@ func streamChat(c *gin.Context, req ChatRequest) {
 					}
 				}
 
-				text, imageData, imageMime, reasoning := extractContentFromReply(replyMap, usedJWT, respSession, usedConfigID, usedOrigAuth)
+				text, imageData, imageMime, reasoning := extractContentFromReply(attemptCtx, replyMap, usedJWT, respSession, usedConfigID, usedOrigAuth)
 				if reasoning != "" {
 					fullReasoning.WriteString(reasoning)
 				}
 				if text != "" {
 					fullContent.WriteString(text)
 				}
+				usage.CompletionTokens += estimateTokens(reasoning + text)
 				if imageData != "" && imageMime != "" {
+					metrics.MediaBytesOut += base64Size(imageData)
 					fullContent.WriteString(formatImageAsMarkdown(imageMime, imageData))
 				}
 			}
 		}
 		toolCalls := extractToolCalls(dataList)
+		for _, tc := range toolCalls {
+			usage.CompletionTokens += estimateTokens(tc.Function.Name + tc.Function.Arguments)
+		}
 		// 调试日志
-		log.Printf("📊 非流式响应统计: %d 个 reply, 包含文件=%v, content长度=%d, reasoning长度=%d, 工具调用=%d",
+		lg.Printf("📊 非流式响应统计: %d 个 reply, 包含文件=%v, content长度=%d, reasoning长度=%d, 工具调用=%d",
 			replyCount, hasFile, fullContent.Len(), fullReasoning.Len(), len(toolCalls))
 
 		// 构建响应消息
@@ -1735,11 +1618,12 @@ func streamChat(c *gin.Context, req ChatRequest) {
 				"finish_reason": finishReason,
 			}},
 			"usage": gin.H{
-				"prompt_tokens":     0,
-				"completion_tokens": 0,
-				"total_tokens":      0,
+				"prompt_tokens":     usage.PromptTokens,
+				"completion_tokens": usage.CompletionTokens,
+				"total_tokens":      usage.Total(),
 			},
 		}
+		c.Set(ctxTokenUsage, usage)
 
 		// 对于长时间运行的模型，停止心跳后直接写入 JSON
 		if isLongRunning && heartbeatDone != nil {
@@ -1751,45 +1635,6 @@ func streamChat(c *gin.Context, req ChatRequest) {
 		}
 	}
 }
-func apiKeyAuth() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if len(appConfig.APIKeys) == 0 {
-			c.Next()
-			return
-		}
-		authHeader := c.GetHeader("Authorization")
-		apiKey := ""
-
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
-		} else {
-			apiKey = c.GetHeader("X-API-Key")
-		}
-
-		if apiKey == "" {
-			c.JSON(401, gin.H{"error": "Missing API key"})
-			c.Abort()
-			return
-		}
-
-		// 验证 API Key
-		valid := false
-		for _, key := range appConfig.APIKeys {
-			if key == apiKey {
-				valid = true
-				break
-			}
-		}
-
-		if !valid {
-			c.JSON(401, gin.H{"error": "Invalid API key"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
 
 // runBrowserRefreshMode 有头浏览器刷新模式
 func runBrowserRefreshMode(email string) {
@@ -1797,10 +1642,10 @@ func runBrowserRefreshMode(email string) {
 	initHTTPClient()
 
 	// 强制有头模式
-	BrowserRefreshHeadless = false
 	log.Println("🌐 有头浏览器刷新模式")
 
-	if err := pool.Load(DataDir); err != nil {
+	initAccountStore()
+	if err := pool.Load(accountStore); err != nil {
 		log.Fatalf("❌ 加载账号失败: %v", err)
 	}
 
@@ -1872,11 +1717,11 @@ func runBrowserRefreshMode(email string) {
 		}
 		targetAcc.mu.Unlock()
 
-		// 保存到文件
-		if err := targetAcc.SaveToFile(); err != nil {
+		// 写回账号存储
+		if err := targetAcc.Save(); err != nil {
 			log.Printf("⚠️ 保存失败: %v", err)
 		} else {
-			log.Printf("💾 已保存到: %s", targetAcc.FilePath)
+			log.Printf("💾 已保存: %s", targetAcc.ID)
 		}
 	} else {
 		log.Printf("❌ 刷新失败: %v", result.Error)
@@ -1889,10 +1734,22 @@ func main() {
 	var refreshEmail string
 	var refreshMode bool
 	var testImapMode bool
+	var replayPath string
+	var replayStream *bool
+	var decryptTarget string
+	var reencryptMode bool
+
+	if v := os.Getenv("B2A_CONFIG"); v != "" {
+		configPath, configPathExplicit = v, true
+	}
 
 	// 解析命令行参数
 	for i, arg := range os.Args[1:] {
 		switch arg {
+		case "--config", "-c":
+			if i+2 < len(os.Args) {
+				configPath, configPathExplicit = os.Args[i+2], true
+			}
 		case "--debug", "-d":
 			RegisterDebug = true
 			log.Println("🔧 调试模式已启用，将保存截图到 data/screenshots/")
@@ -1907,14 +1764,31 @@ func main() {
 			}
 		case "--test-imap":
 			testImapMode = true
+		case "--replay":
+			if i+2 < len(os.Args) {
+				replayPath = os.Args[i+2]
+			}
+		case "--decrypt":
+			if i+2 < len(os.Args) {
+				decryptTarget = os.Args[i+2]
+			}
+		case "--reencrypt":
+			reencryptMode = true
+		case "--stream", "--no-stream":
+			stream := arg == "--stream"
+			replayStream = &stream
 		case "--help", "-h":
 			fmt.Println(`用法: ./gemini-gateway [选项]
 
 选项:
+  --config, -c <path>   配置文件路径（默认 config.json，也可用 B2A_CONFIG）
   --debug, -d           调试模式，保存注册过程截图
   --once                单次注册模式（调试用）
   --refresh [email]     有头浏览器刷新账号（不指定email则使用第一个账号）
   --test-imap           测试QQ邮箱IMAP连接
+  --replay <path>       离线回放上游录制（文件或目录），可加 --stream/--no-stream
+  --decrypt <file|id>   解密并打印账号凭据（账号文件路径或存储中的 ID）
+  --reencrypt           用当前密钥加密全部账号（迁移明文、轮换密钥）后退出
   --help, -h            显示帮助`)
 			os.Exit(0)
 		}
@@ -1927,6 +1801,25 @@ func main() {
 		return
 	}
 
+	// 回放模式：离线回放录制的上游流量
+	if replayPath != "" {
+		loadAppConfig()
+		runReplayMode(replayPath, replayStream)
+		return
+	}
+
+	// 凭据加密相关命令
+	if decryptTarget != "" {
+		loadAppConfig()
+		runDecryptMode(decryptTarget)
+		return
+	}
+	if reencryptMode {
+		loadAppConfig()
+		runReencryptMode()
+		return
+	}
+
 	// 刷新模式：直接执行浏览器刷新后退出
 	if refreshMode {
 		runBrowserRefreshMode(refreshEmail)
@@ -1935,7 +1828,9 @@ func main() {
 
 	loadAppConfig()
 	initHTTPClient()
-	if err := pool.Load(DataDir); err != nil {
+	initAccountStates()
+	initAccountStore()
+	if err := pool.Load(accountStore); err != nil {
 		log.Fatalf("❌ 加载账号失败: %v", err)
 	}
 
@@ -1945,29 +1840,55 @@ func main() {
 	}
 
 	// 检查 API Key 配置
-	if len(appConfig.APIKeys) == 0 {
+	initKeyStore()
+	if keyStore.Empty() {
 		log.Println("⚠️ 未配置 API Key，API 将无鉴权运行")
 	}
+	startKeyFlusher(30 * time.Second)
+	startStateFlusher(30 * time.Second)
+	initAdminAuth()
+	initAuditLog()
+	initUsageLedger()
+	initAdmissionQueue()
+	initEvents()
 
 	// 启动号池管理
-	if appConfig.Pool.RefreshOnStartup {
+	poolCfg := currentConfig().Pool
+	if poolCfg.RefreshOnStartup {
 		pool.StartPoolManager()
 	}
-	if pool.TotalCount() == 0 {
-		needCount := appConfig.Pool.TargetCount
+	if pool.CapacityCount() == 0 {
+		needCount := poolCfg.TargetCount
 		log.Printf("📝 无账号，启动注册 %d 个...", needCount)
 		startRegister(needCount)
 	}
-	if appConfig.Pool.CheckIntervalMinutes > 0 {
-		go poolMaintainer()
+	if poolCfg.CheckIntervalMinutes > 0 {
+		app.Go("pool-maintainer", poolMaintainer)
 	}
+	startAccountWatcher()
+	startPoolSampler(30 * time.Second)
 	gin.SetMode(gin.ReleaseMode)
+	initTracing()
+	initHealth()
+	startConfigReloader()
+	r := setupRouter()
+	startMetricsServer()
+
+	log.Printf(" 服务启动于 %s，账号: ready=%d, pending=%d", ListenAddr, pool.ReadyCount(), pool.PendingCount())
+	os.Exit(runGateway(r))
+}
+
+// setupRouter 注册所有路由和中间件
+func setupRouter() *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(requestIDMiddleware())
+	r.Use(tracingMiddleware())
 	r.Use(func(c *gin.Context) {
 		start := time.Now()
 		c.Next()
-		log.Printf("%s %s %d %v", c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(start))
+		logFor(c.Request.Context(), "http").Info(c.Request.Method+" "+c.Request.URL.Path,
+			"status", responseStatus(c), "latency_ms", time.Since(start).Milliseconds(), "client_ip", c.ClientIP())
 	})
 
 	r.GET("/", func(c *gin.Context) {
@@ -1981,6 +1902,8 @@ func main() {
 				"gemini": "/v1beta/models/{model}:generateContent",
 				"models": "/v1/models",
 				"health": "/health",
+				"livez":  "/livez",
+				"readyz": "/readyz",
 			},
 			"pool": gin.H{
 				"ready":   pool.ReadyCount(),
@@ -1997,12 +1920,20 @@ func main() {
 			"pending": pool.PendingCount(),
 		})
 	})
+	registerHealthRoutes(r)
+	if cfg := currentConfig().Metrics; cfg.Enabled && cfg.Listen == "" {
+		r.GET("/metrics", metricsHandler())
+	}
 	api := r.Group("/")
-	api.Use(apiKeyAuth())
+	api.Use(usageMiddleware(), apiKeyAuth(), rateLimit())
 	api.GET("/v1/models", func(c *gin.Context) {
 		now := time.Now().Unix()
 		var models []gin.H
-		for _, m := range FixedModels {
+		key := requestKey(c)
+		for _, m := range availableModels() {
+			if key != nil && !keyStore.AllowsModel(key, m) {
+				continue
+			}
 			models = append(models, gin.H{
 				"id":         m,
 				"object":     "model",
@@ -2017,12 +1948,16 @@ func main() {
 	api.POST("/v1/chat/completions", func(c *gin.Context) {
 		var req ChatRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+			respondError(c, 400, "invalid_request_error", err.Error())
+			return
+		}
+		if verr := validateChatRequest(&req); verr != nil {
+			respondValidationError(c, verr)
 			return
 		}
 
 		if req.Model == "" {
-			req.Model = FixedModels[0]
+			req.Model = availableModels()[0]
 		}
 
 		streamChat(c, req)
@@ -2031,16 +1966,22 @@ func main() {
 	api.POST("/v1beta/models/*action", handleGeminiGenerate)
 	api.POST("/v1/models/*action", handleGeminiGenerate)
 	admin := r.Group("/admin")
-	admin.Use(apiKeyAuth())
-	admin.POST("/register", func(c *gin.Context) {
+	admin.Use(adminAuth(), auditMiddleware())
+	registerKeyRoutes(admin)
+	registerAccountRoutes(admin)
+	registerAuditRoutes(admin)
+	registerUsageRoutes(admin)
+	registerDashboardRoutes(r, admin)
+	registerEventRoutes(admin)
+	admin.POST("/register", requireRole(RoleOperator), func(c *gin.Context) {
 		var req struct {
 			Count int `json:"count"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil || req.Count <= 0 {
-			req.Count = appConfig.Pool.TargetCount - pool.Count()
+			req.Count = currentConfig().Pool.TargetCount - pool.CapacityCount()
 		}
 		if req.Count <= 0 {
-			c.JSON(200, gin.H{"message": "账号数量已足够", "count": pool.Count()})
+			c.JSON(200, gin.H{"message": "账号数量已足够", "count": pool.CapacityCount()})
 			return
 		}
 		if err := startRegister(req.Count); err != nil {
@@ -2049,8 +1990,8 @@ func main() {
 		}
 		c.JSON(200, gin.H{"message": "注册已启动", "target": req.Count})
 	})
-	admin.POST("/refresh", func(c *gin.Context) {
-		pool.Load(DataDir)
+	admin.POST("/refresh", requireRole(RoleOperator), func(c *gin.Context) {
+		pool.Load(accountStore)
 		c.JSON(200, gin.H{
 			"message": "刷新完成",
 			"ready":   pool.ReadyCount(),
@@ -2059,17 +2000,19 @@ func main() {
 	})
 
 	// 获取状态（增强版）
-	admin.GET("/status", func(c *gin.Context) {
+	admin.GET("/status", requireRole(RoleViewer), func(c *gin.Context) {
 		stats := pool.Stats()
-		stats["target"] = appConfig.Pool.TargetCount
-		stats["min"] = appConfig.Pool.MinCount
+		poolCfg := currentConfig().Pool
+		stats["target"] = poolCfg.TargetCount
+		stats["min"] = poolCfg.MinCount
 		stats["is_registering"] = atomic.LoadInt32(&isRegistering) == 1
 		stats["register_stats"] = registerStats.Get()
+		stats["queue"] = admission.Stats()
 		c.JSON(200, stats)
 	})
 
 	// 列出所有账号
-	admin.GET("/accounts", func(c *gin.Context) {
+	admin.GET("/accounts", requireRole(RoleViewer), func(c *gin.Context) {
 		accounts := pool.ListAccounts()
 		c.JSON(200, gin.H{
 			"count":    len(accounts),
@@ -2078,7 +2021,7 @@ func main() {
 	})
 
 	// 强制刷新所有账号
-	admin.POST("/force-refresh", func(c *gin.Context) {
+	admin.POST("/force-refresh", requireRole(RoleOperator), func(c *gin.Context) {
 		count := pool.ForceRefreshAll()
 		c.JSON(200, gin.H{
 			"message": "已触发强制刷新",
@@ -2087,7 +2030,7 @@ func main() {
 	})
 
 	// 更新冷却配置
-	admin.POST("/config/cooldown", func(c *gin.Context) {
+	admin.POST("/config/cooldown", requireRole(RoleOwner), func(c *gin.Context) {
 		var req struct {
 			RefreshCooldownSec int `json:"refresh_cooldown_sec"`
 			UseCooldownSec     int `json:"use_cooldown_sec"`
@@ -2096,16 +2039,38 @@ func main() {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-		SetCooldowns(req.RefreshCooldownSec, req.UseCooldownSec)
+		// 0 或负数表示不修改
+		values := map[string]interface{}{}
+		if req.RefreshCooldownSec > 0 {
+			values["pool.refresh_cooldown_sec"] = req.RefreshCooldownSec
+		}
+		if req.UseCooldownSec > 0 {
+			values["pool.use_cooldown_sec"] = req.UseCooldownSec
+		}
+		settings, err := runtimeSettings.Update(adminActor(c).Name, values)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(200, gin.H{
 			"message":              "冷却配置已更新",
-			"refresh_cooldown_sec": int(RefreshCooldown.Seconds()),
-			"use_cooldown_sec":     int(UseCooldown.Seconds()),
+			"refresh_cooldown_sec": int(settings.RefreshCooldown.Seconds()),
+			"use_cooldown_sec":     int(settings.UseCooldown.Seconds()),
 		})
 	})
 
+	// 重新加载配置文件（与 SIGHUP 相同）
+	admin.POST("/config/reload", requireRole(RoleOwner), func(c *gin.Context) {
+		res := reloadConfig("admin:" + adminActor(c).Name)
+		if res.Error != "" {
+			c.JSON(422, res)
+			return
+		}
+		c.JSON(200, res)
+	})
+
 	// 手动触发浏览器刷新指定账号
-	admin.POST("/browser-refresh", func(c *gin.Context) {
+	admin.POST("/browser-refresh", requireRole(RoleOperator), func(c *gin.Context) {
 		var req struct {
 			Email string `json:"email"`
 		}
@@ -2119,35 +2084,19 @@ func main() {
 			return
 		}
 
-		// 查找账号
-		accounts := pool.ListAccounts()
-		var targetAcc *Account
-		pool.mu.RLock()
-		for _, acc := range pool.readyAccounts {
-			if acc.Data.Email == req.Email {
-				targetAcc = acc
-				break
-			}
-		}
-		if targetAcc == nil {
-			for _, acc := range pool.pendingAccounts {
-				if acc.Data.Email == req.Email {
-					targetAcc = acc
-					break
-				}
-			}
-		}
-		pool.mu.RUnlock()
-
+		targetAcc := pool.Get(req.Email)
 		if targetAcc == nil {
 			c.JSON(404, gin.H{"error": "账号未找到", "email": req.Email})
 			return
 		}
 
-		// 执行浏览器刷新
-		go func() {
+		// 执行浏览器刷新；关闭时等待其保存 Cookie 后再关闭账号存储
+		app.Go("browser-refresh", func(ctx context.Context) {
+			if ctx.Err() != nil {
+				return
+			}
 			log.Printf(" 手动触发浏览器刷新: %s", req.Email)
-			result := RefreshCookieWithBrowser(targetAcc, BrowserRefreshHeadless, Proxy)
+			result := RefreshCookieWithBrowser(targetAcc, currentSettings().BrowserRefreshHeadless, Proxy)
 			if result.Success {
 				targetAcc.mu.Lock()
 				targetAcc.Data.Cookies = result.SecureCookies
@@ -2158,25 +2107,24 @@ func main() {
 				targetAcc.FailCount = 0
 				targetAcc.mu.Unlock()
 
-				if err := targetAcc.SaveToFile(); err != nil {
+				if err := targetAcc.Save(); err != nil {
 					log.Printf(" [%s] 保存刷新后的Cookie失败: %v", req.Email, err)
 				}
-				pool.MarkNeedsRefresh(targetAcc)
+				pool.MarkNeedsRefresh(targetAcc, "手动浏览器刷新成功")
 				log.Printf(" 手动浏览器刷新成功: %s", req.Email)
 			} else {
 				log.Printf(" 手动浏览器刷新失败: %s - %v", req.Email, result.Error)
 			}
-		}()
+		})
 
 		c.JSON(200, gin.H{
 			"message": "浏览器刷新已触发",
 			"email":   req.Email,
 		})
-		_ = accounts // 避免未使用警告
 	})
 
 	// 切换浏览器刷新开关
-	admin.POST("/config/browser-refresh", func(c *gin.Context) {
+	admin.POST("/config/browser-refresh", requireRole(RoleOwner), func(c *gin.Context) {
 		var req struct {
 			Enable   *bool `json:"enable"`
 			Headless *bool `json:"headless"`
@@ -2186,22 +2134,34 @@ func main() {
 			return
 		}
 
+		values := map[string]interface{}{}
 		if req.Enable != nil {
-			EnableBrowserRefresh = *req.Enable
+			values["pool.enable_browser_refresh"] = *req.Enable
 		}
 		if req.Headless != nil {
-			BrowserRefreshHeadless = *req.Headless
+			values["pool.browser_refresh_headless"] = *req.Headless
+		}
+		settings, err := runtimeSettings.Update(adminActor(c).Name, values)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
 
 		c.JSON(200, gin.H{
 			"message":  "浏览器刷新配置已更新",
-			"enable":   EnableBrowserRefresh,
-			"headless": BrowserRefreshHeadless,
+			"enable":   settings.EnableBrowserRefresh,
+			"headless": settings.BrowserRefreshHeadless,
 		})
 	})
 
-	log.Printf(" 服务启动于 %s，账号: ready=%d, pending=%d", ListenAddr, pool.ReadyCount(), pool.PendingCount())
-	if err := r.Run(ListenAddr); err != nil {
-		log.Fatalf(" 服务启动失败: %v", err)
-	}
+	// 运行时设置的修改记录
+	admin.GET("/config/history", requireRole(RoleViewer), func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		c.JSON(200, gin.H{
+			"overrides": runtimeSettings.Overrides(),
+			"history":   runtimeSettings.History(limit),
+		})
+	})
+
+	return r
 }
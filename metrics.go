@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ==================== Prometheus 指标 ====================
+
+// MetricsConfig /metrics 配置
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"` // 是否启用 /metrics
+	Listen  string `json:"listen"`  // 单独的监听地址（如 127.0.0.1:9090），留空则挂在主服务上
+	Token   string `json:"token"`   // 访问令牌（Authorization: Bearer），留空不校验
+}
+
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_requests_total",
+		Help: "API 请求数",
+	}, []string{"endpoint", "model", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "b2a_request_duration_seconds",
+		Help:    "API 请求耗时",
+		Buckets: latencyBuckets,
+	}, []string{"endpoint", "model", "status"})
+	requestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_request_retries_total",
+		Help: "切换账号重试次数",
+	}, []string{"endpoint", "model"})
+	tokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_tokens_total",
+		Help: "估算 Token 数",
+	}, []string{"type"})
+	mediaBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_media_bytes_total",
+		Help: "媒体传输字节数（in: 上传到上游，out: 返回给客户端）",
+	}, []string{"direction"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "b2a_upstream_duration_seconds",
+		Help:    "上游调用耗时（stream_assist 统计到收到响应头）",
+		Buckets: latencyBuckets,
+	}, []string{"operation", "outcome"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_upstream_errors_total",
+		Help: "上游调用错误数，按错误类型分类",
+	}, []string{"operation", "class"})
+
+	jwtRefreshTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_jwt_refresh_total",
+		Help: "JWT 刷新结果",
+	}, []string{"outcome"})
+	browserRefreshTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "b2a_browser_refresh_total",
+		Help: "浏览器刷新 Cookie 结果",
+	}, []string{"outcome"})
+	accountsInvalidated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "b2a_pool_accounts_invalidated_total",
+		Help: "因连续失败被移出号池的账号数",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal, requestDuration, requestRetries, tokensTotal, mediaBytesTotal,
+		upstreamDuration, upstreamErrors,
+		jwtRefreshTotal, browserRefreshTotal, accountsInvalidated,
+		gatewayCollector{},
+	)
+}
+
+// gatewayCollector 采集时读取号池和排队状态
+type gatewayCollector struct{}
+
+var (
+	poolAccountsDesc = prometheus.NewDesc("b2a_pool_accounts", "号池账号数", []string{"status"}, nil)
+	queueDepthDesc   = prometheus.NewDesc("b2a_queue_depth", "排队中的请求数", nil, nil)
+	queueWaitDesc    = prometheus.NewDesc("b2a_queue_wait_seconds_total", "排队请求累计等待时间", nil, nil)
+)
+
+func (gatewayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAccountsDesc
+	ch <- queueDepthDesc
+	ch <- queueWaitDesc
+}
+
+func (gatewayCollector) Collect(ch chan<- prometheus.Metric) {
+	for status, n := range pool.StatusCounts() {
+		ch <- prometheus.MustNewConstMetric(poolAccountsDesc, prometheus.GaugeValue, float64(n), status)
+	}
+	stats := admission.Stats()
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(stats["depth"].(int)))
+	ch <- prometheus.MustNewConstMetric(queueWaitDesc, prometheus.CounterValue, stats["wait_seconds_sum"].(float64))
+}
+
+// metricModel 只保留已知模型作为标签，避免任意模型名导致时间序列膨胀
+func metricModel(model string) string {
+	if model == "" {
+		return ""
+	}
+	for _, m := range availableModels() {
+		if m == model {
+			return model
+		}
+	}
+	return "other"
+}
+
+// observeRequest 由 usageMiddleware 在请求结束后调用
+func observeRequest(rec UsageRecord) {
+	model := metricModel(rec.Model)
+	status := strconv.Itoa(rec.Status)
+	requestsTotal.WithLabelValues(rec.Endpoint, model, status).Inc()
+	requestDuration.WithLabelValues(rec.Endpoint, model, status).Observe(float64(rec.LatencyMs) / 1000)
+	if rec.Retries > 0 {
+		requestRetries.WithLabelValues(rec.Endpoint, model).Add(float64(rec.Retries))
+	}
+	tokensTotal.WithLabelValues("prompt").Add(float64(rec.PromptTokens))
+	tokensTotal.WithLabelValues("completion").Add(float64(rec.CompletionTokens))
+	mediaBytesTotal.WithLabelValues("in").Add(float64(rec.MediaBytesIn))
+	mediaBytesTotal.WithLabelValues("out").Add(float64(rec.MediaBytesOut))
+}
+
+// observeJWTRefresh 记录号池刷新 JWT 的结果
+func observeJWTRefresh(err error) {
+	outcome := "success"
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "刷新冷却中"):
+			outcome = "cooldown"
+		case strings.Contains(msg, "账号失效") || strings.Contains(msg, "401") || strings.Contains(msg, "403"):
+			outcome = "auth_failed"
+		default:
+			outcome = "error"
+		}
+	}
+	jwtRefreshTotal.WithLabelValues(outcome).Inc()
+}
+
+// upstreamStatusRe 从上游错误信息中提取 HTTP 状态码（"xxx 失败: 401 ..." 或 "HTTP 500: ..."）
+var upstreamStatusRe = regexp.MustCompile(`(?:失败: |HTTP )(\d{3})\b`)
+
+// upstreamErrorClass 把上游错误归类为有限的几种，status 为 0 表示没有 HTTP 响应
+func upstreamErrorClass(err error, status int) string {
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return "timeout"
+		case errors.Is(err, context.Canceled):
+			return "canceled"
+		}
+		msg := err.Error()
+		if m := upstreamStatusRe.FindStringSubmatch(msg); m != nil {
+			status, _ = strconv.Atoi(m[1])
+		} else if strings.Contains(msg, "账号失效") {
+			return "auth"
+		} else if strings.Contains(msg, "请求失败") {
+			return "network"
+		} else {
+			return "other"
+		}
+	}
+	switch {
+	case status == 401 || status == 403:
+		return "auth"
+	case status == 429:
+		return "rate_limited"
+	case status >= 500:
+		return "server_error"
+	case status >= 400:
+		return "client_error"
+	case err != nil:
+		return "other"
+	}
+	return ""
+}
+
+// upstreamCall 一次上游调用：统计耗时/错误并记录 trace span
+type upstreamCall struct {
+	ctx       context.Context
+	operation string
+	start     time.Time
+	span      trace.Span
+}
+
+func beginUpstream(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, *upstreamCall) {
+	ctx, span := startSpan(ctx, "upstream."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, &upstreamCall{ctx: ctx, operation: operation, start: time.Now(), span: span}
+}
+
+// observe 记录指标和日志，返回错误类型（成功为空）
+func (u *upstreamCall) observe(err error, status int) string {
+	elapsed := time.Since(u.start)
+	outcome := "ok"
+	lg := logFor(u.ctx, "upstream")
+	class := upstreamErrorClass(err, status)
+	if class != "" {
+		outcome = "error"
+		upstreamErrors.WithLabelValues(u.operation, class).Inc()
+		args := []any{"operation", u.operation, "class", class, "status", status, "latency_ms", elapsed.Milliseconds()}
+		entry := UpstreamErrorEntry{Time: time.Now(), RequestID: requestIDFrom(u.ctx), Operation: u.operation, Class: class, Status: status}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if class != "canceled" {
+			recentUpstreamErrors.Add(entry)
+		}
+		if err != nil {
+			args = append(args, "error", err)
+			u.span.RecordError(err)
+		}
+		lg.Warn("上游调用失败", args...)
+		u.span.SetStatus(codes.Error, class)
+	} else {
+		lg.Debug("上游调用", "operation", u.operation, "latency_ms", elapsed.Milliseconds())
+	}
+	if status > 0 {
+		u.span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+	upstreamDuration.WithLabelValues(u.operation, outcome).Observe(elapsed.Seconds())
+	if class != "canceled" {
+		upstreamWindow.Record(time.Now(), class != "")
+	}
+	return class
+}
+
+func (u *upstreamCall) end(err error, attrs ...attribute.KeyValue) {
+	u.observe(err, 0)
+	u.span.SetAttributes(attrs...)
+	u.span.End()
+}
+
+// instrumentedUpstream 为上游调用统计耗时、错误并记录 trace span
+type instrumentedUpstream struct {
+	Upstream
+}
+
+func instrumentUpstream(u Upstream) Upstream {
+	return instrumentedUpstream{Upstream: u}
+}
+
+func (u instrumentedUpstream) GetOXSRF(ctx context.Context, csesidx, cookie string) (string, string, error) {
+	ctx, call := beginUpstream(ctx, "getoxsrf")
+	xsrf, keyID, err := u.Upstream.GetOXSRF(ctx, csesidx, cookie)
+	call.end(err)
+	return xsrf, keyID, err
+}
+
+func (u instrumentedUpstream) CreateSession(ctx context.Context, jwt, configID, origAuth string) (string, error) {
+	ctx, call := beginUpstream(ctx, "create_session")
+	session, err := u.Upstream.CreateSession(ctx, jwt, configID, origAuth)
+	call.end(err)
+	return session, err
+}
+
+func (u instrumentedUpstream) UploadContextFile(ctx context.Context, jwt, configID, sessionName, mimeType, base64Content, origAuth string) (string, error) {
+	ctx, call := beginUpstream(ctx, "upload",
+		attribute.String("media.mime_type", mimeType), attribute.Int64("media.bytes", base64Size(base64Content)))
+	fileID, err := u.Upstream.UploadContextFile(ctx, jwt, configID, sessionName, mimeType, base64Content, origAuth)
+	call.end(err)
+	return fileID, err
+}
+
+func (u instrumentedUpstream) UploadContextFileByURL(ctx context.Context, jwt, configID, sessionName, fileURL, origAuth string) (string, error) {
+	ctx, call := beginUpstream(ctx, "upload", attribute.Bool("media.by_url", true))
+	fileID, err := u.Upstream.UploadContextFileByURL(ctx, jwt, configID, sessionName, fileURL, origAuth)
+	call.end(err)
+	return fileID, err
+}
+
+// StreamAssist 指标统计到收到响应头；span 持续到响应体读取完毕并关闭
+func (u instrumentedUpstream) StreamAssist(ctx context.Context, jwt, origAuth string, body []byte) (*http.Response, error) {
+	ctx, call := beginUpstream(ctx, "stream_assist", attribute.Int("http.request.body.size", len(body)))
+	resp, err := u.Upstream.StreamAssist(ctx, jwt, origAuth, body)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	call.observe(err, status)
+	if resp == nil {
+		call.span.End()
+		return resp, err
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: call.span}
+	return resp, err
+}
+
+func (u instrumentedUpstream) DownloadGeneratedFile(ctx context.Context, jwt, fileId, session, configID, origAuth string) (string, error) {
+	ctx, call := beginUpstream(ctx, "download")
+	data, err := u.Upstream.DownloadGeneratedFile(ctx, jwt, fileId, session, configID, origAuth)
+	call.end(err, attribute.Int64("media.bytes", base64Size(data)))
+	return data, err
+}
+
+// metricsHandler 返回 /metrics 处理函数；配置了 token 时校验 Bearer
+func metricsHandler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token := currentConfig().Metrics.Token; token != "" {
+			got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				c.JSON(401, gin.H{"error": "无效的 metrics 令牌"})
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// startMetricsServer 配置了单独监听地址时在独立端口提供 /metrics
+func startMetricsServer() {
+	cfg := currentConfig().Metrics
+	if !cfg.Enabled || cfg.Listen == "" {
+		return
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/metrics", metricsHandler())
+	srv := &http.Server{Addr: cfg.Listen, Handler: r}
+	go func() {
+		log.Printf("📈 指标服务启动于 %s/metrics", cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("❌ 指标服务启动失败: %v", err)
+		}
+	}()
+	// 排空主服务期间仍可采集指标，后台任务停止时关闭
+	app.Go("metrics-server", func(ctx context.Context) {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics 抓取 /metrics，返回 "名称{标签}" -> 值
+func scrapeMetrics(t *testing.T, g *testGateway, headers ...string) map[string]float64 {
+	t.Helper()
+	w := g.get(t, "/metrics", headers...)
+	if w.Code != 200 {
+		t.Fatalf("/metrics 返回 %d", w.Code)
+	}
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndexByte(line, ' '); i > 0 {
+			v, _ := strconv.ParseFloat(line[i+1:], 64)
+			values[line[:i]] = v
+		}
+	}
+	return values
+}
+
+func TestMetricsExposition(t *testing.T) {
+	g := newTestGateway(t, 2)
+	before := scrapeMetrics(t, g)
+
+	g.fake.Script(epStreamAssist, fakeResponse{Status: 401, Body: []byte(`{"error":{"status":"UNAUTHENTICATED"}}`)})
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+	g.post(t, "/v1/chat/completions", chatBody("no-such-model", false, "hi"))
+
+	after := scrapeMetrics(t, g)
+	delta := func(series string) float64 { return after[series] - before[series] }
+
+	checks := map[string]float64{
+		`b2a_requests_total{endpoint="openai",model="gemini-2.5-flash",status="200"}`:                 1,
+		`b2a_request_duration_seconds_count{endpoint="openai",model="gemini-2.5-flash",status="200"}`: 1,
+		`b2a_request_retries_total{endpoint="openai",model="gemini-2.5-flash"}`:                       1,
+		`b2a_upstream_errors_total{class="auth",operation="stream_assist"}`:                           1,
+		`b2a_upstream_duration_seconds_count{operation="create_session",outcome="ok"}`:                3,
+	}
+	for series, want := range checks {
+		if got := delta(series); got != want {
+			t.Errorf("%s 增加了 %v，期望 %v", series, got, want)
+		}
+	}
+	// 未知模型归入 other，避免标签膨胀
+	for series := range after {
+		if strings.Contains(series, "no-such-model") {
+			t.Errorf("未知模型出现在标签中: %s", series)
+		}
+	}
+	if delta(`b2a_tokens_total{type="prompt"}`) <= 0 {
+		t.Error("未统计 Token")
+	}
+	if after[`b2a_pool_accounts{status="ready"}`] != 1 || after[`b2a_pool_accounts{status="pending"}`] != 1 {
+		t.Errorf("号池指标: ready=%v pending=%v", after[`b2a_pool_accounts{status="ready"}`], after[`b2a_pool_accounts{status="pending"}`])
+	}
+	if _, ok := after["b2a_queue_depth"]; !ok {
+		t.Error("缺少 b2a_queue_depth")
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	useConfig(t, func(cfg *AppConfig) { cfg.Metrics = MetricsConfig{Enabled: true, Token: "scrape-secret"} })
+	g := newTestGateway(t, 1)
+
+	if w := g.get(t, "/metrics"); w.Code != 401 {
+		t.Fatalf("未带令牌返回 %d", w.Code)
+	}
+	scrapeMetrics(t, g, bearer("scrape-secret")...)
+
+	// 单独监听时主服务不暴露 /metrics
+	useConfig(t, func(cfg *AppConfig) { cfg.Metrics.Listen = "127.0.0.1:0" })
+	g = newTestGateway(t, 1)
+	if w := g.get(t, "/metrics", bearer("scrape-secret")...); w.Code != 404 {
+		t.Fatalf("单独监听时主服务返回 %d", w.Code)
+	}
+}
@@ -1,15 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -29,13 +28,15 @@ type Cookie struct {
 type AccountData struct {
 	Email           string            `json:"email"`
 	FullName        string            `json:"fullName"`
-	Authorization   string            `json:"authorization"`
-	Cookies         []Cookie          `json:"cookies"`
+	Authorization   string            `json:"authorization,omitempty"`
+	Cookies         []Cookie          `json:"cookies,omitempty"`
 	CookieString    string            `json:"cookie_string,omitempty"`    // 兼容老版本：完整cookie字符串
 	ResponseHeaders map[string]string `json:"response_headers,omitempty"` // 捕获的响应头
 	Timestamp       string            `json:"timestamp"`
 	ConfigID        string            `json:"configId,omitempty"`
 	CSESIDX         string            `json:"csesidx,omitempty"`
+	Disabled        bool              `json:"disabled,omitempty"` // 管理员禁用，不参与刷新和分配
+	Sealed          *SealedSecrets    `json:"sealed,omitempty"`   // 加密后的敏感字段（启用加密时）
 }
 
 // ParseCookieString 解析cookie字符串为Cookie数组（兼容老版本）
@@ -74,54 +75,39 @@ func (a *AccountData) GetAllCookies() []Cookie {
 	return nil
 }
 
-// AccountStatus 账号状态
-type AccountStatus int
-
-const (
-	StatusPending  AccountStatus = iota // 待刷新
-	StatusReady                         // 就绪可用
-	StatusCooldown                      // 冷却中
-	StatusInvalid                       // 失效
-)
-
 // Account 账号实例
 type Account struct {
 	Data                AccountData
-	FilePath            string
+	ID                  string // 账号存储中的 ID（文件存储时为文件名）
 	JWT                 string
 	JWTExpires          time.Time
 	ConfigID            string
 	CSESIDX             string
 	LastRefresh         time.Time
-	LastUsed            time.Time // 最后使用时间
-	Refreshed           bool
-	FailCount           int // 连续失败次数
-	BrowserRefreshCount int // 浏览器刷新尝试次数
-	SuccessCount        int // 成功次数
-	TotalCount          int // 总使用次数
-	Status              AccountStatus
+	LastUsed            time.Time           // 最后使用时间
+	CoolUntil           time.Time           // 限流冷却结束时间（cooling 状态）
+	FailCount           int                 // 连续失败次数
+	BrowserRefreshCount int                 // 浏览器刷新尝试次数
+	SuccessCount        int                 // 成功次数
+	TotalCount          int                 // 总使用次数
+	Status              AccountStatus       // 只通过 transitionLocked 修改
+	history             []AccountTransition // 最近的状态转换
+	removed             bool                // 已从号池删除，刷新完成后不再放回队列
 	mu                  sync.Mutex
 }
 
-// 默认冷却时间（可通过配置覆盖）
-var (
-	RefreshCooldown        = 4 * time.Minute  // 刷新冷却
-	UseCooldown            = 15 * time.Second // 使用冷却
-	JWTRefreshThreshold    = 60 * time.Second // JWT刷新阈值
-	MaxFailCount           = 3                // 最大连续失败次数
-	EnableBrowserRefresh   = true             // 是否启用浏览器刷新
-	BrowserRefreshHeadless = true             // 浏览器刷新是否无头模式
-	BrowserRefreshMaxRetry = 1                // 浏览器刷新最大重试次数
-)
+// JWTRefreshThreshold JWT 过期前多久开始刷新；冷却等可调参数见 currentSettings()
+var JWTRefreshThreshold = 60 * time.Second
 
 type AccountPool struct {
-	readyAccounts   []*Account
-	pendingAccounts []*Account
-	index           uint64
-	mu              sync.RWMutex
-	refreshInterval time.Duration
-	refreshWorkers  int
-	stopChan        chan struct{}
+	readyAccounts    []*Account
+	pendingAccounts  []*Account
+	disabledAccounts []*Account
+	byID             map[string]*Account // 包括正在刷新、不在任何队列中的账号
+	index            uint64
+	mu               sync.RWMutex
+	refreshInterval  time.Duration
+	refreshWorkers   int
 	// 统计
 	totalSuccess  int64
 	totalFailed   int64
@@ -131,69 +117,80 @@ type AccountPool struct {
 var pool = &AccountPool{
 	refreshInterval: 5 * time.Second,
 	refreshWorkers:  5,
-	stopChan:        make(chan struct{}),
 }
 
-// SetCooldowns 设置冷却时间（从配置加载）
-func SetCooldowns(refreshSec, useSec int) {
-	if refreshSec > 0 {
-		RefreshCooldown = time.Duration(refreshSec) * time.Second
-	}
-	if useSec > 0 {
-		UseCooldown = time.Duration(useSec) * time.Second
+// Load 从账号存储重新加载号池：保留已有账号的运行状态，加入新账号，移除已删除的账号
+func (p *AccountPool) Load(store AccountStore) error {
+	stored, err := store.List(context.Background())
+	if err != nil {
+		return err
 	}
-	log.Printf("⚙️ 冷却配置: 刷新=%v, 使用=%v", RefreshCooldown, UseCooldown)
-}
 
-func (p *AccountPool) Load(dir string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
-	if err != nil {
-		return err
-	}
-
-	existingAccounts := make(map[string]*Account)
-	for _, acc := range p.readyAccounts {
-		existingAccounts[acc.FilePath] = acc
+	// 正在刷新的账号不在任何队列中，刷新完成后由 worker 放回
+	queued := make(map[*Account]bool)
+	for _, list := range [][]*Account{p.readyAccounts, p.pendingAccounts, p.disabledAccounts} {
+		for _, acc := range list {
+			queued[acc] = true
+		}
 	}
-	for _, acc := range p.pendingAccounts {
-		existingAccounts[acc.FilePath] = acc
+	existingAccounts := make(map[string]*Account, len(p.byID))
+	for id, acc := range p.byID {
+		existingAccounts[id] = acc
 	}
 
 	var newReadyAccounts []*Account
 	var newPendingAccounts []*Account
+	var newDisabledAccounts []*Account
+	byID := make(map[string]*Account, len(stored))
 
-	for _, f := range files {
-		if acc, ok := existingAccounts[f]; ok {
-			if acc.Refreshed {
+	for _, item := range stored {
+		if acc, ok := existingAccounts[item.ID]; ok {
+			// 其他实例更新了凭据（如浏览器刷新后的 Cookie）或禁用状态
+			acc.mu.Lock()
+			wasDisabled := acc.Data.Disabled
+			if item.Data.Timestamp != "" && item.Data.Timestamp != acc.Data.Timestamp {
+				acc.Data = item.Data
+				if item.Data.CSESIDX != "" {
+					acc.CSESIDX = item.Data.CSESIDX
+				}
+				if item.Data.ConfigID != "" {
+					acc.ConfigID = item.Data.ConfigID
+				}
+			}
+			disabled := acc.Data.Disabled
+			switch {
+			case disabled:
+				acc.moveLocked(StatusDisabled, "存储中已标记禁用")
+			case wasDisabled:
+				acc.moveLocked(StatusPending, "存储中已取消禁用")
+			}
+			usable := acc.Status.usable()
+			acc.mu.Unlock()
+
+			byID[item.ID] = acc
+			delete(existingAccounts, item.ID)
+			switch {
+			case !queued[acc] && !disabled && !wasDisabled:
+			case disabled:
+				newDisabledAccounts = append(newDisabledAccounts, acc)
+			case usable:
 				newReadyAccounts = append(newReadyAccounts, acc)
-			} else {
+			default:
 				newPendingAccounts = append(newPendingAccounts, acc)
 			}
-			delete(existingAccounts, f)
-			continue
-		}
-
-		data, err := os.ReadFile(f)
-		if err != nil {
-			log.Printf("⚠️ 读取 %s 失败: %v", f, err)
-			continue
-		}
-
-		var acc AccountData
-		if err := json.Unmarshal(data, &acc); err != nil {
-			log.Printf("⚠️ 解析 %s 失败: %v", f, err)
 			continue
 		}
 
+		acc := item.Data
 		csesidx := acc.CSESIDX
 		if csesidx == "" {
 			csesidx = extractCSESIDX(acc.Authorization)
 		}
 		if csesidx == "" {
-			log.Printf("⚠️ %s 无法获取 csesidx", f)
+			log.Printf("⚠️ %s 无法获取 csesidx", item.ID)
 			continue
 		}
 
@@ -202,132 +199,335 @@ func (p *AccountPool) Load(dir string) error {
 			configID = DefaultConfig
 		}
 
-		newPendingAccounts = append(newPendingAccounts, &Account{
-			Data:      acc,
-			FilePath:  f,
-			CSESIDX:   csesidx,
-			ConfigID:  configID,
-			Refreshed: false,
-		})
+		account := &Account{
+			Data:     acc,
+			ID:       item.ID,
+			CSESIDX:  csesidx,
+			ConfigID: configID,
+			Status:   StatusPending,
+		}
+		accountStates.Restore(account)
+		byID[item.ID] = account
+		if acc.Disabled {
+			account.Transition(StatusDisabled, "存储中已标记禁用")
+			newDisabledAccounts = append(newDisabledAccounts, account)
+		} else {
+			newPendingAccounts = append(newPendingAccounts, account)
+		}
+	}
+
+	// 存储中已删除的账号：正在刷新的也不再放回
+	for _, acc := range existingAccounts {
+		acc.mu.Lock()
+		acc.removed = true
+		acc.mu.Unlock()
 	}
 
 	p.readyAccounts = newReadyAccounts
 	p.pendingAccounts = newPendingAccounts
+	p.disabledAccounts = newDisabledAccounts
+	p.byID = byID
 	return nil
 }
 
-// GetPendingAccount 获取待刷新账号
+// GetPendingAccount 取出待刷新账号并转为刷新中
 func (p *AccountPool) GetPendingAccount() *Account {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if len(p.pendingAccounts) == 0 {
-		return nil
+	for len(p.pendingAccounts) > 0 {
+		acc := p.pendingAccounts[0]
+		p.pendingAccounts = p.pendingAccounts[1:]
+		acc.mu.Lock()
+		ok := acc.moveLocked(StatusRefreshing, "开始刷新")
+		acc.mu.Unlock()
+		if ok {
+			return acc
+		}
+	}
+	return nil
+}
+
+// Get 按 ID 查找账号，找不到时按邮箱查找
+func (p *AccountPool) Get(key string) *Account {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if acc, ok := p.byID[key]; ok {
+		return acc
+	}
+	if acc, ok := p.byID[accountIDForEmail(key)]; ok {
+		return acc
+	}
+	for _, acc := range p.byID {
+		if strings.EqualFold(acc.Data.Email, key) {
+			return acc
+		}
 	}
+	return nil
+}
 
-	acc := p.pendingAccounts[0]
-	p.pendingAccounts = p.pendingAccounts[1:]
-	return acc
+func containsAccount(list []*Account, acc *Account) bool {
+	for _, a := range list {
+		if a == acc {
+			return true
+		}
+	}
+	return false
 }
 
-// MarkReady 标记账号为就绪
+func withoutAccount(list []*Account, acc *Account) []*Account {
+	for i, a := range list {
+		if a == acc {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// parkLocked 将账号转为 to 状态，并判断能否放回刷新/就绪队列：已删除的丢弃，
+// 已禁用的放入禁用列表，不允许的转换和已在队列中的不加入。返回 true 表示不应再加入队列
+func (p *AccountPool) parkLocked(acc *Account, to AccountStatus, reason string) bool {
+	acc.mu.Lock()
+	removed, disabled := acc.removed, acc.Data.Disabled
+	ok := true
+	if !removed {
+		if disabled {
+			to, reason = StatusDisabled, "已禁用"
+		}
+		ok = acc.moveLocked(to, reason)
+	}
+	acc.mu.Unlock()
+	if removed || !ok {
+		return true
+	}
+	if p.byID == nil {
+		p.byID = make(map[string]*Account)
+	}
+	p.byID[acc.ID] = acc
+	if disabled {
+		if !containsAccount(p.disabledAccounts, acc) {
+			p.disabledAccounts = append(p.disabledAccounts, acc)
+		}
+		return true
+	}
+	return containsAccount(p.readyAccounts, acc) || containsAccount(p.pendingAccounts, acc)
+}
+
+// MarkReady 标记账号刷新成功、可以使用
 func (p *AccountPool) MarkReady(acc *Account) {
+	p.markReady(acc, "刷新成功")
+}
+
+func (p *AccountPool) markReady(acc *Account, reason string) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	acc.Refreshed = true
+	p.pendingAccounts = withoutAccount(p.pendingAccounts, acc)
+	if p.parkLocked(acc, StatusReady, reason) {
+		p.mu.Unlock()
+		return
+	}
 	p.readyAccounts = append(p.readyAccounts, acc)
+	notifyAccountReady()
+	p.mu.Unlock()
+	p.checkPoolLevel()
 }
 
-// MarkPending 标记账号待刷新
-func (p *AccountPool) MarkPending(acc *Account) {
+// requeue 将刷新失败的账号放回刷新队列
+func (p *AccountPool) requeue(acc *Account, reason string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-
-	for i, a := range p.readyAccounts {
-		if a == acc {
-			p.readyAccounts = append(p.readyAccounts[:i], p.readyAccounts[i+1:]...)
-			break
-		}
+	if !p.parkLocked(acc, StatusPending, reason) {
+		p.pendingAccounts = append(p.pendingAccounts, acc)
 	}
+}
+
+// MarkPending 标记账号待刷新；正在刷新的账号不重复加入
+func (p *AccountPool) MarkPending(acc *Account, reason string) {
+	p.mu.Lock()
+	defer p.checkPoolLevel()
+	defer p.mu.Unlock()
 
 	acc.mu.Lock()
-	acc.Refreshed = false
+	refreshing := acc.Status == StatusRefreshing
 	acc.mu.Unlock()
+	if refreshing {
+		return
+	}
+
+	p.readyAccounts = withoutAccount(p.readyAccounts, acc)
+	if p.parkLocked(acc, StatusPending, reason) {
+		return
+	}
 
 	p.pendingAccounts = append(p.pendingAccounts, acc)
-	log.Printf("🔄 账号 %s 移至刷新池", filepath.Base(acc.FilePath))
+	log.Printf("🔄 账号 %s 移至刷新池", acc.ID)
+}
+
+// detach 将账号移出号池，正在刷新的账号完成后也不会放回
+func (p *AccountPool) detach(acc *Account) {
+	acc.mu.Lock()
+	acc.removed = true
+	acc.mu.Unlock()
+	p.mu.Lock()
+	p.readyAccounts = withoutAccount(p.readyAccounts, acc)
+	p.pendingAccounts = withoutAccount(p.pendingAccounts, acc)
+	p.disabledAccounts = withoutAccount(p.disabledAccounts, acc)
+	if p.byID[acc.ID] == acc {
+		delete(p.byID, acc.ID)
+	}
+	p.mu.Unlock()
 }
 
 // RemoveAccount 删除失效账号
 func (p *AccountPool) RemoveAccount(acc *Account) {
-	if err := os.Remove(acc.FilePath); err != nil {
-		log.Printf("⚠️ 删除文件失败 %s: %v", acc.FilePath, err)
+	p.detach(acc)
+	if err := accountStore.Delete(context.Background(), acc.ID); err != nil {
+		log.Printf("⚠️ 删除账号失败 %s: %v", acc.ID, err)
 	} else {
-		log.Printf("🗑️ 已删除失效账号: %s", filepath.Base(acc.FilePath))
+		log.Printf("🗑️ 已删除失效账号: %s", acc.ID)
+	}
+	accountStates.Delete(acc)
+	acc.mu.Lock()
+	data := accountEventData(acc)
+	data["fail_count"] = acc.FailCount
+	acc.mu.Unlock()
+	publishEvent(EventAccountRemoved, SeverityWarning, accountHash(acc), "账号连续刷新失败，已移除", data)
+	p.checkPoolLevel()
+}
+
+// DeleteAccount 管理员删除账号
+func (p *AccountPool) DeleteAccount(acc *Account, actor string) error {
+	if err := accountStore.Delete(context.Background(), acc.ID); err != nil && !errors.Is(err, errAccountNotFound) {
+		return err
 	}
+	p.detach(acc)
+	accountStates.Delete(acc)
+	log.Printf("🗑️ %s 删除账号: %s", actor, acc.ID)
+	data := accountEventData(acc)
+	data["actor"] = actor
+	publishEvent(EventAccountRemoved, SeverityInfo, accountHash(acc), "账号已被管理员删除", data)
+	p.checkPoolLevel()
+	return nil
 }
 
-// SaveToFile 保存账号到文件
-func (acc *Account) SaveToFile() error {
+// SetDisabled 禁用或启用账号并写回存储。禁用的账号保留凭据和统计，但不参与刷新和分配。
+// 先写存储，写入失败时内存状态不变、不发布事件
+func (p *AccountPool) SetDisabled(acc *Account, disabled bool, actor string) error {
 	acc.mu.Lock()
-	defer acc.mu.Unlock()
+	unchanged := acc.removed || acc.Data.Disabled == disabled
+	acc.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	if err := acc.saveWith(func(d *AccountData) { d.Disabled = disabled }); err != nil {
+		return err
+	}
 
-	acc.Data.Timestamp = time.Now().Format(time.RFC3339)
+	p.mu.Lock()
+	acc.mu.Lock()
+	if acc.removed || acc.Data.Disabled == disabled {
+		acc.mu.Unlock()
+		p.mu.Unlock()
+		return nil
+	}
+	acc.Data.Disabled = disabled
+	if disabled {
+		acc.moveLocked(StatusDisabled, actor+" 禁用")
+	} else {
+		// 重新启用时清除失败计数，重新刷新后再投入使用
+		acc.moveLocked(StatusPending, actor+" 启用")
+		acc.FailCount = 0
+		acc.BrowserRefreshCount = 0
+	}
+	acc.mu.Unlock()
+	if disabled {
+		p.readyAccounts = withoutAccount(p.readyAccounts, acc)
+		p.pendingAccounts = withoutAccount(p.pendingAccounts, acc)
+		p.disabledAccounts = append(p.disabledAccounts, acc)
+	} else {
+		p.disabledAccounts = withoutAccount(p.disabledAccounts, acc)
+		p.pendingAccounts = append(p.pendingAccounts, acc)
+	}
+	p.mu.Unlock()
+	p.checkPoolLevel()
+
+	data := accountEventData(acc)
+	data["actor"] = actor
+	if disabled {
+		log.Printf("⏸️ %s 禁用账号: %s", actor, acc.ID)
+		publishEvent(EventAccountDisabled, SeverityInfo, accountHash(acc), "账号已被禁用", data)
+	} else {
+		log.Printf("▶️ %s 启用账号: %s", actor, acc.ID)
+		publishEvent(EventAccountEnabled, SeverityInfo, accountHash(acc), "账号已重新启用", data)
+	}
+	return nil
+}
 
-	// 同时生成 cookie 字符串（方便调试和兼容老版本）
-	if len(acc.Data.Cookies) > 0 {
+// stamp 更新保存时间并生成 cookie 字符串（方便调试和兼容老版本）
+func (d *AccountData) stamp() {
+	d.Timestamp = time.Now().Format(time.RFC3339)
+	if len(d.Cookies) > 0 {
 		var cookieParts []string
-		for _, c := range acc.Data.Cookies {
+		for _, c := range d.Cookies {
 			cookieParts = append(cookieParts, fmt.Sprintf("%s=%s", c.Name, c.Value))
 		}
-		acc.Data.CookieString = strings.Join(cookieParts, "; ")
+		d.CookieString = strings.Join(cookieParts, "; ")
 	}
+}
 
-	data, err := json.MarshalIndent(acc.Data, "", "  ")
-	if err != nil {
-		return fmt.Errorf("序列化账号数据失败: %w", err)
-	}
+// Save 将账号凭据写回账号存储
+func (acc *Account) Save() error {
+	acc.mu.Lock()
+	acc.Data.stamp()
+	data := acc.Data
+	acc.mu.Unlock()
 
-	if err := os.WriteFile(acc.FilePath, data, 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %w", err)
-	}
-	return nil
+	return accountStore.Put(context.Background(), acc.ID, data)
+}
+
+// saveWith 将 mutate 应用到凭据副本后写回存储，不修改内存中的账号；
+// 调用方在写入成功后再应用同样的修改，避免存储失败时内存与存储不一致
+func (acc *Account) saveWith(mutate func(d *AccountData)) error {
+	acc.mu.Lock()
+	data := acc.Data
+	acc.mu.Unlock()
+	mutate(&data)
+	data.stamp()
+	return accountStore.Put(context.Background(), acc.ID, data)
 }
 
-// StartPoolManager 启动号池管理器
+// StartPoolManager 启动号池管理器，随进程关闭退出
 func (p *AccountPool) StartPoolManager() {
 	for i := 0; i < p.refreshWorkers; i++ {
-		go p.refreshWorker(i)
+		id := i
+		app.Go(fmt.Sprintf("refresh-worker-%d", id), func(ctx context.Context) { p.refreshWorker(ctx, id) })
 	}
-	go p.scanWorker()
+	app.Go("scan-worker", p.scanWorker)
 }
 
-func (p *AccountPool) refreshWorker(id int) {
-	for {
-		select {
-		case <-p.stopChan:
-			return
-		default:
-		}
-
+func (p *AccountPool) refreshWorker(ctx context.Context, id int) {
+	for ctx.Err() == nil {
 		acc := p.GetPendingAccount()
 		if acc == nil {
-			time.Sleep(time.Second)
+			sleepCtx(ctx, time.Second)
 			continue
 		}
+		settings := currentSettings()
 
-		// 检查冷却
-		if time.Since(acc.LastRefresh) < RefreshCooldown {
-			acc.mu.Lock()
-			acc.Refreshed = true
-			acc.Status = StatusReady
-			acc.mu.Unlock()
-			p.MarkReady(acc)
+		// 检查冷却（没有 JWT 时必须刷新）；需要刷新时清除过期时间，强制重新获取
+		acc.mu.Lock()
+		inCooldown := acc.JWT != "" && time.Since(acc.LastRefresh) < settings.RefreshCooldown
+		if !inCooldown {
+			acc.JWTExpires = time.Time{}
+		}
+		acc.mu.Unlock()
+		if inCooldown {
+			p.markReady(acc, "刷新冷却期内，沿用现有 JWT")
 			continue
 		}
 
-		acc.JWTExpires = time.Time{}
-		if err := acc.RefreshJWT(); err != nil {
+		err := acc.RefreshJWT()
+		observeJWTRefresh(err)
+		if err != nil {
 			errMsg := err.Error()
 
 			// 认证失败：尝试浏览器刷新（不删除账号）
@@ -342,13 +542,14 @@ func (p *AccountPool) refreshWorker(id int) {
 				acc.mu.Unlock()
 
 				// 尝试浏览器刷新（有次数限制）
-				if EnableBrowserRefresh && BrowserRefreshMaxRetry > 0 && browserRefreshCount < BrowserRefreshMaxRetry {
+				if settings.EnableBrowserRefresh && settings.BrowserRefreshMaxRetry > 0 && browserRefreshCount < settings.BrowserRefreshMaxRetry {
 					acc.mu.Lock()
 					acc.BrowserRefreshCount++
 					acc.mu.Unlock()
-					refreshResult := RefreshCookieWithBrowser(acc, BrowserRefreshHeadless, Proxy)
+					refreshResult := RefreshCookieWithBrowser(acc, settings.BrowserRefreshHeadless, Proxy)
 
 					if refreshResult.Success {
+						browserRefreshTotal.WithLabelValues("success").Inc()
 						acc.mu.Lock()
 						acc.Data.Cookies = refreshResult.SecureCookies
 						if refreshResult.Authorization != "" {
@@ -368,22 +569,20 @@ func (p *AccountPool) refreshWorker(id int) {
 						acc.FailCount = 0
 						acc.BrowserRefreshCount = 0  // 成功后重置计数
 						acc.JWTExpires = time.Time{} // 重置JWT过期时间
-						acc.Status = StatusPending
 						acc.mu.Unlock()
 
 						// 保存更新后的账号
-						if err := acc.SaveToFile(); err != nil {
+						if err := acc.Save(); err != nil {
 							log.Printf("⚠️ [%s] 保存刷新后的账号失败: %v", acc.Data.Email, err)
 						}
-						p.mu.Lock()
-						p.pendingAccounts = append(p.pendingAccounts, acc)
-						p.mu.Unlock()
+						p.requeue(acc, "浏览器刷新 Cookie 成功，重新获取 JWT")
 						continue
 					} else {
+						browserRefreshTotal.WithLabelValues("failed").Inc()
 						log.Printf("⚠️ [worker-%d] [%s] 浏览器刷新失败: %v", id, acc.Data.Email, refreshResult.Error)
 					}
-				} else if browserRefreshCount >= BrowserRefreshMaxRetry && BrowserRefreshMaxRetry > 0 {
-					log.Printf("⚠️ [worker-%d] [%s] 已达浏览器刷新上限 (%d次)，跳过浏览器刷新", id, acc.Data.Email, BrowserRefreshMaxRetry)
+				} else if browserRefreshCount >= settings.BrowserRefreshMaxRetry && settings.BrowserRefreshMaxRetry > 0 {
+					log.Printf("⚠️ [worker-%d] [%s] 已达浏览器刷新上限 (%d次)，跳过浏览器刷新", id, acc.Data.Email, settings.BrowserRefreshMaxRetry)
 				}
 				acc.mu.Lock()
 				acc.FailCount++
@@ -395,21 +594,15 @@ func (p *AccountPool) refreshWorker(id int) {
 					waitTime = 5 * time.Minute // 最大等待5分钟
 				}
 				log.Printf("⏳ [worker-%d] [%s] 401刷新失败 (%d次)，%v后重试", id, acc.Data.Email, failCount, waitTime)
-				time.Sleep(waitTime)
+				sleepCtx(ctx, waitTime)
 
-				p.mu.Lock()
-				p.pendingAccounts = append(p.pendingAccounts, acc)
-				p.mu.Unlock()
+				p.requeue(acc, fmt.Sprintf("认证失效 (%d次): %v", failCount, err))
 				continue
 			}
 
-			// 冷却中：直接标记就绪
+			// 冷却中：已有 JWT 时直接标记就绪（RefreshJWT 只在有 JWT 时返回此错误）
 			if strings.Contains(errMsg, "刷新冷却中") {
-				acc.mu.Lock()
-				acc.Refreshed = true
-				acc.Status = StatusReady
-				acc.mu.Unlock()
-				p.MarkReady(acc)
+				p.markReady(acc, errMsg)
 				continue
 			}
 
@@ -419,28 +612,32 @@ func (p *AccountPool) refreshWorker(id int) {
 			failCount := acc.FailCount
 			acc.mu.Unlock()
 
-			if failCount >= MaxFailCount {
-				log.Printf("❌ [worker-%d] [%s] 连续失败 %d 次，移除账号: %v", id, acc.Data.Email, failCount, err)
+			if failCount >= settings.MaxFailCount {
+				reason := fmt.Sprintf("连续失败 %d 次: %v", failCount, err)
 				acc.mu.Lock()
-				acc.Status = StatusInvalid
+				invalid := acc.moveLocked(StatusInvalid, reason)
 				acc.mu.Unlock()
+				if !invalid {
+					// 刷新过程中被禁用或删除
+					p.requeue(acc, reason)
+					continue
+				}
+				log.Printf("❌ [worker-%d] [%s] 连续失败 %d 次，移除账号: %v", id, acc.Data.Email, failCount, err)
 				p.RemoveAccount(acc)
+				accountsInvalidated.Inc()
 			} else {
-				log.Printf("⚠️ [worker-%d] [%s] 刷新失败 (%d/%d): %v", id, acc.Data.Email, failCount, MaxFailCount, err)
+				log.Printf("⚠️ [worker-%d] [%s] 刷新失败 (%d/%d): %v", id, acc.Data.Email, failCount, settings.MaxFailCount, err)
 				// 延迟后重试
-				time.Sleep(time.Duration(failCount) * 5 * time.Second)
-				p.mu.Lock()
-				p.pendingAccounts = append(p.pendingAccounts, acc)
-				p.mu.Unlock()
+				sleepCtx(ctx, time.Duration(failCount)*5*time.Second)
+				p.requeue(acc, fmt.Sprintf("刷新失败 (%d/%d): %v", failCount, settings.MaxFailCount, err))
 			}
 		} else {
 			// 刷新成功：重置失败计数
 			acc.mu.Lock()
 			acc.FailCount = 0
-			acc.Status = StatusReady
 			acc.mu.Unlock()
 
-			if err := acc.SaveToFile(); err != nil {
+			if err := acc.Save(); err != nil {
 				log.Printf("⚠️ [%s] 写回文件失败: %v", acc.Data.Email, err)
 			}
 			p.MarkReady(acc)
@@ -448,18 +645,19 @@ func (p *AccountPool) refreshWorker(id int) {
 	}
 }
 
-func (p *AccountPool) scanWorker() {
+func (p *AccountPool) scanWorker(ctx context.Context) {
 	ticker := time.NewTicker(p.refreshInterval)
+	// 账号变化由 startAccountWatcher 实时加载，这里只是兜底
 	fileScanTicker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 	defer fileScanTicker.Stop()
 
 	for {
 		select {
-		case <-p.stopChan:
+		case <-ctx.Done():
 			return
 		case <-fileScanTicker.C:
-			p.Load(DataDir)
+			p.Load(accountStore)
 		case <-ticker.C:
 			p.RefreshExpiredAccounts()
 		}
@@ -474,20 +672,17 @@ func (p *AccountPool) RefreshExpiredAccounts() {
 	var stillReady []*Account
 	refreshed := 0
 	now := time.Now()
+	refreshCooldown := currentSettings().RefreshCooldown
 
 	for _, acc := range p.readyAccounts {
 		acc.mu.Lock()
-		jwtExpires := acc.JWTExpires
-		lastRefresh := acc.LastRefresh
+		acc.releaseCoolingLocked(now)
+		needsRefresh := acc.JWTExpires.IsZero() || now.Add(JWTRefreshThreshold).After(acc.JWTExpires)
+		inCooldown := now.Sub(acc.LastRefresh) < refreshCooldown
+		moved := needsRefresh && !inCooldown && acc.moveLocked(StatusPending, "JWT 即将过期")
 		acc.mu.Unlock()
 
-		needsRefresh := jwtExpires.IsZero() || now.Add(JWTRefreshThreshold).After(jwtExpires)
-		inCooldown := now.Sub(lastRefresh) < RefreshCooldown
-
-		if needsRefresh && !inCooldown {
-			acc.mu.Lock()
-			acc.Refreshed = false
-			acc.mu.Unlock()
+		if moved {
 			p.pendingAccounts = append(p.pendingAccounts, acc)
 			refreshed++
 		} else {
@@ -507,15 +702,18 @@ func (p *AccountPool) RefreshAllAccounts() {
 
 	var stillReady []*Account
 	refreshed, skipped := 0, 0
+	refreshCooldown := currentSettings().RefreshCooldown
 
 	for _, acc := range p.readyAccounts {
-		if time.Since(acc.LastRefresh) < RefreshCooldown {
+		acc.mu.Lock()
+		if time.Since(acc.LastRefresh) < refreshCooldown || !acc.moveLocked(StatusPending, "全量刷新") {
+			acc.mu.Unlock()
 			stillReady = append(stillReady, acc)
 			skipped++
 			continue
 		}
-		acc.Refreshed = false
 		acc.JWTExpires = time.Time{}
+		acc.mu.Unlock()
 		p.pendingAccounts = append(p.pendingAccounts, acc)
 		refreshed++
 	}
@@ -526,7 +724,24 @@ func (p *AccountPool) RefreshAllAccounts() {
 	}
 }
 
+// accountLease Take 的一次分配，记录本次写入的使用时间，供 Release 撤销
+type accountLease struct {
+	acc          *Account
+	prevLastUsed time.Time
+	usedAt       time.Time
+}
+
+// Next 取出一个就绪账号并计入使用
 func (p *AccountPool) Next() *Account {
+	if l := p.Take(); l != nil {
+		return l.acc
+	}
+	return nil
+}
+
+// Take 同 Next，同时返回分配记录。回退分支可能把同一账号同时分给多个请求，
+// 撤销所需的信息因此随分配返回，而不是保存在账号上
+func (p *AccountPool) Take() *accountLease {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -537,45 +752,70 @@ func (p *AccountPool) Next() *Account {
 	n := len(p.readyAccounts)
 	startIdx := atomic.AddUint64(&p.index, 1) - 1
 	now := time.Now()
+	useCooldown := currentSettings().UseCooldown
 
 	var bestAccount *Account
 	var oldestUsed time.Time
 
-	// 第一轮：找不在使用冷却中的账号
+	// 第一轮：找不在使用冷却中的账号；限流冷却（StatusCooling）中的账号不参与分配
 	for i := 0; i < n; i++ {
 		acc := p.readyAccounts[(startIdx+uint64(i))%uint64(n)]
 		acc.mu.Lock()
-		inUseCooldown := now.Sub(acc.LastUsed) < UseCooldown
-		lastUsed := acc.LastUsed
-		acc.mu.Unlock()
-
-		if !inUseCooldown {
+		acc.releaseCoolingLocked(now)
+		if acc.Status != StatusReady {
+			acc.mu.Unlock()
+			continue
+		}
+		if now.Sub(acc.LastUsed) >= useCooldown {
 			// 找到可用账号，标记使用时间
-			acc.mu.Lock()
+			lease := &accountLease{acc: acc, prevLastUsed: acc.LastUsed, usedAt: now}
 			acc.LastUsed = now
 			acc.TotalCount++
 			acc.mu.Unlock()
 			atomic.AddInt64(&p.totalRequests, 1)
-			return acc
+			return lease
 		}
 
 		// 记录最久未使用的账号作为备选
-		if bestAccount == nil || lastUsed.Before(oldestUsed) {
+		if bestAccount == nil || acc.LastUsed.Before(oldestUsed) {
 			bestAccount = acc
-			oldestUsed = lastUsed
+			oldestUsed = acc.LastUsed
 		}
+		acc.mu.Unlock()
 	}
 
-	// 所有账号都在冷却中，返回最久未使用的
-	if bestAccount != nil {
-		bestAccount.mu.Lock()
-		bestAccount.LastUsed = now
-		bestAccount.TotalCount++
+	// 所有就绪账号都在使用冷却中，返回最久未使用的
+	if bestAccount == nil {
+		return nil
+	}
+	bestAccount.mu.Lock()
+	if bestAccount.Status != StatusReady {
+		// 选择后状态已变化（被限流、禁用等）
 		bestAccount.mu.Unlock()
-		atomic.AddInt64(&p.totalRequests, 1)
-		log.Printf("⏳ 所有账号在使用冷却中，选择最久未用: %s", bestAccount.Data.Email)
+		return nil
+	}
+	lease := &accountLease{acc: bestAccount, prevLastUsed: bestAccount.LastUsed, usedAt: now}
+	bestAccount.LastUsed = now
+	bestAccount.TotalCount++
+	bestAccount.mu.Unlock()
+	atomic.AddInt64(&p.totalRequests, 1)
+	log.Printf("⏳ 所有账号在使用冷却中，选择最久未用: %s", bestAccount.Data.Email)
+	return lease
+}
+
+// Release 归还 Take 取出但未使用的账号：撤销本次的使用计数和使用时间；
+// 账号之后又被其他请求使用过时保留其使用时间
+func (p *AccountPool) Release(l *accountLease) {
+	acc := l.acc
+	acc.mu.Lock()
+	if acc.LastUsed.Equal(l.usedAt) {
+		acc.LastUsed = l.prevLastUsed
+	}
+	if acc.TotalCount > 0 {
+		acc.TotalCount--
 	}
-	return bestAccount
+	acc.mu.Unlock()
+	atomic.AddInt64(&p.totalRequests, -1)
 }
 
 // MarkUsed 标记账号已使用（成功）
@@ -596,15 +836,37 @@ func (p *AccountPool) MarkUsed(acc *Account, success bool) {
 	}
 }
 
+// MarkCooling 账号被限流：延长使用冷却并转为 cooling，冷却结束后由 Next 或扫描恢复就绪
+func (p *AccountPool) MarkCooling(acc *Account, d time.Duration, reason string) {
+	if acc == nil {
+		return
+	}
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	until := time.Now().Add(d)
+	acc.LastUsed = until
+	if acc.Status.usable() && acc.moveLocked(StatusCooling, reason) {
+		acc.CoolUntil = until
+	}
+}
+
+// releaseCoolingLocked 冷却到期的账号恢复就绪，调用方需持有 acc.mu
+func (acc *Account) releaseCoolingLocked(now time.Time) {
+	if acc.Status == StatusCooling && !now.Before(acc.CoolUntil) {
+		acc.moveLocked(StatusReady, "限流冷却结束")
+	}
+}
+
 // MarkNeedsRefresh 标记账号需要刷新（遇到401/403等）
-func (p *AccountPool) MarkNeedsRefresh(acc *Account) {
+func (p *AccountPool) MarkNeedsRefresh(acc *Account, reason string) {
 	if acc == nil {
 		return
 	}
 	acc.mu.Lock()
 	acc.LastRefresh = time.Time{} // 重置刷新时间，强制刷新
 	acc.mu.Unlock()
-	p.MarkPending(acc)
+	p.MarkPending(acc, reason)
+	publishEvent(EventAccountNeedsRefresh, SeverityInfo, accountHash(acc), "账号移回刷新池", accountEventData(acc))
 }
 
 func (p *AccountPool) Count() int { p.mu.RLock(); defer p.mu.RUnlock(); return len(p.readyAccounts) }
@@ -624,6 +886,14 @@ func (p *AccountPool) TotalCount() int {
 	return len(p.readyAccounts) + len(p.pendingAccounts)
 }
 
+// CapacityCount 号池已有的账号数（含管理员禁用的），用于判断是否需要注册新账号：
+// 禁用账号不算缺口，否则每禁用一个账号就会多注册一个
+func (p *AccountPool) CapacityCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.readyAccounts) + len(p.pendingAccounts) + len(p.disabledAccounts)
+}
+
 // Stats 返回号池统计信息
 func (p *AccountPool) Stats() map[string]interface{} {
 	p.mu.RLock()
@@ -637,6 +907,7 @@ func (p *AccountPool) Stats() map[string]interface{} {
 	if totalRequests > 0 {
 		successRate = float64(totalSuccess) / float64(totalRequests) * 100
 	}
+	settings := currentSettings()
 
 	return map[string]interface{}{
 		"ready":          len(p.readyAccounts),
@@ -647,14 +918,31 @@ func (p *AccountPool) Stats() map[string]interface{} {
 		"total_failed":   totalFailed,
 		"success_rate":   fmt.Sprintf("%.1f%%", successRate),
 		"cooldowns": map[string]interface{}{
-			"refresh_sec": int(RefreshCooldown.Seconds()),
-			"use_sec":     int(UseCooldown.Seconds()),
+			"refresh_sec": int(settings.RefreshCooldown.Seconds()),
+			"use_sec":     int(settings.UseCooldown.Seconds()),
 		},
 	}
 }
 
+// StatusCounts 按状态统计号池中的账号数（含正在刷新的账号；失效账号已移除，恒为 0）
+func (p *AccountPool) StatusCounts() map[string]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	counts := make(map[string]int, len(accountStatusNames))
+	for _, name := range accountStatusNames {
+		counts[name] = 0
+	}
+	for _, acc := range p.byID {
+		acc.mu.Lock()
+		counts[acc.Status.String()]++
+		acc.mu.Unlock()
+	}
+	return counts
+}
+
 // AccountInfo 账号信息（用于API返回）
 type AccountInfo struct {
+	ID           string    `json:"id"`
 	Email        string    `json:"email"`
 	Status       string    `json:"status"`
 	LastRefresh  time.Time `json:"last_refresh"`
@@ -671,19 +959,14 @@ func (p *AccountPool) ListAccounts() []AccountInfo {
 	defer p.mu.RUnlock()
 
 	var accounts []AccountInfo
-	statusNames := map[AccountStatus]string{
-		StatusPending:  "pending",
-		StatusReady:    "ready",
-		StatusCooldown: "cooldown",
-		StatusInvalid:  "invalid",
-	}
 
 	addAccounts := func(list []*Account) {
 		for _, acc := range list {
 			acc.mu.Lock()
 			info := AccountInfo{
+				ID:           acc.ID,
 				Email:        acc.Data.Email,
-				Status:       statusNames[acc.Status],
+				Status:       acc.Status.String(),
 				LastRefresh:  acc.LastRefresh,
 				LastUsed:     acc.LastUsed,
 				FailCount:    acc.FailCount,
@@ -698,6 +981,16 @@ func (p *AccountPool) ListAccounts() []AccountInfo {
 
 	addAccounts(p.readyAccounts)
 	addAccounts(p.pendingAccounts)
+	addAccounts(p.disabledAccounts)
+	var refreshing []*Account
+	for _, acc := range p.byID {
+		acc.mu.Lock()
+		if acc.Status == StatusRefreshing {
+			refreshing = append(refreshing, acc)
+		}
+		acc.mu.Unlock()
+	}
+	addAccounts(refreshing)
 
 	return accounts
 }
@@ -708,16 +1001,21 @@ func (p *AccountPool) ForceRefreshAll() int {
 	defer p.mu.Unlock()
 
 	count := 0
+	var stillReady []*Account
 	for _, acc := range p.readyAccounts {
 		acc.mu.Lock()
-		acc.Refreshed = false
+		if !acc.moveLocked(StatusPending, "强制刷新") {
+			acc.mu.Unlock()
+			stillReady = append(stillReady, acc)
+			continue
+		}
 		acc.JWTExpires = time.Time{}
 		acc.LastRefresh = time.Time{} // 强制跳过冷却
 		acc.mu.Unlock()
 		p.pendingAccounts = append(p.pendingAccounts, acc)
 		count++
 	}
-	p.readyAccounts = nil
+	p.readyAccounts = stillReady
 
 	log.Printf("🔄 强制刷新: %d 个账号已加入刷新队列", count)
 	return count
@@ -803,12 +1101,12 @@ func (acc *Account) RefreshJWT() error {
 	acc.mu.Lock()
 	defer acc.mu.Unlock()
 
-	if time.Now().Before(acc.JWTExpires) {
+	if acc.JWT != "" && time.Now().Before(acc.JWTExpires) {
 		return nil
 	}
 
-	if time.Since(acc.LastRefresh) < RefreshCooldown {
-		return fmt.Errorf("刷新冷却中，剩余 %.0f 秒", (RefreshCooldown - time.Since(acc.LastRefresh)).Seconds())
+	if cooldown := currentSettings().RefreshCooldown; acc.JWT != "" && time.Since(acc.LastRefresh) < cooldown {
+		return fmt.Errorf("刷新冷却中，剩余 %.0f 秒", (cooldown - time.Since(acc.LastRefresh)).Seconds())
 	}
 
 	secureSES := acc.getCookie("__Secure-C_SES")
@@ -819,42 +1117,14 @@ func (acc *Account) RefreshJWT() error {
 		cookie += fmt.Sprintf("; __Host-C_OSES=%s", hostOSES)
 	}
 
-	req, _ := http.NewRequest("GET", "https://business.gemini.google/auth/getoxsrf", nil)
-	q := req.URL.Query()
-	q.Add("csesidx", acc.CSESIDX)
-	req.URL.RawQuery = q.Encode()
-
-	req.Header.Set("Cookie", cookie)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Referer", "https://business.gemini.google/")
-
-	resp, err := httpClient.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	xsrfToken, keyID, err := upstream.GetOXSRF(ctx, acc.CSESIDX, cookie)
 	if err != nil {
-		return fmt.Errorf("getoxsrf 请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := readResponseBody(resp)
-		if resp.StatusCode == 401 || resp.StatusCode == 403 {
-			return fmt.Errorf("账号失效: %d %s", resp.StatusCode, string(body))
-		}
-		return fmt.Errorf("getoxsrf 失败: %d %s", resp.StatusCode, string(body))
-	}
-
-	body, _ := readResponseBody(resp)
-	txt := strings.TrimPrefix(string(body), ")]}'")
-	txt = strings.TrimSpace(txt)
-
-	var data struct {
-		XsrfToken string `json:"xsrfToken"`
-		KeyID     string `json:"keyId"`
-	}
-	if err := json.Unmarshal([]byte(txt), &data); err != nil {
-		return fmt.Errorf("解析 xsrf 响应失败: %w", err)
+		return err
 	}
 
-	token := data.XsrfToken
+	token := xsrfToken
 	switch len(token) % 4 {
 	case 2:
 		token += "=="
@@ -866,7 +1136,7 @@ func (acc *Account) RefreshJWT() error {
 		return fmt.Errorf("解码 xsrfToken 失败: %w", err)
 	}
 
-	acc.JWT = createJWT(keyBytes, data.KeyID, acc.CSESIDX)
+	acc.JWT = createJWT(keyBytes, keyID, acc.CSESIDX)
 	acc.JWTExpires = time.Now().Add(JwtTTL)
 	acc.LastRefresh = time.Now()
 
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 准入队列 ====================
+
+// QueueConfig 号池繁忙时的排队配置
+type QueueConfig struct {
+	MaxSize      int            `json:"max_size"`      // 最大排队数，0 表示不排队（无可用账号立即失败）
+	TimeoutSec   int            `json:"timeout_sec"`   // 最长等待时间
+	KeepAliveSec int            `json:"keepalive_sec"` // 流式请求排队期间发送 SSE 注释的间隔
+	TierWeights  map[string]int `json:"tier_weights"`  // 各限流等级的调度权重，默认 1
+}
+
+var (
+	errNoAccount    = errors.New("没有可用账号")
+	errQueueFull    = errors.New("排队请求过多，请稍后重试")
+	errQueueTimeout = errors.New("等待可用账号超时")
+)
+
+// queueWaiter 一个排队中的请求
+type queueWaiter struct {
+	tenant   string
+	tag      float64 // 虚拟完成时间，越小越先调度
+	ch       chan *accountLease
+	enqueued time.Time
+}
+
+// admissionQueue 按租户加权公平调度（start-time fair queueing）：
+// 每个请求的标签 = max(全局虚拟时间, 该租户上一个标签) + 1/权重，按标签从小到大分配账号，
+// 因此高权重租户获得更多份额，但任何租户都不会被饿死
+type admissionQueue struct {
+	mu        sync.Mutex
+	maxSize   int
+	timeout   time.Duration
+	keepAlive time.Duration
+	waiters   []*queueWaiter
+	lastTag   map[string]float64
+	vtime     float64
+	running   bool
+	wakeCh    chan struct{}
+
+	// 统计
+	admitted   int64
+	queued     int64
+	dispatched int64
+	timeouts   int64
+	rejected   int64
+	canceled   int64
+	totalWait  time.Duration
+	maxWait    time.Duration
+}
+
+var admission = newAdmissionQueue(0, 0, 0)
+
+func newAdmissionQueue(maxSize int, timeout, keepAlive time.Duration) *admissionQueue {
+	return &admissionQueue{
+		maxSize:   maxSize,
+		timeout:   timeout,
+		keepAlive: keepAlive,
+		lastTag:   make(map[string]float64),
+		wakeCh:    make(chan struct{}, 1),
+	}
+}
+
+func initAdmissionQueue() {
+	cfg := currentConfig().Queue
+	admission = newAdmissionQueue(cfg.MaxSize, time.Duration(cfg.TimeoutSec)*time.Second, time.Duration(cfg.KeepAliveSec)*time.Second)
+	if cfg.MaxSize > 0 {
+		log.Printf("⏳ 准入队列已启用: 最大排队 %d, 超时 %ds", cfg.MaxSize, cfg.TimeoutSec)
+	}
+}
+
+// notifyAccountReady 有账号就绪时唤醒调度
+func notifyAccountReady() {
+	if q := admission; q != nil {
+		select {
+		case q.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Acquire 获取一个账号；号池为空时排队等待，onWait 在等待期间按 keepalive 间隔调用
+func (q *admissionQueue) Acquire(ctx context.Context, tenant string, weight int, onWait func()) (*Account, error) {
+	q.mu.Lock()
+	// 没有人排队时直接取，避免插队
+	if len(q.waiters) == 0 {
+		if l := pool.Take(); l != nil {
+			q.admitted++
+			q.mu.Unlock()
+			return l.acc, nil
+		}
+	}
+	if q.maxSize <= 0 {
+		q.mu.Unlock()
+		return nil, errNoAccount
+	}
+	if len(q.waiters) >= q.maxSize {
+		q.rejected++
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+
+	if weight <= 0 {
+		weight = 1
+	}
+	start := q.vtime
+	if last := q.lastTag[tenant]; last > start {
+		start = last
+	}
+	w := &queueWaiter{tenant: tenant, tag: start + 1/float64(weight), ch: make(chan *accountLease, 1), enqueued: time.Now()}
+	q.lastTag[tenant] = w.tag
+	q.waiters = append(q.waiters, w)
+	q.queued++
+	if !q.running {
+		q.running = true
+		go q.dispatch()
+	}
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	var tick <-chan time.Time
+	if q.keepAlive > 0 && onWait != nil {
+		ticker := time.NewTicker(q.keepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case l := <-w.ch:
+			return l.acc, nil
+		case <-tick:
+			onWait()
+		case <-timer.C:
+			if l := q.cancel(w, &q.timeouts); l != nil {
+				return l.acc, nil
+			}
+			return nil, errQueueTimeout
+		case <-ctx.Done():
+			// 客户端已离开：账号若恰好已分配，转给下一个排队请求或归还号池
+			if l := q.cancel(w, &q.canceled); l != nil {
+				q.release(l)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cancel 移出队列；若账号恰好已分配则返回该分配
+func (q *admissionQueue) cancel(w *queueWaiter, counter *int64) *accountLease {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, other := range q.waiters {
+		if other == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			*counter++
+			return nil
+		}
+	}
+	return <-w.ch
+}
+
+// release 处理已分配但未被使用的账号：仍有人排队时直接转交，否则归还号池
+func (q *admissionQueue) release(l *accountLease) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.canceled++
+	if len(q.waiters) > 0 {
+		q.admitLocked(l)
+		return
+	}
+	pool.Release(l)
+}
+
+// dispatch 有人排队时持续为标签最小的请求分配账号
+func (q *admissionQueue) dispatch() {
+	poll := time.NewTicker(200 * time.Millisecond)
+	defer poll.Stop()
+	for {
+		q.mu.Lock()
+		if len(q.waiters) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		l := pool.Take()
+		if l == nil {
+			q.mu.Unlock()
+			select {
+			case <-q.wakeCh:
+			case <-poll.C:
+			}
+			continue
+		}
+		q.admitLocked(l)
+		q.mu.Unlock()
+	}
+}
+
+// admitLocked 将账号分配给标签最小的排队请求（需持有 q.mu 且队列非空）
+func (q *admissionQueue) admitLocked(l *accountLease) {
+	best := 0
+	for i, w := range q.waiters {
+		if w.tag < q.waiters[best].tag {
+			best = i
+		}
+	}
+	w := q.waiters[best]
+	q.waiters = append(q.waiters[:best], q.waiters[best+1:]...)
+	q.vtime = w.tag
+	for tenant, tag := range q.lastTag {
+		if tag <= q.vtime {
+			delete(q.lastTag, tenant)
+		}
+	}
+
+	wait := time.Since(w.enqueued)
+	q.admitted++
+	q.dispatched++
+	q.totalWait += wait
+	if wait > q.maxWait {
+		q.maxWait = wait
+	}
+	w.ch <- l
+}
+
+// Stats 队列统计
+func (q *admissionQueue) Stats() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	byTenant := make(map[string]int)
+	for _, w := range q.waiters {
+		byTenant[w.tenant]++
+	}
+	avgWait := 0.0
+	if q.dispatched > 0 {
+		avgWait = q.totalWait.Seconds() / float64(q.dispatched)
+	}
+	return map[string]interface{}{
+		"enabled":          q.maxSize > 0,
+		"depth":            len(q.waiters),
+		"max_size":         q.maxSize,
+		"waiting_tenants":  byTenant,
+		"admitted_total":   q.admitted,
+		"queued_total":     q.queued,
+		"timeouts_total":   q.timeouts,
+		"rejected_total":   q.rejected,
+		"canceled_total":   q.canceled,
+		"wait_seconds_sum": q.totalWait.Seconds(),
+		"wait_seconds_avg": avgWait,
+		"wait_seconds_max": q.maxWait.Seconds(),
+	}
+}
+
+// queueTenant 返回调度租户（Key 或客户端 IP）及其权重
+func queueTenant(c *gin.Context) (string, int) {
+	key := requestKey(c)
+	if key == nil {
+		return "ip:" + c.ClientIP(), 1
+	}
+	cfg := currentConfig()
+	tier := keyStore.TierOf(key)
+	if tier == "" {
+		tier = cfg.RateLimit.DefaultTier
+	}
+	weight := cfg.Queue.TierWeights[tier]
+	if weight <= 0 {
+		weight = 1
+	}
+	return "key:" + key.ID, weight
+}
+
+// sseKeepAlive 流式请求排队时发送 SSE 注释，防止客户端或代理断开
+func sseKeepAlive(c *gin.Context) func() {
+	return func() {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Status(200)
+		}
+		fmt.Fprint(c.Writer, ": queued\n\n")
+		if flusher, ok := c.Writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// sseStarted 排队 keepalive 是否已提交 200 text/event-stream 响应
+func sseStarted(c *gin.Context) bool {
+	return c.Writer.Written() && strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// writeSSEError 已开始输出 SSE 时，以 error 事件加 [DONE] 结束流并返回 true；
+// 尚未输出时返回 false，由调用方按普通 JSON 响应处理
+func writeSSEError(c *gin.Context, status int, errType, message string) bool {
+	if !sseStarted(c) {
+		return false
+	}
+	body, _ := json.Marshal(errorBody(dialectOf(c), status, errType, message, ""))
+	fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\ndata: [DONE]\n\n", body)
+	c.Set(ctxResponseStatus, status)
+	if flusher, ok := c.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	c.Abort()
+	return true
+}
+
+// respondChatError 返回对话请求失败（{"error": message}）；排队期间已开始输出 SSE 时改为 error 事件
+func respondChatError(c *gin.Context, status int, message string) {
+	if writeSSEError(c, status, "api_error", message) {
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+// respondQueueError 返回排队失败；已开始输出 SSE 时以事件形式返回错误
+func respondQueueError(c *gin.Context, status int, err error) {
+	if writeSSEError(c, status, "overloaded_error", err.Error()) {
+		return
+	}
+	if status == 503 {
+		c.Header("Retry-After", "1")
+	}
+	respondError(c, status, "overloaded_error", err.Error())
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readyLater 在 delay 后把一个新账号加入号池
+func readyLater(delay time.Duration) {
+	acc := &Account{
+		Data:       AccountData{Email: "late@test.local"},
+		JWT:        "jwt-late",
+		JWTExpires: time.Now().Add(5 * time.Minute),
+		ConfigID:   "test-config",
+		Status:     StatusReady,
+	}
+	time.AfterFunc(delay, func() { pool.MarkReady(acc) })
+}
+
+func TestQueueWaitsForAccount(t *testing.T) {
+	g := newTestGateway(t, 0)
+	admission = newAdmissionQueue(10, 2*time.Second, 0)
+	readyLater(100 * time.Millisecond)
+
+	start := time.Now()
+	decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi")))
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("未排队等待: %v", waited)
+	}
+	stats := admission.Stats()
+	if stats["queued_total"].(int64) != 1 || stats["depth"].(int) != 0 || stats["wait_seconds_max"].(float64) < 0.05 {
+		t.Fatalf("队列统计: %+v", stats)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	g := newTestGateway(t, 0)
+	admission = newAdmissionQueue(10, 100*time.Millisecond, 0)
+
+	w := g.post(t, "/v1/messages", map[string]interface{}{
+		"model": "gemini-2.5-flash", "messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+	if w.Code != 503 || !strings.Contains(w.Body.String(), "overloaded_error") || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("排队超时响应: %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if admission.Stats()["timeouts_total"].(int64) != 1 {
+		t.Fatalf("超时未计数")
+	}
+}
+
+func TestQueueFullAndCancel(t *testing.T) {
+	newTestGateway(t, 0)
+	admission = newAdmissionQueue(1, time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := admission.Acquire(ctx, "a", 1, nil)
+		done <- err
+	}()
+	for admission.Stats()["depth"].(int) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := admission.Acquire(context.Background(), "b", 1, nil); err != errQueueFull {
+		t.Fatalf("队列已满时 err = %v", err)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("取消后 err = %v", err)
+	}
+	if stats := admission.Stats(); stats["depth"].(int) != 0 || stats["canceled_total"].(int64) != 1 {
+		t.Fatalf("取消后队列统计: %+v", stats)
+	}
+}
+
+func TestQueueWeightedFairness(t *testing.T) {
+	newTestGateway(t, 0)
+	q := newAdmissionQueue(100, 2*time.Second, 0)
+	admission = q
+
+	results := make(chan error, 7)
+	enqueue := func(tenant string, weight, n int) {
+		for i := 0; i < n; i++ {
+			depth := q.Stats()["depth"].(int)
+			go func() {
+				_, err := q.Acquire(context.Background(), tenant, weight, nil)
+				results <- err
+			}()
+			for q.Stats()["depth"].(int) == depth {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	// 重度租户先排 4 个，普通租户随后排 1 个，权重为 2 的租户排 2 个
+	enqueue("heavy", 1, 4)
+	enqueue("light", 1, 1)
+	enqueue("premium", 2, 2)
+
+	// 标签越小越先分配：后到的 light 排在 heavy 的第 2 个请求之前，premium 获得双倍份额
+	q.mu.Lock()
+	var got []string
+	for _, w := range q.waiters {
+		got = append(got, fmt.Sprintf("%s:%.1f", w.tenant, w.tag))
+	}
+	q.mu.Unlock()
+	want := "heavy:1.0,heavy:2.0,heavy:3.0,heavy:4.0,light:1.0,premium:0.5,premium:1.0"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("调度标签 = %s, 期望 %s", strings.Join(got, ","), want)
+	}
+
+	readyLater(0)
+	for i := 0; i < 7; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("排队请求失败: %v", err)
+		}
+	}
+}
+
+func TestQueueStreamKeepAlive(t *testing.T) {
+	g := newTestGateway(t, 0)
+	admission = newAdmissionQueue(10, 2*time.Second, 20*time.Millisecond)
+	readyLater(150 * time.Millisecond)
+
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", true, "hi"))
+	if !strings.HasPrefix(w.Body.String(), ": queued\n\n") {
+		t.Fatalf("排队期间未发送 keep-alive: %q", w.Body.String()[:min(80, w.Body.Len())])
+	}
+	if res := decodeStream(t, w); !res.Done || res.Content == "" {
+		t.Fatalf("排队后流式响应不完整: %+v", res)
+	}
+}
+
+// keepalive 已开始输出 SSE 后失败：以 error 事件和 [DONE] 结束，不追加 JSON 响应体
+func TestQueueStreamErrorAfterKeepAlive(t *testing.T) {
+	g := newTestGateway(t, 0)
+	admission = newAdmissionQueue(10, 2*time.Second, 20*time.Millisecond)
+	for i := 0; i < maxRetries; i++ {
+		g.fake.Script(epStreamAssist, fakeResponse{Status: 500, Body: []byte(`{"error":"boom"}`)})
+	}
+	readyLater(100 * time.Millisecond)
+
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", true, "hi"))
+	body := w.Body.String()
+	if w.Code != 200 || w.Header().Get("Content-Type") != "text/event-stream" || !strings.HasPrefix(body, ": queued\n\n") {
+		t.Fatalf("排队期间未开始 SSE: %d %q", w.Code, body[:min(80, len(body))])
+	}
+	if !strings.Contains(body, "event: error\ndata: {") || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("SSE 错误事件格式错误: %q", body)
+	}
+	for _, block := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		if !strings.HasPrefix(block, ":") && !strings.HasPrefix(block, "data: ") && !strings.HasPrefix(block, "event: ") {
+			t.Fatalf("SSE 流中出现非事件内容: %q", block)
+		}
+	}
+	// 账本与指标记录实际失败状态，而非已发出的 200
+	records, err := ledger.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), nil)
+	if err != nil || len(records) != 1 || records[0].Status < 500 {
+		t.Fatalf("账本记录: %+v %v", records, err)
+	}
+}
+
+// 分配账号与取消同时发生：已分配的账号转给下一个排队请求
+func TestQueueCancelHandsOffDispatchedAccount(t *testing.T) {
+	newTestGateway(t, 0)
+	q := newAdmissionQueue(10, 2*time.Second, 0)
+	admission = q
+
+	next := make(chan *Account)
+	go func() {
+		acc, _ := q.Acquire(context.Background(), "b", 1, nil)
+		next <- acc
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx, "a", 2, nil) // 权重更高，先于 b 分配
+		done <- err
+	}()
+	for q.Stats()["depth"].(int) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 持有队列锁：取消的请求阻塞在 cancel 中，此时账号恰好分配给它
+	acc := &Account{Data: AccountData{Email: "handoff@test.local"}, Status: StatusReady}
+	q.mu.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	q.admitLocked(&accountLease{acc: acc})
+	q.mu.Unlock()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("取消后 err = %v", err)
+	}
+	select {
+	case got := <-next:
+		if got != acc {
+			t.Fatalf("转交的账号 = %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("已分配的账号未转交给下一个排队请求")
+	}
+	if stats := q.Stats(); stats["depth"].(int) != 0 || stats["canceled_total"].(int64) != 1 {
+		t.Fatalf("队列统计: %+v", stats)
+	}
+}
+
+// 没有其他排队请求时，账号归还号池并撤销本次使用计数
+func TestPoolReleaseRestoresUsage(t *testing.T) {
+	g := newTestGateway(t, 1)
+	acc := g.accounts[0]
+	used := time.Now().Add(-time.Hour)
+	acc.LastUsed, acc.TotalCount = used, 3
+
+	l := pool.Take()
+	if l == nil || l.acc != acc || acc.TotalCount != 4 {
+		t.Fatalf("Take = %v, total = %d", l, acc.TotalCount)
+	}
+	pool.Release(l)
+	if !acc.LastUsed.Equal(used) || acc.TotalCount != 3 {
+		t.Fatalf("归还后 LastUsed = %v, TotalCount = %d", acc.LastUsed, acc.TotalCount)
+	}
+}
+
+// 使用冷却（默认 15 秒）内回退分支把同一账号同时分给两个请求：先取的请求归还时不能覆盖后一个请求的使用时间
+func TestPoolReleaseKeepsLaterUse(t *testing.T) {
+	g := newTestGateway(t, 1)
+	acc := g.accounts[0]
+	acc.LastUsed = time.Now().Add(-time.Hour)
+
+	first := pool.Take()
+	time.Sleep(time.Millisecond)
+	second := pool.Take()
+	if first == nil || second == nil || first.acc != acc || second.acc != acc {
+		t.Fatalf("Take = %v, %v", first, second)
+	}
+	pool.Release(first)
+	if !acc.LastUsed.Equal(second.usedAt) || acc.TotalCount != 1 {
+		t.Fatalf("归还后 LastUsed = %v（应为 %v）, TotalCount = %d", acc.LastUsed, second.usedAt, acc.TotalCount)
+	}
+}
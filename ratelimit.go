@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 限流 ====================
+
+// RateLimitTier 一组限流参数，0 表示不限制
+type RateLimitTier struct {
+	RPM         int `json:"rpm"`         // 每分钟请求数
+	Concurrency int `json:"concurrency"` // 并发请求数
+	TPM         int `json:"tpm"`         // 每分钟 Token 数（估算）
+}
+
+// RateLimitConfig 限流配置：按 Key 的等级限流，并对客户端 IP 单独限流
+type RateLimitConfig struct {
+	DefaultTier string                   `json:"default_tier"` // 未指定等级的 Key 使用的等级
+	Tiers       map[string]RateLimitTier `json:"tiers"`
+	PerIP       RateLimitTier            `json:"per_ip"`
+}
+
+// tokenBucket 令牌桶：容量为每分钟额度，按秒匀速补充；允许透支（用于事后扣除 Token）
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64 // 每秒补充
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	c := float64(perMinute)
+	return &tokenBucket{capacity: c, tokens: c, rate: c / 60, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// wait 返回可用额度达到 n 还需等待的时间
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// resetAfter 返回额度回满所需时间
+func (b *tokenBucket) resetAfter() time.Duration {
+	return time.Duration((b.capacity - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) remaining() int {
+	return int(math.Max(0, b.tokens))
+}
+
+// limiterState 一个限流对象（Key 或 IP）的状态
+type limiterState struct {
+	tier     RateLimitTier
+	requests *tokenBucket
+	tokens   *tokenBucket
+	inflight int
+	lastSeen time.Time
+}
+
+// rateLimiter 所有限流对象共用一把锁，保证 Key 与 IP 的检查和扣减是原子的
+type rateLimiter struct {
+	mu     sync.Mutex
+	states map[string]*limiterState
+	sweep  time.Time
+}
+
+var limiter = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{states: make(map[string]*limiterState)}
+}
+
+// state 获取限流对象；等级参数变化时重建令牌桶
+func (l *rateLimiter) state(id string, tier RateLimitTier, now time.Time) *limiterState {
+	s, ok := l.states[id]
+	if !ok || s.tier != tier {
+		// 等级变化时重新计数，进行中的请求仍在旧状态上释放
+		s = &limiterState{tier: tier}
+		if tier.RPM > 0 {
+			s.requests = newTokenBucket(tier.RPM, now)
+		}
+		if tier.TPM > 0 {
+			s.tokens = newTokenBucket(tier.TPM, now)
+		}
+		l.states[id] = s
+	}
+	s.lastSeen = now
+	if s.requests != nil {
+		s.requests.refill(now)
+	}
+	if s.tokens != nil {
+		s.tokens.refill(now)
+	}
+	return s
+}
+
+// check 返回被限流的原因和建议重试时间，未限流返回空
+func (s *limiterState) check() (string, time.Duration) {
+	if s.tier.Concurrency > 0 && s.inflight >= s.tier.Concurrency {
+		return fmt.Sprintf("并发请求数超过上限 %d", s.tier.Concurrency), time.Second
+	}
+	if s.requests != nil {
+		if wait := s.requests.wait(1); wait > 0 {
+			return fmt.Sprintf("每分钟请求数超过上限 %d", s.tier.RPM), wait
+		}
+	}
+	if s.tokens != nil && s.tokens.tokens <= 0 {
+		return fmt.Sprintf("每分钟 Token 数超过上限 %d", s.tier.TPM), s.tokens.wait(1)
+	}
+	return "", 0
+}
+
+// Acquire 同时检查所有限流对象，全部通过才占用额度
+func (l *rateLimiter) Acquire(subjects map[string]RateLimitTier, now time.Time) ([]*limiterState, string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepIdle(now)
+
+	// 先取全部对象再检查，结果与 map 遍历顺序无关
+	states := make([]*limiterState, 0, len(subjects))
+	for id, tier := range subjects {
+		states = append(states, l.state(id, tier, now))
+	}
+	for _, s := range states {
+		if reason, wait := s.check(); reason != "" {
+			return nil, reason, wait
+		}
+	}
+	for _, s := range states {
+		s.inflight++
+		if s.requests != nil {
+			s.requests.tokens--
+		}
+	}
+	return states, "", 0
+}
+
+// Release 请求结束：释放并发占用并扣除实际 Token
+func (l *rateLimiter) Release(states []*limiterState, tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range states {
+		s.inflight--
+		if s.tokens != nil {
+			s.tokens.tokens -= float64(tokens)
+		}
+	}
+}
+
+// sweepIdle 每分钟清理一次 10 分钟未活动的对象
+func (l *rateLimiter) sweepIdle(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	for id, s := range l.states {
+		if s.inflight == 0 && now.Sub(s.lastSeen) > 10*time.Minute {
+			delete(l.states, id)
+		}
+	}
+}
+
+// keyTier 返回 Key 对应的限流等级
+func keyTier(key *APIKey, cfg RateLimitConfig) (RateLimitTier, bool) {
+	name := cfg.DefaultTier
+	if key != nil {
+		if t := keyStore.TierOf(key); t != "" {
+			name = t
+		}
+	}
+	tier, ok := cfg.Tiers[name]
+	return tier, ok && tier != RateLimitTier{}
+}
+
+// formatReset 以 OpenAI 风格输出重置时间，如 1s、6m0s
+func formatReset(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return d.Round(time.Second).String()
+}
+
+// setRateLimitHeaders 输出 x-ratelimit-* 头（以 Key 的限流状态为准，没有 Key 时使用 IP）
+func setRateLimitHeaders(c *gin.Context, s *limiterState) {
+	if s.requests != nil {
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(s.tier.RPM))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(s.requests.remaining()))
+		c.Header("x-ratelimit-reset-requests", formatReset(s.requests.resetAfter()))
+	}
+	if s.tokens != nil {
+		c.Header("x-ratelimit-limit-tokens", strconv.Itoa(s.tier.TPM))
+		c.Header("x-ratelimit-remaining-tokens", strconv.Itoa(s.tokens.remaining()))
+		c.Header("x-ratelimit-reset-tokens", formatReset(s.tokens.resetAfter()))
+	}
+}
+
+// rateLimit 限流中间件，需放在 apiKeyAuth 之后
+func rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := currentConfig().RateLimit
+		subjects := make(map[string]RateLimitTier, 2)
+		primary := ""
+		if ipTier := cfg.PerIP; ipTier != (RateLimitTier{}) {
+			primary = "ip:" + c.ClientIP()
+			subjects[primary] = ipTier
+		}
+		key := requestKey(c)
+		if tier, ok := keyTier(key, cfg); ok && key != nil {
+			primary = "key:" + key.ID
+			subjects[primary] = tier
+		}
+		if len(subjects) == 0 {
+			if chargeRequest(c) {
+				c.Next()
+			}
+			return
+		}
+
+		states, reason, wait := limiter.Acquire(subjects, time.Now())
+		if reason != "" {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			respondRateLimited(c, retryAfter, fmt.Sprintf("请求过于频繁: %s，请 %d 秒后重试", reason, retryAfter))
+			return
+		}
+
+		limiter.mu.Lock()
+		setRateLimitHeaders(c, limiter.states[primary])
+		limiter.mu.Unlock()
+
+		defer func() {
+			tokens := 0
+			if usage, ok := c.Get(ctxTokenUsage); ok {
+				tokens = usage.(TokenUsage).Total()
+			}
+			limiter.Release(states, tokens)
+		}()
+		// 被限流的请求不消耗 Key 配额
+		if !chargeRequest(c) {
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBuckets(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	tier := map[string]RateLimitTier{"key:a": {RPM: 2, Concurrency: 1, TPM: 100}}
+
+	// 并发
+	held, reason, _ := l.Acquire(tier, now)
+	if reason != "" {
+		t.Fatalf("首个请求被限流: %s", reason)
+	}
+	if _, reason, _ := l.Acquire(tier, now); !strings.Contains(reason, "并发") {
+		t.Fatalf("并发限制未生效: %q", reason)
+	}
+	l.Release(held, 10)
+
+	// 每分钟请求数：第 3 个请求需等待约 30 秒
+	held, _, _ = l.Acquire(tier, now)
+	l.Release(held, 10)
+	_, reason, wait := l.Acquire(tier, now)
+	if !strings.Contains(reason, "请求数") || wait < 29*time.Second || wait > 31*time.Second {
+		t.Fatalf("RPM 限制: reason=%q wait=%v", reason, wait)
+	}
+	// 30 秒后补充 1 个
+	if held, reason, _ = l.Acquire(tier, now.Add(30*time.Second)); reason != "" {
+		t.Fatalf("补充后仍被限流: %s", reason)
+	}
+
+	// 每分钟 Token 数：透支后拒绝
+	l.Release(held, 500)
+	if _, reason, _ := l.Acquire(tier, now.Add(90*time.Second)); !strings.Contains(reason, "Token") {
+		t.Fatalf("TPM 限制未生效: %q", reason)
+	}
+}
+
+func TestRateLimiterKeyAndIP(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	ip := RateLimitTier{RPM: 1}
+	// 同一 IP 下的两个 Key 共享 IP 额度
+	if _, reason, _ := l.Acquire(map[string]RateLimitTier{"key:a": {RPM: 10}, "ip:1.2.3.4": ip}, now); reason != "" {
+		t.Fatal(reason)
+	}
+	if _, reason, _ := l.Acquire(map[string]RateLimitTier{"key:b": {RPM: 10}, "ip:1.2.3.4": ip}, now); reason == "" {
+		t.Fatal("IP 限制未生效")
+	}
+	// 被拒绝的请求不应消耗 Key 的额度
+	l.mu.Lock()
+	remaining := l.states["key:b"].requests.remaining()
+	l.mu.Unlock()
+	if remaining != 10 {
+		t.Fatalf("被拒绝的请求消耗了额度: remaining=%d", remaining)
+	}
+}
+
+func TestRateLimitHeadersAndDialects(t *testing.T) {
+	g := newTestGateway(t, 1)
+	updateConfig(func(cfg *AppConfig) {
+		cfg.RateLimit = RateLimitConfig{
+			DefaultTier: "free",
+			Tiers:       map[string]RateLimitTier{"free": {RPM: 2, TPM: 10000}, "pro": {RPM: 100}},
+		}
+	})
+	key, _ := createTestKey(t, g, map[string]interface{}{"owner": "team-c"})
+
+	w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...)
+	if w.Code != 200 || w.Header().Get("x-ratelimit-limit-requests") != "2" ||
+		w.Header().Get("x-ratelimit-remaining-requests") != "1" || w.Header().Get("x-ratelimit-limit-tokens") != "10000" {
+		t.Fatalf("限流头错误: %d %v", w.Code, w.Header())
+	}
+	g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...)
+
+	claude := map[string]interface{}{"model": "gemini-2.5-flash", "messages": []map[string]string{{"role": "user", "content": "hi"}}}
+	w = g.post(t, "/v1/messages", claude, bearer(key)...)
+	if w.Code != 429 || !strings.Contains(w.Body.String(), `"type":"rate_limit_error"`) {
+		t.Fatalf("Claude 429 格式错误: %d %s", w.Code, w.Body.String())
+	}
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 1 || retry > 30 {
+		t.Fatalf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+	w = g.post(t, "/v1beta/models/gemini-2.5-flash:generateContent", map[string]interface{}{
+		"contents": []map[string]interface{}{{"parts": []map[string]string{{"text": "hi"}}}},
+	}, bearer(key)...)
+	if w.Code != 429 || !strings.Contains(w.Body.String(), `"status":"RESOURCE_EXHAUSTED"`) || !strings.Contains(w.Body.String(), `"retryDelay"`) {
+		t.Fatalf("Gemini 429 格式错误: %d %s", w.Code, w.Body.String())
+	}
+
+	// 升级等级后立即生效
+	keys := keyStore.List()
+	keyStore.Update(keys[0].ID, func(k *APIKey) { k.Tier = "pro" })
+	if w := g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash", false, "hi"), bearer(key)...); w.Code != 200 {
+		t.Fatalf("升级等级后仍被限流: %d", w.Code)
+	}
+}
+
+func TestRateLimitedRequestsDoNotUseKeyQuota(t *testing.T) {
+	g := newTestGateway(t, 1)
+	updateConfig(func(cfg *AppConfig) {
+		cfg.RateLimit = RateLimitConfig{DefaultTier: "free", Tiers: map[string]RateLimitTier{"free": {RPM: 1}}}
+	})
+	key, id := createTestKey(t, g, map[string]interface{}{"owner": "team-d", "quota": map[string]int{"daily_requests": 5}})
+
+	body := chatBody("gemini-2.5-flash", false, "hi")
+	if w := g.post(t, "/v1/chat/completions", body, bearer(key)...); w.Code != 200 {
+		t.Fatalf("首个请求失败: %d %s", w.Code, w.Body.String())
+	}
+	for i := 0; i < 3; i++ {
+		if w := g.post(t, "/v1/chat/completions", body, bearer(key)...); w.Code != 429 || !strings.Contains(w.Body.String(), "请求过于频繁") {
+			t.Fatalf("未被限流: %d %s", w.Code, w.Body.String())
+		}
+	}
+	for _, k := range keyStore.List() {
+		if k.ID == id && (k.Usage.DailyRequests != 1 || k.Usage.MonthlyRequests != 1) {
+			t.Fatalf("被限流的请求计入了配额: %+v", k.Usage)
+		}
+	}
+}
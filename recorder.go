@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 上游流量录制与回放 ====================
+
+// RecordConfig 上游流量录制配置（默认关闭）
+type RecordConfig struct {
+	Enabled bool   `json:"enabled"` // 启用录制
+	Dir     string `json:"dir"`     // 录制目录，默认 <data_dir>/recordings
+}
+
+// RecordedExchange 一次上游请求/响应
+type RecordedExchange struct {
+	Time                 time.Time         `json:"time"`
+	Method               string            `json:"method"`
+	URL                  string            `json:"url"`
+	RequestHeaders       map[string]string `json:"request_headers,omitempty"`
+	RequestBody          string            `json:"request_body,omitempty"`
+	Status               int               `json:"status"`
+	ResponseHeaders      map[string]string `json:"response_headers,omitempty"`
+	ResponseBody         string            `json:"response_body,omitempty"`
+	ResponseBodyEncoding string            `json:"response_body_encoding,omitempty"` // 二进制响应为 base64
+	DurationMs           int64             `json:"duration_ms"`
+	Error                string            `json:"error,omitempty"`
+}
+
+// Recording 一个客户端请求期间的所有上游交互
+type Recording struct {
+	ID        string             `json:"id"`
+	Time      time.Time          `json:"time"`
+	Model     string             `json:"model,omitempty"`
+	Stream    bool               `json:"stream"`
+	Exchanges []RecordedExchange `json:"exchanges"`
+	mu        sync.Mutex
+}
+
+func (r *Recording) add(ex RecordedExchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Exchanges = append(r.Exchanges, ex)
+}
+
+type recordingKey struct{}
+
+// withRecording 将录制挂到请求上下文，上游调用据此归档
+func withRecording(ctx context.Context, rec *Recording) context.Context {
+	return context.WithValue(ctx, recordingKey{}, rec)
+}
+
+func recordingFromContext(ctx context.Context) *Recording {
+	rec, _ := ctx.Value(recordingKey{}).(*Recording)
+	return rec
+}
+
+type trafficRecorder struct {
+	dir string
+}
+
+// recorder 为 nil 表示未启用录制
+var recorder *trafficRecorder
+
+func initRecorder() {
+	cfg := currentConfig().Record
+	if !cfg.Enabled {
+		return
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(DataDir, "recordings")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("❌ 创建录制目录失败: %v，录制已禁用", err)
+		return
+	}
+	recorder = &trafficRecorder{dir: dir}
+	log.Printf("🎙️ 上游流量录制已启用: %s", dir)
+}
+
+// Start 开始录制一个客户端请求
+func (r *trafficRecorder) Start(id, model string, stream bool) *Recording {
+	return &Recording{ID: id, Time: time.Now(), Model: model, Stream: stream}
+}
+
+// Finish 将录制写入磁盘
+func (r *trafficRecorder) Finish(rec *Recording) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.Exchanges) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		log.Printf("⚠️ 序列化录制失败: %v", err)
+		return
+	}
+	name := fmt.Sprintf("%s_%s.json", rec.Time.Format("20060102-150405"), rec.ID)
+	if err := os.WriteFile(filepath.Join(r.dir, name), data, 0600); err != nil {
+		log.Printf("⚠️ 写入录制失败: %v", err)
+	}
+}
+
+// recordingTransport 录制经过的上游请求，请求上下文中没有录制时单独落盘
+type recordingTransport struct {
+	base     http.RoundTripper
+	recorder *trafficRecorder
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := RecordedExchange{
+		Time:           time.Now(),
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeaders: redactHeaders(req.Header),
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		ex.RequestBody = redactBody(string(body))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	ex.DurationMs = time.Since(ex.Time).Milliseconds()
+	if err != nil {
+		ex.Error = err.Error()
+		t.save(req.Context(), ex)
+		return nil, err
+	}
+
+	raw, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+
+	ex.Status = resp.StatusCode
+	ex.ResponseHeaders = redactHeaders(resp.Header)
+	decoded := raw
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		if zr, err := gzip.NewReader(bytes.NewReader(raw)); err == nil {
+			if d, err := io.ReadAll(zr); err == nil {
+				decoded = d
+				delete(ex.ResponseHeaders, "content-encoding")
+			}
+		}
+	}
+	if utf8.Valid(decoded) {
+		ex.ResponseBody = redactBody(string(decoded))
+	} else {
+		ex.ResponseBody = base64.StdEncoding.EncodeToString(decoded)
+		ex.ResponseBodyEncoding = "base64"
+	}
+	if readErr != nil {
+		ex.Error = readErr.Error()
+	}
+	t.save(req.Context(), ex)
+	return resp, readErr
+}
+
+// standalone 单独保存不属于任何客户端请求的交互（如 JWT 刷新）
+func (r *trafficRecorder) standalone(ex RecordedExchange) {
+	r.Finish(&Recording{ID: "exchange", Time: ex.Time, Exchanges: []RecordedExchange{ex}})
+}
+
+func (t *recordingTransport) save(ctx context.Context, ex RecordedExchange) {
+	if rec := recordingFromContext(ctx); rec != nil {
+		rec.add(ex)
+		return
+	}
+	t.recorder.standalone(ex)
+}
+
+// ==================== 脱敏 ====================
+
+var sensitiveHeaders = map[string]bool{
+	"authorization":            true,
+	"x-original-authorization": true,
+	"cookie":                   true,
+	"set-cookie":               true,
+	"x-api-key":                true,
+}
+
+var (
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	xsrfPattern  = regexp.MustCompile(`"xsrfToken"\s*:\s*"[^"]*"`)
+	redactedMark = "[REDACTED]"
+)
+
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		key := strings.ToLower(k)
+		if sensitiveHeaders[key] {
+			out[key] = redactedMark
+			continue
+		}
+		out[key] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+func redactBody(body string) string {
+	body = jwtPattern.ReplaceAllString(body, redactedMark)
+	return xsrfPattern.ReplaceAllString(body, `"xsrfToken":"`+redactedMark+`"`)
+}
+
+// ==================== 回放 ====================
+
+// replayTransport 按 (方法, 路径) 顺序返回录制的响应
+type replayTransport struct {
+	mu        sync.Mutex
+	exchanges []RecordedExchange
+	used      []bool
+}
+
+func newReplayTransport(rec *Recording) *replayTransport {
+	return &replayTransport{exchanges: rec.Exchanges, used: make([]bool, len(rec.Exchanges))}
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, ex := range t.exchanges {
+		if t.used[i] || ex.Method != req.Method {
+			continue
+		}
+		u, err := req.URL.Parse(ex.URL)
+		if err != nil || u.Path != req.URL.Path {
+			continue
+		}
+		t.used[i] = true
+		if ex.Error != "" && ex.Status == 0 {
+			return nil, fmt.Errorf("录制的请求错误: %s", ex.Error)
+		}
+
+		body := []byte(ex.ResponseBody)
+		if ex.ResponseBodyEncoding == "base64" {
+			body, _ = base64.StdEncoding.DecodeString(ex.ResponseBody)
+		}
+		header := make(http.Header)
+		for k, v := range ex.ResponseHeaders {
+			header.Set(k, v)
+		}
+		return &http.Response{
+			StatusCode: ex.Status,
+			Status:     fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+	return nil, fmt.Errorf("录制中没有匹配的请求: %s %s", req.Method, req.URL.Path)
+}
+
+func loadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("解析录制文件失败: %w", err)
+	}
+	return &rec, nil
+}
+
+// replayRecording 离线将录制送入完整的解析与格式化流程，返回网关的响应
+func replayRecording(rec *Recording, stream bool) *httptest.ResponseRecorder {
+	upstream = newHTTPUpstream(&http.Client{Transport: newReplayTransport(rec)}, UpstreamConfig{})
+	pool = &AccountPool{}
+	pool.MarkReady(&Account{
+		Data:       AccountData{Email: "replay@local"},
+		JWT:        "replay",
+		JWTExpires: time.Now().Add(time.Hour),
+		ConfigID:   "replay",
+		Status:     StatusReady,
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	streamChat(c, ChatRequest{
+		Model:    rec.Model,
+		Stream:   stream,
+		Messages: []Message{{Role: "user", Content: "replay"}},
+	})
+	return w
+}
+
+// runReplayMode 回放录制文件；传入目录时逐个回放并输出汇总
+func runReplayMode(path string, streamOverride *bool) {
+	gin.SetMode(gin.ReleaseMode)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("❌ 读取录制失败: %v", err)
+	}
+
+	if !info.IsDir() {
+		rec, err := loadRecording(path)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		stream := rec.Stream
+		if streamOverride != nil {
+			stream = *streamOverride
+		}
+		w := replayRecording(rec, stream)
+		log.Printf("▶️ 回放 %s: model=%s, stream=%v, status=%d", filepath.Base(path), rec.Model, stream, w.Code)
+		os.Stdout.Write(w.Body.Bytes())
+		fmt.Println()
+		return
+	}
+
+	files, _ := filepath.Glob(filepath.Join(path, "*.json"))
+	sort.Strings(files)
+	replayed, failed := 0, 0
+	for _, f := range files {
+		rec, err := loadRecording(f)
+		if err != nil || rec.Model == "" {
+			continue // 跳过损坏文件和非对话录制
+		}
+		stream := rec.Stream
+		if streamOverride != nil {
+			stream = *streamOverride
+		}
+		w := replayRecording(rec, stream)
+		replayed++
+		mark := "✅"
+		if w.Code != 200 {
+			mark = "❌"
+			failed++
+		}
+		fmt.Printf("%s %s model=%s stream=%v status=%d bytes=%d\n", mark, filepath.Base(f), rec.Model, stream, w.Code, w.Body.Len())
+	}
+	fmt.Printf("共回放 %d 个录制，失败 %d 个\n", replayed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
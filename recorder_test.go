@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	g := newTestGateway(t, 1)
+	imgData := []byte("\x89PNG\x00\xff binary")
+	g.fake.AddFile("gen-1", "image/png", imgData)
+	g.fake.Script(epStreamAssist, fakeResponse{Body: assistTruncated(textReply("Hello "), fileReply("gen-1", "image/png"))})
+
+	oldRecorder := recorder
+	t.Cleanup(func() { recorder = oldRecorder })
+	recorder = &trafficRecorder{dir: t.TempDir()}
+	upstream = newHTTPUpstream(&http.Client{
+		Transport: &recordingTransport{base: g.fake.Client().Transport, recorder: recorder},
+	}, UpstreamConfig{APIBaseURL: g.fake.URL, AuthBaseURL: g.fake.URL})
+
+	live := decodeCompletion(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash-image", false, "draw")))
+
+	files, _ := filepath.Glob(filepath.Join(recorder.dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("录制文件数量 = %d", len(files))
+	}
+	rec, err := loadRecording(files[0])
+	if err != nil {
+		t.Fatalf("加载录制失败: %v", err)
+	}
+	if rec.Model != "gemini-2.5-flash-image" || len(rec.Exchanges) != 4 {
+		t.Fatalf("录制内容: model=%s, exchanges=%d", rec.Model, len(rec.Exchanges))
+	}
+	for _, ex := range rec.Exchanges {
+		if auth := ex.RequestHeaders["authorization"]; auth != redactedMark {
+			t.Fatalf("authorization 未脱敏: %q", auth)
+		}
+	}
+	if last := rec.Exchanges[3]; last.ResponseBodyEncoding != "base64" || last.ResponseBody != base64.StdEncoding.EncodeToString(imgData) {
+		t.Fatalf("二进制响应未按 base64 保存: %+v", last)
+	}
+
+	// 离线回放应得到与在线请求相同的结果
+	replayed := decodeCompletion(t, replayRecording(rec, false))
+	if *replayed.Choices[0].Message.Content != *live.Choices[0].Message.Content {
+		t.Fatalf("回放结果不一致:\n在线: %s\n回放: %s", *live.Choices[0].Message.Content, *replayed.Choices[0].Message.Content)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body := `{"xsrfToken":"c2VjcmV0","jwt":"eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJ4In0.c2ln"}`
+	got := redactBody(body)
+	if strings.Contains(got, "c2VjcmV0") || strings.Contains(got, "eyJhbGci") {
+		t.Fatalf("未脱敏: %s", got)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -79,37 +80,39 @@ func startRegister(count int) error {
 	}
 
 	// 使用配置的线程数
-	threads := appConfig.Pool.RegisterThreads
+	threads := currentConfig().Pool.RegisterThreads
 	if threads <= 0 {
 		threads = 1
 	}
 
+	// 关闭时等待进行中的注册保存完成（最长 shutdown.worker_timeout_sec），再关闭账号存储
 	for i := 0; i < threads; i++ {
-		go NativeRegisterWorker(i+1, dataDirAbs)
+		id := i + 1
+		app.Go("register-worker", func(ctx context.Context) { NativeRegisterWorker(ctx, id) })
 	}
 
-	// 监控进度
-	go func() {
-		checkCount := 0
+	// 监控进度（新账号由注册线程和账号存储监听加入号池）；关闭时通知注册线程在当前任务后退出
+	app.Go("register-monitor", func(ctx context.Context) {
 		for {
-			time.Sleep(10 * time.Second)
-			checkCount++
-			pool.Load(DataDir)
-			currentCount := pool.TotalCount()
-			targetCount := appConfig.Pool.TargetCount
+			if !sleepCtx(ctx, 10*time.Second) {
+				atomic.StoreInt32(&isRegistering, 0)
+				return
+			}
+			currentCount := pool.CapacityCount()
+			targetCount := currentConfig().Pool.TargetCount
 
 			if currentCount >= targetCount {
 				atomic.StoreInt32(&isRegistering, 0)
 				return
 			}
 		}
-	}()
+	})
 
 	return nil
 }
 
-func poolMaintainer() {
-	interval := time.Duration(appConfig.Pool.CheckIntervalMinutes) * time.Minute
+func poolMaintainer(ctx context.Context) {
+	interval := time.Duration(currentConfig().Pool.CheckIntervalMinutes) * time.Minute
 	if interval < time.Minute {
 		interval = 30 * time.Minute
 	}
@@ -118,17 +121,24 @@ func poolMaintainer() {
 	defer ticker.Stop()
 	checkAndMaintainPool()
 
-	for range ticker.C {
-		checkAndMaintainPool()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkAndMaintainPool()
+		}
 	}
 }
 
 func checkAndMaintainPool() {
-	pool.Load(DataDir)
+	pool.Load(accountStore)
+	pool.checkPoolLevel()
 
-	totalCount := pool.TotalCount()
-	targetCount := appConfig.Pool.TargetCount
-	minCount := appConfig.Pool.MinCount
+	totalCount := pool.CapacityCount()
+	poolCfg := currentConfig().Pool
+	targetCount := poolCfg.TargetCount
+	minCount := poolCfg.MinCount
 
 	if totalCount < targetCount {
 		needCount := targetCount - totalCount
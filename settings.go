@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ==================== 运行时设置 ====================
+
+// RuntimeSettings 号池运行时参数快照。快照发布后不再修改，读取方无需加锁
+type RuntimeSettings struct {
+	RefreshCooldown        time.Duration // 刷新冷却
+	UseCooldown            time.Duration // 使用冷却
+	MaxFailCount           int           // 最大连续失败次数
+	EnableBrowserRefresh   bool          // 是否启用浏览器刷新
+	BrowserRefreshHeadless bool          // 浏览器刷新是否无头模式
+	BrowserRefreshMaxRetry int           // 浏览器刷新最大重试次数
+}
+
+// settingsFromPool 由号池配置得到运行时参数，未配置（<=0）的项使用默认值
+func settingsFromPool(p PoolConfig) *RuntimeSettings {
+	s := &RuntimeSettings{
+		RefreshCooldown:        4 * time.Minute,
+		UseCooldown:            15 * time.Second,
+		MaxFailCount:           3,
+		EnableBrowserRefresh:   p.EnableBrowserRefresh,
+		BrowserRefreshHeadless: p.BrowserRefreshHeadless,
+		BrowserRefreshMaxRetry: 1,
+	}
+	if p.RefreshCooldownSec > 0 {
+		s.RefreshCooldown = time.Duration(p.RefreshCooldownSec) * time.Second
+	}
+	if p.UseCooldownSec > 0 {
+		s.UseCooldown = time.Duration(p.UseCooldownSec) * time.Second
+	}
+	if p.MaxFailCount > 0 {
+		s.MaxFailCount = p.MaxFailCount
+	}
+	if p.BrowserRefreshMaxRetry >= 0 {
+		s.BrowserRefreshMaxRetry = p.BrowserRefreshMaxRetry
+	}
+	if s.BrowserRefreshMaxRetry == 0 {
+		s.EnableBrowserRefresh = false
+	}
+	return s
+}
+
+// settingOverride 通过管理接口修改的值。Config 记录修改时 config.json 中的值：
+// 之后 config.json 中该项被改动，则以 config.json 为准并丢弃覆盖
+type settingOverride struct {
+	Value  json.RawMessage `json:"value"`
+	Config json.RawMessage `json:"config"`
+	Actor  string          `json:"actor"`
+	Time   time.Time       `json:"time"`
+}
+
+// SettingsChange 一次运行时设置变更
+type SettingsChange struct {
+	Time  time.Time       `json:"time"`
+	Actor string          `json:"actor"`
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// settingsFile <data_dir>/meta/runtime_settings.json 的内容
+type settingsFile struct {
+	Overrides map[string]settingOverride `json:"overrides"`
+	History   []SettingsChange           `json:"history"`
+}
+
+const settingsHistoryMax = 200
+
+// settingsStore 保存 config.json 中的号池配置与管理接口的覆盖值，
+// 修改时串行计算新快照并原子替换
+type settingsStore struct {
+	mu        sync.Mutex
+	current   atomic.Pointer[RuntimeSettings]
+	path      string
+	base      PoolConfig
+	overrides map[string]settingOverride
+	history   []SettingsChange
+}
+
+var runtimeSettings = newSettingsStore("")
+
+// currentSettings 返回当前的运行时设置快照
+func currentSettings() *RuntimeSettings {
+	return runtimeSettings.current.Load()
+}
+
+func newSettingsStore(path string) *settingsStore {
+	s := &settingsStore{
+		path:      path,
+		base:      defaultAppConfig().Pool,
+		overrides: make(map[string]settingOverride),
+	}
+	s.current.Store(settingsFromPool(s.base))
+	return s
+}
+
+// initRuntimeSettings 加载管理接口保存的覆盖值，并以当前配置为基础发布快照
+func initRuntimeSettings() {
+	store := newSettingsStore(filepath.Join(DataDir, "meta", "runtime_settings.json"))
+	if err := store.load(); err != nil {
+		log.Printf("❌ 加载运行时设置失败: %v", err)
+	}
+	poolCfg := currentConfig().Pool
+	store.SetBase(poolCfg, "config")
+	runtimeSettings = store
+
+	s := currentSettings()
+	log.Printf("⚙️ 冷却配置: 刷新=%v, 使用=%v", s.RefreshCooldown, s.UseCooldown)
+	if s.EnableBrowserRefresh {
+		log.Printf("🌐 浏览器刷新已启用 (headless=%v, 最大重试=%d)", s.BrowserRefreshHeadless, s.BrowserRefreshMaxRetry)
+	} else if poolCfg.EnableBrowserRefresh && s.BrowserRefreshMaxRetry == 0 {
+		log.Printf("🌐 浏览器刷新已禁用 (max_retry=0)")
+	}
+	if len(store.overrides) > 0 {
+		log.Printf("⚙️ 已应用 %d 项管理接口修改的设置（%s）", len(store.overrides), store.path)
+	}
+}
+
+func (s *settingsStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f settingsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for field, ov := range f.Overrides {
+		if poolSettingField(&PoolConfig{}, field) == nil {
+			log.Printf("⚠️ 忽略未知的运行时设置 %s", field)
+			continue
+		}
+		s.overrides[field] = ov
+	}
+	s.history = f.History
+	return nil
+}
+
+// poolSettingField 返回可通过管理接口修改的号池配置项
+func poolSettingField(p *PoolConfig, field string) interface{} {
+	switch field {
+	case "pool.refresh_cooldown_sec":
+		return &p.RefreshCooldownSec
+	case "pool.use_cooldown_sec":
+		return &p.UseCooldownSec
+	case "pool.enable_browser_refresh":
+		return &p.EnableBrowserRefresh
+	case "pool.browser_refresh_headless":
+		return &p.BrowserRefreshHeadless
+	}
+	return nil
+}
+
+func poolSettingValue(p PoolConfig, field string) json.RawMessage {
+	v, _ := json.Marshal(poolSettingField(&p, field))
+	return v
+}
+
+// effectiveLocked 在 config.json 的配置上叠加覆盖值
+func (s *settingsStore) effectiveLocked() PoolConfig {
+	p := s.base
+	for field, ov := range s.overrides {
+		json.Unmarshal(ov.Value, poolSettingField(&p, field))
+	}
+	return p
+}
+
+func (s *settingsStore) publishLocked() {
+	s.current.Store(settingsFromPool(s.effectiveLocked()))
+}
+
+func (s *settingsStore) recordLocked(changes ...SettingsChange) {
+	s.history = append(s.history, changes...)
+	if len(s.history) > settingsHistoryMax {
+		s.history = s.history[len(s.history)-settingsHistoryMax:]
+	}
+}
+
+// SetBase 更新 config.json 中的号池配置（启动或热重载时）。
+// config.json 中被改动过的项以新值为准，丢弃对应的覆盖值并记录历史
+func (s *settingsStore) SetBase(p PoolConfig, actor string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dropped []SettingsChange
+	for field, ov := range s.overrides {
+		cur := poolSettingValue(p, field)
+		if bytes.Equal(cur, ov.Config) {
+			continue
+		}
+		delete(s.overrides, field)
+		dropped = append(dropped, SettingsChange{Time: time.Now(), Actor: actor, Field: field, Old: ov.Value, New: cur})
+		log.Printf("⚙️ config.json 中的 %s 已修改，取消管理接口设置的 %s（%s 于 %s）",
+			field, ov.Value, ov.Actor, ov.Time.Format(time.RFC3339))
+	}
+	s.base = p
+	s.publishLocked()
+	if len(dropped) > 0 {
+		sort.Slice(dropped, func(i, j int) bool { return dropped[i].Field < dropped[j].Field })
+		s.recordLocked(dropped...)
+		if err := s.saveLocked(); err != nil {
+			log.Printf("⚠️ 保存运行时设置失败: %v", err)
+		}
+	}
+}
+
+// Update 以管理员身份修改设置（key 为配置路径），先持久化再发布新快照
+func (s *settingsStore) Update(actor string, values map[string]interface{}) (*RuntimeSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		if poolSettingField(&PoolConfig{}, field) == nil {
+			return nil, fmt.Errorf("不支持修改 %s", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	prevOverrides := make(map[string]settingOverride, len(s.overrides))
+	for k, v := range s.overrides {
+		prevOverrides[k] = v
+	}
+	prevHistory := append([]SettingsChange(nil), s.history...)
+
+	now := time.Now()
+	var changes []SettingsChange
+	for _, field := range fields {
+		val, err := json.Marshal(values[field])
+		if err != nil {
+			s.overrides = prevOverrides
+			return nil, err
+		}
+		old := poolSettingValue(s.effectiveLocked(), field)
+		if bytes.Equal(old, val) {
+			continue
+		}
+		s.overrides[field] = settingOverride{Value: val, Config: poolSettingValue(s.base, field), Actor: actor, Time: now}
+		changes = append(changes, SettingsChange{Time: now, Actor: actor, Field: field, Old: old, New: val})
+	}
+	if len(changes) == 0 {
+		return s.current.Load(), nil
+	}
+
+	s.recordLocked(changes...)
+	if err := s.saveLocked(); err != nil {
+		s.overrides, s.history = prevOverrides, prevHistory
+		return nil, fmt.Errorf("保存运行时设置失败: %w", err)
+	}
+	s.publishLocked()
+	for _, ch := range changes {
+		log.Printf("⚙️ %s 修改运行时设置 %s: %s → %s", actor, ch.Field, ch.Old, ch.New)
+	}
+	return s.current.Load(), nil
+}
+
+// History 返回最近 n 条变更（新的在前）
+func (s *settingsStore) History(n int) []SettingsChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= 0 || n > len(s.history) {
+		n = len(s.history)
+	}
+	out := make([]SettingsChange, 0, n)
+	for i := len(s.history) - 1; i >= len(s.history)-n; i-- {
+		out = append(out, s.history[i])
+	}
+	return out
+}
+
+// Overrides 返回当前生效的覆盖值
+func (s *settingsStore) Overrides() map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]json.RawMessage, len(s.overrides))
+	for field, ov := range s.overrides {
+		out[field] = ov.Value
+	}
+	return out
+}
+
+func (s *settingsStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(settingsFile{Overrides: s.overrides, History: s.history}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSettingsStoreOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runtime_settings.json")
+	base := defaultAppConfig().Pool
+	base.RefreshCooldownSec = 240
+
+	store := newSettingsStore(path)
+	store.SetBase(base, "config")
+	if _, err := store.Update("alice", map[string]interface{}{"pool.refresh_cooldown_sec": 60, "pool.enable_browser_refresh": false}); err != nil {
+		t.Fatal(err)
+	}
+	if s := store.current.Load(); s.RefreshCooldown != time.Minute || s.EnableBrowserRefresh {
+		t.Fatalf("快照未更新: %+v", s)
+	}
+	if _, err := store.Update("alice", map[string]interface{}{"pool.max_fail_count": 1}); err == nil {
+		t.Fatal("不可修改的项应报错")
+	}
+	hist := store.History(0)
+	if len(hist) != 2 || hist[1].Field != "pool.enable_browser_refresh" || hist[0].Actor != "alice" ||
+		string(hist[0].Old) != "240" || string(hist[0].New) != "60" {
+		t.Fatalf("历史: %+v", hist)
+	}
+
+	// 重启后覆盖值仍然生效
+	reloaded := newSettingsStore(path)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.SetBase(base, "config")
+	if s := reloaded.current.Load(); s.RefreshCooldown != time.Minute || s.EnableBrowserRefresh || len(reloaded.History(0)) != 2 {
+		t.Fatalf("重新加载后: %+v", s)
+	}
+
+	// config.json 中的值被修改后以 config.json 为准
+	base.RefreshCooldownSec = 120
+	reloaded.SetBase(base, "sighup")
+	if s := reloaded.current.Load(); s.RefreshCooldown != 2*time.Minute || s.EnableBrowserRefresh {
+		t.Fatalf("config.json 修改后: %+v", s)
+	}
+	if h := reloaded.History(1)[0]; h.Actor != "sighup" || h.Field != "pool.refresh_cooldown_sec" || string(h.New) != "120" {
+		t.Fatalf("历史: %+v", h)
+	}
+	if ov := reloaded.Overrides(); len(ov) != 1 || string(ov["pool.enable_browser_refresh"]) != "false" {
+		t.Fatalf("覆盖值: %v", ov)
+	}
+}
+
+func TestSettingsStoreConcurrentAccess(t *testing.T) {
+	store := newSettingsStore("")
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if s := store.current.Load(); s.UseCooldown <= 0 {
+					t.Error("快照无效")
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		if _, err := store.Update("test", map[string]interface{}{"pool.use_cooldown_sec": i, "pool.browser_refresh_headless": i%2 == 0}); err != nil {
+			t.Fatal(err)
+		}
+		store.SetBase(defaultAppConfig().Pool, "config")
+	}
+	close(stop)
+	wg.Wait()
+	if s := store.current.Load(); s.UseCooldown != 50*time.Second || !s.BrowserRefreshHeadless {
+		t.Fatalf("最终快照: %+v", s)
+	}
+}
+
+func TestAdminRuntimeSettings(t *testing.T) {
+	g := newTestGateway(t, 1)
+	useAdminCredentials(AdminCredential{Name: "carol", Token: "tok-owner", Role: RoleOwner})
+
+	w := g.post(t, "/admin/config/cooldown", map[string]int{"refresh_cooldown_sec": 300}, "X-Admin-Token", "tok-owner")
+	if w.Code != 200 {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	g.post(t, "/admin/config/browser-refresh", map[string]bool{"headless": false}, "X-Admin-Token", "tok-owner")
+	if s := currentSettings(); s.RefreshCooldown != 5*time.Minute || s.BrowserRefreshHeadless || s.UseCooldown != 15*time.Second {
+		t.Fatalf("设置: %+v", s)
+	}
+
+	w = g.get(t, "/admin/config/history", "X-Admin-Token", "tok-owner")
+	var resp struct {
+		Overrides map[string]json.RawMessage `json:"overrides"`
+		History   []SettingsChange           `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.History) != 2 || resp.History[0].Field != "pool.browser_refresh_headless" || resp.History[1].Actor != "carol" {
+		t.Fatalf("历史: %s", w.Body.String())
+	}
+	if string(resp.Overrides["pool.refresh_cooldown_sec"]) != "300" {
+		t.Fatalf("覆盖值: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ==================== 链路追踪 ====================
+
+// TracingConfig OpenTelemetry 配置
+type TracingConfig struct {
+	Exporter    string            `json:"exporter"`     // 留空不导出；stdout 或 otlp
+	Endpoint    string            `json:"endpoint"`     // OTLP/HTTP 地址，如 http://otel-collector:4318；留空使用 OTEL_EXPORTER_OTLP_ENDPOINT
+	Headers     map[string]string `json:"headers"`      // OTLP 请求头（如鉴权）
+	ServiceName string            `json:"service_name"` // 默认 business2api
+	SampleRatio float64           `json:"sample_ratio"` // 采样比例 0~1，默认 1；有上游 traceparent 时跟随其采样决定
+}
+
+const tracerName = "gemini-gateway"
+
+var tracerProvider *sdktrace.TracerProvider
+
+func init() {
+	// 即使不导出也解析 traceparent，保证日志中的 trace_id 与调用方一致
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// initTracing 按配置创建导出器；未配置时 span 为空操作
+func initTracing() {
+	cfg := currentConfig().Tracing
+	exporterName := cfg.Exporter
+	if exporterName == "" {
+		// 兼容 OpenTelemetry 标准环境变量
+		exporterName = os.Getenv("OTEL_TRACES_EXPORTER")
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "", "none":
+		return
+	case "stdout", "console":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		err = fmt.Errorf("不支持的导出器 %q（可选 stdout、otlp）", exporterName)
+	}
+	if err != nil {
+		log.Printf("❌ 初始化链路追踪失败: %v", err)
+		return
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "business2api"
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+	res, _ := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	log.Printf("🔭 链路追踪已启用: exporter=%s, service=%s, 采样=%.2f", exporterName, serviceName, ratio)
+}
+
+// shutdownTracing 导出剩余的 span
+func shutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// startSpan 创建子 span，并附加 context 中的阶段属性（模型、重试次数、账号）
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if attrs := spanAttrsFrom(ctx); len(attrs) > 0 {
+		opts = append(opts, trace.WithAttributes(attrs...))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+type spanAttrsKey struct{}
+
+// withSpanAttrs 在 context 中追加属性，之后创建的 span 都会带上
+func withSpanAttrs(ctx context.Context, attrs ...attribute.KeyValue) context.Context {
+	merged := append(append([]attribute.KeyValue{}, spanAttrsFrom(ctx)...), attrs...)
+	return context.WithValue(ctx, spanAttrsKey{}, merged)
+}
+
+func spanAttrsFrom(ctx context.Context) []attribute.KeyValue {
+	attrs, _ := ctx.Value(spanAttrsKey{}).([]attribute.KeyValue)
+	return attrs
+}
+
+// accountHash 账号标识的摘要，避免在 trace 中暴露邮箱
+func accountHash(acc *Account) string {
+	sum := sha256.Sum256([]byte(acc.Data.Email))
+	return hex.EncodeToString(sum[:6])
+}
+
+// tracedBody 响应体关闭时结束 span，并记录读取的字节数
+type tracedBody struct {
+	io.ReadCloser
+	span trace.Span
+	n    int64
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.SetAttributes(attribute.Int64("http.response.body.size", b.n))
+	b.span.End()
+	return err
+}
+
+// tracingMiddleware 从 traceparent 继续调用方的链路，为每个请求创建服务端 span
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request.id", requestIDFrom(ctx)),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := responseStatus(c)
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, strings.TrimSpace(c.Errors.String()))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useSpanRecorder 在测试期间把 span 记录到内存
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return rec
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingPipelineSpans(t *testing.T) {
+	rec := useSpanRecorder(t)
+	g := newTestGateway(t, 2)
+	g.fake.AddFile("gen-1", "image/png", []byte("\x89PNG fake image"))
+	g.fake.Script(epStreamAssist,
+		fakeResponse{Status: 401, Body: []byte(`{"error":{"status":"UNAUTHENTICATED"}}`)},
+		fakeResponse{Body: assistBody(textReply("here: "), fileReply("gen-1", "image/png"))},
+	)
+
+	content := []map[string]interface{}{
+		{"type": "text", "text": "draw"},
+		{"type": "image_url", "image_url": map[string]string{"url": "data:image/png;base64,iVBORw0KGgo="}},
+	}
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	decodeStream(t, g.post(t, "/v1/chat/completions", chatBody("gemini-2.5-flash-image", true, content),
+		"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s 未继承 traceparent: %s", s.Name(), s.SpanContext().TraceID())
+		}
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+
+	server := byName["POST /v1/chat/completions"]
+	if len(server) != 1 || server[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("服务端 span: %v", server)
+	}
+	if v, _ := spanAttr(byName["chat"][0], "gen_ai.request.model"); v.AsString() != "gemini-2.5-flash-image" {
+		t.Fatalf("chat span 模型属性 = %v", v)
+	}
+	if n := len(byName["account.select"]); n != 2 {
+		t.Fatalf("account.select span 数 = %d", n)
+	}
+
+	// 第二次尝试的上游调用带有重试次数和账号摘要
+	assists := byName["upstream.stream_assist"]
+	if len(assists) != 2 {
+		t.Fatalf("stream_assist span 数 = %d", len(assists))
+	}
+	if assists[0].Status().Code.String() != "Error" {
+		t.Errorf("401 的 stream_assist span 状态 = %v", assists[0].Status())
+	}
+	retry, _ := spanAttr(assists[1], "retry")
+	hash, _ := spanAttr(assists[1], "account.hash")
+	if retry.AsInt64() != 1 || hash.AsString() != accountHash(g.accounts[1]) {
+		t.Fatalf("第二次尝试属性: retry=%v hash=%v", retry, hash)
+	}
+	if size, _ := spanAttr(assists[1], "http.response.body.size"); size.AsInt64() == 0 {
+		t.Error("stream_assist 未记录响应大小")
+	}
+	if size, _ := spanAttr(byName["upstream.upload"][0], "media.bytes"); size.AsInt64() != 8 {
+		t.Errorf("upload media.bytes = %v", size)
+	}
+
+	download := byName["upstream.download"]
+	if len(download) != 1 || len(byName["download_files"]) != 1 ||
+		download[0].Parent().SpanID() != byName["download_files"][0].SpanContext().SpanID() {
+		t.Fatalf("下载 span 层级错误")
+	}
+	if size, _ := spanAttr(download[0], "media.bytes"); size.AsInt64() != 15 {
+		t.Errorf("download media.bytes = %v", size)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ==================== 上游接口 ====================
+
+const (
+	DefaultUpstreamAPIBase  = "https://biz-discoveryengine.googleapis.com"
+	DefaultUpstreamAuthBase = "https://business.gemini.google"
+)
+
+// UpstreamConfig 上游地址配置（可指向区域端点、调试代理或本地桩服务）
+type UpstreamConfig struct {
+	APIBaseURL  string `json:"api_base_url"`  // discoveryengine 接口地址
+	AuthBaseURL string `json:"auth_base_url"` // getoxsrf 鉴权地址
+}
+
+// Upstream Gemini Business 上游调用抽象
+type Upstream interface {
+	// GetOXSRF 获取 xsrfToken 和 keyId，用于签发 JWT
+	GetOXSRF(ctx context.Context, csesidx, cookie string) (xsrfToken, keyID string, err error)
+	// CreateSession 创建对话 Session，返回 session 名称
+	CreateSession(ctx context.Context, jwt, configID, origAuth string) (string, error)
+	// UploadContextFile 上传 base64 文件到 Session，返回 fileId
+	UploadContextFile(ctx context.Context, jwt, configID, sessionName, mimeType, base64Content, origAuth string) (string, error)
+	// UploadContextFileByURL 通过 URL 上传文件到 Session，返回 fileId
+	UploadContextFileByURL(ctx context.Context, jwt, configID, sessionName, fileURL, origAuth string) (string, error)
+	// StreamAssist 发起生成请求，由调用方处理状态码和响应体
+	StreamAssist(ctx context.Context, jwt, origAuth string, body []byte) (*http.Response, error)
+	// DownloadGeneratedFile 单次下载生成的文件，返回 base64 数据
+	DownloadGeneratedFile(ctx context.Context, jwt, fileId, session, configID, origAuth string) (string, error)
+}
+
+var upstream Upstream
+
+// httpUpstream 基于 HTTP 的上游实现
+type httpUpstream struct {
+	client   *http.Client
+	apiBase  string
+	authBase string
+}
+
+func newHTTPUpstream(client *http.Client, cfg UpstreamConfig) *httpUpstream {
+	apiBase := strings.TrimRight(cfg.APIBaseURL, "/")
+	if apiBase == "" {
+		apiBase = DefaultUpstreamAPIBase
+	}
+	authBase := strings.TrimRight(cfg.AuthBaseURL, "/")
+	if authBase == "" {
+		authBase = DefaultUpstreamAuthBase
+	}
+	return &httpUpstream{client: client, apiBase: apiBase, authBase: authBase}
+}
+
+func getCommonHeaders(jwt, origAuth string) map[string]string {
+	headers := map[string]string{
+		"accept":             "*/*",
+		"accept-encoding":    "gzip, deflate, br, zstd",
+		"accept-language":    "zh-CN,zh;q=0.9,en;q=0.8",
+		"authorization":      "Bearer " + jwt,
+		"content-type":       "application/json",
+		"origin":             "https://business.gemini.google",
+		"referer":            "https://business.gemini.google/",
+		"user-agent":         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+		"x-server-timeout":   "1800",
+		"sec-ch-ua":          `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`,
+		"sec-ch-ua-mobile":   "?0",
+		"sec-ch-ua-platform": `"Windows"`,
+		"sec-fetch-dest":     "empty",
+		"sec-fetch-mode":     "cors",
+		"sec-fetch-site":     "cross-site",
+	}
+	// 同时携带原始 authorization
+	if origAuth != "" {
+		headers["x-original-authorization"] = origAuth
+	}
+	return headers
+}
+
+// postJSON 以通用请求头 POST 到 discoveryengine 接口
+func (u *httpUpstream) postJSON(ctx context.Context, path, jwt, origAuth string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u.apiBase+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range getCommonHeaders(jwt, origAuth) {
+		req.Header.Set(k, v)
+	}
+	return u.client.Do(req)
+}
+
+func (u *httpUpstream) GetOXSRF(ctx context.Context, csesidx, cookie string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.authBase+"/auth/getoxsrf", nil)
+	if err != nil {
+		return "", "", err
+	}
+	q := req.URL.Query()
+	q.Add("csesidx", csesidx)
+	req.URL.RawQuery = q.Encode()
+
+	req.Header.Set("Cookie", cookie)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://business.gemini.google/")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("getoxsrf 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := readResponseBody(resp)
+		if resp.StatusCode == 401 || resp.StatusCode == 403 {
+			return "", "", fmt.Errorf("账号失效: %d %s", resp.StatusCode, string(body))
+		}
+		return "", "", fmt.Errorf("getoxsrf 失败: %d %s", resp.StatusCode, string(body))
+	}
+
+	body, _ := readResponseBody(resp)
+	txt := strings.TrimPrefix(string(body), ")]}'")
+	txt = strings.TrimSpace(txt)
+
+	var data struct {
+		XsrfToken string `json:"xsrfToken"`
+		KeyID     string `json:"keyId"`
+	}
+	if err := json.Unmarshal([]byte(txt), &data); err != nil {
+		return "", "", fmt.Errorf("解析 xsrf 响应失败: %w", err)
+	}
+	return data.XsrfToken, data.KeyID, nil
+}
+
+func (u *httpUpstream) CreateSession(ctx context.Context, jwt, configID, origAuth string) (string, error) {
+	body := map[string]interface{}{
+		"configId":         configID,
+		"additionalParams": map[string]string{"token": "-"},
+		"createSessionRequest": map[string]interface{}{
+			"session": map[string]string{"name": "", "displayName": ""},
+		},
+	}
+
+	bodyBytes, _ := json.Marshal(body)
+	resp, err := u.postJSON(ctx, "/v1alpha/locations/global/widgetCreateSession", jwt, origAuth, bodyBytes)
+	if err != nil {
+		return "", fmt.Errorf("createSession 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readResponseBody(resp)
+	if err != nil {
+		return "", fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("createSession 失败: %d %s", resp.StatusCode, string(respBody))
+	}
+
+	var result struct {
+		Session struct {
+			Name string `json:"name"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("解析 session 响应失败: %w", err)
+	}
+
+	return result.Session.Name, nil
+}
+
+func (u *httpUpstream) UploadContextFile(ctx context.Context, jwt, configID, sessionName, mimeType, base64Content, origAuth string) (string, error) {
+	ext := "jpg"
+	if parts := strings.Split(mimeType, "/"); len(parts) == 2 {
+		ext = parts[1]
+	}
+	fileName := fmt.Sprintf("upload_%d_%s.%s", time.Now().Unix(), uuid.New().String()[:6], ext)
+
+	body := map[string]interface{}{
+		"configId":         configID,
+		"additionalParams": map[string]string{"token": "-"},
+		"addContextFileRequest": map[string]interface{}{
+			"name":         sessionName,
+			"fileName":     fileName,
+			"mimeType":     mimeType,
+			"fileContents": base64Content,
+		},
+	}
+
+	bodyBytes, _ := json.Marshal(body)
+	respBody, err := u.addContextFile(ctx, jwt, origAuth, bodyBytes, "上传文件失败")
+	if err != nil {
+		return "", err
+	}
+	return parseAddContextFileResponse(respBody, "上传成功但 fileId 为空")
+}
+
+func (u *httpUpstream) UploadContextFileByURL(ctx context.Context, jwt, configID, sessionName, fileURL, origAuth string) (string, error) {
+	body := map[string]interface{}{
+		"configId":         configID,
+		"additionalParams": map[string]string{"token": "-"},
+		"addContextFileRequest": map[string]interface{}{
+			"name":    sessionName,
+			"fileUri": fileURL,
+		},
+	}
+
+	bodyBytes, _ := json.Marshal(body)
+	respBody, err := u.addContextFile(ctx, jwt, origAuth, bodyBytes, "URL上传文件失败")
+	if err != nil {
+		return "", err
+	}
+	return parseAddContextFileResponse(respBody, "URL上传成功但 fileId 为空")
+}
+
+func (u *httpUpstream) addContextFile(ctx context.Context, jwt, origAuth string, body []byte, failMsg string) ([]byte, error) {
+	resp, err := u.postJSON(ctx, "/v1alpha/locations/global/widgetAddContextFile", jwt, origAuth, body)
+	if err != nil {
+		return nil, fmt.Errorf("上传文件请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readResponseBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s: %d %s", failMsg, resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+func parseAddContextFileResponse(respBody []byte, emptyMsg string) (string, error) {
+	var result struct {
+		AddContextFileResponse struct {
+			FileID string `json:"fileId"`
+		} `json:"addContextFileResponse"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("解析上传响应失败: %w", err)
+	}
+
+	if result.AddContextFileResponse.FileID == "" {
+		return "", fmt.Errorf("%s，响应: %s", emptyMsg, string(respBody))
+	}
+
+	return result.AddContextFileResponse.FileID, nil
+}
+
+func (u *httpUpstream) StreamAssist(ctx context.Context, jwt, origAuth string, body []byte) (*http.Response, error) {
+	return u.postJSON(ctx, "/v1alpha/locations/global/widgetStreamAssist", jwt, origAuth, body)
+}
+
+func (u *httpUpstream) DownloadGeneratedFile(ctx context.Context, jwt, fileId, session, configID, origAuth string) (string, error) {
+	// 步骤1: 使用 widgetListSessionFileMetadata 获取文件下载 URL
+	listBody := map[string]interface{}{
+		"configId":         configID,
+		"additionalParams": map[string]string{"token": "-"},
+		"listSessionFileMetadataRequest": map[string]interface{}{
+			"name":   session,
+			"filter": "file_origin_type = AI_GENERATED",
+		},
+	}
+	listBodyBytes, _ := json.Marshal(listBody)
+
+	listResp, err := u.postJSON(ctx, "/v1alpha/locations/global/widgetListSessionFileMetadata", jwt, origAuth, listBodyBytes)
+	if err != nil {
+		return "", fmt.Errorf("获取文件元数据失败: %w", err)
+	}
+	defer listResp.Body.Close()
+
+	listRespBody, _ := readResponseBody(listResp)
+
+	if listResp.StatusCode != 200 {
+		return "", fmt.Errorf("获取文件元数据失败: HTTP %d: %s", listResp.StatusCode, string(listRespBody))
+	}
+
+	// 解析响应，查找匹配的 fileId
+	var listResult struct {
+		ListSessionFileMetadataResponse struct {
+			FileMetadata []struct {
+				FileID      string `json:"fileId"`
+				Session     string `json:"session"` // 包含完整的 projects 路径
+				DownloadURI string `json:"downloadUri"`
+			} `json:"fileMetadata"`
+		} `json:"listSessionFileMetadataResponse"`
+	}
+	if err := json.Unmarshal(listRespBody, &listResult); err != nil {
+		return "", fmt.Errorf("解析文件元数据失败: %w", err)
+	}
+
+	// 查找匹配的文件，获取完整 session 路径
+	var fullSession string
+	for _, meta := range listResult.ListSessionFileMetadataResponse.FileMetadata {
+		if meta.FileID == fileId {
+			fullSession = meta.Session // 如: projects/372889301682/locations/global/collections/...
+			break
+		}
+	}
+
+	if fullSession == "" {
+		return "", fmt.Errorf("未找到 fileId=%s 的文件信息", fileId)
+	}
+
+	downloadURL := fmt.Sprintf("%s/download/v1alpha/%s:downloadFile?fileId=%s&alt=media", u.apiBase, fullSession, fileId)
+	downloadReq, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	for k, v := range getCommonHeaders(jwt, origAuth) {
+		downloadReq.Header.Set(k, v)
+	}
+
+	downloadResp, err := u.client.Do(downloadReq)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer downloadResp.Body.Close()
+
+	imgBody, _ := readResponseBody(downloadResp)
+
+	if downloadResp.StatusCode != 200 {
+		return "", fmt.Errorf("下载图片失败: HTTP %d: %s", downloadResp.StatusCode, string(imgBody))
+	}
+
+	// 响应是原始二进制图片数据，需要转为 base64
+	return base64.StdEncoding.EncodeToString(imgBody), nil
+}
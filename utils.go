@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"io"
@@ -42,6 +43,17 @@ func newHTTPClient() *http.Client {
 
 func initHTTPClient() {
 	httpClient = newHTTPClient()
+	upstream = newHTTPUpstream(httpClient, currentConfig().Upstream)
+
+	initRecorder()
+	if recorder != nil {
+		recordingClient := &http.Client{
+			Transport: &recordingTransport{base: httpClient.Transport, recorder: recorder},
+			Timeout:   httpClient.Timeout,
+		}
+		upstream = newHTTPUpstream(recordingClient, currentConfig().Upstream)
+	}
+	upstream = instrumentUpstream(upstream)
 	if Proxy != "" {
 		log.Printf("✅ 使用代理: %s", Proxy)
 	}
@@ -63,6 +75,46 @@ func readResponseBody(resp *http.Response) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
+// sleepCtx 可取消的等待，上下文结束时返回 false
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ==================== Token 统计 ====================
+
+// ctxTokenUsage 请求处理完成后写入 gin.Context 的 Token 用量
+const ctxTokenUsage = "tokenUsage"
+
+// TokenUsage 一次请求的 Token 用量（估算值）
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// estimateTokens 粗略估算 Token 数：ASCII 约 4 字符 1 个，其余字符（如中文）按 1 个计
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
 // parseNDJSON 解析NDJSON格式数据
 func parseNDJSON(data []byte) []map[string]interface{} {
 	var result []map[string]interface{}
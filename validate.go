@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ==================== 请求校验 ====================
+
+// LimitsConfig 请求大小限制
+type LimitsConfig struct {
+	MaxMessages   int `json:"max_messages"`    // 单次请求最大消息数
+	MaxMediaCount int `json:"max_media_count"` // 单次请求最大媒体数
+	MaxMediaMB    int `json:"max_media_mb"`    // 单个 base64 媒体最大体积(MB)
+	MaxTools      int `json:"max_tools"`       // 最大工具定义数
+}
+
+// ValidationError 字段级校验错误
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func invalid(field, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// 网关支持的消息角色（human / tool_result 为 Claude 兼容写法）
+var validRoles = map[string]bool{
+	"system": true, "user": true, "human": true,
+	"assistant": true, "tool": true, "tool_result": true,
+}
+
+// 可作为最后一条消息（即需要模型回复）的角色
+var promptRoles = map[string]bool{"user": true, "human": true, "tool": true, "tool_result": true}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// maxMediaBytes 单个 base64 媒体的字节上限
+func (l LimitsConfig) maxMediaBytes() int {
+	mb := l.MaxMediaMB
+	if mb <= 0 {
+		mb = 20
+	}
+	return mb * 1024 * 1024
+}
+
+// validateChatRequest 校验 OpenAI 格式请求
+func validateChatRequest(req *ChatRequest) *ValidationError {
+	limits := currentConfig().Limits
+	if verr := validateMessages(req.Messages, false, limits); verr != nil {
+		return verr
+	}
+	if req.Temperature < 0 || req.Temperature > 2 {
+		return invalid("temperature", "必须在 0 到 2 之间")
+	}
+	if req.TopP < 0 || req.TopP > 1 {
+		return invalid("top_p", "必须在 0 到 1 之间")
+	}
+	switch req.ToolChoice {
+	case "", "auto", "none", "required":
+	default:
+		return invalid("tool_choice", "不支持的取值 %q", req.ToolChoice)
+	}
+	return validateTools(req.Tools, limits)
+}
+
+// validateMessages 校验消息列表；claude 为 true 时按 Claude Messages 规则：允许以 assistant 消息结尾（预填充），
+// 并跳过已由 validateClaudeRequest 校验过的 Claude 内容块
+func validateMessages(messages []Message, claude bool, limits LimitsConfig) *ValidationError {
+	if len(messages) == 0 {
+		return invalid("messages", "不能为空")
+	}
+	if limits.MaxMessages > 0 && len(messages) > limits.MaxMessages {
+		return invalid("messages", "消息数 %d 超过上限 %d", len(messages), limits.MaxMessages)
+	}
+
+	mediaCount := 0
+	hasDialog := false
+	for i, msg := range messages {
+		field := fmt.Sprintf("messages[%d]", i)
+		if !validRoles[msg.Role] {
+			return invalid(field+".role", "不支持的角色 %q", msg.Role)
+		}
+		if msg.Role != "system" {
+			hasDialog = true
+		}
+		if msg.Role == "tool" && i > 0 {
+			prev := messages[i-1].Role
+			if prev != "assistant" && prev != "tool" {
+				return invalid(field+".role", "tool 消息必须紧跟在 assistant 或 tool 消息之后")
+			}
+		}
+		n, verr := validateMessageContent(field+".content", msg, claude, limits)
+		if verr != nil {
+			return verr
+		}
+		mediaCount += n
+	}
+
+	if !hasDialog {
+		return invalid("messages", "至少需要一条非 system 消息")
+	}
+	last := messages[len(messages)-1]
+	if !promptRoles[last.Role] && !(claude && last.Role == "assistant") {
+		return invalid(fmt.Sprintf("messages[%d].role", len(messages)-1), "最后一条消息必须是 user 或 tool，实际为 %q", last.Role)
+	}
+	if limits.MaxMediaCount > 0 && mediaCount > limits.MaxMediaCount {
+		return invalid("messages", "媒体数 %d 超过上限 %d", mediaCount, limits.MaxMediaCount)
+	}
+	return nil
+}
+
+// validateMessageContent 校验消息内容，返回其中的媒体数量
+func validateMessageContent(field string, msg Message, claude bool, limits LimitsConfig) (int, *ValidationError) {
+	switch content := msg.Content.(type) {
+	case nil:
+		if msg.Role == "assistant" && len(msg.ToolCalls) > 0 {
+			return 0, nil
+		}
+		return 0, invalid(field, "不能为空")
+	case string:
+		if strings.TrimSpace(content) == "" && !(msg.Role == "assistant" && len(msg.ToolCalls) > 0) {
+			return 0, invalid(field, "不能为空")
+		}
+		return 0, nil
+	case []interface{}:
+		if len(content) == 0 {
+			return 0, invalid(field, "不能为空")
+		}
+		mediaCount := 0
+		for j, part := range content {
+			partField := fmt.Sprintf("%s[%d]", field, j)
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				return 0, invalid(partField, "必须是对象")
+			}
+			partType, _ := partMap["type"].(string)
+			switch partType {
+			case "text":
+				if _, ok := partMap["text"].(string); !ok {
+					return 0, invalid(partField+".text", "必须是字符串")
+				}
+			case "image_url", "video_url":
+				obj, _ := partMap[partType].(map[string]interface{})
+				urlStr, _ := obj["url"].(string)
+				if verr := validateMediaURL(partField+"."+partType+".url", urlStr, limits); verr != nil {
+					return 0, verr
+				}
+				mediaCount++
+			case "file":
+				obj, _ := partMap["file"].(map[string]interface{})
+				urlStr, _ := obj["url"].(string)
+				if verr := validateMediaURL(partField+".file.url", urlStr, limits); verr != nil {
+					return 0, verr
+				}
+				mediaCount++
+			case "tool_use", "tool_result", "thinking", "redacted_thinking":
+				if !claude {
+					return 0, invalid(partField+".type", "不支持的内容类型 %q", partType)
+				}
+			case "":
+				return 0, invalid(partField+".type", "缺少类型")
+			default:
+				return 0, invalid(partField+".type", "不支持的内容类型 %q", partType)
+			}
+		}
+		return mediaCount, nil
+	default:
+		return 0, invalid(field, "必须是字符串或内容数组")
+	}
+}
+
+// validateMediaURL 校验媒体地址：http(s) URL 或 base64 data URI
+func validateMediaURL(field, urlStr string, limits LimitsConfig) *ValidationError {
+	switch {
+	case urlStr == "":
+		return invalid(field, "不能为空")
+	case strings.HasPrefix(urlStr, "data:"):
+		header, data, ok := strings.Cut(urlStr, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return invalid(field, "data URI 必须为 base64 编码")
+		}
+		if header == "data:;base64" {
+			return invalid(field, "data URI 缺少 MIME 类型")
+		}
+		return validateBase64Size(field, data, limits)
+	case strings.HasPrefix(urlStr, "http://"), strings.HasPrefix(urlStr, "https://"):
+		return nil
+	default:
+		return invalid(field, "必须是 http(s) URL 或 data URI")
+	}
+}
+
+func validateBase64Size(field, data string, limits LimitsConfig) *ValidationError {
+	if data == "" {
+		return invalid(field, "媒体数据为空")
+	}
+	if size, limit := base64.StdEncoding.DecodedLen(len(data)), limits.maxMediaBytes(); size > limit {
+		return invalid(field, "媒体大小约 %d 字节，超过上限 %d 字节", size, limit)
+	}
+	return nil
+}
+
+// validateTools 校验工具定义
+func validateTools(tools []ToolDef, limits LimitsConfig) *ValidationError {
+	if maxTools := limits.MaxTools; maxTools > 0 && len(tools) > maxTools {
+		return invalid("tools", "工具数 %d 超过上限 %d", len(tools), maxTools)
+	}
+	seen := make(map[string]bool)
+	for i, tool := range tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		if tool.Type != "" && tool.Type != "function" {
+			return invalid(field+".type", "仅支持 function，实际为 %q", tool.Type)
+		}
+		if verr := validateFunctionDecl(field+".function", "parameters", tool.Function.Name, tool.Function.Parameters); verr != nil {
+			return verr
+		}
+		if seen[tool.Function.Name] {
+			return invalid(field+".function.name", "工具名 %q 重复", tool.Function.Name)
+		}
+		seen[tool.Function.Name] = true
+	}
+	return nil
+}
+
+// validateFunctionDecl 校验函数名与参数 schema；schemaKey 为参数 schema 所在字段名（用于错误定位）
+func validateFunctionDecl(field, schemaKey, name string, params map[string]interface{}) *ValidationError {
+	if !toolNamePattern.MatchString(name) {
+		return invalid(field+".name", "必须由 1-64 位字母、数字、下划线或连字符组成")
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	if t, _ := params["type"].(string); !strings.EqualFold(t, "object") {
+		return invalid(field+"."+schemaKey+".type", "参数 schema 的顶层类型必须是 object")
+	}
+	if props, ok := params["properties"]; ok {
+		if _, ok := props.(map[string]interface{}); !ok {
+			return invalid(field+"."+schemaKey+".properties", "必须是对象")
+		}
+	}
+	if required, ok := params["required"]; ok {
+		list, ok := required.([]interface{})
+		if !ok {
+			return invalid(field+"."+schemaKey+".required", "必须是字符串数组")
+		}
+		for _, r := range list {
+			if _, ok := r.(string); !ok {
+				return invalid(field+"."+schemaKey+".required", "必须是字符串数组")
+			}
+		}
+	}
+	return nil
+}
+
+// validateClaudeRequest 校验 Claude Messages 请求：先校验 Claude 特有的内容块，
+// image 内容块转换后按 OpenAI 规则继续校验
+func validateClaudeRequest(req *ClaudeRequest) *ValidationError {
+	if req.MaxTokens < 0 {
+		return invalid("max_tokens", "不能为负数")
+	}
+	if req.Temperature < 0 || req.Temperature > 1 {
+		return invalid("temperature", "必须在 0 到 1 之间")
+	}
+	for i, msg := range req.Messages {
+		field := fmt.Sprintf("messages[%d]", i)
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return invalid(field+".role", "必须是 user 或 assistant，实际为 %q", msg.Role)
+		}
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, block := range blocks {
+			blockField := fmt.Sprintf("%s.content[%d]", field, j)
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				return invalid(blockField, "必须是对象")
+			}
+			switch blockType, _ := blockMap["type"].(string); blockType {
+			case "image":
+			case "tool_use":
+				if msg.Role != "assistant" {
+					return invalid(blockField+".type", "tool_use 只能出现在 assistant 消息中")
+				}
+				if id, _ := blockMap["id"].(string); id == "" {
+					return invalid(blockField+".id", "不能为空")
+				}
+				if name, _ := blockMap["name"].(string); !toolNamePattern.MatchString(name) {
+					return invalid(blockField+".name", "必须由 1-64 位字母、数字、下划线或连字符组成")
+				}
+				if input, ok := blockMap["input"]; ok {
+					if _, ok := input.(map[string]interface{}); !ok {
+						return invalid(blockField+".input", "必须是对象")
+					}
+				}
+				continue
+			case "tool_result":
+				if msg.Role != "user" {
+					return invalid(blockField+".type", "tool_result 只能出现在 user 消息中")
+				}
+				if id, _ := blockMap["tool_use_id"].(string); id == "" {
+					return invalid(blockField+".tool_use_id", "不能为空")
+				}
+				switch blockMap["content"].(type) {
+				case nil, string, []interface{}:
+				default:
+					return invalid(blockField+".content", "必须是字符串或内容数组")
+				}
+				continue
+			case "thinking", "redacted_thinking":
+				if msg.Role != "assistant" {
+					return invalid(blockField+".type", "%s 只能出现在 assistant 消息中", blockType)
+				}
+				continue
+			default:
+				continue // 其余类型由通用规则校验
+			}
+			source, _ := blockMap["source"].(map[string]interface{})
+			switch sourceType, _ := source["type"].(string); sourceType {
+			case "base64":
+				if mediaType, _ := source["media_type"].(string); !strings.HasPrefix(mediaType, "image/") {
+					return invalid(blockField+".source.media_type", "必须是图片类型")
+				}
+				if data, _ := source["data"].(string); data == "" {
+					return invalid(blockField+".source.data", "不能为空")
+				}
+			case "url":
+				if u, _ := source["url"].(string); u == "" {
+					return invalid(blockField+".source.url", "不能为空")
+				}
+			default:
+				return invalid(blockField+".source.type", "不支持的图片来源 %q", sourceType)
+			}
+		}
+	}
+	limits := currentConfig().Limits
+	if verr := validateMessages(convertClaudeMessages(req.Messages), true, limits); verr != nil {
+		return verr
+	}
+	return validateClaudeTools(req.Tools, limits)
+}
+
+// validateClaudeTools 校验 Claude 工具定义：原生格式（name/input_schema）或 OpenAI function 格式
+func validateClaudeTools(tools []ClaudeTool, limits LimitsConfig) *ValidationError {
+	if maxTools := limits.MaxTools; maxTools > 0 && len(tools) > maxTools {
+		return invalid("tools", "工具数 %d 超过上限 %d", len(tools), maxTools)
+	}
+	seen := make(map[string]bool)
+	for i, tool := range tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		var name string
+		if tool.Function.Name != "" {
+			if tool.Type != "" && tool.Type != "function" {
+				return invalid(field+".type", "仅支持 function，实际为 %q", tool.Type)
+			}
+			if verr := validateFunctionDecl(field+".function", "parameters", tool.Function.Name, tool.Function.Parameters); verr != nil {
+				return verr
+			}
+			name = tool.Function.Name
+		} else {
+			if tool.Type != "" && tool.Type != "custom" {
+				return invalid(field+".type", "不支持的工具类型 %q，仅支持自定义工具", tool.Type)
+			}
+			if verr := validateFunctionDecl(field, "input_schema", tool.Name, tool.InputSchema); verr != nil {
+				return verr
+			}
+			name = tool.Name
+		}
+		if seen[name] {
+			return invalid(field+".name", "工具名 %q 重复", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// convertClaudeMessages 将 Claude image 内容块转换为 OpenAI image_url 格式
+func convertClaudeMessages(messages []Message) []Message {
+	out := make([]Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		blocks, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		converted := make([]interface{}, len(blocks))
+		for j, block := range blocks {
+			converted[j] = block
+			blockMap, ok := block.(map[string]interface{})
+			if !ok || blockMap["type"] != "image" {
+				continue
+			}
+			source, _ := blockMap["source"].(map[string]interface{})
+			var url string
+			if source["type"] == "base64" {
+				url = fmt.Sprintf("data:%v;base64,%v", source["media_type"], source["data"])
+			} else {
+				url, _ = source["url"].(string)
+			}
+			converted[j] = map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]interface{}{"url": url},
+			}
+		}
+		out[i].Content = converted
+	}
+	return out
+}
+
+// validateGeminiRequest 校验 Gemini generateContent 请求
+func validateGeminiRequest(req *GeminiRequest) *ValidationError {
+	limits := currentConfig().Limits
+	if len(req.Contents) == 0 {
+		return invalid("contents", "不能为空")
+	}
+	for i, content := range req.Contents {
+		field := fmt.Sprintf("contents[%d]", i)
+		if content.Role != "" && content.Role != "user" && content.Role != "model" {
+			return invalid(field+".role", "必须是 user 或 model，实际为 %q", content.Role)
+		}
+		if verr := validateGeminiParts(field+".parts", content.Parts, limits); verr != nil {
+			return verr
+		}
+	}
+	if last := req.Contents[len(req.Contents)-1]; last.Role == "model" {
+		return invalid(fmt.Sprintf("contents[%d].role", len(req.Contents)-1), "最后一条内容必须是 user")
+	}
+	if req.SystemInstruction != nil {
+		for j, part := range req.SystemInstruction.Parts {
+			if part.InlineData != nil {
+				return invalid(fmt.Sprintf("systemInstruction.parts[%d]", j), "只支持文本")
+			}
+		}
+	}
+
+	cfg := req.GenerationConfig
+	if v, ok := cfg["temperature"].(float64); ok && (v < 0 || v > 2) {
+		return invalid("generationConfig.temperature", "必须在 0 到 2 之间")
+	}
+	if v, ok := cfg["topP"].(float64); ok && (v < 0 || v > 1) {
+		return invalid("generationConfig.topP", "必须在 0 到 1 之间")
+	}
+	if v, ok := cfg["candidateCount"].(float64); ok && v != 1 {
+		return invalid("generationConfig.candidateCount", "仅支持 1")
+	}
+	if v, ok := cfg["maxOutputTokens"].(float64); ok && v < 0 {
+		return invalid("generationConfig.maxOutputTokens", "不能为负数")
+	}
+
+	count := 0
+	for i, tool := range req.GeminiTools {
+		decls, ok := tool["functionDeclarations"]
+		if !ok {
+			continue
+		}
+		list, ok := decls.([]interface{})
+		if !ok {
+			return invalid(fmt.Sprintf("tools[%d].functionDeclarations", i), "必须是数组")
+		}
+		for j, d := range list {
+			field := fmt.Sprintf("tools[%d].functionDeclarations[%d]", i, j)
+			decl, ok := d.(map[string]interface{})
+			if !ok {
+				return invalid(field, "必须是对象")
+			}
+			name, _ := decl["name"].(string)
+			params, _ := decl["parameters"].(map[string]interface{})
+			if verr := validateFunctionDecl(field, "parameters", name, params); verr != nil {
+				return verr
+			}
+			count++
+		}
+	}
+	if maxTools := limits.MaxTools; maxTools > 0 && count > maxTools {
+		return invalid("tools", "工具数 %d 超过上限 %d", count, maxTools)
+	}
+	return nil
+}
+
+func validateGeminiParts(field string, parts []GeminiPart, limits LimitsConfig) *ValidationError {
+	if len(parts) == 0 {
+		return invalid(field, "不能为空")
+	}
+	for j, part := range parts {
+		partField := fmt.Sprintf("%s[%d]", field, j)
+		if part.InlineData == nil {
+			if part.Text == "" {
+				return invalid(partField, "必须包含 text 或 inlineData")
+			}
+			continue
+		}
+		if part.InlineData.MimeType == "" {
+			return invalid(partField+".inlineData.mimeType", "不能为空")
+		}
+		if verr := validateBase64Size(partField+".inlineData.data", part.InlineData.Data, limits); verr != nil {
+			return verr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateChatRequest(t *testing.T) {
+	user := Message{Role: "user", Content: "hi"}
+	cases := []struct {
+		name  string
+		req   ChatRequest
+		field string // 为空表示应通过
+	}{
+		{"合法", ChatRequest{Messages: []Message{{Role: "system", Content: "s"}, user}}, ""},
+		{"空消息", ChatRequest{}, "messages"},
+		{"仅 system", ChatRequest{Messages: []Message{{Role: "system", Content: "s"}}}, "messages"},
+		{"未知角色", ChatRequest{Messages: []Message{{Role: "bot", Content: "x"}}}, "messages[0].role"},
+		{"以 assistant 结尾", ChatRequest{Messages: []Message{user, {Role: "assistant", Content: "x"}}}, "messages[1].role"},
+		{"tool 位置错误", ChatRequest{Messages: []Message{user, {Role: "tool", Content: "r"}}}, "messages[1].role"},
+		{"tool 跟随 assistant", ChatRequest{Messages: []Message{user, {Role: "assistant", ToolCalls: []ToolCall{{ID: "1"}}}, {Role: "tool", Content: "r"}}}, ""},
+		{"空内容", ChatRequest{Messages: []Message{{Role: "user", Content: " "}}}, "messages[0].content"},
+		{"未知内容类型", ChatRequest{Messages: []Message{{Role: "user", Content: []interface{}{map[string]interface{}{"type": "audio"}}}}}, "messages[0].content[0].type"},
+		{"非法图片地址", ChatRequest{Messages: []Message{{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "ftp://x"}},
+		}}}}, "messages[0].content[0].image_url.url"},
+		{"temperature 越界", ChatRequest{Messages: []Message{user}, Temperature: 3}, "temperature"},
+		{"top_p 越界", ChatRequest{Messages: []Message{user}, TopP: 1.5}, "top_p"},
+		{"tool_choice 非法", ChatRequest{Messages: []Message{user}, ToolChoice: "any"}, "tool_choice"},
+		{"工具名非法", ChatRequest{Messages: []Message{user}, Tools: []ToolDef{{Type: "function", Function: FunctionDef{Name: "bad name"}}}}, "tools[0].function.name"},
+		{"工具名重复", ChatRequest{Messages: []Message{user}, Tools: []ToolDef{
+			{Type: "function", Function: FunctionDef{Name: "f"}},
+			{Type: "function", Function: FunctionDef{Name: "f"}},
+		}}, "tools[1].function.name"},
+		{"参数 schema 非 object", ChatRequest{Messages: []Message{user}, Tools: []ToolDef{
+			{Type: "function", Function: FunctionDef{Name: "f", Parameters: map[string]interface{}{"type": "string"}}},
+		}}, "tools[0].function.parameters.type"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			verr := validateChatRequest(&tc.req)
+			switch {
+			case tc.field == "" && verr != nil:
+				t.Fatalf("不应报错: %v", verr)
+			case tc.field != "" && verr == nil:
+				t.Fatalf("应报错 %s", tc.field)
+			case tc.field != "" && verr.Field != tc.field:
+				t.Fatalf("字段 = %s, 期望 %s (%v)", verr.Field, tc.field, verr)
+			}
+		})
+	}
+}
+
+func TestValidateMediaLimits(t *testing.T) {
+	useConfig(t, func(cfg *AppConfig) { cfg.Limits = LimitsConfig{MaxMediaCount: 1, MaxMediaMB: 1} })
+
+	img := func(data string) interface{} {
+		return map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64," + data}}
+	}
+	two := ChatRequest{Messages: []Message{{Role: "user", Content: []interface{}{img("aGk="), img("aGk=")}}}}
+	if verr := validateChatRequest(&two); verr == nil || verr.Field != "messages" {
+		t.Fatalf("媒体数量超限未拦截: %v", verr)
+	}
+	big := ChatRequest{Messages: []Message{{Role: "user", Content: []interface{}{img(strings.Repeat("A", 2*1024*1024))}}}}
+	if verr := validateChatRequest(&big); verr == nil || verr.Field != "messages[0].content[0].image_url.url" {
+		t.Fatalf("媒体大小超限未拦截: %v", verr)
+	}
+}
+
+func TestValidationErrorDialects(t *testing.T) {
+	g := newTestGateway(t, 1)
+
+	cases := []struct {
+		path string
+		body interface{}
+		want string
+	}{
+		{"/v1/chat/completions", map[string]interface{}{"messages": []interface{}{}}, `"param":"messages"`},
+		{"/v1/messages", map[string]interface{}{"messages": []map[string]interface{}{{"role": "system", "content": "x"}}}, `"type":"invalid_request_error"`},
+		{"/v1beta/models/gemini-2.5-flash:generateContent", map[string]interface{}{"contents": []interface{}{}}, `"status":"INVALID_ARGUMENT"`},
+	}
+	for _, tc := range cases {
+		w := g.post(t, tc.path, tc.body)
+		if w.Code != 400 || !strings.Contains(w.Body.String(), tc.want) {
+			t.Fatalf("%s: 状态码 = %d, 响应: %s", tc.path, w.Code, w.Body.String())
+		}
+	}
+	// 校验失败不应占用账号或请求上游
+	if n := g.fake.Count(epCreateSession); n != 0 {
+		t.Fatalf("校验失败后仍请求了上游 %d 次", n)
+	}
+}
+
+func TestValidateClaudeRequest(t *testing.T) {
+	var req ClaudeRequest
+	json.Unmarshal([]byte(`{"messages":[{"role":"user","content":[
+		{"type":"text","text":"看图"},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGk="}}
+	]},{"role":"assistant","content":"这是"}]}`), &req)
+	if verr := validateClaudeRequest(&req); verr != nil {
+		t.Fatalf("不应报错: %v", verr)
+	}
+	converted := convertClaudeMessages(req.Messages)
+	part := converted[0].Content.([]interface{})[1].(map[string]interface{})
+	if part["type"] != "image_url" {
+		t.Fatalf("image 未转换: %v", part)
+	}
+
+	json.Unmarshal([]byte(`{"messages":[{"role":"user","content":[{"type":"document"}]}]}`), &req)
+	if verr := validateClaudeRequest(&req); verr == nil || verr.Field != "messages[0].content[0].type" {
+		t.Fatalf("未知内容块未拦截: %v", verr)
+	}
+}
+
+// Claude 原生工具与 tool_use / tool_result / thinking 内容块
+const claudeToolUseRequest = `{
+	"model": "claude-sonnet-4-5",
+	"max_tokens": 1024,
+	"tools": [{
+		"name": "get_weather",
+		"description": "Get the current weather in a given location",
+		"input_schema": {"type": "object", "properties": {"location": {"type": "string"}}, "required": ["location"]}
+	}],
+	"messages": [
+		{"role": "user", "content": "What's the weather like in Paris?"},
+		{"role": "assistant", "content": [
+			{"type": "thinking", "thinking": "I should call get_weather.", "signature": "sig"},
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "toolu_01A09q90qw90lq917835lq9", "name": "get_weather", "input": {"location": "Paris"}}
+		]},
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_01A09q90qw90lq917835lq9", "content": "15 degrees"}
+		]}
+	]
+}`
+
+func TestValidateClaudeToolUse(t *testing.T) {
+	var req ClaudeRequest
+	if err := json.Unmarshal([]byte(claudeToolUseRequest), &req); err != nil {
+		t.Fatal(err)
+	}
+	if verr := validateClaudeRequest(&req); verr != nil {
+		t.Fatalf("不应报错: %v", verr)
+	}
+	tools := convertClaudeTools(req.Tools)
+	if len(tools) != 1 || tools[0].Type != "function" || tools[0].Function.Name != "get_weather" ||
+		tools[0].Function.Parameters["type"] != "object" {
+		t.Fatalf("工具未转换: %+v", tools)
+	}
+
+	cases := map[string]string{
+		`{"messages":[{"role":"user","content":"hi"}],"tools":[{"name":"bad name","input_schema":{"type":"object"}}]}`:     "tools[0].name",
+		`{"messages":[{"role":"user","content":"hi"}],"tools":[{"name":"f","input_schema":{"type":"string"}}]}`:            "tools[0].input_schema.type",
+		`{"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"web_search_20250305","name":"web_search"}]}`:       "tools[0].type",
+		`{"messages":[{"role":"user","content":"hi"}],"tools":[{"name":"f"},{"type":"function","function":{"name":"f"}}]}`: "tools[1].name",
+		`{"messages":[{"role":"user","content":[{"type":"tool_use","id":"t1","name":"f","input":{}}]}]}`:                   "messages[0].content[0].type",
+		`{"messages":[{"role":"assistant","content":[{"type":"tool_use","id":"","name":"f"}]}]}`:                           "messages[0].content[0].id",
+		`{"messages":[{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"f","input":"x"}]}]}`:             "messages[0].content[0].input",
+		`{"messages":[{"role":"user","content":[{"type":"tool_result","content":"x"}]}]}`:                                  "messages[0].content[0].tool_use_id",
+		`{"messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":1}]}]}`:                 "messages[0].content[0].content",
+	}
+	for body, field := range cases {
+		var req ClaudeRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		if verr := validateClaudeRequest(&req); verr == nil || verr.Field != field {
+			t.Errorf("%s: 期望字段 %s, 实际 %v", body, field, verr)
+		}
+	}
+
+	// OpenAI 接口不接受 Claude 内容块
+	chat := ChatRequest{Messages: []Message{{Role: "user", Content: []interface{}{
+		map[string]interface{}{"type": "tool_result", "tool_use_id": "t1", "content": "x"},
+	}}}}
+	if verr := validateChatRequest(&chat); verr == nil || verr.Field != "messages[0].content[0].type" {
+		t.Fatalf("OpenAI 请求中的 tool_result 未拦截: %v", verr)
+	}
+}
+
+func TestValidateGeminiRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`:                                    "",
+		`{"contents":[{"role":"bot","parts":[{"text":"hi"}]}]}`:                                     "contents[0].role",
+		`{"contents":[{"parts":[]}]}`:                                                               "contents[0].parts",
+		`{"contents":[{"parts":[{"inlineData":{"mimeType":"","data":"aGk="}}]}]}`:                   "contents[0].parts[0].inlineData.mimeType",
+		`{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"candidateCount":2}}`:          "generationConfig.candidateCount",
+		`{"contents":[{"parts":[{"text":"hi"}]}],"tools":[{"functionDeclarations":[{"name":""}]}]}`: "tools[0].functionDeclarations[0].name",
+	}
+	for body, field := range cases {
+		var req GeminiRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		verr := validateGeminiRequest(&req)
+		if field == "" && verr != nil || field != "" && (verr == nil || verr.Field != field) {
+			t.Fatalf("%s: 得到 %v, 期望字段 %q", body, verr, field)
+		}
+	}
+}